@@ -21,4 +21,7 @@ const (
 	KeyStoragePool   = "storage-pool"
 	KeyCertPath      = "cert"
 	KeyComposeFolder = "compose"
+	KeyArchive       = "archive"
+
+	archiveCurrent = "current"
 )
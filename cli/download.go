@@ -23,7 +23,8 @@ import (
 )
 
 const (
-	pathFlagName = "path"
+	pathFlagName   = "path"
+	offsetFlagName = "offset"
 )
 
 // DownloadCommand downloads a volume via ssh from a remote server
@@ -39,6 +40,10 @@ func DownloadCommand(version, compiled, commit string) *c.Command {
 				Required: true,
 				Aliases:  []string{"p"},
 			},
+			&c.Uint64Flag{
+				Name:  offsetFlagName,
+				Usage: "Byte offset to start fetching from",
+			},
 		},
 		Action: func(ctx *c.Context) error {
 			// path to download
@@ -50,8 +55,8 @@ func DownloadCommand(version, compiled, commit string) *c.Command {
 				return err
 			}
 			// download the volume
-			getVolume := onprem.GetLoggingVolumeViaSSH(&sshConfig)
-			content, err := getVolume(path)
+			getVolume := onprem.GetLoggingVolumeFromOffsetViaSSH(&sshConfig)
+			content, err := getVolume(path, ctx.Uint64(offsetFlagName))
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,100 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/urfave/cli/v2"
+)
+
+// CreateLogsCommand lists the archived console logs of a VSI or prints one of them
+func CreateLogsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "logs",
+		Usage: "lists the archived console logs of an onprem VSI or prints the content of one of them",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     KeyConfig,
+				Aliases:  []string{"c"},
+				Usage:    "Name of the SSH config entry",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:     KeyName,
+				Aliases:  []string{"n"},
+				Usage:    "Name of the VSI (the UID of the custom resource)",
+				Required: true,
+			},
+			&cli.StringFlag{
+				Name:        KeyStoragePool,
+				Aliases:     []string{"p"},
+				Usage:       "Name of the storage pool",
+				DefaultText: DefaultStoragePool,
+				Required:    false,
+			},
+			&cli.StringFlag{
+				Name:     KeyArchive,
+				Aliases:  []string{"a"},
+				Usage:    "Name of the archive to print, prints the current console log if set to 'current'",
+				Required: false,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			// find SSH path
+			sshPath, err := onprem.GetSSHConfigPath()
+			if err != nil {
+				return err
+			}
+			// load config
+			sshConfig, err := onprem.LoadSSHConfig(sshPath)(ctx.String(KeyConfig))
+			if err != nil {
+				return err
+			}
+			client, err := onprem.CreateLivirtClient(sshConfig)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+			// the inputs
+			name := ctx.String(KeyName)
+			storagePool := onprem.BoxStoragePool(ctx.String(KeyStoragePool))
+			archive := ctx.String(KeyArchive)
+			// list the archives
+			if len(archive) == 0 {
+				archives, err := onprem.ListLoggingArchives(client)(storagePool, name)
+				if err != nil {
+					return err
+				}
+				for _, archive := range archives {
+					fmt.Println(archive)
+				}
+				return nil
+			}
+			if archive == archiveCurrent {
+				archive = onprem.GetLoggingVolumeName(name)
+			}
+			// print the archive
+			content, err := onprem.GetLoggingVolume(client)(storagePool, archive)
+			if err != nil {
+				return err
+			}
+			_, err = os.Stdout.WriteString(content)
+			return err
+		},
+	}
+}
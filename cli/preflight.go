@@ -0,0 +1,101 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package cli
+
+import (
+	"fmt"
+
+	A "github.com/IBM/fp-go/array"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	KeyNetwork = "network"
+)
+
+func formatPreflightCheck(check onprem.PreflightCheck) string {
+	result := "FAIL"
+	switch {
+	case check.Passed:
+		result = "PASS"
+	case check.Informational:
+		result = "WARN"
+	}
+	return fmt.Sprintf("%s\t%s\t%s", result, check.Name, check.Message)
+}
+
+// CreatePreflightCommand checks if a KVM host is able to run onprem VSIs
+func CreatePreflightCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "preflight",
+		Usage: "checks if a KVM host is able to run onprem VSIs",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     KeyConfig,
+				Aliases:  []string{"c"},
+				Usage:    "Name of the SSH config entry",
+				Required: true,
+			},
+			&cli.StringSliceFlag{
+				Name:        KeyStoragePool,
+				Aliases:     []string{"p"},
+				Usage:       "Name of a storage pool that must be active",
+				DefaultText: DefaultStoragePool,
+				Required:    false,
+			},
+			&cli.StringSliceFlag{
+				Name:        KeyNetwork,
+				Aliases:     []string{"w"},
+				Usage:       "Name of a network that must be active",
+				DefaultText: onprem.DefaultNetwork,
+				Required:    false,
+			},
+		},
+		Action: func(ctx *cli.Context) error {
+			// find SSH path
+			sshPath, err := onprem.GetSSHConfigPath()
+			if err != nil {
+				return err
+			}
+			// load config
+			sshConfig, err := onprem.LoadSSHConfig(sshPath)(ctx.String(KeyConfig))
+			if err != nil {
+				return err
+			}
+			client, err := onprem.CreateLivirtClient(sshConfig)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+			// run the checks
+			opt := onprem.BoxPreflightOptions(&onprem.HypervisorHostPreflightSpec{
+				StoragePools: ctx.StringSlice(KeyStoragePool),
+				Networks:     ctx.StringSlice(KeyNetwork),
+			})
+			_, checks, err := onprem.RunPreflight(client)(opt)
+			if err != nil {
+				return err
+			}
+			for _, check := range checks {
+				fmt.Println(formatPreflightCheck(check))
+			}
+			if failed := onprem.GetFailedPreflightChecks(checks); A.IsNonEmpty(failed) {
+				return fmt.Errorf("the host failed [%d] of [%d] pre-flight checks", len(failed), len(checks))
+			}
+			return nil
+		},
+	}
+}
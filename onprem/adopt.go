@@ -0,0 +1,215 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/google/uuid"
+	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+	"github.com/kdomanski/iso9660"
+	"libvirt.org/go/libvirtxml"
+)
+
+const (
+	// maximum size of a cloud init ISO that is inspected during adoption
+	maxCloudInitVolumeSize = 16 * 1024 * 1024
+	// prefix of the element that keeps the instance metadata
+	instanceMetadataKey = "hpcr"
+)
+
+var (
+	// ErrAdoptionRefused signals that an existing domain does not match the spec of the VSI that should adopt it
+	ErrAdoptionRefused = errors.New("adoption refused")
+)
+
+// ReadCloudInitUserData extracts the user data from a cloud init ISO file
+func ReadCloudInitUserData(isoData []byte) (string, error) {
+	img, err := iso9660.OpenImage(bytes.NewReader(isoData))
+	if err != nil {
+		return "", err
+	}
+	root, err := img.RootDir()
+	if err != nil {
+		return "", err
+	}
+	children, err := root.GetChildren()
+	if err != nil {
+		return "", err
+	}
+	for _, child := range children {
+		// the name might carry the version suffix of the ISO9660 file system
+		name := strings.ToLower(strings.TrimSuffix(child.Name(), ";1"))
+		if child.IsDir() || name != userDataFilename {
+			continue
+		}
+		data, err := io.ReadAll(child.Reader())
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	return "", fmt.Errorf("the cloud init image does not contain the file [%s]", userDataFilename)
+}
+
+// lookupDomainForAdoption locates the domain to adopt by name or UUID
+func lookupDomainForAdoption(conn *libvirt.Libvirt, spec *AdoptSpec) (libvirt.Domain, error) {
+	if spec.Name != "" {
+		return conn.DomainLookupByName(spec.Name)
+	}
+	id, err := uuid.Parse(spec.UUID)
+	if err != nil {
+		return libvirt.Domain{}, fmt.Errorf("%w: invalid UUID [%s], cause: [%v]", ErrAdoptionRefused, spec.UUID, err)
+	}
+	return conn.DomainLookupByUUID(libvirt.UUID(id))
+}
+
+// findCloudInitDisk returns the name of the volume of the cloud init disk of a domain that carries the expected user data
+func findCloudInitDisk(conn *libvirt.Libvirt) func(domainXML *libvirtxml.Domain, userData string) (string, bool) {
+	return func(domainXML *libvirtxml.Domain, userData string) (string, bool) {
+		if domainXML.Devices == nil {
+			return "", false
+		}
+		for _, disk := range domainXML.Devices.Disks {
+			// the cloud init disk is either a CD-ROM or attached at the device the operator uses
+			if disk.Source == nil || disk.Source.File == nil || disk.Target == nil {
+				continue
+			}
+			if disk.Device != "cdrom" && disk.Target.Dev != "vdb" {
+				continue
+			}
+			vol, err := conn.StorageVolLookupByPath(disk.Source.File.File)
+			if err != nil {
+				log.Printf("Unable to lookup the volume of disk [%s] of domain [%s], cause: [%v]", disk.Target.Dev, domainXML.Name, err)
+				continue
+			}
+			var buffer bytes.Buffer
+			err = conn.StorageVolDownload(vol, &buffer, 0, maxCloudInitVolumeSize, 0)
+			if err != nil {
+				log.Printf("Unable to download volume [%s] of domain [%s], cause: [%v]", vol.Name, domainXML.Name, err)
+				continue
+			}
+			data, err := ReadCloudInitUserData(buffer.Bytes())
+			if err != nil {
+				log.Printf("Volume [%s] of domain [%s] is not a cloud init disk, cause: [%v]", vol.Name, domainXML.Name, err)
+				continue
+			}
+			if data == userData {
+				return vol.Name, true
+			}
+			log.Printf("The user data on volume [%s] of domain [%s] does not match the contract", vol.Name, domainXML.Name)
+		}
+		return "", false
+	}
+}
+
+// getDomainNetworks returns the sorted names of the networks the interfaces of a domain are attached to
+func getDomainNetworks(domainXML *libvirtxml.Domain) []string {
+	var networks []string
+	if domainXML.Devices != nil {
+		for i := range domainXML.Devices.Interfaces {
+			networks = append(networks, getInterfaceNetwork(&domainXML.Devices.Interfaces[i]))
+		}
+	}
+	return sortNetwoks(networks)
+}
+
+// verifyAdoption checks that the disks, networks and contract of an existing domain match the spec
+func verifyAdoption(conn *libvirt.Libvirt) func(domainXML *libvirtxml.Domain, opt *InstanceOptions) error {
+	getAttachedDataDisks := getAttachedDataDisks(conn)
+	findCloudInitDisk := findCloudInitDisk(conn)
+
+	return func(domainXML *libvirtxml.Domain, opt *InstanceOptions) error {
+		name := domainXML.Name
+		// the contract
+		cidata, ok := findCloudInitDisk(domainXML, opt.UserData)
+		if !ok {
+			return fmt.Errorf("%w: domain [%s] does not have a cloud init disk with the contract of the VSI", ErrAdoptionRefused, name)
+		}
+		// the data disks
+		attached := getAttachedDataDisks(domainXML)
+		delete(attached, cidata)
+		for _, disk := range opt.DataDisks {
+			if _, ok := attached[disk.Name]; !ok {
+				return fmt.Errorf("%w: data disk [%s] is not attached to domain [%s]", ErrAdoptionRefused, disk.Name, name)
+			}
+			delete(attached, disk.Name)
+		}
+		if len(attached) > 0 {
+			return fmt.Errorf("%w: domain [%s] has disks that are not data disks of the VSI", ErrAdoptionRefused, name)
+		}
+		// the networks
+		expected := sortNetwoks(GetNetworks(opt))
+		actual := getDomainNetworks(domainXML)
+		if strings.Join(expected, ",") != strings.Join(actual, ",") {
+			return fmt.Errorf("%w: domain [%s] is attached to the networks %v, expected %v", ErrAdoptionRefused, name, actual, expected)
+		}
+		return nil
+	}
+}
+
+// AdoptInstance imports an existing domain into the management of the operator by writing the instance metadata,
+// the domain is neither restarted nor modified otherwise. The function returns the name of the adopted domain.
+func AdoptInstance(client *LivirtClient) func(spec *AdoptSpec, opt *InstanceOptions) (string, error) {
+	conn := client.LibVirt
+	verify := verifyAdoption(conn)
+
+	return func(spec *AdoptSpec, opt *InstanceOptions) (string, error) {
+		// log this config
+		defer CM.EntryExit(fmt.Sprintf("AdoptInstance(%s%s)", spec.Name, spec.UUID))()
+
+		dom, err := lookupDomainForAdoption(conn, spec)
+		if err != nil {
+			return "", err
+		}
+		desc, err := conn.DomainGetXMLDesc(dom, 0)
+		if err != nil {
+			return "", err
+		}
+		domainXML, err := parseDomainXML(desc)
+		if err != nil {
+			return "", err
+		}
+		// the adopted domain keeps its name
+		adopted := *opt
+		adopted.Name = dom.Name
+		adopted.Adopted = true
+		desired := createInstanceMetadata(&adopted)
+		// a domain that is already managed can only be adopted again by the same VSI
+		if domainXML.Metadata != nil && isManagedDomainXML(domainXML.Metadata.XML) {
+			if metadata, err := parseInstanceMetadata(domainXML.Metadata.XML); err == nil && metadata.Hash == desired.Hash && client.Fence.Permits(dom.Name, metadata, &opt.Owner) {
+				log.Printf("Domain [%s] has already been adopted.", dom.Name)
+				return dom.Name, nil
+			}
+			return "", fmt.Errorf("%w: domain [%s] is already managed by the operator", ErrAdoptionRefused, dom.Name)
+		}
+		if err := verify(domainXML, &adopted); err != nil {
+			return "", err
+		}
+		// write the metadata, to the running domain as well so no restart is required
+		if err := setInstanceMetadata(conn, dom, desired); err != nil {
+			log.Printf("Unable to write the metadata of domain [%s], cause: [%v]", dom.Name, err)
+			return "", err
+		}
+		log.Printf("Domain [%s] has been adopted.", dom.Name)
+		return dom.Name, nil
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"libvirt.org/go/libvirtxml"
+)
+
+func TestReadCloudInitUserData(t *testing.T) {
+	userData := "hyper-protect-basic.contract"
+	metaData, err := createMetaData(&InstanceOptions{Name: "vsi"})
+	require.NoError(t, err)
+	isoData, err := CreateCloudInit([]byte(userData), metaData, nil)
+	require.NoError(t, err)
+
+	data, err := ReadCloudInitUserData(isoData)
+	require.NoError(t, err)
+	assert.Equal(t, userData, data)
+}
+
+func TestReadCloudInitUserDataInvalid(t *testing.T) {
+	_, err := ReadCloudInitUserData([]byte("not an iso"))
+	assert.Error(t, err)
+}
+
+func TestDetectDriftOfAdoptedDomain(t *testing.T) {
+	opt := &InstanceOptions{Name: "legacy", Networks: []string{"net2", "net1"}, Adopted: true}
+	domain := createDesiredDomain(t, &InstanceOptions{Name: "other", Networks: []string{"net1", "net2"}})
+	// disks and mac addresses of an adopted domain do not follow the naming of the operator
+	assert.Empty(t, DetectDrift(opt, domain))
+
+	domain.Devices.Interfaces = domain.Devices.Interfaces[:1]
+	drift := DetectDrift(opt, domain)
+	require.Len(t, drift, 1)
+	assert.Equal(t, DomainDrift{Field: "interfaces", Expected: "net1,net2", Actual: "net1"}, drift[0])
+}
+
+func TestGetDomainNetworks(t *testing.T) {
+	domain := &libvirtxml.Domain{}
+	assert.Empty(t, getDomainNetworks(domain))
+	domain = createDesiredDomain(t, &InstanceOptions{Name: "vsi", Networks: []string{"b", "a"}})
+	assert.Equal(t, []string{"a", "b"}, getDomainNetworks(domain))
+}
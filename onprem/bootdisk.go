@@ -15,10 +15,12 @@
 package onprem
 
 import (
+	"io"
 	"log"
 	"net/http"
 	"time"
 
+	libvirt "github.com/digitalocean/go-libvirt"
 	"libvirt.org/go/libvirtxml"
 )
 
@@ -56,6 +58,7 @@ func CloneBootDisk(client *LivirtClient) func(storagePool string, existingVolume
 	conn := client.LibVirt
 	storageVolByNameXMLDesc := getStorageVolByNameXMLDesc(conn)
 	storageVolXMLDesc := getStorageVolXMLDesc(conn)
+	checkSpace := checkStoragePoolSpace(conn)
 
 	return func(storagePool string, existingVolumeXML *libvirtxml.StorageVolume, newName string) (*libvirtxml.StorageVolume, error) {
 		// some logging
@@ -85,6 +88,13 @@ func CloneBootDisk(client *LivirtClient) func(storagePool string, existingVolume
 			return nil, err
 		}
 
+		// the clone requires the space of the source volume
+		if size, ok := getVolumeSize(existingVolumeXML); ok {
+			if err := checkSpace(pool, size); err != nil {
+				return nil, err
+			}
+		}
+
 		// update the volume identifier
 		volumeDef := createDefaultVolume()
 		volumeDef.Name = newName
@@ -125,6 +135,7 @@ func UploadBootDisk(client *LivirtClient) func(storagePool, name, url string) (*
 	conn := client.LibVirt
 	// hooks
 	storageVolXMLDesc := getStorageVolByNameXMLDesc(conn)
+	uploadVolume := uploadVolumeFromURL(conn)
 	return func(storagePool, name, url string) (*libvirtxml.StorageVolume, error) {
 		// some logging
 		log.Printf("Make boot disk [%s] available on pool [%s] ...", name, storagePool)
@@ -152,13 +163,34 @@ func UploadBootDisk(client *LivirtClient) func(storagePool, name, url string) (*
 		if err != nil {
 			return nil, err
 		}
+		// transfer the image
+		err = uploadVolume(pool, name, url, io.Discard)
+		if err != nil {
+			return nil, err
+		}
+		// refresh the description
+		return storageVolXMLDesc(pool, name)
+	}
+}
+
+// uploadVolumeFromURL creates a qcow2 volume from the content of a URL, the content is also written to the given
+// writer, e.g. to compute its digest
+func uploadVolumeFromURL(conn *libvirt.Libvirt) func(pool libvirt.StoragePool, name, url string, w io.Writer) error {
+	checkSpace := checkStoragePoolSpace(conn)
+
+	return func(pool libvirt.StoragePool, name, url string, w io.Writer) error {
 		// get some metadata
 		resp, err := http.Get(url) // #nosec G107 - we do want the URL to come from config
 		if err != nil {
-			return nil, err
+			return err
 		}
 		defer safeClose(resp.Body)
 		size := uint64(resp.ContentLength)
+		// make sure the image fits
+		err = checkSpace(pool, size)
+		if err != nil {
+			return err
+		}
 		// update the volume identifier
 		volumeDef := createDefaultVolume()
 		volumeDef.Name = name
@@ -168,33 +200,27 @@ func UploadBootDisk(client *LivirtClient) func(storagePool, name, url string) (*
 
 		volumeDefXML, err := XMLMarshall(volumeDef)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		// create the volume
 		volume, err := conn.StorageVolCreateXML(pool, string(volumeDefXML), 0)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		t0 := time.Now()
 		log.Printf("Starting upload of [%s] to pool [%s], size=[%d bytes]...", url, pool.Name, size)
 
-		err = conn.StorageVolUpload(volume, createReaderWithLog(resp.Body, size), 0, size, 0)
+		err = conn.StorageVolUpload(volume, createReaderWithLog(io.TeeReader(resp.Body, w), size), 0, size, 0)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		t1 := time.Now()
 		log.Printf("Upload of [%s] to pool [%s] done in [%f s].", url, pool.Name, t1.Sub(t0).Seconds())
 
 		// Refresh the pool
-		err = refreshPool(conn)(pool)
-		if err != nil {
-			return nil, err
-		}
-
-		// refresh the description
-		return storageVolXMLDesc(pool, name)
+		return refreshPool(conn)(pool)
 	}
 }
 
@@ -21,6 +21,7 @@ import (
 
 	"github.com/kdomanski/iso9660"
 	"libvirt.org/go/libvirtxml"
+	"sigs.k8s.io/yaml"
 )
 
 type CloudInit struct {
@@ -63,8 +64,32 @@ func CreateCloudInitDisk(client *LivirtClient) func(key string) (*libvirtxml.Dom
 	}
 }
 
-// CreateCloudInit produces a cloud init ISO file as a data blob with a userdata and a metadata section
-func CreateCloudInit(userData, metaData []byte) ([]byte, error) {
+type cloudInitMetaData struct {
+	InstanceID    string   `json:"instance-id"`
+	LocalHostname string   `json:"local-hostname"`
+	PublicKeys    []string `json:"public-keys,omitempty"`
+}
+
+// getInstanceID returns the cloud-init instance identifier, it is stable across the recreations of the domain of a VSI
+func getInstanceID(opt *InstanceOptions) string {
+	if len(opt.Owner.UID) > 0 {
+		return opt.Owner.UID
+	}
+	return opt.Name
+}
+
+// createMetaData produces the meta-data document of an instance
+func createMetaData(opt *InstanceOptions) ([]byte, error) {
+	return yaml.Marshal(cloudInitMetaData{
+		InstanceID:    getInstanceID(opt),
+		LocalHostname: opt.Name,
+		PublicKeys:    opt.PublicKeys,
+	})
+}
+
+// CreateCloudInit produces a cloud init ISO file as a data blob with a userdata and a metadata section, the
+// network-config section is only added if a network config is given
+func CreateCloudInit(userData, metaData, networkConfig []byte) ([]byte, error) {
 	writer, err := iso9660.NewWriter()
 	if err != nil {
 		return nil, err
@@ -86,6 +111,13 @@ func CreateCloudInit(userData, metaData []byte) ([]byte, error) {
 		return nil, err
 	}
 
+	if networkConfig != nil {
+		err = writer.AddFile(bytes.NewReader(networkConfig), networkConfigFilename)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	var buf bytes.Buffer
 
 	err = writer.WriteTo(&buf, ciDataVolumeName)
@@ -37,7 +37,7 @@ func TestCloudInit(t *testing.T) {
 
 	os.Remove(path)
 
-	isoData, err := CreateCloudInit(userDataContent, metaDataContent)
+	isoData, err := CreateCloudInit(userDataContent, metaDataContent, nil)
 	require.NoError(t, err)
 
 	err = os.WriteFile(path, isoData, os.ModePerm)
@@ -80,7 +80,7 @@ func TestCloudInitUpload(t *testing.T) {
 	userDataContent := []byte("userdata1")
 	metaDataContent := []byte("metadata2")
 
-	isoData, err := CreateCloudInit(userDataContent, metaDataContent)
+	isoData, err := CreateCloudInit(userDataContent, metaDataContent, nil)
 	require.NoError(t, err)
 
 	client, err := CreateLivirtClient(config)
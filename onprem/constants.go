@@ -17,22 +17,152 @@ package onprem
 const (
 	DefaultStoragePool  = "default"
 	DefaultDataDiskSize = uint64(100 * 1024 * 1024 * 1024)
+	// forward mode of managed networks
+	DefaultNetworkMode = NetworkModeNAT
+	// type of managed storage pools
+	DefaultStoragePoolType = StoragePoolTypeDir
+	// percentage of used capacity of a storage pool that triggers a warning
+	DefaultCapacityWarningThreshold = 80
+	// number of console logs of previous boots to retain per VSI
+	DefaultConsoleLogRetention = 3
+	// what happens to the volume of a deleted data disk
+	DefaultReclaimPolicy = ReclaimPolicyDelete
+	// format of data disk volumes
+	DefaultVolumeFormat = VolumeFormatQCOW2
+	// allocation of data disk volumes
+	DefaultProvisioning = ProvisioningThin
+	// memory of a VSI in bytes
+	InstanceMemory = uint64(4 * 1024 * 1024 * 1024)
+	// number of virtual CPUs of a VSI
+	InstanceCPUs = 2
+	// timeout of a single probe attempt
+	DefaultProbeTimeoutSeconds = 1
+	// time all probes of a VSI may take per sync, the sync holds the lock of the controller meanwhile
+	MaxProbeDurationSeconds = 5
+	// consecutive failures of a probe before it is considered failed
+	DefaultProbeFailureThreshold = 3
+	// what happens to a VSI that failed its liveness probe
+	DefaultProbeFailurePolicy = ProbeFailurePolicyNone
+	// time a VSI gets to power off gracefully before it is destroyed
+	DefaultTerminationGracePeriodSeconds = 100
+	// what happens to a VSI whose domain has been modified outside of the operator
+	DefaultDriftPolicy = DriftPolicyAlert
+	// time an orphaned libvirt object is kept before the garbage collector deletes it
+	DefaultGarbageCollectionGracePeriodSeconds = 24 * 60 * 60
+	// requested power state of a VSI
+	DefaultPowerState = PowerStateRunning
+	// Secure Execution of a VSI
+	DefaultSecureExecution = SecureExecutionAuto
+	// addressing of the interfaces of VSIs configured via cloud-init
+	DefaultAddressing = AddressingDHCP
+	// template of the names of the domains of VSIs
+	DefaultNamingScheme = NamingPlaceholderCluster + "-" + NamingPlaceholderNamespace + "-" + NamingPlaceholderName
+	// estimated space of the boot disk and the cloud-init disk of a VSI in bytes
+	InstanceStorage = uint64(10 * 1024 * 1024 * 1024)
 
-	userDataFilename   = "user-data"
-	metaDataFilename   = "meta-data"
-	vendorDataFilename = "vendor-data"
-	ciDataVolumeName   = "cidata"
+	userDataFilename      = "user-data"
+	metaDataFilename      = "meta-data"
+	vendorDataFilename    = "vendor-data"
+	networkConfigFilename = "network-config"
+	ciDataVolumeName      = "cidata"
 
 	APIVersion      = "hpse.ibm.com/v1"
 	KindVSI         = "HyperProtectContainerRuntimeOnPrem"
 	KindDataDisk    = "HyperProtectContainerRuntimeOnPremDataDisk"
 	KindDataDiskRef = "HyperProtectContainerRuntimeOnPremDataDiskRef"
 	KindNetworkRef  = "HyperProtectContainerRuntimeOnPremNetworkRef"
+	KindNetwork     = "HyperProtectContainerRuntimeOnPremNetwork"
+	KindStoragePool = "HyperProtectContainerRuntimeOnPremStoragePool"
+	KindSnapshot    = "HyperProtectContainerRuntimeOnPremDataDiskSnapshot"
+	KindRestore     = "HyperProtectContainerRuntimeOnPremDataDiskRestore"
+	KindHost        = "HyperProtectContainerRuntimeOnPremHypervisorHost"
+	KindGC          = "HyperProtectContainerRuntimeOnPremGarbageCollector"
+	KindImage       = "HyperProtectContainerRuntimeOnPremImage"
 
 	ResourceNameDataDisks    = "onprem-datadisks"
 	ResourceNameDataDiskRefs = "onprem-datadiskrefs"
 	ResourceNameNetworkRefs  = "onprem-networkrefs"
+	ResourceNameNetworks     = "onprem-networks"
+	ResourceNameStoragePools = "onprem-storagepools"
+	ResourceNameSnapshots    = "onprem-datadisksnapshots"
+	ResourceNameRestores     = "onprem-datadiskrestores"
+	ResourceNameHosts        = "onprem-hypervisorhosts"
+	ResourceNameGCs          = "onprem-garbagecollectors"
+	ResourceNameImages       = "onprem-images"
 	ResourceNameVSIs         = "onprem-hpcrs"
 
 	NeedResults = int32(1)
 )
+
+const (
+	// forward modes of managed networks
+	NetworkModeNAT      = "nat"
+	NetworkModeRoute    = "route"
+	NetworkModeIsolated = "isolated"
+	NetworkModeBridge   = "bridge"
+)
+
+const (
+	// addressing of the interfaces of VSIs attached to a network reference
+	AddressingDHCP   = "DHCP"
+	AddressingStatic = "Static"
+)
+
+const (
+	// reclaim policies of data disks
+	ReclaimPolicyDelete   = "Delete"
+	ReclaimPolicyRetain   = "Retain"
+	ReclaimPolicySnapshot = "Snapshot"
+)
+
+const (
+	// reactions to a failed liveness probe
+	ProbeFailurePolicyNone     = "None"
+	ProbeFailurePolicyRestart  = "Restart"
+	ProbeFailurePolicyRecreate = "Recreate"
+)
+
+const (
+	// reactions to a domain that has been modified outside of the operator
+	DriftPolicyAlert    = "Alert"
+	DriftPolicyRepair   = "Repair"
+	DriftPolicyRecreate = "Recreate"
+
+	// the VSI runs as a Secure Execution guest if the host supports it
+	SecureExecutionAuto = "Auto"
+	// the VSI only starts on hosts that support Secure Execution
+	SecureExecutionRequired = "Required"
+)
+
+const (
+	// requested power states of a VSI
+	PowerStateRunning = "Running"
+	PowerStateStopped = "Stopped"
+	PowerStatePaused  = "Paused"
+)
+
+const (
+	// schemes of HTTP probes
+	ProbeSchemeHTTP  = "HTTP"
+	ProbeSchemeHTTPS = "HTTPS"
+)
+
+const (
+	// formats of data disk volumes
+	VolumeFormatQCOW2 = "qcow2"
+	VolumeFormatRaw   = "raw"
+)
+
+const (
+	// allocation of data disk volumes
+	ProvisioningThin         = "thin"
+	ProvisioningPreallocated = "preallocated"
+)
+
+const (
+	// types of managed storage pools
+	StoragePoolTypeDir     = "dir"
+	StoragePoolTypeLogical = "logical"
+	StoragePoolTypeNetFS   = "netfs"
+	StoragePoolTypeISCSI   = "iscsi"
+)
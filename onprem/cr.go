@@ -30,6 +30,10 @@ type OnPremCustomResourceSpec struct {
 	Contract string `json:"contract"`
 	// URL to the service that serves the base qcow2 image
 	ImageURL string `json:"imageURL"`
+	// digest of the base qcow2 image in the form `sha256:<hex>`, identifies the image in the base image cache
+	ImageDigest string `json:"imageDigest,omitempty"`
+	// name of a HyperProtectContainerRuntimeOnPremImage in the same namespace, replaces imageURL and imageDigest
+	Image string `json:"image,omitempty"`
 	// name of the storage pool, must exist and must be large enough
 	StoragePool string `json:"storagePool"`
 	// specification of the associated config maps
@@ -38,6 +42,77 @@ type OnPremCustomResourceSpec struct {
 	DiskSelector *metav1.LabelSelector `json:"diskSelector"`
 	// specification of the associated networks
 	NetworkSelector *metav1.LabelSelector `json:"networkSelector"`
+	// number of console logs of previous boots to retain, defaults to 3
+	ConsoleLogRetention int `json:"consoleLogRetention,omitempty"`
+	// specification of the hypervisor hosts the VSI may be scheduled on, the VSI runs on the host selected
+	// by the target selector if not set
+	HostSelector *metav1.LabelSelector `json:"hostSelector,omitempty"`
+	// specification of the VSIs that must not run on the same hypervisor host
+	AntiAffinitySelector *metav1.LabelSelector `json:"antiAffinitySelector,omitempty"`
+	// probe that decides if the started VSI is ready to serve
+	ReadinessProbe *ProbeSpec `json:"readinessProbe,omitempty"`
+	// probe that decides if the started VSI is still alive
+	LivenessProbe *LivenessProbeSpec `json:"livenessProbe,omitempty"`
+	// requested power state of the VSI, one of Running, Stopped or Paused, defaults to Running
+	PowerState string `json:"powerState,omitempty"`
+	// time in seconds the VSI gets to power off gracefully before it is destroyed, defaults to 100
+	TerminationGracePeriodSeconds *int `json:"terminationGracePeriodSeconds,omitempty"`
+	// what happens if the domain has been modified outside of the operator, one of Alert, Repair or Recreate,
+	// defaults to Alert
+	DriftPolicy string `json:"driftPolicy,omitempty"`
+	// imports an existing libvirt domain instead of creating a new one
+	Adopt *AdoptSpec `json:"adopt,omitempty"`
+	// one of Auto or Required, Auto runs the VSI as a Secure Execution guest if the host supports it, defaults to Auto
+	SecureExecution string `json:"secureExecution,omitempty"`
+	// public SSH keys passed to the VSI via the cloud-init metadata
+	PublicKeys []string `json:"publicKeys,omitempty"`
+}
+
+type AdoptSpec struct {
+	// name of the libvirt domain to adopt
+	Name string `json:"name,omitempty"`
+	// UUID of the libvirt domain to adopt, used if no name is given
+	UUID string `json:"uuid,omitempty"`
+}
+
+type TCPSocketProbeSpec struct {
+	// port to connect to
+	Port int `json:"port"`
+}
+
+type HTTPGetProbeSpec struct {
+	// port of the HTTP server
+	Port int `json:"port"`
+	// path of the request, defaults to `/`
+	Path string `json:"path,omitempty"`
+	// one of `HTTP` (default) or `HTTPS`, certificates are not verified
+	Scheme string `json:"scheme,omitempty"`
+}
+
+type ProbeSpec struct {
+	// connects to a TCP port of the VSI
+	TCPSocket *TCPSocketProbeSpec `json:"tcpSocket,omitempty"`
+	// sends an HTTP GET request to the VSI, status codes from 200 to 399 signal success
+	HTTPGet *HTTPGetProbeSpec `json:"httpGet,omitempty"`
+	// HPL tokens that must appear in the console log, e.g. `HPL10001I`
+	LogTokens []string `json:"logTokens,omitempty"`
+	// timeout of a single connection attempt, defaults to 1
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+	// number of consecutive failures before the probe is considered failed, defaults to 3
+	FailureThreshold int `json:"failureThreshold,omitempty"`
+}
+
+type LivenessProbeSpec struct {
+	ProbeSpec `json:",inline"`
+	// what happens once the probe failed, one of `None` (default), `Restart` or `Recreate`
+	FailurePolicy string `json:"failurePolicy,omitempty"`
+}
+
+type DataDiskRestoreSource struct {
+	// name of the storage pool holding the snapshot volume
+	StoragePool string `json:"storagePool"`
+	// name of the snapshot volume
+	VolumeName string `json:"volumeName"`
 }
 
 type DataDiskCustomResourceSpec struct {
@@ -45,6 +120,53 @@ type DataDiskCustomResourceSpec struct {
 	Size uint64 `json:"size"`
 	// name of the storage pool, must exist and must be large enough
 	StoragePool string `json:"storagePool"`
+	// snapshot volume to initialize the data disk from when it is created
+	RestoreFrom *DataDiskRestoreSource `json:"restoreFrom,omitempty"`
+	// what happens to the volume when the data disk is deleted, one of `Delete` (default), `Retain` or `Snapshot`
+	ReclaimPolicy string `json:"reclaimPolicy,omitempty"`
+	// format of the volume, one of `qcow2` (default) or `raw`
+	Format string `json:"format,omitempty"`
+	// allocation of the volume, one of `thin` (default) or `preallocated`
+	Provisioning string `json:"provisioning,omitempty"`
+	// cache mode of the disk driver, e.g. `none` or `writeback`, defaults to the hypervisor default
+	Cache string `json:"cache,omitempty"`
+	// IO mode of the disk driver, e.g. `native` or `threads`, defaults to the hypervisor default
+	IO string `json:"io,omitempty"`
+	// discard mode of the disk driver, `unmap` or `ignore`, defaults to the hypervisor default
+	Discard string `json:"discard,omitempty"`
+	// specification of the associated config maps
+	TargetSelector *metav1.LabelSelector `json:"targetSelector"`
+}
+
+type DataDiskSnapshotExportSpec struct {
+	// URL of the S3 compatible endpoint or a `file://` URL of a directory
+	Endpoint string `json:"endpoint"`
+	// name of the bucket, not used for `file://` endpoints
+	Bucket string `json:"bucket,omitempty"`
+	// region used to sign requests, defaults to `us-east-1`
+	Region string `json:"region,omitempty"`
+	// object key, defaults to the name of the snapshot volume
+	Key string `json:"key,omitempty"`
+}
+
+type DataDiskSnapshotCustomResourceSpec struct {
+	// specification of the data disk to snapshot, must select exactly one data disk
+	DiskSelector *metav1.LabelSelector `json:"diskSelector"`
+	// number of snapshots of the data disk to retain, 0 retains all snapshots
+	Retention int `json:"retention,omitempty"`
+	// optional export of the snapshot volume
+	Export *DataDiskSnapshotExportSpec `json:"export,omitempty"`
+	// specification of the associated config maps
+	TargetSelector *metav1.LabelSelector `json:"targetSelector"`
+}
+
+type DataDiskRestoreCustomResourceSpec struct {
+	// specification of the snapshot to restore, must select exactly one snapshot
+	SnapshotSelector *metav1.LabelSelector `json:"snapshotSelector"`
+	// name of the storage pool of the restored data disk, defaults to the pool of the snapshot
+	StoragePool string `json:"storagePool,omitempty"`
+	// labels of the restored data disk, VSIs select the disk by these labels
+	DiskLabels map[string]string `json:"diskLabels,omitempty"`
 	// specification of the associated config maps
 	TargetSelector *metav1.LabelSelector `json:"targetSelector"`
 }
@@ -58,9 +180,94 @@ type DataDiskRefCustomResourceSpec struct {
 	TargetSelector *metav1.LabelSelector `json:"targetSelector"`
 }
 
+type StaticIPReservation struct {
+	// name of the HyperProtectContainerRuntimeOnPrem resource the IP address is reserved for
+	Name string `json:"name"`
+	// the reserved IP address, must be part of a DHCP enabled subnet of the network
+	IP string `json:"ip"`
+}
+
+type NetworkConfigSpec struct {
+	// one of `DHCP` (default) or `Static`, `Static` configures the reserved IP address in the VSI
+	Addressing string `json:"addressing,omitempty"`
+	// subnet of the static IP addresses in CIDR notation, required for `Static` addressing
+	Subnet string `json:"subnet,omitempty"`
+	// default gateway of VSIs with static IP addresses
+	Gateway string `json:"gateway,omitempty"`
+	// IP addresses of the DNS servers
+	Nameservers []string `json:"nameservers,omitempty"`
+}
+
 type NetworkRefCustomResourceSpec struct {
 	// name of the network, must exist
 	NetworkName string `json:"networkName"`
+	// static IP addresses reserved for VSIs attached to the network
+	StaticIPs []StaticIPReservation `json:"staticIPs,omitempty"`
+	// configuration of the interfaces of attached VSIs passed via the cloud-init network-config
+	NetworkConfig *NetworkConfigSpec `json:"networkConfig,omitempty"`
+	// specification of the associated config maps
+	TargetSelector *metav1.LabelSelector `json:"targetSelector"`
+}
+
+type NetworkDHCPSpec struct {
+	// disables DHCP on the network
+	Disabled bool `json:"disabled,omitempty"`
+	// first address of the DHCP range, defaults to the second host address of the subnet
+	Start string `json:"start,omitempty"`
+	// last address of the DHCP range, defaults to the last host address of the subnet
+	End string `json:"end,omitempty"`
+}
+
+type NetworkDNSHostSpec struct {
+	// IP address of the host
+	IP string `json:"ip"`
+	// hostnames resolving to the IP address
+	Hostnames []string `json:"hostnames"`
+}
+
+type NetworkCustomResourceSpec struct {
+	// forward mode of the network, one of `nat`, `route`, `isolated` or `bridge`, defaults to `nat`
+	Mode string `json:"mode,omitempty"`
+	// for `nat` and `route` the optional host interface to forward to, for `bridge` the existing host bridge to attach to
+	Interface string `json:"interface,omitempty"`
+	// IPv4 subnet in CIDR notation, the first host address is assigned to the host, not used for `bridge`
+	Subnet string `json:"subnet,omitempty"`
+	// DHCP configuration, by default DHCP serves the full subnet
+	DHCP *NetworkDHCPSpec `json:"dhcp,omitempty"`
+	// static DNS entries
+	DNSHosts []NetworkDNSHostSpec `json:"dnsHosts,omitempty"`
+	// MTU of the network, defaults to the libvirt default
+	MTU uint `json:"mtu,omitempty"`
+	// specification of the associated config maps
+	TargetSelector *metav1.LabelSelector `json:"targetSelector"`
+}
+
+type StoragePoolSourceSpec struct {
+	// host name of the NFS server (netfs) or of the iSCSI portal (iscsi)
+	Host string `json:"host,omitempty"`
+	// exported directory of the NFS server (netfs)
+	Dir string `json:"dir,omitempty"`
+	// IQN of the iSCSI target (iscsi)
+	IQN string `json:"iqn,omitempty"`
+	// name of the volume group (logical), defaults to the name of the pool
+	Name string `json:"name,omitempty"`
+	// physical devices backing the volume group (logical)
+	Devices []string `json:"devices,omitempty"`
+	// format of the source, e.g. `nfs` (netfs) or `lvm2` (logical)
+	Format string `json:"format,omitempty"`
+}
+
+type StoragePoolCustomResourceSpec struct {
+	// name of the libvirt storage pool, defaults to the name of the custom resource
+	Name string `json:"name,omitempty"`
+	// type of the pool, one of `dir`, `logical`, `netfs` or `iscsi`, defaults to `dir`
+	Type string `json:"type,omitempty"`
+	// target path of the pool, e.g. the directory (dir) or the mount point (netfs)
+	Path string `json:"path,omitempty"`
+	// source of the pool, not used for `dir`
+	Source *StoragePoolSourceSpec `json:"source,omitempty"`
+	// percentage of used capacity that triggers a warning, defaults to 80
+	CapacityWarningThreshold int `json:"capacityWarningThreshold,omitempty"`
 	// specification of the associated config maps
 	TargetSelector *metav1.LabelSelector `json:"targetSelector"`
 }
@@ -86,6 +293,81 @@ type NetworkRefStatus struct {
 	Status int `json:"status"`
 }
 
+type DataDiskSnapshotStatus struct {
+	// description of the snapshot status
+	Description string `json:"description"`
+	// the status flag
+	Status int `json:"status"`
+	// details of the snapshot
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+type DataDiskRestoreStatus struct {
+	// description of the restore status
+	Description string `json:"description"`
+	// the status flag
+	Status int `json:"status"`
+	// details of the restored data disk
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+type NetworkStatus struct {
+	// description of the network status
+	Description string `json:"description"`
+	// the status flag
+	Status int `json:"status"`
+}
+
+type HypervisorHostCapacitySpec struct {
+	// number of virtual CPUs that may be allocated on the host, defaults to the number of physical CPUs
+	CPUs int `json:"cpus,omitempty"`
+	// memory in bytes that may be allocated on the host, defaults to the physical memory
+	Memory uint64 `json:"memory,omitempty"`
+}
+
+type HypervisorHostPreflightSpec struct {
+	// storage pools that must be active on the host, defaults to `default`
+	StoragePools []string `json:"storagePools,omitempty"`
+	// networks that must be active on the host, defaults to `default`
+	Networks []string `json:"networks,omitempty"`
+}
+
+type HypervisorHostCustomResourceSpec struct {
+	// optional limits of the resources allocated to VSIs on the host
+	Capacity *HypervisorHostCapacitySpec `json:"capacity,omitempty"`
+	// libvirt objects checked before VSIs are scheduled on the host
+	Preflight *HypervisorHostPreflightSpec `json:"preflight,omitempty"`
+	// specification of the associated config maps, these carry the SSH configuration of the host
+	TargetSelector *metav1.LabelSelector `json:"targetSelector"`
+}
+
+type OnPremStatus struct {
+	// description of the VSI status
+	Description string `json:"description"`
+	// the status flag
+	Status int `json:"status"`
+	// details of the VSI
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+type HypervisorHostStatus struct {
+	// description of the hypervisor host status
+	Description string `json:"description"`
+	// the status flag
+	Status int `json:"status"`
+	// resources of the host
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+type StoragePoolStatus struct {
+	// description of the storage pool status
+	Description string `json:"description"`
+	// the status flag
+	Status int `json:"status"`
+	// details of the storage pool
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
 type OnPremCustomResource struct {
 	metav1.TypeMeta `json:",inline"`
 	// Standard object's metadata.
@@ -97,6 +379,9 @@ type OnPremCustomResource struct {
 	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#spec-and-status
 	// +optional
 	Spec OnPremCustomResourceSpec `json:"spec,omitempty" protobuf:"bytes,2,opt,name=spec"`
+
+	// status of this custom resource
+	Status OnPremStatus `json:"status,omitempty"`
 }
 
 type DataDiskCustomResource struct {
@@ -147,6 +432,86 @@ type NetworkRefCustomResource struct {
 	Status NetworkRefStatus `json:"status,omitempty"`
 }
 
+type DataDiskSnapshotCustomResource struct {
+	metav1.TypeMeta `json:",inline"`
+	// Standard object's metadata.
+	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#metadata
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
+
+	// Specification of the desired behavior of the pod.
+	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#spec-and-status
+	// +optional
+	Spec DataDiskSnapshotCustomResourceSpec `json:"spec,omitempty" protobuf:"bytes,2,opt,name=spec"`
+
+	// status of this custom resource
+	Status DataDiskSnapshotStatus `json:"status,omitempty"`
+}
+
+type DataDiskRestoreCustomResource struct {
+	metav1.TypeMeta `json:",inline"`
+	// Standard object's metadata.
+	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#metadata
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
+
+	// Specification of the desired behavior of the pod.
+	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#spec-and-status
+	// +optional
+	Spec DataDiskRestoreCustomResourceSpec `json:"spec,omitempty" protobuf:"bytes,2,opt,name=spec"`
+
+	// status of this custom resource
+	Status DataDiskRestoreStatus `json:"status,omitempty"`
+}
+
+type NetworkCustomResource struct {
+	metav1.TypeMeta `json:",inline"`
+	// Standard object's metadata.
+	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#metadata
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
+
+	// Specification of the desired behavior of the pod.
+	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#spec-and-status
+	// +optional
+	Spec NetworkCustomResourceSpec `json:"spec,omitempty" protobuf:"bytes,2,opt,name=spec"`
+
+	// status of this custom resource
+	Status NetworkStatus `json:"status,omitempty"`
+}
+
+type StoragePoolCustomResource struct {
+	metav1.TypeMeta `json:",inline"`
+	// Standard object's metadata.
+	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#metadata
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
+
+	// Specification of the desired behavior of the pod.
+	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#spec-and-status
+	// +optional
+	Spec StoragePoolCustomResourceSpec `json:"spec,omitempty" protobuf:"bytes,2,opt,name=spec"`
+
+	// status of this custom resource
+	Status StoragePoolStatus `json:"status,omitempty"`
+}
+
+type HypervisorHostCustomResource struct {
+	metav1.TypeMeta `json:",inline"`
+	// Standard object's metadata.
+	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#metadata
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
+
+	// Specification of the desired behavior of the pod.
+	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#spec-and-status
+	// +optional
+	Spec HypervisorHostCustomResourceSpec `json:"spec,omitempty" protobuf:"bytes,2,opt,name=spec"`
+
+	// status of this custom resource
+	Status HypervisorHostStatus `json:"status,omitempty"`
+}
+
 type OnPremCustomResourceOptions struct {
 	// name of the instance, will also be the hostname
 	Name string
@@ -242,3 +607,79 @@ func CreateCustomResourceFromEnv(envMap env.Environment) func(opt *OnPremCustomR
 		)
 	}
 }
+
+type GarbageCollectorCustomResourceSpec struct {
+	// specification of the associated config maps, these carry the SSH configuration of the host
+	TargetSelector *metav1.LabelSelector `json:"targetSelector"`
+	// names of the storage pools to scan for orphaned volumes, defaults to the default storage pool
+	StoragePools []string `json:"storagePools,omitempty"`
+	// delete orphans after the grace period, orphans are only reported if not set
+	DeleteOrphans bool `json:"deleteOrphans,omitempty"`
+	// time in seconds an orphan is kept before it is deleted, defaults to one day
+	GracePeriodSeconds *int `json:"gracePeriodSeconds,omitempty"`
+}
+
+type GarbageCollectorStatus struct {
+	// description of the garbage collector status
+	Description string `json:"description"`
+	// the status flag
+	Status int `json:"status"`
+	// orphans and known base images
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+type GarbageCollectorCustomResource struct {
+	metav1.TypeMeta `json:",inline"`
+	// Standard object's metadata.
+	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#metadata
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
+
+	// Specification of the desired behavior of the pod.
+	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#spec-and-status
+	// +optional
+	Spec GarbageCollectorCustomResourceSpec `json:"spec,omitempty" protobuf:"bytes,2,opt,name=spec"`
+
+	// status of this custom resource
+	Status GarbageCollectorStatus `json:"status,omitempty"`
+}
+
+type ImageCustomResourceSpec struct {
+	// URL to the service that serves the base qcow2 image
+	ImageURL string `json:"imageURL"`
+	// digest of the base qcow2 image in the form `sha256:<hex>`
+	ImageDigest string `json:"imageDigest,omitempty"`
+	// version of the image, informational
+	Version string `json:"version,omitempty"`
+	// specification of the hypervisor hosts to stage the image on
+	HostSelector *metav1.LabelSelector `json:"hostSelector"`
+	// names of the storage pools to stage the image in, defaults to the default storage pool
+	StoragePools []string `json:"storagePools,omitempty"`
+	// removes the staged image from the hosts once it backs no boot disk, VSIs can no longer reference the image
+	Retired bool `json:"retired,omitempty"`
+}
+
+type ImageStatus struct {
+	// description of the image status
+	Description string `json:"description"`
+	// the status flag
+	Status int `json:"status"`
+	// state of the image on the hosts
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+type ImageCustomResource struct {
+	metav1.TypeMeta `json:",inline"`
+	// Standard object's metadata.
+	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#metadata
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
+
+	// Specification of the desired behavior of the pod.
+	// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#spec-and-status
+	// +optional
+	Spec ImageCustomResourceSpec `json:"spec,omitempty" protobuf:"bytes,2,opt,name=spec"`
+
+	// status of this custom resource
+	Status ImageStatus `json:"status,omitempty"`
+}
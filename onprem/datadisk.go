@@ -15,6 +15,7 @@
 package onprem
 
 import (
+	"errors"
 	"fmt"
 	"log"
 
@@ -24,7 +25,17 @@ import (
 	"libvirt.org/go/libvirtxml"
 )
 
+const (
+	// data disks are allocated in full sectors
+	dataDiskSectorSize = uint64(512)
+	// space a thin volume allocates when it is created, e.g. for the qcow2 header and tables
+	thinDataDiskAllocation = uint64(16 << 20)
+)
+
 var (
+	// ErrShrinkRefused signals that the requested size of a data disk is smaller than its capacity
+	ErrShrinkRefused = errors.New("shrinking a data disk is not supported")
+
 	// full identifier of the disk config entry
 	KeyDiskConfig = fmt.Sprintf("%s.%s", KindDataDisk, APIVersion)
 	// full identifier of the disk ref config entry
@@ -36,20 +47,92 @@ func RemoveDataDisk(client *LivirtClient) func(key string) error {
 	return deleteVolumeByKey(client)
 }
 
-// CreateDataDisk creates a data disk or resizes an existing one if required
-func CreateDataDisk(client *LivirtClient) func(storagePool, name string, size uint64) (*libvirt.StorageVol, error) {
+// GetDataDiskCapacity returns the capacity of a volume of the requested size, the capacity is rounded up to full sectors
+func GetDataDiskCapacity(size uint64) uint64 {
+	return (size + dataDiskSectorSize - 1) / dataDiskSectorSize * dataDiskSectorSize
+}
+
+// resizeDataDisk grows a volume, the guest of a running domain is notified about the new size
+func resizeDataDisk(conn *libvirt.Libvirt) func(vol libvirt.StorageVol, size uint64) error {
+	volumeAttachments := getVolumeAttachments(conn)
+
+	return func(vol libvirt.StorageVol, size uint64) error {
+		path, err := conn.StorageVolGetPath(vol)
+		if err != nil {
+			return err
+		}
+		attachments, err := volumeAttachments(path, libvirt.ConnectListDomainsActive)
+		if err != nil {
+			return err
+		}
+		if A.IsNonEmpty(attachments) {
+			attachment := attachments[0]
+			domain, err := conn.DomainLookupByName(attachment.Domain)
+			if err != nil {
+				return err
+			}
+			log.Printf("Resizing device [%s] of running domain [%s] to [%d] ...", attachment.Device, attachment.Domain, size)
+			return conn.DomainBlockResize(domain, attachment.Device, size, libvirt.DomainBlockResizeBytes)
+		}
+		return conn.StorageVolResize(vol, size, 0)
+	}
+}
+
+// CreateDataDiskVolumeDef produces the volume definition of a new data disk
+func CreateDataDiskVolumeDef(opt *DataDiskOptions) (*libvirtxml.StorageVolume, libvirt.StorageVolCreateFlags, error) {
+	format := BoxVolumeFormat(opt.Format)
+	if format != VolumeFormatQCOW2 && format != VolumeFormatRaw {
+		return nil, 0, fmt.Errorf("data disk [%s] has unsupported format [%s]", opt.Name, format)
+	}
+	capacity := GetDataDiskCapacity(opt.Size)
+
+	volumeDef := createDefaultVolume()
+	volumeDef.Name = opt.Name
+	volumeDef.Target.Format.Type = format
+	volumeDef.Capacity.Value = capacity
+
+	var flags libvirt.StorageVolCreateFlags
+	switch BoxProvisioning(opt.Provisioning) {
+	case ProvisioningThin:
+		volumeDef.Allocation = &libvirtxml.StorageVolumeSize{Unit: "bytes", Value: 0}
+	case ProvisioningPreallocated:
+		volumeDef.Allocation = &libvirtxml.StorageVolumeSize{Unit: "bytes", Value: capacity}
+		if format == VolumeFormatQCOW2 {
+			flags = libvirt.StorageVolCreatePreallocMetadata
+		}
+	default:
+		return nil, 0, fmt.Errorf("data disk [%s] has unsupported provisioning [%s]", opt.Name, opt.Provisioning)
+	}
+	return &volumeDef, flags, nil
+}
+
+// getRequiredSpace returns the space a new volume of the capacity allocates on its pool, a thin volume allocates its
+// space on demand
+func getRequiredSpace(opt *DataDiskOptions, capacity uint64) uint64 {
+	if BoxProvisioning(opt.Provisioning) == ProvisioningPreallocated {
+		return capacity
+	}
+	return min(capacity, thinDataDiskAllocation)
+}
+
+// CreateDataDisk creates a data disk or grows an existing one if required, shrinking a data disk is refused
+func CreateDataDisk(client *LivirtClient) func(opt *DataDiskOptions) (*libvirt.StorageVol, error) {
 	conn := client.LibVirt
 
 	storageVolXMLDesc := getStorageVolXMLDesc(conn)
+	checkSpace := checkStoragePoolSpace(conn)
+	resizeVolume := resizeDataDisk(conn)
 
-	return func(storagePool, name string, size uint64) (*libvirt.StorageVol, error) {
+	return func(opt *DataDiskOptions) (*libvirt.StorageVol, error) {
 		// check if we already know the disk
-		pool, err := conn.StoragePoolLookupByName(storagePool)
+		pool, err := conn.StoragePoolLookupByName(opt.StoragePool)
 		if err != nil {
 			return nil, err
 		}
+		size := opt.Size
+		capacity := GetDataDiskCapacity(size)
 		// check if the volume exists
-		existing, err := conn.StorageVolLookupByName(pool, name)
+		existing, err := conn.StorageVolLookupByName(pool, opt.Name)
 		if err == nil {
 			// check some metadata
 			existingXML, err := storageVolXMLDesc(&existing)
@@ -57,30 +140,45 @@ func CreateDataDisk(client *LivirtClient) func(storagePool, name string, size ui
 				return nil, err
 			}
 			// check if the capacity matches
-			if existingXML.Capacity.Value < size {
-				log.Printf("Resizing storage volume [%s] on pool [%s] from [%d] to [%d] ...", existingXML.Name, pool.Name, existingXML.Capacity.Value, size)
+			switch {
+			case existingXML.Capacity.Value > capacity:
+				return nil, fmt.Errorf("%w: data disk [%s] on pool [%s] has a capacity of [%d] bytes, the requested size of [%d] bytes is smaller", ErrShrinkRefused, existingXML.Name, pool.Name, existingXML.Capacity.Value, size)
+			case existingXML.Capacity.Value < capacity:
+				log.Printf("Resizing storage volume [%s] on pool [%s] from [%d] to [%d] ...", existingXML.Name, pool.Name, existingXML.Capacity.Value, capacity)
+				// make sure the additional space is available
+				err = checkSpace(pool, capacity-existingXML.Capacity.Value)
+				if err != nil {
+					return nil, err
+				}
 				// resize
-				err := conn.StorageVolResize(existing, size, 0)
+				err := resizeVolume(existing, capacity)
 				if err != nil {
 					return nil, err
 				}
 				log.Printf("Successfully resized volume [%s] on pool [%s]", existingXML.Name, pool.Name)
-				return &existing, nil
 			}
+			return &existing, nil
 		}
 		// need to create a new volume
-		volumeDef := createDefaultVolume()
-		volumeDef.Name = name
-		volumeDef.Capacity.Value = size
+		volumeDef, flags, err := CreateDataDiskVolumeDef(opt)
+		if err != nil {
+			return nil, err
+		}
 
 		volumeDefXML, err := XMLMarshall(volumeDef)
 		if err != nil {
 			return nil, err
 		}
 
+		// make sure the volume fits
+		err = checkSpace(pool, getRequiredSpace(opt, capacity))
+		if err != nil {
+			return nil, err
+		}
+
 		// create the volume
-		log.Printf("Creating new volume [%s] on pool [%s] with size [%d] ...", volumeDef.Name, pool.Name, size)
-		volume, err := conn.StorageVolCreateXML(pool, string(volumeDefXML), 0)
+		log.Printf("Creating new volume [%s] of format [%s] on pool [%s] with size [%d] ...", volumeDef.Name, volumeDef.Target.Format.Type, pool.Name, capacity)
+		volume, err := conn.StorageVolCreateXML(pool, string(volumeDefXML), flags)
 		if err != nil {
 			return nil, err
 		}
@@ -91,18 +189,45 @@ func CreateDataDisk(client *LivirtClient) func(storagePool, name string, size ui
 	}
 }
 
-// CreateDataDiskXML creates the XML for the data disk
-func CreateDataDiskXML(client *LivirtClient) func(storagePool, name string, index int) (*libvirtxml.DomainDisk, error) {
+// getDataDiskDriverType returns the driver type matching the format of the volume
+func getDataDiskDriverType(vol *libvirtxml.StorageVolume) string {
+	if vol.Target != nil && vol.Target.Format != nil && vol.Target.Format.Type == VolumeFormatQCOW2 {
+		return VolumeFormatQCOW2
+	}
+	// volumes of block based pools do not report a format
+	return VolumeFormatRaw
+}
+
+// createDataDiskDriver returns the driver of a data disk with the given driver type
+func createDataDiskDriver(disk *AttachedDataDisk, driverType string) *libvirtxml.DomainDiskDriver {
+	return &libvirtxml.DomainDiskDriver{
+		Name:    "qemu",
+		Type:    driverType,
+		Cache:   disk.Cache,
+		IO:      disk.IO,
+		Discard: disk.Discard,
+		IOMMU:   "on",
+	}
+}
+
+// CreateDataDiskXML creates the XML for the data disk attached as the given device
+func CreateDataDiskXML(client *LivirtClient) func(disk *AttachedDataDisk, dev string) (*libvirtxml.DomainDisk, error) {
 	conn := client.LibVirt
+	storageVolXMLDesc := getStorageVolXMLDesc(conn)
 
-	return func(storagePool, name string, index int) (*libvirtxml.DomainDisk, error) {
+	return func(disk *AttachedDataDisk, dev string) (*libvirtxml.DomainDisk, error) {
 		// check if we already know the disk
-		pool, err := conn.StoragePoolLookupByName(storagePool)
+		pool, err := conn.StoragePoolLookupByName(disk.StoragePool)
 		if err != nil {
 			return nil, err
 		}
 		// check if the volume exists
-		existing, err := conn.StorageVolLookupByName(pool, name)
+		existing, err := conn.StorageVolLookupByName(pool, disk.Name)
+		if err != nil {
+			return nil, err
+		}
+		// the driver must match the format of the volume
+		existingXML, err := storageVolXMLDesc(&existing)
 		if err != nil {
 			return nil, err
 		}
@@ -113,9 +238,6 @@ func CreateDataDiskXML(client *LivirtClient) func(storagePool, name string, inde
 			return nil, err
 		}
 
-		// define the bus by index
-		dev := fmt.Sprintf("vd%x", index+13) // use offset 13 so it starts with 'd' for `vdd`
-
 		log.Printf("Defining data disk [%s] on path [%s]", dev, path)
 
 		return &libvirtxml.DomainDisk{
@@ -124,11 +246,7 @@ func CreateDataDiskXML(client *LivirtClient) func(storagePool, name string, inde
 				Dev: dev,
 				Bus: "virtio",
 			},
-			Driver: &libvirtxml.DomainDiskDriver{
-				Name:  "qemu",
-				Type:  "qcow2",
-				IOMMU: "on",
-			},
+			Driver: createDataDiskDriver(disk, getDataDiskDriverType(existingXML)),
 			Source: &libvirtxml.DomainDiskSource{
 				File: &libvirtxml.DomainDiskSourceFile{
 					File: path,
@@ -187,8 +305,8 @@ func IsDataDiskValid(client *LivirtClient) func(opt *DataDiskOptions) (*libvirtx
 			return nil, false
 		}
 		// check the capacity
-		if volXML.Capacity.Value < opt.Size {
-			log.Printf("Size of the existing volume [%s] is [%d] and is less than the requested size [%d]", volXML.Name, volXML.Capacity.Value, opt.Size)
+		if volXML.Capacity.Value != GetDataDiskCapacity(opt.Size) {
+			log.Printf("Size of the existing volume [%s] is [%d] and does not match the requested size [%d]", volXML.Name, volXML.Capacity.Value, opt.Size)
 			return volXML, false
 		}
 		// nothing to do
@@ -227,11 +345,36 @@ func GetDataDiskRef(client *LivirtClient) func(opt *DataDiskRefOptions) (*libvir
 	}
 }
 
-// CreateDataDiskSync creates a data disk or resizes an existing one if required
+// CreateDataDiskSync creates a data disk or resizes an existing one if required. A disk with a restore
+// source is cloned from the source volume when it does not exist, yet.
 func CreateDataDiskSync(client *LivirtClient) func(opt *DataDiskOptions) (*libvirt.StorageVol, error) {
+	conn := client.LibVirt
+	storageVolXMLDesc := getStorageVolXMLDesc(conn)
 	createDataDisk := CreateDataDisk(client)
+	restoreDataDisk := RestoreDataDisk(client)
+
 	return func(opt *DataDiskOptions) (*libvirt.StorageVol, error) {
-		return createDataDisk(opt.StoragePool, opt.Name, opt.Size)
+		if opt.RestoreFrom != nil {
+			pool, err := conn.StoragePoolLookupByName(opt.StoragePool)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := conn.StorageVolLookupByName(pool, opt.Name); err != nil {
+				restored, err := restoreDataDisk(opt)
+				if err != nil {
+					return nil, err
+				}
+				restoredXML, err := storageVolXMLDesc(restored)
+				if err != nil {
+					return nil, err
+				}
+				// the clone might already have the requested size
+				if restoredXML.Capacity.Value >= GetDataDiskCapacity(opt.Size) {
+					return restored, nil
+				}
+			}
+		}
+		return createDataDisk(opt)
 	}
 }
 
@@ -293,6 +436,9 @@ func dataDiskCustomResourceToAttachedDataDisk(res *DataDiskCustomResource) *Atta
 	return &AttachedDataDisk{
 		StoragePool: res.Spec.StoragePool,
 		Name:        string(res.UID),
+		Cache:       res.Spec.Cache,
+		IO:          res.Spec.IO,
+		Discard:     res.Spec.Discard,
 	}
 }
 
@@ -20,9 +20,11 @@ import (
 	"os"
 	"testing"
 
+	libvirt "github.com/digitalocean/go-libvirt"
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"libvirt.org/go/libvirtxml"
 )
 
 func TestDecodeNoAttachedDataDisk(t *testing.T) {
@@ -55,6 +57,80 @@ func TestDecodeOneAttachedDataDisk(t *testing.T) {
 	assert.Len(t, disks, 1)
 }
 
+func TestGetDataDiskCapacity(t *testing.T) {
+	assert.Equal(t, uint64(0), GetDataDiskCapacity(0))
+	assert.Equal(t, uint64(512), GetDataDiskCapacity(1))
+	assert.Equal(t, uint64(512), GetDataDiskCapacity(512))
+	assert.Equal(t, uint64(1024), GetDataDiskCapacity(513))
+	assert.Equal(t, DefaultDataDiskSize, GetDataDiskCapacity(DefaultDataDiskSize))
+}
+
+func TestGetRequiredSpace(t *testing.T) {
+	capacity := uint64(10 << 30)
+	// thin volumes allocate on demand
+	assert.Equal(t, thinDataDiskAllocation, getRequiredSpace(&DataDiskOptions{}, capacity))
+	assert.Equal(t, uint64(1<<20), getRequiredSpace(&DataDiskOptions{Provisioning: ProvisioningThin}, 1<<20))
+	assert.Equal(t, capacity, getRequiredSpace(&DataDiskOptions{Provisioning: ProvisioningPreallocated}, capacity))
+}
+
+func TestCreateDataDiskVolumeDef(t *testing.T) {
+	// defaults
+	volumeDef, flags, err := CreateDataDiskVolumeDef(&DataDiskOptions{
+		Name: "disk",
+		Size: 1000,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "qcow2", volumeDef.Target.Format.Type)
+	assert.Equal(t, uint64(1024), volumeDef.Capacity.Value)
+	assert.Equal(t, uint64(0), volumeDef.Allocation.Value)
+	assert.Equal(t, libvirt.StorageVolCreateFlags(0), flags)
+
+	// preallocated raw volume
+	volumeDef, flags, err = CreateDataDiskVolumeDef(&DataDiskOptions{
+		Name:         "disk",
+		Size:         1024,
+		Format:       VolumeFormatRaw,
+		Provisioning: ProvisioningPreallocated,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "raw", volumeDef.Target.Format.Type)
+	assert.Equal(t, uint64(1024), volumeDef.Allocation.Value)
+	assert.Equal(t, libvirt.StorageVolCreateFlags(0), flags)
+
+	// preallocated qcow2 volume
+	_, flags, err = CreateDataDiskVolumeDef(&DataDiskOptions{
+		Name:         "disk",
+		Size:         1024,
+		Provisioning: ProvisioningPreallocated,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, libvirt.StorageVolCreatePreallocMetadata, flags)
+
+	// invalid format
+	_, _, err = CreateDataDiskVolumeDef(&DataDiskOptions{
+		Name:   "disk",
+		Format: "vmdk",
+	})
+	assert.Error(t, err)
+}
+
+func TestCreateDataDiskDriver(t *testing.T) {
+	driver := createDataDiskDriver(&AttachedDataDisk{
+		Name:    "disk",
+		Cache:   "none",
+		IO:      "native",
+		Discard: "unmap",
+	}, getDataDiskDriverType(&libvirtxml.StorageVolume{}))
+
+	assert.Equal(t, "raw", driver.Type)
+	assert.Equal(t, "none", driver.Cache)
+	assert.Equal(t, "native", driver.IO)
+	assert.Equal(t, "unmap", driver.Discard)
+	assert.Equal(t, "on", driver.IOMMU)
+}
+
 func TestCreateDataDisk(t *testing.T) {
 	env, err := godotenv.Read("../.env")
 	if err != nil {
@@ -75,7 +151,11 @@ func TestCreateDataDisk(t *testing.T) {
 	expSize := uint64(100 * 1024 * 1024 * 1024)
 
 	// create the data disk
-	dataDisk, err := CreateDataDisk(client)(storagePool, "TestCreateDataDisk", expSize)
+	dataDisk, err := CreateDataDisk(client)(&DataDiskOptions{
+		Name:        "TestCreateDataDisk",
+		StoragePool: storagePool,
+		Size:        expSize,
+	})
 	require.NoError(t, err)
 
 	defer func() {
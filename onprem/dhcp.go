@@ -0,0 +1,352 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	A "github.com/IBM/fp-go/array"
+	F "github.com/IBM/fp-go/function"
+	libvirt "github.com/digitalocean/go-libvirt"
+	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+	"libvirt.org/go/libvirtxml"
+)
+
+// InterfaceStatus describes the addresses of a network interface of a VSI
+type InterfaceStatus struct {
+	// name of the network or bridge the interface is attached to
+	Network string `json:"network"`
+	// mac address of the interface
+	MAC string `json:"mac"`
+	// IP addresses assigned to the interface
+	IPAddresses []string `json:"ipaddresses"`
+	// the static IP address reserved for the interface, if any
+	StaticIP string `json:"staticIP,omitempty"`
+}
+
+// IsNetworkDhcpLeaseForMAC checks if this lease is for the mac address
+func IsNetworkDhcpLeaseForMAC(mac string) func(libvirt.NetworkDhcpLease) bool {
+	return func(lease libvirt.NetworkDhcpLease) bool {
+		for _, addr := range lease.Mac {
+			if strings.EqualFold(addr, mac) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// getNetworkIPNet returns the subnet of an IP element of a network
+func getNetworkIPNet(ip *libvirtxml.NetworkIP) (*net.IPNet, error) {
+	addr := net.ParseIP(ip.Address)
+	if addr == nil {
+		return nil, fmt.Errorf("invalid address [%s]", ip.Address)
+	}
+	if ip.Netmask != "" {
+		mask := net.ParseIP(ip.Netmask)
+		if mask == nil {
+			return nil, fmt.Errorf("invalid netmask [%s]", ip.Netmask)
+		}
+		ipMask := net.IPMask(mask.To4())
+		return &net.IPNet{IP: addr.Mask(ipMask), Mask: ipMask}, nil
+	}
+	bits := 8 * net.IPv6len
+	if addr.To4() != nil {
+		bits = 8 * net.IPv4len
+	}
+	ipMask := net.CIDRMask(int(ip.Prefix), bits)
+	return &net.IPNet{IP: addr.Mask(ipMask), Mask: ipMask}, nil
+}
+
+// FindDHCPIPIndex returns the index of the DHCP enabled IP element of the network that contains the address
+func FindDHCPIPIndex(netXML *libvirtxml.Network, address string) (int, error) {
+	addr := net.ParseIP(address)
+	if addr == nil {
+		return -1, fmt.Errorf("invalid IP address [%s]", address)
+	}
+	for idx := range netXML.IPs {
+		ip := &netXML.IPs[idx]
+		if ip.DHCP == nil {
+			continue
+		}
+		ipNet, err := getNetworkIPNet(ip)
+		if err != nil {
+			log.Printf("Unable to decode IP [%s] of network [%s], cause: [%v]", ip.Address, netXML.Name, err)
+			continue
+		}
+		if ipNet.Contains(addr) {
+			return idx, nil
+		}
+	}
+	return -1, fmt.Errorf("network [%s] has no DHCP enabled subnet containing the IP address [%s]", netXML.Name, address)
+}
+
+// FindDHCPHost locates the DHCP host entry matching the predicate, returns the index of the IP element and the entry
+func FindDHCPHost(netXML *libvirtxml.Network, pred func(host *libvirtxml.NetworkDHCPHost) bool) (int, *libvirtxml.NetworkDHCPHost) {
+	for idx := range netXML.IPs {
+		dhcp := netXML.IPs[idx].DHCP
+		if dhcp == nil {
+			continue
+		}
+		for hostIdx := range dhcp.Hosts {
+			if pred(&dhcp.Hosts[hostIdx]) {
+				return idx, &dhcp.Hosts[hostIdx]
+			}
+		}
+	}
+	return -1, nil
+}
+
+// isDHCPHostForMAC checks if a DHCP host entry is for the mac address
+func isDHCPHostForMAC(mac string) func(host *libvirtxml.NetworkDHCPHost) bool {
+	return func(host *libvirtxml.NetworkDHCPHost) bool {
+		return strings.EqualFold(host.MAC, mac)
+	}
+}
+
+// isDHCPHostForIP checks if a DHCP host entry is for the IP address
+func isDHCPHostForIP(ip string) func(host *libvirtxml.NetworkDHCPHost) bool {
+	return func(host *libvirtxml.NetworkDHCPHost) bool {
+		return host.IP == ip
+	}
+}
+
+// ValidateStaticIPs checks that all reserved IP addresses can be served by the network
+func ValidateStaticIPs(netXML *libvirtxml.Network, reservations []StaticIPReservation) error {
+	seen := make(map[string]string)
+	for _, reservation := range reservations {
+		if other, ok := seen[reservation.IP]; ok {
+			return fmt.Errorf("IP address [%s] is reserved for both [%s] and [%s]", reservation.IP, other, reservation.Name)
+		}
+		seen[reservation.IP] = reservation.Name
+		if _, err := FindDHCPIPIndex(netXML, reservation.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateDHCPHost applies a change to the DHCP host section of a network, to the persistent config and
+// to the running network
+func updateDHCPHost(conn *libvirt.Libvirt) func(network libvirt.Network, cmd libvirt.NetworkUpdateCommand, ipIndex int, host *libvirtxml.NetworkDHCPHost) error {
+	return func(network libvirt.Network, cmd libvirt.NetworkUpdateCommand, ipIndex int, host *libvirtxml.NetworkDHCPHost) error {
+		hostXML, err := XMLMarshall(host)
+		if err != nil {
+			return err
+		}
+		flags := libvirt.NetworkUpdateAffectConfig
+		active, err := conn.NetworkIsActive(network)
+		if err == nil && active != 0 {
+			flags |= libvirt.NetworkUpdateAffectLive
+		}
+		// older libvirt daemons expect the command and the section in swapped order
+		return conn.NetworkUpdateCompat(network, cmd, libvirt.NetworkSectionIPDhcpHost, int32(ipIndex), hostXML, flags)
+	}
+}
+
+// syncDHCPHost makes sure that the DHCP host entry of the mac address reflects the static IP address, an
+// empty IP address removes the entry
+func syncDHCPHost(conn *libvirt.Libvirt) func(networkName, hostname, mac, ip string) error {
+	networkXMLDesc := getNetworkXMLDesc(conn)
+	update := updateDHCPHost(conn)
+
+	return func(networkName, hostname, mac, ip string) error {
+		network, err := conn.NetworkLookupByName(networkName)
+		if err != nil {
+			return err
+		}
+		netXML, err := networkXMLDesc(&network)
+		if err != nil {
+			return err
+		}
+		existingIdx, existing := FindDHCPHost(netXML, isDHCPHostForMAC(mac))
+		// remove the entry
+		if ip == "" {
+			if existing == nil {
+				return nil
+			}
+			log.Printf("Removing DHCP host [%s] with IP [%s] from network [%s] ...", mac, existing.IP, networkName)
+			return update(network, libvirt.NetworkUpdateCommandDelete, existingIdx, existing)
+		}
+		// check if the entry is up to date
+		if existing != nil && existing.IP == ip && existing.Name == hostname {
+			return nil
+		}
+		// the IP must not be used by a different host
+		if _, other := FindDHCPHost(netXML, isDHCPHostForIP(ip)); other != nil && !strings.EqualFold(other.MAC, mac) {
+			return fmt.Errorf("IP address [%s] on network [%s] is already assigned to [%s]", ip, networkName, other.MAC)
+		}
+		ipIdx, err := FindDHCPIPIndex(netXML, ip)
+		if err != nil {
+			return err
+		}
+		host := &libvirtxml.NetworkDHCPHost{
+			MAC:  mac,
+			Name: hostname,
+			IP:   ip,
+		}
+		// the entry moved to a different subnet
+		if existing != nil && existingIdx != ipIdx {
+			if err := update(network, libvirt.NetworkUpdateCommandDelete, existingIdx, existing); err != nil {
+				return err
+			}
+			existing = nil
+		}
+		if existing != nil {
+			log.Printf("Updating DHCP host [%s] to IP [%s] on network [%s] ...", mac, ip, networkName)
+			return update(network, libvirt.NetworkUpdateCommandModify, ipIdx, host)
+		}
+		log.Printf("Adding DHCP host [%s] with IP [%s] to network [%s] ...", mac, ip, networkName)
+		return update(network, libvirt.NetworkUpdateCommandAddLast, ipIdx, host)
+	}
+}
+
+// SyncDHCPHosts programs the DHCP host entries for the static IP addresses of a VSI and removes stale entries
+func SyncDHCPHosts(client *LivirtClient) func(opt *InstanceOptions) error {
+	syncHost := syncDHCPHost(client.LibVirt)
+
+	return func(opt *InstanceOptions) error {
+		defer CM.EntryExit(fmt.Sprintf("SyncDHCPHosts(%s)", opt.Name))()
+		for _, network := range opt.Networks {
+			err := syncHost(network, opt.Name, GetInterfaceMacAddress(opt.Name, network), opt.StaticIPs[network])
+			if err != nil {
+				log.Printf("Unable to synchronize the DHCP host of [%s] on network [%s], cause: [%v]", opt.Name, network, err)
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// RemoveDHCPHosts removes the DHCP host entries of a VSI
+func RemoveDHCPHosts(client *LivirtClient) func(opt *InstanceOptions) error {
+	syncHost := syncDHCPHost(client.LibVirt)
+
+	return func(opt *InstanceOptions) error {
+		defer CM.EntryExit(fmt.Sprintf("RemoveDHCPHosts(%s)", opt.Name))()
+		for _, network := range opt.Networks {
+			err := syncHost(network, opt.Name, GetInterfaceMacAddress(opt.Name, network), "")
+			if err != nil {
+				log.Printf("Unable to remove the DHCP host of [%s] from network [%s], cause: [%v]", opt.Name, network, err)
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// getInterfaceNetwork returns the name of the network or bridge of a domain interface
+func getInterfaceNetwork(iface *libvirtxml.DomainInterface) string {
+	if iface.Source == nil {
+		return ""
+	}
+	if iface.Source.Network != nil {
+		return iface.Source.Network.Network
+	}
+	if iface.Source.Bridge != nil {
+		return iface.Source.Bridge.Bridge
+	}
+	if iface.Source.Direct != nil {
+		return iface.Source.Direct.Dev
+	}
+	return ""
+}
+
+func getLeaseIPAddress(lease libvirt.NetworkDhcpLease) string {
+	return lease.Ipaddr
+}
+
+func getDomainIPAddress(addr libvirt.DomainIPAddr) string {
+	return addr.Addr
+}
+
+// GetInterfaceStatus determines the IP addresses of each interface of a VSI. Addresses are looked up in the DHCP
+// leases of libvirt managed networks by mac address and fall back to the ARP table of the host, e.g. for bridged networks.
+func GetInterfaceStatus(client *LivirtClient) func(inst *libvirtxml.Domain, opt *InstanceOptions) []InterfaceStatus {
+	conn := client.LibVirt
+	getLeases := GetDCHPLeases(client)
+
+	return func(inst *libvirtxml.Domain, opt *InstanceOptions) []InterfaceStatus {
+		defer CM.EntryExit(fmt.Sprintf("GetInterfaceStatus(%s)", opt.Name))()
+		if inst.Devices == nil {
+			return A.Empty[InterfaceStatus]()
+		}
+		// the ARP table is only consulted if required
+		var arp []libvirt.DomainInterface
+		arpLoaded := false
+		getARP := func() []libvirt.DomainInterface {
+			if !arpLoaded {
+				arpLoaded = true
+				domain, err := conn.DomainLookupByName(inst.Name)
+				if err != nil {
+					log.Printf("Unable to lookup domain [%s], cause: [%v]", inst.Name, err)
+					return arp
+				}
+				arp, err = conn.DomainInterfaceAddresses(domain, uint32(libvirt.DomainInterfaceAddressesSrcArp), 0)
+				if err != nil {
+					log.Printf("Unable to get the interface addresses of domain [%s], cause: [%v]", inst.Name, err)
+				}
+			}
+			return arp
+		}
+
+		result := make([]InterfaceStatus, 0, len(inst.Devices.Interfaces))
+		for idx := range inst.Devices.Interfaces {
+			iface := &inst.Devices.Interfaces[idx]
+			if iface.MAC == nil {
+				continue
+			}
+			status := InterfaceStatus{
+				Network:     getInterfaceNetwork(iface),
+				MAC:         iface.MAC.Address,
+				IPAddresses: A.Empty[string](),
+				StaticIP:    opt.StaticIPs[getInterfaceNetwork(iface)],
+			}
+			// check the leases of libvirt managed networks
+			if iface.Source != nil && iface.Source.Network != nil {
+				leases, err := getLeases(iface.Source.Network.Network)
+				if err == nil {
+					status.IPAddresses = F.Pipe2(
+						leases,
+						A.Filter(IsNetworkDhcpLeaseForMAC(status.MAC)),
+						A.Map(getLeaseIPAddress),
+					)
+				}
+			}
+			// fallback to the ARP table
+			if len(status.IPAddresses) == 0 {
+				for _, addr := range getARP() {
+					for _, hwaddr := range addr.Hwaddr {
+						if strings.EqualFold(hwaddr, status.MAC) {
+							status.IPAddresses = append(status.IPAddresses, A.MonadMap(addr.Addrs, getDomainIPAddress)...)
+						}
+					}
+				}
+			}
+			result = append(result, status)
+		}
+		return result
+	}
+}
+
+func getInterfaceIPAddresses(status InterfaceStatus) []string {
+	return status.IPAddresses
+}
+
+// InterfaceStatusToIPAddresses flattens the IP addresses of all interfaces
+var InterfaceStatusToIPAddresses = A.Chain(getInterfaceIPAddresses)
@@ -0,0 +1,120 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"testing"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"libvirt.org/go/libvirtxml"
+)
+
+func createTestNetwork() *libvirtxml.Network {
+	return &libvirtxml.Network{
+		Name: "default",
+		IPs: []libvirtxml.NetworkIP{
+			{
+				Address: "fd00::1",
+				Family:  "ipv6",
+				Prefix:  64,
+			},
+			{
+				Address: "192.168.122.1",
+				Netmask: "255.255.255.0",
+				DHCP: &libvirtxml.NetworkDHCP{
+					Hosts: []libvirtxml.NetworkDHCPHost{
+						{MAC: "52:54:00:00:00:01", Name: "first", IP: "192.168.122.10"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestFindDHCPIPIndex(t *testing.T) {
+	netXML := createTestNetwork()
+
+	idx, err := FindDHCPIPIndex(netXML, "192.168.122.20")
+	require.NoError(t, err)
+	assert.Equal(t, 1, idx)
+
+	// IPv6 subnet has no DHCP
+	_, err = FindDHCPIPIndex(netXML, "fd00::20")
+	assert.Error(t, err)
+
+	_, err = FindDHCPIPIndex(netXML, "10.0.0.1")
+	assert.Error(t, err)
+}
+
+func TestFindDHCPHost(t *testing.T) {
+	netXML := createTestNetwork()
+
+	idx, host := FindDHCPHost(netXML, isDHCPHostForMAC("52:54:00:00:00:01"))
+	require.NotNil(t, host)
+	assert.Equal(t, 1, idx)
+	assert.Equal(t, "192.168.122.10", host.IP)
+
+	_, host = FindDHCPHost(netXML, isDHCPHostForIP("192.168.122.11"))
+	assert.Nil(t, host)
+}
+
+func TestValidateStaticIPs(t *testing.T) {
+	netXML := createTestNetwork()
+
+	assert.NoError(t, ValidateStaticIPs(netXML, []StaticIPReservation{
+		{Name: "a", IP: "192.168.122.20"},
+		{Name: "b", IP: "192.168.122.21"},
+	}))
+	assert.Error(t, ValidateStaticIPs(netXML, []StaticIPReservation{
+		{Name: "a", IP: "192.168.122.20"},
+		{Name: "b", IP: "192.168.122.20"},
+	}))
+	assert.Error(t, ValidateStaticIPs(netXML, []StaticIPReservation{
+		{Name: "a", IP: "10.0.0.1"},
+	}))
+}
+
+func TestNetworkRefCustomResourceToStaticIPs(t *testing.T) {
+	refs := []*NetworkRefCustomResource{
+		{
+			Spec: NetworkRefCustomResourceSpec{
+				NetworkName: "default",
+				StaticIPs: []StaticIPReservation{
+					{Name: "sample", IP: "192.168.122.20"},
+					{Name: "other", IP: "192.168.122.21"},
+				},
+			},
+		},
+		{
+			Spec: NetworkRefCustomResourceSpec{
+				NetworkName: "internal",
+			},
+		},
+	}
+
+	assert.Equal(t, map[string]string{"default": "192.168.122.20"}, NetworkRefCustomResourceToStaticIPs("sample")(refs))
+}
+
+func TestIsNetworkDhcpLeaseForMAC(t *testing.T) {
+	lease := libvirt.NetworkDhcpLease{
+		Mac:    libvirt.OptString{"52:54:00:AB:00:01"},
+		Ipaddr: "192.168.122.20",
+	}
+
+	assert.True(t, IsNetworkDhcpLeaseForMAC("52:54:00:ab:00:01")(lease))
+	assert.False(t, IsNetworkDhcpLeaseForMAC("52:54:00:ab:00:02")(lease))
+}
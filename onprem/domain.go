@@ -126,13 +126,13 @@ func createDefaultDomainDef(client *LivirtClient) (*libvirtxml.Domain, error) {
 		},
 		Metadata: &libvirtxml.DomainMetadata{},
 		Memory: &libvirtxml.DomainMemory{
-			Value: 4 * 1024 * 1024,
+			Value: uint(InstanceMemory / 1024),
 		},
 		CurrentMemory: &libvirtxml.DomainCurrentMemory{
-			Value: 4 * 1024 * 1024,
+			Value: uint(InstanceMemory / 1024),
 		},
 		VCPU: &libvirtxml.DomainVCPU{
-			Value: 2,
+			Value: InstanceCPUs,
 		},
 		Clock: &libvirtxml.DomainClock{
 			Offset: "utc",
@@ -189,67 +189,85 @@ func parseDomainXML(s string) (*libvirtxml.Domain, error) {
 	return &domainDef, nil
 }
 
-func shutDownDomain(client *LivirtClient) func(domain *libvirt.Domain) error {
+// ShutdownResult reports the progress of the graceful shutdown of a domain
+type ShutdownResult struct {
+	// the domain has powered off or does not exist
+	Done bool `json:"done"`
+	// the domain has been destroyed because it did not power off gracefully
+	Forced bool `json:"forced"`
+	// why the domain has been destroyed
+	Reason string `json:"reason,omitempty"`
+}
+
+// ShutdownDomain advances the graceful shutdown of a domain without waiting for it. It requests the guest to power
+// off and destroys the domain once the grace period since the first request has elapsed. The caller repeats the
+// call until the result reports that the shutdown is done.
+func ShutdownDomain(client *LivirtClient) func(name string, requestedAt time.Time, gracePeriod time.Duration) (*ShutdownResult, error) {
 	conn := client.LibVirt
-	return func(domain *libvirt.Domain) error {
-		// check if the domain is running
-		state, _, err := conn.DomainGetState(*domain, 0)
+
+	destroy := func(domain libvirt.Domain, reason string) (*ShutdownResult, error) {
+		log.Printf("Destroying domain [%s], cause: [%s]", domain.Name, reason)
+		err := conn.DomainDestroy(domain)
+		if err != nil && !isError(err, libvirt.ErrOperationInvalid) {
+			return nil, err
+		}
+		return &ShutdownResult{Done: true, Forced: true, Reason: reason}, nil
+	}
+
+	return func(name string, requestedAt time.Time, gracePeriod time.Duration) (*ShutdownResult, error) {
+		domain, err := conn.DomainLookupByName(name)
+		if err != nil {
+			// nothing to shut down
+			return &ShutdownResult{Done: true}, nil
+		}
+		state, reason, err := conn.DomainGetState(domain, 0)
 		if err != nil {
 			// if we cannot get the domain state, assume it's gone
-			log.Printf("Unable to get the domain state for domain [%s], err: [%v]", domain.Name, err)
-			return nil
+			log.Printf("Unable to get the domain state for domain [%s], err: [%v]", name, err)
+			return &ShutdownResult{Done: true}, nil
 		}
-		if libvirt.DomainState(state) != libvirt.DomainRunning {
-			return nil
+		actual := CreateDomainPowerState(state, reason)
+		switch libvirt.DomainState(state) {
+		case libvirt.DomainShutoff, libvirt.DomainNostate:
+			return &ShutdownResult{Done: true}, nil
+		case libvirt.DomainCrashed:
+			// a crashed guest does not react to a shutdown request
+			return destroy(domain, fmt.Sprintf("domain is [%s] with reason [%s]", actual.State, actual.Reason))
+		}
+		elapsed := time.Since(requestedAt)
+		if elapsed >= gracePeriod {
+			return destroy(domain, fmt.Sprintf("domain is still [%s] after the grace period of [%s]", actual.State, gracePeriod))
+		}
+		// a suspended guest must run to process the shutdown request
+		switch libvirt.DomainState(state) {
+		case libvirt.DomainPaused:
+			log.Printf("Resuming domain [%s] to shut it down ...", name)
+			err = conn.DomainResume(domain)
+		case libvirt.DomainPmsuspended:
+			log.Printf("Waking up domain [%s] to shut it down ...", name)
+			err = conn.DomainPmWakeup(domain, 0)
 		}
-		// try to shutdown the domain
-		log.Printf("Shutting down domain [%s] ...", domain.Name)
-		err = conn.DomainShutdown(*domain)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		// wait for the domain state
-		for i := 0; i < 50; i++ {
-			// get the domain state
-			state, reason, err := conn.DomainGetState(*domain, 0)
-			log.Printf("Domain State [%d], reason [%d]", state, reason)
-			if err != nil {
-				// if we cannot get the domain state, assume it's gone
-				log.Printf("Unable to get the domain state for domain [%s], err: [%v]", domain.Name, err)
-				return nil
-			}
-			// check for states that depict a shutdown system
-			switch libvirt.DomainState(state) {
-			case libvirt.DomainShutdown, libvirt.DomainShutoff, libvirt.DomainCrashed, libvirt.DomainNostate, libvirt.DomainPaused, libvirt.DomainPmsuspended:
-				return nil
-			case libvirt.DomainRunning:
-				// keep trying
-			case libvirt.DomainBlocked:
-				log.Printf("Domain [%s] is blocked, not sure what to do ...", domain.Name)
-			default:
-				log.Printf("Domain [%s] is in unknown state [%d]", domain.Name, state)
-			}
-			// wait a bit
-			time.Sleep(2 * time.Second)
+		// repeating the request is harmless, the guest may have missed a previous one while booting
+		log.Printf("Shutting down domain [%s], [%s] of the grace period of [%s] elapsed ...", name, elapsed.Round(time.Second), gracePeriod)
+		err = conn.DomainShutdown(domain)
+		if err != nil && !isError(err, libvirt.ErrOperationInvalid) {
+			return nil, err
 		}
-		return fmt.Errorf("timeout waiting for domain [%s] to complete", domain.Name)
+		return &ShutdownResult{}, nil
 	}
 }
 
-// deleteDomain tries to gracefully shutdown the domain
+// deleteDomain destroys and undefines a domain. Callers shut down the domain gracefully before, see ShutdownDomain.
 func deleteDomain(client *LivirtClient) func(domain *libvirt.Domain) error {
 	conn := client.LibVirt
-	shutdown := shutDownDomain(client)
 
 	return func(domain *libvirt.Domain) error {
-		// shutdown
-		err := shutdown(domain)
-		if err != nil {
-			return err
-		}
 		// final cleanup
 		log.Printf("Destroying domain [%s] ...", domain.Name)
-		err = conn.DomainDestroy(*domain)
+		err := conn.DomainDestroy(*domain)
 
 		if err != nil {
 			var libvirtErr libvirt.Error
@@ -265,15 +283,30 @@ func deleteDomain(client *LivirtClient) func(domain *libvirt.Domain) error {
 	}
 }
 
+// DeleteDomainByName deletes a domain unless it belongs to the operator of a different cluster
 func DeleteDomainByName(client *LivirtClient) func(name string) error {
+	deleteOwnedDomain := DeleteOwnedDomainByName(client)
+
+	return func(name string) error {
+		return deleteOwnedDomain(name, nil)
+	}
+}
+
+// DeleteOwnedDomainByName deletes the domain of a VSI unless it belongs to the operator of a different cluster
+func DeleteOwnedDomainByName(client *LivirtClient) func(name string, owner *InstanceOwner) error {
 
 	conn := client.LibVirt
 
 	delDomain := deleteDomain(client)
+	checkOwnership := CheckDomainOwnership(client)
 
-	return func(name string) error {
+	return func(name string, owner *InstanceOwner) error {
 		// log this config
 		defer CM.EntryExit(fmt.Sprintf("DeleteDomainByName(%s)", name))()
+		// never touch the domains of other clusters
+		if err := checkOwnership(name, owner); err != nil {
+			return err
+		}
 		// log this
 		log.Printf("Deleting domain by name [%s] ...", name)
 		// locate the domain
@@ -300,10 +333,20 @@ func GetDomains(client *LivirtClient) func() ([]libvirt.Domain, error) {
 }
 
 func StartDomain(client *LivirtClient) func(*libvirtxml.Domain) (*libvirtxml.Domain, error) {
+	defineDomain := DefineDomain(client)
+
+	return func(domainXML *libvirtxml.Domain) (*libvirtxml.Domain, error) {
+		return defineDomain(domainXML, PowerStateRunning)
+	}
+}
+
+// DefineDomain defines a domain and only creates it if the requested power state is running. Other power states are
+// reached by [ApplyPowerState] once the domain exists.
+func DefineDomain(client *LivirtClient) func(domainXML *libvirtxml.Domain, powerState string) (*libvirtxml.Domain, error) {
 
 	conn := client.LibVirt
 
-	return func(domainXML *libvirtxml.Domain) (*libvirtxml.Domain, error) {
+	return func(domainXML *libvirtxml.Domain, powerState string) (*libvirtxml.Domain, error) {
 		// marshal
 		domainString, err := XMLMarshall(domainXML)
 		if err != nil {
@@ -317,17 +360,24 @@ func StartDomain(client *LivirtClient) func(*libvirtxml.Domain) (*libvirtxml.Dom
 		if err != nil {
 			return nil, err
 		}
-		err = conn.DomainSetAutostart(domain, 1)
+		// a stopped VSI must not come back when the hypervisor host restarts
+		autostart := int32(1)
+		if BoxPowerState(powerState) == PowerStateStopped {
+			autostart = 0
+		}
+		err = conn.DomainSetAutostart(domain, autostart)
 		if err != nil {
 			return nil, err
 		}
 		// get some identifier
 		domainId := uuidToString(domain.UUID)
 		// create the beast
-		log.Printf("Creating domain [%s] with ID [%s]...", domain.Name, domainId)
-		err = conn.DomainCreate(domain)
-		if err != nil {
-			return nil, err
+		if BoxPowerState(powerState) == PowerStateRunning {
+			log.Printf("Creating domain [%s] with ID [%s]...", domain.Name, domainId)
+			err = conn.DomainCreate(domain)
+			if err != nil {
+				return nil, err
+			}
 		}
 		// read back the domain info
 		log.Printf("Reading domain info for [%s] with ID [%s] ...", domain.Name, domainId)
@@ -18,9 +18,22 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+func TestBoxTerminationGracePeriodSeconds(t *testing.T) {
+	zero := 0
+	negative := -1
+	custom := 300
+
+	assert.Equal(t, DefaultTerminationGracePeriodSeconds, BoxTerminationGracePeriodSeconds(nil))
+	assert.Equal(t, DefaultTerminationGracePeriodSeconds, BoxTerminationGracePeriodSeconds(&negative))
+	// no grace period at all destroys the domain right away
+	assert.Equal(t, 0, BoxTerminationGracePeriodSeconds(&zero))
+	assert.Equal(t, 300, BoxTerminationGracePeriodSeconds(&custom))
+}
+
 func TestDomainXML(t *testing.T) {
 	config, err := defaultSSHConfig("../.env")
 	if err != nil {
@@ -39,7 +52,7 @@ func TestDomainXML(t *testing.T) {
 	userDataContent := []byte("userdata1")
 	metaDataContent := []byte("metadata2")
 
-	isoData, err := CreateCloudInit(userDataContent, metaDataContent)
+	isoData, err := CreateCloudInit(userDataContent, metaDataContent, nil)
 	require.NoError(t, err)
 
 	vol, err := uploader("libvirt", "TestDomainXML.iso", isoData)
@@ -0,0 +1,359 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+	"libvirt.org/go/libvirtxml"
+)
+
+// DomainDrift describes a property of a domain that differs from the desired configuration
+type DomainDrift struct {
+	// name of the property
+	Field string `json:"field"`
+	// desired value
+	Expected string `json:"expected"`
+	// actual value of the domain
+	Actual string `json:"actual"`
+	// the drift has been repaired
+	Repaired bool `json:"repaired,omitempty"`
+}
+
+const (
+	driftMissing = "missing"
+	driftAbsent  = "absent"
+
+	driftFieldMemory        = "memory"
+	driftFieldCurrentMemory = "currentMemory"
+	driftFieldVCPUs         = "vcpus"
+	driftFieldCurrentVCPUs  = "vcpus.current"
+	driftFieldInterfaces    = "interfaces"
+)
+
+// multipliers of the memory units supported by libvirt relative to bytes
+var memoryUnits = map[string]uint64{
+	"b":     1,
+	"bytes": 1,
+	"KB":    1000,
+	"k":     1024,
+	"KiB":   1024,
+	"MB":    1000 * 1000,
+	"M":     1024 * 1024,
+	"MiB":   1024 * 1024,
+	"GB":    1000 * 1000 * 1000,
+	"G":     1024 * 1024 * 1024,
+	"GiB":   1024 * 1024 * 1024,
+	"TB":    1000 * 1000 * 1000 * 1000,
+	"T":     1024 * 1024 * 1024 * 1024,
+	"TiB":   1024 * 1024 * 1024 * 1024,
+}
+
+// memoryToKiB converts a memory size to KiB, libvirt defaults to KiB
+func memoryToKiB(value uint, unit string) uint64 {
+	multiplier, ok := memoryUnits[unit]
+	if !ok {
+		multiplier = 1024
+	}
+	return uint64(value) * multiplier / 1024
+}
+
+func formatKiB(value uint64) string {
+	return fmt.Sprintf("%d KiB", value)
+}
+
+func getDiskName(disk *libvirtxml.DomainDisk) string {
+	if disk.Source == nil || disk.Source.File == nil {
+		return ""
+	}
+	return path.Base(disk.Source.File.File)
+}
+
+func findDisk(domainXML *libvirtxml.Domain, dev string) *libvirtxml.DomainDisk {
+	for idx := range domainXML.Devices.Disks {
+		disk := &domainXML.Devices.Disks[idx]
+		if disk.Target != nil && disk.Target.Dev == dev {
+			return disk
+		}
+	}
+	return nil
+}
+
+func getInterfaceMac(iface *libvirtxml.DomainInterface) string {
+	if iface.MAC == nil {
+		return ""
+	}
+	return strings.ToLower(iface.MAC.Address)
+}
+
+// indexInterfaces returns the interfaces keyed by their mac address
+func indexInterfaces(ifaces []libvirtxml.DomainInterface) map[string]*libvirtxml.DomainInterface {
+	result := make(map[string]*libvirtxml.DomainInterface)
+	for idx := range ifaces {
+		result[getInterfaceMac(&ifaces[idx])] = &ifaces[idx]
+	}
+	return result
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func getInterfaceField(mac string) string {
+	return fmt.Sprintf("interfaces[%s]", mac)
+}
+
+func getDiskField(dev string) string {
+	return fmt.Sprintf("disks[%s]", dev)
+}
+
+func isInterfaceField(field string) bool {
+	return strings.HasPrefix(field, "interfaces[")
+}
+
+// IsDriftReportOnly tests if a drift is reported without applying the drift policy. The interfaces of an adopted
+// domain are compared by network only, so the operator cannot tell which interface to repair.
+func IsDriftReportOnly(opt *InstanceOptions, item DomainDrift) bool {
+	return opt.Adopted && item.Field == driftFieldInterfaces
+}
+
+func isDefinitionField(field string) bool {
+	switch field {
+	case driftFieldMemory, driftFieldCurrentMemory, driftFieldVCPUs, driftFieldCurrentVCPUs:
+		return true
+	}
+	return false
+}
+
+// detectDiskDrift compares the volume attached to a device with the expected volume
+func detectDiskDrift(domainXML *libvirtxml.Domain, dev, expected string) []DomainDrift {
+	actual := driftMissing
+	if disk := findDisk(domainXML, dev); disk != nil {
+		actual = getDiskName(disk)
+	}
+	if actual == expected {
+		return nil
+	}
+	return []DomainDrift{{Field: getDiskField(dev), Expected: expected, Actual: actual}}
+}
+
+// DetectDrift compares the domain with the configuration derived from the instance options. Data disks are not
+// compared, they are synchronized on every sync anyway. The disks of an adopted domain do not follow the naming of the
+// operator and its interfaces have been created with different mac addresses, so only the networks are compared.
+func DetectDrift(opt *InstanceOptions, domainXML *libvirtxml.Domain) []DomainDrift {
+	var result []DomainDrift
+	// memory
+	expectedMemory := InstanceMemory / 1024
+	if domainXML.Memory == nil || memoryToKiB(domainXML.Memory.Value, domainXML.Memory.Unit) != expectedMemory {
+		actual := driftMissing
+		if domainXML.Memory != nil {
+			actual = formatKiB(memoryToKiB(domainXML.Memory.Value, domainXML.Memory.Unit))
+		}
+		result = append(result, DomainDrift{Field: driftFieldMemory, Expected: formatKiB(expectedMemory), Actual: actual})
+	}
+	if domainXML.CurrentMemory != nil && memoryToKiB(domainXML.CurrentMemory.Value, domainXML.CurrentMemory.Unit) != expectedMemory {
+		actual := formatKiB(memoryToKiB(domainXML.CurrentMemory.Value, domainXML.CurrentMemory.Unit))
+		result = append(result, DomainDrift{Field: driftFieldCurrentMemory, Expected: formatKiB(expectedMemory), Actual: actual})
+	}
+	// CPUs
+	if domainXML.VCPU == nil || domainXML.VCPU.Value != InstanceCPUs {
+		actual := driftMissing
+		if domainXML.VCPU != nil {
+			actual = fmt.Sprint(domainXML.VCPU.Value)
+		}
+		result = append(result, DomainDrift{Field: driftFieldVCPUs, Expected: fmt.Sprint(InstanceCPUs), Actual: actual})
+	}
+	if domainXML.VCPU != nil && domainXML.VCPU.Current != 0 && domainXML.VCPU.Current != InstanceCPUs {
+		result = append(result, DomainDrift{Field: driftFieldCurrentVCPUs, Expected: fmt.Sprint(InstanceCPUs), Actual: fmt.Sprint(domainXML.VCPU.Current)})
+	}
+	if domainXML.Devices == nil {
+		return append(result, DomainDrift{Field: "devices", Expected: "present", Actual: driftMissing})
+	}
+	if opt.Adopted {
+		expected := strings.Join(sortNetwoks(GetNetworks(opt)), ",")
+		actual := strings.Join(getDomainNetworks(domainXML), ",")
+		if actual != expected {
+			result = append(result, DomainDrift{Field: driftFieldInterfaces, Expected: expected, Actual: actual})
+		}
+		return result
+	}
+	// boot disk and cloud-init disk
+	result = append(result, detectDiskDrift(domainXML, "vda", GetBootVolumeName(opt.Name))...)
+	result = append(result, detectDiskDrift(domainXML, "vdb", GetCIDataVolumeName(opt.Name))...)
+	// network interfaces, identified by their mac address
+	desired, err := CreateInstanceInterfacesXML(opt)
+	if err != nil {
+		log.Printf("Unable to create the interfaces of domain [%s], cause: [%v]", opt.Name, err)
+		return result
+	}
+	expectedIfaces := indexInterfaces(desired)
+	actualIfaces := indexInterfaces(domainXML.Devices.Interfaces)
+	for _, mac := range sortedKeys(expectedIfaces) {
+		expected := getInterfaceNetwork(expectedIfaces[mac])
+		actual := driftMissing
+		if iface, ok := actualIfaces[mac]; ok {
+			actual = getInterfaceNetwork(iface)
+		}
+		if actual != expected {
+			result = append(result, DomainDrift{Field: getInterfaceField(mac), Expected: expected, Actual: actual})
+		}
+	}
+	for _, mac := range sortedKeys(actualIfaces) {
+		if _, ok := expectedIfaces[mac]; !ok {
+			result = append(result, DomainDrift{Field: getInterfaceField(mac), Expected: driftAbsent, Actual: getInterfaceNetwork(actualIfaces[mac])})
+		}
+	}
+	return result
+}
+
+// repairInterfaces attaches missing and detaches unexpected network interfaces
+func repairInterfaces(conn *libvirt.Libvirt, domain libvirt.Domain, domainXML *libvirtxml.Domain, opt *InstanceOptions, flags uint32) error {
+	desired, err := CreateInstanceInterfacesXML(opt)
+	if err != nil {
+		return err
+	}
+	expectedIfaces := indexInterfaces(desired)
+	actualIfaces := indexInterfaces(domainXML.Devices.Interfaces)
+	// detach the interfaces that are not expected or that are attached to the wrong network
+	for _, mac := range sortedKeys(actualIfaces) {
+		iface := actualIfaces[mac]
+		if expected, ok := expectedIfaces[mac]; ok && getInterfaceNetwork(expected) == getInterfaceNetwork(iface) {
+			continue
+		}
+		ifaceXML, err := XMLMarshall(iface)
+		if err != nil {
+			return err
+		}
+		log.Printf("Detaching interface [%s] from domain [%s] ...", mac, opt.Name)
+		if err := conn.DomainDetachDeviceFlags(domain, ifaceXML, flags); err != nil {
+			return fmt.Errorf("%w: unable to detach interface [%s] from domain [%s], cause: [%v]", ErrHotplugFailed, mac, opt.Name, err)
+		}
+		delete(actualIfaces, mac)
+	}
+	// attach the missing interfaces
+	for _, mac := range sortedKeys(expectedIfaces) {
+		if _, ok := actualIfaces[mac]; ok {
+			continue
+		}
+		ifaceXML, err := XMLMarshall(expectedIfaces[mac])
+		if err != nil {
+			return err
+		}
+		log.Printf("Attaching interface [%s] to domain [%s] ...", mac, opt.Name)
+		if err := conn.DomainAttachDeviceFlags(domain, ifaceXML, flags); err != nil {
+			return fmt.Errorf("%w: unable to attach interface [%s] to domain [%s], cause: [%v]", ErrHotplugFailed, mac, opt.Name, err)
+		}
+	}
+	return nil
+}
+
+// repairDefinition resets memory and CPUs in the persistent definition of a domain
+func repairDefinition(conn *libvirt.Libvirt, domain libvirt.Domain) error {
+	xmlDesc, err := conn.DomainGetXMLDesc(domain, libvirt.DomainXMLInactive)
+	if err != nil {
+		return err
+	}
+	domainXML, err := parseDomainXML(xmlDesc)
+	if err != nil {
+		return err
+	}
+	domainXML.Memory = &libvirtxml.DomainMemory{
+		Value: uint(InstanceMemory / 1024),
+	}
+	domainXML.CurrentMemory = &libvirtxml.DomainCurrentMemory{
+		Value: uint(InstanceMemory / 1024),
+	}
+	domainXML.VCPU = &libvirtxml.DomainVCPU{
+		Value: InstanceCPUs,
+	}
+	domainString, err := XMLMarshall(domainXML)
+	if err != nil {
+		return err
+	}
+	log.Printf("Redefining domain [%s] ...", domain.Name)
+	_, err = conn.DomainDefineXML(domainString)
+	return err
+}
+
+// RepairDrift repairs the drift of a domain without recreating it. Network interfaces are attached or detached,
+// memory and CPUs are reset in the definition of the domain, which requires a restart of a running domain. It returns
+// the drift that cannot be repaired and if the domain has been restarted.
+func RepairDrift(client *LivirtClient) func(domainXML *libvirtxml.Domain, opt *InstanceOptions, drift []DomainDrift) ([]DomainDrift, bool, error) {
+	conn := client.LibVirt
+	restartInstance := RestartInstanceSync(client)
+
+	return func(domainXML *libvirtxml.Domain, opt *InstanceOptions, drift []DomainDrift) ([]DomainDrift, bool, error) {
+		// log this config
+		defer CM.EntryExit(fmt.Sprintf("RepairDrift(%s)", opt.Name))()
+
+		var remaining []DomainDrift
+		interfaces := false
+		definition := false
+		for _, item := range drift {
+			switch {
+			case isInterfaceField(item.Field):
+				interfaces = true
+			case isDefinitionField(item.Field):
+				definition = true
+			default:
+				remaining = append(remaining, item)
+			}
+		}
+		if len(remaining) > 0 {
+			// the domain will be recreated anyway
+			return remaining, false, nil
+		}
+		domain, err := conn.DomainLookupByName(opt.Name)
+		if err != nil {
+			return nil, false, err
+		}
+		active, err := conn.DomainIsActive(domain)
+		if err != nil {
+			return nil, false, err
+		}
+		// a running domain is modified live and persistently
+		flags := uint32(libvirt.DomainDeviceModifyConfig)
+		if active == 1 {
+			flags |= uint32(libvirt.DomainDeviceModifyLive)
+		}
+		if interfaces {
+			if err := repairInterfaces(conn, domain, domainXML, opt, flags); err != nil {
+				return nil, false, err
+			}
+		}
+		if !definition {
+			return nil, false, nil
+		}
+		if err := repairDefinition(conn, domain); err != nil {
+			return nil, false, err
+		}
+		if active != 1 {
+			// the definition applies at the next start
+			return nil, false, nil
+		}
+		return nil, true, restartInstance(opt)
+	}
+}
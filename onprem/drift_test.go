@@ -0,0 +1,131 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"libvirt.org/go/libvirtxml"
+)
+
+func createDiskWithSource(dev, file string) libvirtxml.DomainDisk {
+	return libvirtxml.DomainDisk{
+		Target: &libvirtxml.DomainDiskTarget{Dev: dev},
+		Source: &libvirtxml.DomainDiskSource{
+			File: &libvirtxml.DomainDiskSourceFile{File: file},
+		},
+	}
+}
+
+// createDesiredDomain produces a domain as the operator would create it
+func createDesiredDomain(t *testing.T, opt *InstanceOptions) *libvirtxml.Domain {
+	ifaces, err := CreateInstanceInterfacesXML(opt)
+	require.NoError(t, err)
+	return &libvirtxml.Domain{
+		Memory:        &libvirtxml.DomainMemory{Value: uint(InstanceMemory / 1024), Unit: "KiB"},
+		CurrentMemory: &libvirtxml.DomainCurrentMemory{Value: uint(InstanceMemory / 1024), Unit: "KiB"},
+		VCPU:          &libvirtxml.DomainVCPU{Value: InstanceCPUs},
+		Devices: &libvirtxml.DomainDeviceList{
+			Disks: []libvirtxml.DomainDisk{
+				createDiskWithSource("vda", "/var/lib/libvirt/images/"+GetBootVolumeName(opt.Name)),
+				createDiskWithSource("vdb", "/var/lib/libvirt/images/"+GetCIDataVolumeName(opt.Name)),
+				createDiskWithSource("vdd", "/var/lib/libvirt/images/datadisk"),
+			},
+			Interfaces: ifaces,
+		},
+	}
+}
+
+func TestDetectNoDrift(t *testing.T) {
+	opt := &InstanceOptions{Name: "vsi", Networks: []string{"net1", "net2"}}
+	assert.Empty(t, DetectDrift(opt, createDesiredDomain(t, opt)))
+	// the default network
+	opt = &InstanceOptions{Name: "vsi"}
+	assert.Empty(t, DetectDrift(opt, createDesiredDomain(t, opt)))
+}
+
+func TestDetectMemoryDrift(t *testing.T) {
+	opt := &InstanceOptions{Name: "vsi"}
+	domain := createDesiredDomain(t, opt)
+	// same size in a different unit
+	domain.Memory = &libvirtxml.DomainMemory{Value: 4, Unit: "GiB"}
+	assert.Empty(t, DetectDrift(opt, domain))
+
+	domain.Memory = &libvirtxml.DomainMemory{Value: 2, Unit: "GiB"}
+	domain.VCPU.Value = 4
+	assert.Equal(t, []DomainDrift{
+		{Field: "memory", Expected: "4194304 KiB", Actual: "2097152 KiB"},
+		{Field: "vcpus", Expected: "2", Actual: "4"},
+	}, DetectDrift(opt, domain))
+}
+
+func TestDetectDiskDrift(t *testing.T) {
+	opt := &InstanceOptions{Name: "vsi"}
+	domain := createDesiredDomain(t, opt)
+	// remove the cloud-init disk and replace the boot disk
+	domain.Devices.Disks = []libvirtxml.DomainDisk{
+		createDiskWithSource("vda", "/var/lib/libvirt/images/other.qcow2"),
+	}
+	assert.Equal(t, []DomainDrift{
+		{Field: "disks[vda]", Expected: "boot-vsi.qcow2", Actual: "other.qcow2"},
+		{Field: "disks[vdb]", Expected: "cidata-vsi.iso", Actual: "missing"},
+	}, DetectDrift(opt, domain))
+}
+
+func TestDetectInterfaceDrift(t *testing.T) {
+	opt := &InstanceOptions{Name: "vsi", Networks: []string{"net1", "net2"}}
+	domain := createDesiredDomain(t, opt)
+	// libvirt reports mac addresses in lower case
+	mac := strings.ToLower(GetInterfaceMacAddress("vsi", "net2"))
+	// detach the second interface and attach a foreign one
+	domain.Devices.Interfaces = []libvirtxml.DomainInterface{
+		domain.Devices.Interfaces[0],
+		{
+			MAC: &libvirtxml.DomainInterfaceMAC{Address: "52:54:00:00:00:01"},
+			Source: &libvirtxml.DomainInterfaceSource{
+				Network: &libvirtxml.DomainInterfaceSourceNetwork{Network: "other"},
+			},
+		},
+	}
+	assert.Equal(t, []DomainDrift{
+		{Field: "interfaces[" + mac + "]", Expected: "net2", Actual: "missing"},
+		{Field: "interfaces[52:54:00:00:00:01]", Expected: "absent", Actual: "other"},
+	}, DetectDrift(opt, domain))
+}
+
+func TestMemoryToKiB(t *testing.T) {
+	assert.Equal(t, uint64(1024), memoryToKiB(1024, ""))
+	assert.Equal(t, uint64(1024), memoryToKiB(1, "MiB"))
+	assert.Equal(t, uint64(1), memoryToKiB(1024, "bytes"))
+}
+
+func TestBoxDriftPolicy(t *testing.T) {
+	assert.Equal(t, DriftPolicyAlert, BoxDriftPolicy(""))
+	assert.Equal(t, DriftPolicyRepair, BoxDriftPolicy(DriftPolicyRepair))
+}
+
+func TestIsDriftReportOnly(t *testing.T) {
+	opt := &InstanceOptions{Name: "vsi"}
+	interfaces := DomainDrift{Field: driftFieldInterfaces, Expected: "a,b", Actual: "a"}
+	memory := DomainDrift{Field: driftFieldMemory, Expected: "4194304 KiB", Actual: "2097152 KiB"}
+	assert.False(t, IsDriftReportOnly(opt, interfaces))
+	// the interfaces of an adopted domain cannot be repaired
+	opt.Adopted = true
+	assert.True(t, IsDriftReportOnly(opt, interfaces))
+	assert.False(t, IsDriftReportOnly(opt, memory))
+}
@@ -0,0 +1,199 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// environment key of the access key for S3 exports
+	KeyS3AccessKeyID = "S3_ACCESS_KEY_ID"
+	// environment key of the secret key for S3 exports
+	KeyS3SecretAccessKey = "S3_SECRET_ACCESS_KEY"
+
+	// region used to sign S3 requests if none is configured
+	DefaultS3Region = "us-east-1"
+
+	s3Service         = "s3"
+	s3Algorithm       = "AWS4-HMAC-SHA256"
+	s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+	s3DateFormat      = "20060102"
+	s3TimeFormat      = "20060102T150405Z"
+)
+
+// SnapshotExporter copies the bytes of a snapshot to an export target
+type SnapshotExporter interface {
+	// Export stores `size` bytes read from `data` under the given key
+	Export(key string, data io.Reader, size int64) error
+}
+
+// fileExporter stores snapshots in a local directory
+type fileExporter struct {
+	dir string
+}
+
+// s3Exporter stores snapshots in a bucket of an S3 compatible object store
+type s3Exporter struct {
+	client          *http.Client
+	endpoint        *url.URL
+	bucket          string
+	region          string
+	accessKeyID     string
+	secretAccessKey string
+	now             func() time.Time
+}
+
+func (exp *fileExporter) Export(key string, data io.Reader, size int64) error {
+	target := filepath.Join(exp.dir, filepath.Clean("/"+key))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	file, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer safeClose(file)
+
+	written, err := io.Copy(file, data)
+	if err != nil {
+		return err
+	}
+	if written != size {
+		return fmt.Errorf("exported [%d] bytes to [%s], but expected [%d] bytes", written, target, size)
+	}
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3EscapePath encodes the segments of an object path as required by the signature
+func s3EscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(url.PathEscape(segment), "+", "%2B")
+	}
+	return strings.Join(segments, "/")
+}
+
+// sign adds a signature version 4 authorization header to the request, the payload is not signed
+func (exp *s3Exporter) sign(req *http.Request) {
+	now := exp.now().UTC()
+	amzDate := now.Format(s3TimeFormat)
+	date := now.Format(s3DateFormat)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", s3UnsignedPayload)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"",
+		fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", req.URL.Host, s3UnsignedPayload, amzDate),
+		signedHeaders,
+		s3UnsignedPayload,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", date, exp.region, s3Service)
+	stringToSign := strings.Join([]string{s3Algorithm, amzDate, scope, hex.EncodeToString(hash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+exp.secretAccessKey), date)
+	key = hmacSHA256(key, exp.region)
+	key = hmacSHA256(key, s3Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s", s3Algorithm, exp.accessKeyID, scope, signedHeaders, signature))
+}
+
+func (exp *s3Exporter) Export(key string, data io.Reader, size int64) error {
+	target := *exp.endpoint
+	target.Path = fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(exp.endpoint.Path, "/"), exp.bucket, strings.TrimPrefix(key, "/"))
+	target.RawPath = s3EscapePath(target.Path)
+
+	req, err := http.NewRequest(http.MethodPut, target.String(), data)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	exp.sign(req)
+
+	resp, err := exp.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer safeClose(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("export to [%s] failed with status [%s]: [%s]", target.Redacted(), resp.Status, string(body))
+	}
+	return nil
+}
+
+// CreateSnapshotExporter creates the exporter for an export specification, S3 credentials are read from the environment
+func CreateSnapshotExporter(spec *DataDiskSnapshotExportSpec, env map[string]string) (SnapshotExporter, error) {
+	endpoint, err := url.Parse(spec.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	switch endpoint.Scheme {
+	case "file":
+		return &fileExporter{dir: endpoint.Path}, nil
+	case "http", "https":
+		if spec.Bucket == "" {
+			return nil, fmt.Errorf("export to [%s] requires a bucket", endpoint.Redacted())
+		}
+		accessKeyID, ok := env[KeyS3AccessKeyID]
+		if !ok {
+			return nil, fmt.Errorf("export to [%s] requires the [%s] key", endpoint.Redacted(), KeyS3AccessKeyID)
+		}
+		secretAccessKey, ok := env[KeyS3SecretAccessKey]
+		if !ok {
+			return nil, fmt.Errorf("export to [%s] requires the [%s] key", endpoint.Redacted(), KeyS3SecretAccessKey)
+		}
+		region := spec.Region
+		if region == "" {
+			region = DefaultS3Region
+		}
+		return &s3Exporter{
+			client:          http.DefaultClient,
+			endpoint:        endpoint,
+			bucket:          spec.Bucket,
+			region:          region,
+			accessKeyID:     accessKeyID,
+			secretAccessKey: secretAccessKey,
+			now:             time.Now,
+		}, nil
+	default:
+		return nil, fmt.Errorf("export endpoint [%s] has unsupported scheme [%s]", endpoint.Redacted(), endpoint.Scheme)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileExporter(t *testing.T) {
+	dir := t.TempDir()
+
+	exporter, err := CreateSnapshotExporter(&DataDiskSnapshotExportSpec{
+		Endpoint: "file://" + dir,
+	}, nil)
+	require.NoError(t, err)
+
+	data := "snapshot data"
+	err = exporter.Export("backups/snapshot-1", strings.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filepath.Join(dir, "backups", "snapshot-1"))
+	require.NoError(t, err)
+	assert.Equal(t, data, string(content))
+}
+
+func TestS3Exporter(t *testing.T) {
+	var received []byte
+	var path, auth, payload string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("Authorization")
+		payload = r.Header.Get("x-amz-content-sha256")
+		received, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	exporter, err := CreateSnapshotExporter(&DataDiskSnapshotExportSpec{
+		Endpoint: srv.URL,
+		Bucket:   "backups",
+	}, map[string]string{
+		KeyS3AccessKeyID:     "access",
+		KeyS3SecretAccessKey: "secret",
+	})
+	require.NoError(t, err)
+
+	data := "snapshot data"
+	err = exporter.Export("snapshot-1", strings.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	assert.Equal(t, "/backups/snapshot-1", path)
+	assert.Equal(t, data, string(received))
+	assert.Equal(t, "UNSIGNED-PAYLOAD", payload)
+	assert.True(t, strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=access/"))
+	assert.Contains(t, auth, "/us-east-1/s3/aws4_request")
+}
+
+func TestS3ExporterFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	exporter, err := CreateSnapshotExporter(&DataDiskSnapshotExportSpec{
+		Endpoint: srv.URL,
+		Bucket:   "backups",
+	}, map[string]string{
+		KeyS3AccessKeyID:     "access",
+		KeyS3SecretAccessKey: "secret",
+	})
+	require.NoError(t, err)
+
+	err = exporter.Export("snapshot-1", strings.NewReader("x"), 1)
+	assert.Error(t, err)
+}
+
+func TestS3ExporterMissingCredentials(t *testing.T) {
+	_, err := CreateSnapshotExporter(&DataDiskSnapshotExportSpec{
+		Endpoint: "https://s3.example.com",
+		Bucket:   "backups",
+	}, map[string]string{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,117 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/env"
+)
+
+const (
+	// KeySharedHost is the key into the configuration that marks a KVM host shared with operators of other clusters
+	KeySharedHost = "SHARED_HOST"
+)
+
+var (
+	// ErrForeignOwner signals that a libvirt object belongs to the operator of a different cluster
+	ErrForeignOwner = errors.New("owned by a different cluster")
+	// ErrMissingClusterID signals a shared KVM host without an identifier of the cluster
+	ErrMissingClusterID = errors.New("a shared host requires a cluster ID")
+)
+
+// Fence decides which libvirt objects the operator may modify
+type Fence struct {
+	// identifier of the k8s cluster of the operator
+	ClusterID string
+	// the KVM host is shared with operators of other clusters
+	SharedHost bool
+}
+
+// GetFenceFromEnvMap reads the fence from the configuration of a KVM host
+func GetFenceFromEnvMap(envMap env.Environment) (*Fence, error) {
+	fence := &Fence{
+		ClusterID: envMap[KeyClusterID],
+	}
+	if shared, ok := envMap[KeySharedHost]; ok {
+		value, err := strconv.ParseBool(shared)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value [%s] of [%s], cause: [%w]", shared, KeySharedHost, err)
+		}
+		fence.SharedHost = value
+	}
+	if fence.SharedHost && fence.ClusterID == "" {
+		return nil, ErrMissingClusterID
+	}
+	return fence, nil
+}
+
+func (fence *Fence) isShared() bool {
+	return fence != nil && fence.SharedHost
+}
+
+func (fence *Fence) clusterID() string {
+	if fence == nil {
+		return ""
+	}
+	return fence.ClusterID
+}
+
+// Permits tells if the operator may modify a domain. The metadata is nil for a domain not created by the operator,
+// the owner is nil if the VSI the domain belongs to is unknown.
+func (fence *Fence) Permits(name string, metadata *InstanceMetadata, owner *InstanceOwner) bool {
+	if owner != nil && owner.UID != "" {
+		// the VSI that owns a domain may always modify it
+		if metadata != nil && metadata.UID == owner.UID {
+			return true
+		}
+		// domains created by previous versions of the operator are named after the UID of their VSI
+		if name == owner.UID && (metadata == nil || metadata.UID == "") {
+			return true
+		}
+	}
+	// domains not created by the operator and domains created by previous versions are only touched on exclusive hosts
+	if metadata == nil || metadata.ClusterID == "" {
+		return !fence.isShared()
+	}
+	return metadata.ClusterID == fence.clusterID()
+}
+
+// PermitsVolume tells if the operator may delete a volume of a VSI whose domain does not exist. Volumes carry no
+// metadata, so without the domain the owner cannot be told on a shared host and the volume is left alone.
+func (fence *Fence) PermitsVolume(instance string) bool {
+	return !fence.isShared()
+}
+
+// CheckDomainOwnership returns an error if the operator must not modify a domain, a domain that does not exist
+// may be created
+func CheckDomainOwnership(client *LivirtClient) func(name string, owner *InstanceOwner) error {
+	getInstanceMetadata := GetInstanceMetadata(client)
+
+	return func(name string, owner *InstanceOwner) error {
+		metadata, exists := getInstanceMetadata(name)
+		if !exists || client.Fence.Permits(name, metadata, owner) {
+			return nil
+		}
+		log.Printf("Refusing to modify domain [%s], it is not owned by cluster [%s]", name, client.Fence.clusterID())
+		if metadata == nil {
+			return fmt.Errorf("%w: domain [%s] has not been created by the operator", ErrForeignOwner, name)
+		}
+		return fmt.Errorf("%w: domain [%s] is owned by cluster [%s]", ErrForeignOwner, name, metadata.ClusterID)
+	}
+}
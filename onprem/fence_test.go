@@ -0,0 +1,78 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"testing"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/env"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetFenceFromEnvMap(t *testing.T) {
+	fence, err := GetFenceFromEnvMap(env.Environment{})
+	require.NoError(t, err)
+	assert.Equal(t, &Fence{}, fence)
+
+	fence, err = GetFenceFromEnvMap(env.Environment{KeyClusterID: "prod", KeySharedHost: "true"})
+	require.NoError(t, err)
+	assert.Equal(t, &Fence{ClusterID: "prod", SharedHost: true}, fence)
+
+	_, err = GetFenceFromEnvMap(env.Environment{KeySharedHost: "true"})
+	assert.ErrorIs(t, err, ErrMissingClusterID)
+
+	_, err = GetFenceFromEnvMap(env.Environment{KeyClusterID: "prod", KeySharedHost: "maybe"})
+	assert.Error(t, err)
+}
+
+func TestFencePermits(t *testing.T) {
+	owner := &InstanceOwner{UID: "uid-1", ClusterID: "prod"}
+	own := &InstanceMetadata{UID: "uid-1", ClusterID: "prod"}
+	sibling := &InstanceMetadata{UID: "uid-2", ClusterID: "prod"}
+	other := &InstanceMetadata{UID: "uid-3", ClusterID: "staging"}
+	legacy := &InstanceMetadata{Hash: "abc"}
+
+	exclusive := &Fence{ClusterID: "prod"}
+	assert.True(t, exclusive.Permits("vsi", own, owner))
+	assert.True(t, exclusive.Permits("vsi", sibling, nil))
+	assert.False(t, exclusive.Permits("vsi", other, nil))
+	assert.False(t, exclusive.Permits("vsi", other, owner))
+	assert.True(t, exclusive.Permits("vsi", legacy, nil))
+	assert.True(t, exclusive.Permits("vsi", nil, nil))
+
+	shared := &Fence{ClusterID: "prod", SharedHost: true}
+	assert.True(t, shared.Permits("vsi", own, owner))
+	assert.True(t, shared.Permits("vsi", sibling, nil))
+	assert.False(t, shared.Permits("vsi", other, owner))
+	assert.False(t, shared.Permits("vsi", legacy, nil))
+	assert.False(t, shared.Permits("vsi", nil, nil))
+	// a domain of a previous version is named after the UID of its VSI
+	assert.True(t, shared.Permits("uid-1", legacy, owner))
+
+	// no fence behaves like an exclusive host without cluster ID
+	var none *Fence
+	assert.True(t, none.Permits("vsi", legacy, nil))
+	assert.False(t, none.Permits("vsi", other, nil))
+}
+
+func TestFencePermitsVolume(t *testing.T) {
+	assert.True(t, (&Fence{ClusterID: "prod"}).PermitsVolume("uid-1"))
+	shared := &Fence{ClusterID: "prod", SharedHost: true}
+	// the name does not tell the owner, a different cluster might be called `prod-eu`
+	assert.False(t, shared.PermitsVolume("prod-default-vsi"))
+	assert.False(t, shared.PermitsVolume("prod-eu-default-vsi"))
+	assert.False(t, shared.PermitsVolume("uid-1"))
+}
@@ -0,0 +1,298 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+)
+
+const (
+	// kinds of orphaned libvirt objects
+	OrphanKindDomain    = "Domain"
+	OrphanKindVolume    = "Volume"
+	OrphanKindBaseImage = "BaseImage"
+)
+
+// BaseImage identifies the uploaded image of a VSI in a storage pool
+type BaseImage struct {
+	// name of the storage pool
+	StoragePool string `json:"storagePool"`
+	// name of the volume
+	Name string `json:"name"`
+}
+
+// Orphan describes a libvirt object that no longer belongs to a VSI
+type Orphan struct {
+	// kind of the object
+	Kind string `json:"kind"`
+	// name of the storage pool of a volume
+	StoragePool string `json:"storagePool,omitempty"`
+	// name of the object
+	Name string `json:"name"`
+	// time the object has been detected as an orphan for the first time
+	FirstSeen time.Time `json:"firstSeen"`
+}
+
+type GarbageCollectionOptions struct {
+	// names of the storage pools to scan for orphaned volumes
+	StoragePools []string
+	// names of the domains and UIDs of the existing VSIs
+	Instances map[string]bool
+	// base images known to have been uploaded by the operator
+	BaseImages []BaseImage
+	// base images referenced by existing VSIs
+	UsedBaseImages []BaseImage
+	// cached base images staged by image resources, kept even without references
+	PinnedBaseImages []BaseImage
+}
+
+// GetInstanceOfVolume returns the name of the VSI a boot, cloud-init or console log volume belongs to
+func GetInstanceOfVolume(name string) (string, bool) {
+	if rest, ok := strings.CutPrefix(name, "boot-"); ok {
+		return strings.CutSuffix(rest, ".qcow2")
+	}
+	if rest, ok := strings.CutPrefix(name, "cidata-"); ok {
+		return strings.CutSuffix(rest, ".iso")
+	}
+	if rest, ok := strings.CutPrefix(name, "console-"); ok {
+		// the console log or one of its archives
+		idx := strings.Index(rest, ".log")
+		if idx <= 0 {
+			return "", false
+		}
+		suffix := rest[idx:]
+		if suffix == ".log" || strings.HasPrefix(suffix, ".log.") {
+			return rest[:idx], true
+		}
+	}
+	return "", false
+}
+
+// GetBaseImage returns the base image of a VSI
+func GetBaseImage(storagePool, imageURL string) BaseImage {
+	return BaseImage{StoragePool: BoxStoragePool(storagePool), Name: GetBaseImageName(imageURL)}
+}
+
+func getOrphanKey(orphan Orphan) string {
+	return fmt.Sprintf("%s/%s/%s", orphan.Kind, orphan.StoragePool, orphan.Name)
+}
+
+// MergeOrphans keeps the time of the first detection of orphans that have been detected before, new orphans are
+// detected at the given time. Orphans that are no longer detected are dropped.
+func MergeOrphans(previous, current []Orphan, now time.Time) []Orphan {
+	firstSeen := make(map[string]time.Time)
+	for _, orphan := range previous {
+		firstSeen[getOrphanKey(orphan)] = orphan.FirstSeen
+	}
+	result := make([]Orphan, len(current))
+	for idx, orphan := range current {
+		if t, ok := firstSeen[getOrphanKey(orphan)]; ok && !t.IsZero() {
+			orphan.FirstSeen = t
+		} else {
+			orphan.FirstSeen = now
+		}
+		result[idx] = orphan
+	}
+	return result
+}
+
+// IsOrphanDue tests if the grace period of an orphan has elapsed
+func IsOrphanDue(orphan Orphan, now time.Time, gracePeriod time.Duration) bool {
+	return !orphan.FirstSeen.IsZero() && now.Sub(orphan.FirstSeen) >= gracePeriod
+}
+
+// MergeBaseImages adds the base images of existing VSIs to the known base images
+func MergeBaseImages(known, used []BaseImage) []BaseImage {
+	set := make(map[BaseImage]bool)
+	for _, image := range known {
+		set[image] = true
+	}
+	for _, image := range used {
+		set[image] = true
+	}
+	result := make([]BaseImage, 0, len(set))
+	for image := range set {
+		result = append(result, image)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].StoragePool != result[j].StoragePool {
+			return result[i].StoragePool < result[j].StoragePool
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// isManagedDomainXML tests if the metadata of a domain has been written by the operator
+func isManagedDomainXML(metadata string) bool {
+	return strings.Contains(metadata, InstanceMetadataNamespace)
+}
+
+// FindOrphans lists the domains managed by the operator and the volumes of VSIs that do not belong to an existing
+// VSI, as well as the base images that are not referenced by an existing VSI and the cached base images that do not
+// back any volume
+func FindOrphans(client *LivirtClient) func(opt *GarbageCollectionOptions) ([]Orphan, error) {
+	conn := client.LibVirt
+	getDomains := GetDomains(client)
+	getVolumes := getStoragePoolVolumesXML(conn)
+
+	return func(opt *GarbageCollectionOptions) ([]Orphan, error) {
+		// log this config
+		defer CM.EntryExit("FindOrphans")()
+
+		var result []Orphan
+		// domains
+		domains, err := getDomains()
+		if err != nil {
+			return nil, err
+		}
+		// domains that must not be touched, their volumes must not be touched either
+		foreign := make(map[string]bool)
+		existing := make(map[string]bool)
+		for _, domain := range domains {
+			existing[domain.Name] = true
+			xmlDesc, err := conn.DomainGetXMLDesc(domain, 0)
+			if err != nil {
+				log.Printf("Unable to get the description of domain [%s], cause: [%v]", domain.Name, err)
+				foreign[domain.Name] = true
+				continue
+			}
+			domainXML, err := parseDomainXML(xmlDesc)
+			if err != nil || domainXML.Metadata == nil || !isManagedDomainXML(domainXML.Metadata.XML) {
+				// not our business
+				foreign[domain.Name] = !client.Fence.Permits(domain.Name, nil, nil)
+				continue
+			}
+			metadata, err := parseInstanceMetadata(domainXML.Metadata.XML)
+			if err != nil {
+				foreign[domain.Name] = true
+				continue
+			}
+			if !client.Fence.Permits(domain.Name, metadata, nil) {
+				// owned by the operator of a different cluster
+				foreign[domain.Name] = true
+				continue
+			}
+			if opt.Instances[domain.Name] || opt.Instances[metadata.UID] {
+				// the owner is identified by its name or UID
+				continue
+			}
+			result = append(result, Orphan{Kind: OrphanKindDomain, Name: domain.Name})
+		}
+		// volumes
+		used := make(map[BaseImage]bool)
+		for _, image := range opt.UsedBaseImages {
+			used[image] = true
+		}
+		known := make(map[BaseImage]bool)
+		for _, image := range opt.BaseImages {
+			known[image] = true
+		}
+		pinned := make(map[BaseImage]bool)
+		for _, image := range opt.PinnedBaseImages {
+			pinned[image] = true
+		}
+		for _, storagePool := range opt.StoragePools {
+			pool, err := conn.StoragePoolLookupByName(storagePool)
+			if err != nil {
+				return nil, err
+			}
+			volumes, err := getVolumes(pool)
+			if err != nil {
+				return nil, err
+			}
+			// the references cover the boot disks of all clusters on the host
+			references := countBaseImageReferences(volumes)
+			for _, vol := range volumes {
+				if instance, ok := GetInstanceOfVolume(vol.Name); ok {
+					if foreign[instance] || (!existing[instance] && !client.Fence.PermitsVolume(instance)) {
+						// the volume might belong to a VSI of a different cluster
+						continue
+					}
+					if !opt.Instances[instance] {
+						result = append(result, Orphan{Kind: OrphanKindVolume, StoragePool: storagePool, Name: vol.Name})
+					}
+					continue
+				}
+				if refs, ok := references[vol.Name]; ok {
+					if refs == 0 && !pinned[BaseImage{StoragePool: storagePool, Name: vol.Name}] {
+						result = append(result, Orphan{Kind: OrphanKindBaseImage, StoragePool: storagePool, Name: vol.Name})
+					}
+					continue
+				}
+				image := BaseImage{StoragePool: storagePool, Name: vol.Name}
+				// on a shared host the base image might be used by the VSIs of a different cluster
+				if known[image] && !used[image] && !client.Fence.isShared() {
+					result = append(result, Orphan{Kind: OrphanKindBaseImage, StoragePool: storagePool, Name: vol.Name})
+				}
+			}
+		}
+		return result, nil
+	}
+}
+
+// DeleteOrphan deletes an orphaned domain or volume
+func DeleteOrphan(client *LivirtClient) func(orphan Orphan) error {
+	conn := client.LibVirt
+	deleteDomain := DeleteDomainByName(client)
+	delVolume := deleteStorageVol(conn)
+	getInstanceMetadata := GetInstanceMetadata(client)
+	getReferences := GetBaseImageReferences(client)
+
+	return func(orphan Orphan) error {
+		// log this config
+		defer CM.EntryExit(fmt.Sprintf("DeleteOrphan(%s, %s, %s)", orphan.Kind, orphan.StoragePool, orphan.Name))()
+
+		if orphan.Kind == OrphanKindDomain {
+			return deleteDomain(orphan.Name)
+		}
+		// the orphan has been found earlier, so make sure the situation did not change
+		if orphan.Kind == OrphanKindBaseImage && IsCachedBaseImageName(orphan.Name) {
+			refs, err := getReferences(orphan.StoragePool)
+			if err != nil {
+				return err
+			}
+			if refs[orphan.Name] > 0 {
+				return fmt.Errorf("%w: base image [%s] backs [%d] volumes", ErrBaseImageInUse, orphan.Name, refs[orphan.Name])
+			}
+		} else if orphan.Kind == OrphanKindBaseImage && client.Fence.isShared() {
+			return fmt.Errorf("%w: base image [%s] might be used on a shared host", ErrForeignOwner, orphan.Name)
+		}
+		if instance, ok := GetInstanceOfVolume(orphan.Name); ok {
+			metadata, exists := getInstanceMetadata(instance)
+			if (exists && !client.Fence.Permits(instance, metadata, nil)) || (!exists && !client.Fence.PermitsVolume(instance)) {
+				return fmt.Errorf("%w: volume [%s] might belong to a VSI of a different cluster", ErrForeignOwner, orphan.Name)
+			}
+		}
+		pool, err := conn.StoragePoolLookupByName(orphan.StoragePool)
+		if err != nil {
+			return err
+		}
+		if orphan.Kind == OrphanKindBaseImage && IsCachedBaseImageName(orphan.Name) {
+			// the marker goes first, so an interrupted removal leaves an incomplete image behind
+			if err := deleteBaseImageMarker(conn)(pool, orphan.Name); err != nil {
+				return err
+			}
+		}
+		_, err = delVolume(pool, orphan.Name)
+		return err
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetInstanceOfVolume(t *testing.T) {
+	for volume, instance := range map[string]string{
+		GetBootVolumeName("vsi1"):                      "vsi1",
+		GetCIDataVolumeName("vsi2"):                    "vsi2",
+		GetLoggingVolumeName("vsi3"):                   "vsi3",
+		GetLoggingArchiveName("vsi4", time.Unix(0, 0)): "vsi4",
+	} {
+		name, ok := GetInstanceOfVolume(volume)
+		assert.True(t, ok, volume)
+		assert.Equal(t, instance, name)
+	}
+	for _, volume := range []string{"hpcr.qcow2", "boot-vsi1.iso", "console-.log", "console-vsi1.logfile", "datadisk"} {
+		_, ok := GetInstanceOfVolume(volume)
+		assert.False(t, ok, volume)
+	}
+}
+
+func TestMergeOrphans(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Hour)
+
+	previous := []Orphan{
+		{Kind: OrphanKindDomain, Name: "vsi1", FirstSeen: t0},
+		{Kind: OrphanKindVolume, StoragePool: "default", Name: "boot-vsi2.qcow2", FirstSeen: t0},
+	}
+	current := []Orphan{
+		{Kind: OrphanKindDomain, Name: "vsi1"},
+		{Kind: OrphanKindVolume, StoragePool: "images", Name: "boot-vsi2.qcow2"},
+	}
+	assert.Equal(t, []Orphan{
+		{Kind: OrphanKindDomain, Name: "vsi1", FirstSeen: t0},
+		// a different pool makes a different orphan
+		{Kind: OrphanKindVolume, StoragePool: "images", Name: "boot-vsi2.qcow2", FirstSeen: t1},
+	}, MergeOrphans(previous, current, t1))
+}
+
+func TestIsOrphanDue(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	orphan := Orphan{Kind: OrphanKindDomain, Name: "vsi1", FirstSeen: t0}
+
+	assert.False(t, IsOrphanDue(orphan, t0.Add(time.Minute), time.Hour))
+	assert.True(t, IsOrphanDue(orphan, t0.Add(time.Hour), time.Hour))
+	assert.False(t, IsOrphanDue(Orphan{}, t0, 0))
+}
+
+func TestMergeBaseImages(t *testing.T) {
+	known := []BaseImage{{StoragePool: "default", Name: "hpcr-1.qcow2"}}
+	used := []BaseImage{
+		GetBaseImage("", "https://example.com/images/hpcr-2.qcow2"),
+		{StoragePool: "default", Name: "hpcr-1.qcow2"},
+	}
+	assert.Equal(t, []BaseImage{
+		{StoragePool: "default", Name: "hpcr-1.qcow2"},
+		{StoragePool: "default", Name: "hpcr-2.qcow2"},
+	}, MergeBaseImages(known, used))
+}
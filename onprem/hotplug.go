@@ -0,0 +1,325 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	A "github.com/IBM/fp-go/array"
+	libvirt "github.com/digitalocean/go-libvirt"
+	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+	"libvirt.org/go/libvirtxml"
+)
+
+const (
+	// index of the first device used for data disks, `vda` is the boot disk, `vdb` the cidata disk, `vdc` is reserved
+	firstDataDiskIndex = 3
+)
+
+var (
+	// ErrDataDiskInUse signals that a data disk is attached to a different domain
+	ErrDataDiskInUse = errors.New("data disk in use")
+	// ErrHotplugFailed signals that a data disk could not be attached to or detached from a running domain
+	ErrHotplugFailed = errors.New("hot-plug failed")
+
+	// devices that are not used for data disks
+	reservedDevices = map[string]bool{
+		"vda": true,
+		"vdb": true,
+		"vdc": true,
+	}
+)
+
+// GetDataDiskDevice returns the name of the device of the data disk with the given index, starting at `vdd`
+func GetDataDiskDevice(index int) string {
+	var suffix []byte
+	for n := index + firstDataDiskIndex + 1; n > 0; n = (n - 1) / 26 {
+		suffix = append([]byte{byte('a' + (n-1)%26)}, suffix...)
+	}
+	return fmt.Sprintf("vd%s", suffix)
+}
+
+// AssignDataDiskDevices assigns a device to each data disk. Disks that are already attached keep their device,
+// new disks get the lowest free device, so the device names do not shift if disks are added or removed.
+func AssignDataDiskDevices(attached map[string]string, disks []*AttachedDataDisk) map[string]string {
+	result := make(map[string]string)
+	used := make(map[string]bool)
+	sorted := sortDataDisks(disks)
+	for _, disk := range sorted {
+		if dev, ok := attached[disk.Name]; ok {
+			result[disk.Name] = dev
+			used[dev] = true
+		}
+	}
+	idx := 0
+	for _, disk := range sorted {
+		if _, ok := result[disk.Name]; ok {
+			continue
+		}
+		for used[GetDataDiskDevice(idx)] {
+			idx++
+		}
+		dev := GetDataDiskDevice(idx)
+		result[disk.Name] = dev
+		used[dev] = true
+	}
+	return result
+}
+
+// getAttachedDataDisks returns the data disks attached to a domain, keyed by the name of the volume
+func getAttachedDataDisks(conn *libvirt.Libvirt) func(domainXML *libvirtxml.Domain) map[string]libvirtxml.DomainDisk {
+	return func(domainXML *libvirtxml.Domain) map[string]libvirtxml.DomainDisk {
+		result := make(map[string]libvirtxml.DomainDisk)
+		if domainXML == nil || domainXML.Devices == nil {
+			return result
+		}
+		for _, disk := range domainXML.Devices.Disks {
+			if disk.Target == nil || reservedDevices[disk.Target.Dev] || disk.Device == "cdrom" || disk.Source == nil || disk.Source.File == nil {
+				continue
+			}
+			vol, err := conn.StorageVolLookupByPath(disk.Source.File.File)
+			if err != nil {
+				log.Printf("Unable to lookup the volume of disk [%s] of domain [%s], cause: [%v]", disk.Target.Dev, domainXML.Name, err)
+				continue
+			}
+			result[vol.Name] = disk
+		}
+		return result
+	}
+}
+
+// getDataDiskDevices maps the attached disks to their device names
+func getDataDiskDevices(attached map[string]libvirtxml.DomainDisk) map[string]string {
+	result := make(map[string]string)
+	for name, disk := range attached {
+		result[name] = disk.Target.Dev
+	}
+	return result
+}
+
+// GetDomainDataDiskDevices returns the devices of the data disks attached to a domain, keyed by the name of the volume
+func GetDomainDataDiskDevices(client *LivirtClient) func(domainXML *libvirtxml.Domain) map[string]string {
+	getAttached := getAttachedDataDisks(client.LibVirt)
+	return func(domainXML *libvirtxml.Domain) map[string]string {
+		return getDataDiskDevices(getAttached(domainXML))
+	}
+}
+
+// DataDiskAttachment describes the attachment of a volume to a domain
+type DataDiskAttachment struct {
+	// name of the domain
+	Domain string `json:"domain"`
+	// device of the volume in the domain
+	Device string `json:"device"`
+}
+
+// getVolumeAttachments returns the domains that use the volume with the given path
+func getVolumeAttachments(conn *libvirt.Libvirt) func(path string, flags libvirt.ConnectListAllDomainsFlags) ([]DataDiskAttachment, error) {
+	return func(path string, flags libvirt.ConnectListAllDomainsFlags) ([]DataDiskAttachment, error) {
+		domains, _, err := conn.ConnectListAllDomains(1000, flags)
+		if err != nil {
+			return nil, err
+		}
+		var result []DataDiskAttachment
+		for _, domain := range domains {
+			xmlDesc, err := conn.DomainGetXMLDesc(domain, 0)
+			if err != nil {
+				log.Printf("Unable to get the description of domain [%s], cause: [%v]", domain.Name, err)
+				continue
+			}
+			domainXML, err := parseDomainXML(xmlDesc)
+			if err != nil || domainXML.Devices == nil {
+				continue
+			}
+			for idx := range domainXML.Devices.Disks {
+				disk := domainXML.Devices.Disks[idx]
+				if disk.Source != nil && disk.Source.File != nil && disk.Source.File.File == path {
+					attachment := DataDiskAttachment{Domain: domain.Name}
+					if disk.Target != nil {
+						attachment.Device = disk.Target.Dev
+					}
+					result = append(result, attachment)
+					break
+				}
+			}
+		}
+		return result, nil
+	}
+}
+
+func getAttachmentDomain(attachment DataDiskAttachment) string {
+	return attachment.Domain
+}
+
+// getVolumeDomains returns the names of the domains that use the volume with the given path
+func getVolumeDomains(conn *libvirt.Libvirt) func(path string, flags libvirt.ConnectListAllDomainsFlags) ([]string, error) {
+	volumeAttachments := getVolumeAttachments(conn)
+
+	return func(path string, flags libvirt.ConnectListAllDomainsFlags) ([]string, error) {
+		attachments, err := volumeAttachments(path, flags)
+		if err != nil {
+			return nil, err
+		}
+		return A.MonadMap(attachments, getAttachmentDomain), nil
+	}
+}
+
+// getVolumePath returns the path of a volume
+func getVolumePath(conn *libvirt.Libvirt) func(storagePool, name string) (string, error) {
+	return func(storagePool, name string) (string, error) {
+		pool, err := conn.StoragePoolLookupByName(storagePool)
+		if err != nil {
+			return "", err
+		}
+		vol, err := conn.StorageVolLookupByName(pool, name)
+		if err != nil {
+			return "", err
+		}
+		return conn.StorageVolGetPath(vol)
+	}
+}
+
+// GetDataDiskAttachments returns the attachments of a volume to domains, running or not
+func GetDataDiskAttachments(client *LivirtClient) func(storagePool, name string) ([]DataDiskAttachment, error) {
+	conn := client.LibVirt
+	volumePath := getVolumePath(conn)
+	volumeAttachments := getVolumeAttachments(conn)
+
+	return func(storagePool, name string) ([]DataDiskAttachment, error) {
+		path, err := volumePath(storagePool, name)
+		if err != nil {
+			return nil, err
+		}
+		return volumeAttachments(path, 0)
+	}
+}
+
+// checkDataDisksExclusive makes sure that none of the data disks is attached to a domain other than the given one
+func checkDataDisksExclusive(conn *libvirt.Libvirt) func(name string, disks []*AttachedDataDisk) error {
+	volumePath := getVolumePath(conn)
+	volumeDomains := getVolumeDomains(conn)
+
+	return func(name string, disks []*AttachedDataDisk) error {
+		for _, disk := range disks {
+			path, err := volumePath(disk.StoragePool, disk.Name)
+			if err != nil {
+				return err
+			}
+			domains, err := volumeDomains(path, 0)
+			if err != nil {
+				return err
+			}
+			for _, domain := range domains {
+				if domain != name {
+					return fmt.Errorf("%w: data disk [%s] is attached to VSI [%s]", ErrDataDiskInUse, disk.Name, domain)
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// isDataDiskDriverChanged tests if the driver of an attached disk differs from the driver settings of the data disk
+func isDataDiskDriverChanged(disk *AttachedDataDisk, attached *libvirtxml.DomainDisk) bool {
+	var cache, io, discard string
+	if attached.Driver != nil {
+		cache, io, discard = attached.Driver.Cache, attached.Driver.IO, attached.Driver.Discard
+	}
+	return cache != disk.Cache || io != disk.IO || discard != disk.Discard
+}
+
+// SyncDataDisks attaches and detaches data disks to and from a running domain so they match the instance options. A
+// change of the driver of an attached disk is reported as [ErrHotplugFailed], so the domain is recreated.
+func SyncDataDisks(client *LivirtClient) func(domainXML *libvirtxml.Domain, opt *InstanceOptions) (*libvirtxml.Domain, error) {
+	conn := client.LibVirt
+	getAttached := getAttachedDataDisks(conn)
+	checkExclusive := checkDataDisksExclusive(conn)
+	createDataDiskXML := CreateDataDiskXML(client)
+
+	hotplugFlags := uint32(libvirt.DomainDeviceModifyLive | libvirt.DomainDeviceModifyConfig)
+
+	return func(domainXML *libvirtxml.Domain, opt *InstanceOptions) (*libvirtxml.Domain, error) {
+		// log this config
+		defer CM.EntryExit(fmt.Sprintf("SyncDataDisks(%s)", opt.Name))()
+
+		if err := checkExclusive(opt.Name, opt.DataDisks); err != nil {
+			return nil, err
+		}
+		attached := getAttached(domainXML)
+		desired := make(map[string]bool)
+		for _, disk := range opt.DataDisks {
+			desired[disk.Name] = true
+			// the driver of an attached disk cannot be changed while the domain is running
+			if current, ok := attached[disk.Name]; ok && isDataDiskDriverChanged(disk, &current) {
+				return nil, fmt.Errorf("%w: the driver of data disk [%s] of domain [%s] has changed", ErrHotplugFailed, disk.Name, opt.Name)
+			}
+		}
+		// check if there is anything to do
+		changed := len(attached) != len(desired)
+		for name := range attached {
+			changed = changed || !desired[name]
+		}
+		if !changed {
+			return domainXML, nil
+		}
+		domain, err := conn.DomainLookupByName(opt.Name)
+		if err != nil {
+			return nil, err
+		}
+		// detach the disks that are no longer selected
+		for name, disk := range attached {
+			if desired[name] {
+				continue
+			}
+			diskXML, err := XMLMarshall(disk)
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("Detaching data disk [%s] from device [%s] of domain [%s] ...", name, disk.Target.Dev, opt.Name)
+			if err := conn.DomainDetachDeviceFlags(domain, diskXML, hotplugFlags); err != nil {
+				return nil, fmt.Errorf("%w: unable to detach data disk [%s] from domain [%s], cause: [%v]", ErrHotplugFailed, name, opt.Name, err)
+			}
+			delete(attached, name)
+		}
+		// attach the new disks
+		devices := AssignDataDiskDevices(getDataDiskDevices(attached), opt.DataDisks)
+		for _, disk := range sortDataDisks(opt.DataDisks) {
+			if _, ok := attached[disk.Name]; ok {
+				continue
+			}
+			dev := devices[disk.Name]
+			diskXML, err := createDataDiskXML(disk, dev)
+			if err != nil {
+				return nil, err
+			}
+			diskStrg, err := XMLMarshall(diskXML)
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("Attaching data disk [%s] as device [%s] to domain [%s] ...", disk.Name, dev, opt.Name)
+			if err := conn.DomainAttachDeviceFlags(domain, diskStrg, hotplugFlags); err != nil {
+				return nil, fmt.Errorf("%w: unable to attach data disk [%s] to domain [%s], cause: [%v]", ErrHotplugFailed, disk.Name, opt.Name, err)
+			}
+		}
+		// report the updated domain
+		xmlDesc, err := conn.DomainGetXMLDesc(domain, 0)
+		if err != nil {
+			return nil, err
+		}
+		return parseDomainXML(xmlDesc)
+	}
+}
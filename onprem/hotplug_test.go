@@ -0,0 +1,66 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"libvirt.org/go/libvirtxml"
+)
+
+func TestGetDataDiskDevice(t *testing.T) {
+	assert.Equal(t, "vdd", GetDataDiskDevice(0))
+	assert.Equal(t, "vde", GetDataDiskDevice(1))
+	assert.Equal(t, "vdz", GetDataDiskDevice(22))
+	assert.Equal(t, "vdaa", GetDataDiskDevice(23))
+	assert.Equal(t, "vdab", GetDataDiskDevice(24))
+}
+
+func TestAssignDataDiskDevices(t *testing.T) {
+	disks := []*AttachedDataDisk{
+		{Name: "c", StoragePool: "default"},
+		{Name: "a", StoragePool: "default"},
+	}
+	// new disks are assigned in the order of their names
+	devices := AssignDataDiskDevices(map[string]string{}, disks)
+	assert.Equal(t, map[string]string{"a": "vdd", "c": "vde"}, devices)
+
+	// adding a disk does not shift the existing devices
+	disks = append(disks, &AttachedDataDisk{Name: "b", StoragePool: "default"})
+	devices = AssignDataDiskDevices(devices, disks)
+	assert.Equal(t, map[string]string{"a": "vdd", "c": "vde", "b": "vdf"}, devices)
+
+	// removing a disk frees its device for the next disk
+	disks = []*AttachedDataDisk{
+		{Name: "b", StoragePool: "default"},
+		{Name: "c", StoragePool: "default"},
+		{Name: "d", StoragePool: "default"},
+	}
+	devices = AssignDataDiskDevices(map[string]string{"b": "vdf", "c": "vde"}, disks)
+	assert.Equal(t, map[string]string{"b": "vdf", "c": "vde", "d": "vdd"}, devices)
+}
+
+func TestIsDataDiskDriverChanged(t *testing.T) {
+	disk := &AttachedDataDisk{Name: "disk"}
+	attached := &libvirtxml.DomainDisk{Driver: createDataDiskDriver(disk, VolumeFormatQCOW2)}
+	assert.False(t, isDataDiskDriverChanged(disk, attached))
+	assert.False(t, isDataDiskDriverChanged(disk, &libvirtxml.DomainDisk{}))
+
+	changed := &AttachedDataDisk{Name: "disk", Cache: "none", Discard: "unmap"}
+	assert.True(t, isDataDiskDriverChanged(changed, attached))
+	attached.Driver = createDataDiskDriver(changed, VolumeFormatQCOW2)
+	assert.False(t, isDataDiskDriverChanged(changed, attached))
+}
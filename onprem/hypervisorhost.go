@@ -0,0 +1,152 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+)
+
+var (
+	// full identifier of the hypervisor host config entry
+	KeyHostConfig = fmt.Sprintf("%s.%s", KindHost, APIVersion)
+	// full identifier of the VSI config entry
+	KeyVSIConfig = fmt.Sprintf("%s.%s", KindVSI, APIVersion)
+)
+
+// HostInfo describes the resources of a hypervisor host, all sizes are in bytes
+type HostInfo struct {
+	// number of physical CPUs
+	CPUs int `json:"cpus"`
+	// physical memory
+	Memory uint64 `json:"memory"`
+	// memory not in use by the host
+	FreeMemory uint64 `json:"freeMemory"`
+	// number of virtual CPUs of the running domains
+	AllocatedCPUs int `json:"allocatedCpus"`
+	// memory of the running domains
+	AllocatedMemory uint64 `json:"allocatedMemory"`
+	// number of running domains
+	Domains int `json:"domains"`
+	// free space of the active storage pools by name
+	StoragePools map[string]uint64 `json:"storagePools"`
+	// time the information has been collected
+	Observed time.Time `json:"observed"`
+}
+
+// GetHostInfo collects the resources of the hypervisor host and the resources allocated by its running domains
+func GetHostInfo(client *LivirtClient) func() (*HostInfo, error) {
+	conn := client.LibVirt
+	poolInfo := getStoragePoolInfo(conn)
+
+	return func() (*HostInfo, error) {
+		defer CM.EntryExit("GetHostInfo")()
+
+		_, memory, cpus, _, _, _, _, _, err := conn.NodeGetInfo()
+		if err != nil {
+			return nil, err
+		}
+		freeMemory, err := conn.NodeGetFreeMemory()
+		if err != nil {
+			return nil, err
+		}
+		info := &HostInfo{
+			CPUs: int(cpus),
+			// libvirt reports the memory in KiB
+			Memory:       memory * 1024,
+			FreeMemory:   freeMemory,
+			StoragePools: make(map[string]uint64),
+			Observed:     time.Now().UTC(),
+		}
+		domains, _, err := conn.ConnectListAllDomains(NeedResults, libvirt.ConnectListDomainsActive)
+		if err != nil {
+			return nil, err
+		}
+		for _, dom := range domains {
+			_, maxMem, _, vcpus, _, err := conn.DomainGetInfo(dom)
+			if err != nil {
+				// the domain might have stopped in the meantime
+				log.Printf("Unable to get info of domain [%s], cause: [%v]", dom.Name, err)
+				continue
+			}
+			info.Domains++
+			info.AllocatedCPUs += int(vcpus)
+			info.AllocatedMemory += maxMem * 1024
+		}
+		pools, _, err := conn.ConnectListAllStoragePools(NeedResults, libvirt.ConnectListStoragePoolsActive)
+		if err != nil {
+			return nil, err
+		}
+		for _, pool := range pools {
+			pi, err := poolInfo(pool)
+			if err != nil {
+				log.Printf("Unable to get info of storage pool [%s], cause: [%v]", pool.Name, err)
+				continue
+			}
+			info.StoragePools[pi.Name] = pi.Available
+		}
+		return info, nil
+	}
+}
+
+// HostsFromRelated decodes the set of ready hypervisor hosts from the related data structure
+func HostsFromRelated(data map[string]any) ([]*HypervisorHostCustomResource, error) {
+	var result []*HypervisorHostCustomResource
+	if related, ok := data["related"].(map[string]any); ok {
+		// all hosts
+		if hosts, ok := related[KeyHostConfig].(map[string]any); ok {
+			// decode each host
+			for _, host := range hosts {
+				// transcode to the expected format
+				h, err := common.Transcode[*HypervisorHostCustomResource](host)
+				if err != nil {
+					return nil, err
+				}
+				// validate the status of the host
+				if common.Status(h.Status.Status) == common.Ready {
+					result = append(result, h)
+				} else {
+					// host is not in a valid status
+					log.Printf("Hypervisor host [%s] is not in ready state, ignoring, cause: [%s]", h.Name, h.Status.Description)
+				}
+			}
+		}
+	}
+	// ok
+	return result, nil
+}
+
+// VSIsFromRelated decodes the set of VSIs from the related data structure, independent of their status
+func VSIsFromRelated(data map[string]any) ([]*OnPremCustomResource, error) {
+	var result []*OnPremCustomResource
+	if related, ok := data["related"].(map[string]any); ok {
+		if vsis, ok := related[KeyVSIConfig].(map[string]any); ok {
+			for _, vsi := range vsis {
+				// transcode to the expected format
+				v, err := common.Transcode[*OnPremCustomResource](vsi)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, v)
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+)
+
+var (
+	// full identifier of the image config entry
+	KeyImageConfig = fmt.Sprintf("%s.%s", KindImage, APIVersion)
+
+	// ErrImageNotFound signals a VSI that references an image that does not exist
+	ErrImageNotFound = errors.New("image not found")
+	// ErrImageRetired signals a VSI that references a retired image
+	ErrImageRetired = errors.New("image retired")
+)
+
+// ImageStaging describes the state of an image in a storage pool of a hypervisor host
+type ImageStaging struct {
+	// name of the hypervisor host
+	Host string `json:"host"`
+	// name of the storage pool
+	StoragePool string `json:"storagePool"`
+	// name of the volume of the cached base image
+	Name string `json:"name,omitempty"`
+	// the image is available in the cache of the storage pool
+	Ready bool `json:"ready"`
+	// the image has been removed from the storage pool
+	Retired bool `json:"retired,omitempty"`
+	// number of volumes backed by the image, reported for retired images
+	References int `json:"references,omitempty"`
+	// reason why the image is not ready or not retired, yet
+	Message string `json:"message,omitempty"`
+	// error that prevents staging or retiring the image
+	Error string `json:"error,omitempty"`
+}
+
+// ImagesFromRelated decodes the set of images from the related data structure, independent of their status
+func ImagesFromRelated(data map[string]any) ([]*ImageCustomResource, error) {
+	var result []*ImageCustomResource
+	if related, ok := data["related"].(map[string]any); ok {
+		if images, ok := related[KeyImageConfig].(map[string]any); ok {
+			for _, image := range images {
+				// transcode to the expected format
+				img, err := common.Transcode[*ImageCustomResource](image)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, img)
+			}
+		}
+	}
+	return result, nil
+}
+
+// ResolveImage selects the image a VSI references by name, retired images cannot be referenced
+func ResolveImage(images []*ImageCustomResource, name string) (*ImageCustomResource, error) {
+	for _, image := range images {
+		if image.Name != name {
+			continue
+		}
+		if image.Spec.Retired {
+			return nil, fmt.Errorf("%w: image [%s] with version [%s] has been retired", ErrImageRetired, name, image.Spec.Version)
+		}
+		return image, nil
+	}
+	return nil, fmt.Errorf("%w: image [%s]", ErrImageNotFound, name)
+}
+
+// GetPinnedBaseImages returns the cached base images staged for images that have not been retired, the garbage
+// collector must not delete them although they might not back any boot disk, yet
+func GetPinnedBaseImages(staging []ImageStaging) []BaseImage {
+	var result []BaseImage
+	for _, entry := range staging {
+		if entry.Ready && !entry.Retired && len(entry.Name) > 0 {
+			result = append(result, BaseImage{StoragePool: entry.StoragePool, Name: entry.Name})
+		}
+	}
+	return result
+}
+
+// IsBaseImageCached checks if an image is available in the cache of a storage pool and returns the name of its volume
+func IsBaseImageCached(client *LivirtClient) func(storagePool, imageURL, digest string) (string, bool, error) {
+	conn := client.LibVirt
+	storageVolXMLDesc := getStorageVolByNameXMLDesc(conn)
+	isComplete := isBaseImageComplete(conn)
+
+	return func(storagePool, imageURL, digest string) (string, bool, error) {
+		key, err := getImageCacheKey(imageURL, digest)
+		if err != nil {
+			return "", false, err
+		}
+		name := GetCachedBaseImageName(key)
+		pool, err := conn.StoragePoolLookupByName(storagePool)
+		if err != nil {
+			return name, false, err
+		}
+		_, err = storageVolXMLDesc(pool, name)
+		return name, err == nil && isComplete(pool, name), nil
+	}
+}
+
+// RemoveCachedBaseImage deletes a cached base image unless it backs a volume, the result is the number of volumes
+// backed by the image
+func RemoveCachedBaseImage(client *LivirtClient) func(storagePool, name string) (int, error) {
+	conn := client.LibVirt
+	storageVolXMLDesc := getStorageVolByNameXMLDesc(conn)
+	getReferences := GetBaseImageReferences(client)
+
+	return func(storagePool, name string) (int, error) {
+		// log this config
+		defer CM.EntryExit(fmt.Sprintf("RemoveCachedBaseImage(%s, %s)", storagePool, name))()
+		pool, err := conn.StoragePoolLookupByName(storagePool)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := storageVolXMLDesc(pool, name); err != nil {
+			// nothing to remove
+			return 0, nil
+		}
+		refs, err := getReferences(storagePool)
+		if err != nil {
+			return 0, err
+		}
+		if count := refs[name]; count > 0 {
+			return count, fmt.Errorf("%w: base image [%s] backs [%d] volumes", ErrBaseImageInUse, name, count)
+		}
+		log.Printf("Removing base image [%s] from pool [%s] ...", name, storagePool)
+		// the marker goes first, so an interrupted removal leaves an incomplete image behind
+		if err := deleteBaseImageMarker(conn)(pool, name); err != nil {
+			return 0, err
+		}
+		_, err = deleteStorageVol(conn)(pool, name)
+		return 0, err
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func createTestImage(name string, retired bool) *ImageCustomResource {
+	return &ImageCustomResource{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: ImageCustomResourceSpec{
+			ImageURL: "https://images.example.com/" + name + ".qcow2",
+			Version:  "1.0.0",
+			Retired:  retired,
+		},
+	}
+}
+
+func TestResolveImage(t *testing.T) {
+	images := []*ImageCustomResource{
+		createTestImage("hpcr-1", true),
+		createTestImage("hpcr-2", false),
+	}
+
+	img, err := ResolveImage(images, "hpcr-2")
+	require.NoError(t, err)
+	assert.Equal(t, "https://images.example.com/hpcr-2.qcow2", img.Spec.ImageURL)
+
+	_, err = ResolveImage(images, "hpcr-1")
+	assert.ErrorIs(t, err, ErrImageRetired)
+
+	_, err = ResolveImage(images, "hpcr-3")
+	assert.ErrorIs(t, err, ErrImageNotFound)
+}
+
+func TestGetPinnedBaseImages(t *testing.T) {
+	staging := []ImageStaging{
+		{Host: "lpar1", StoragePool: "default", Name: "base-a.qcow2", Ready: true},
+		// upload pending
+		{Host: "lpar1", StoragePool: "images", Message: "waiting for the upload into a different storage pool"},
+		// already removed
+		{Host: "lpar2", StoragePool: "default", Name: "base-a.qcow2", Retired: true},
+	}
+
+	assert.Equal(t, []BaseImage{{StoragePool: "default", Name: "base-a.qcow2"}}, GetPinnedBaseImages(staging))
+}
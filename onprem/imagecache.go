@@ -0,0 +1,424 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+	"libvirt.org/go/libvirtxml"
+)
+
+const (
+	// prefix of the digests of base images
+	imageDigestPrefix = "sha256:"
+	// names of the volumes of cached base images
+	cachedBaseImagePrefix = "base-"
+	cachedBaseImageSuffix = ".qcow2"
+	// suffix of the marker volume that signals a completely uploaded base image
+	completeBaseImageSuffix = ".complete"
+)
+
+var (
+	// ErrInvalidImageDigest signals a digest that is not of the form `sha256:<hex>`
+	ErrInvalidImageDigest = errors.New("invalid image digest")
+	// ErrImageDigestMismatch signals an image whose content does not match its declared digest
+	ErrImageDigestMismatch = errors.New("image digest mismatch")
+	// ErrBaseImageInUse signals a base image that still backs boot disks
+	ErrBaseImageInUse = errors.New("base image in use")
+)
+
+// CachedBaseImage describes the base image a boot disk has been created from
+type CachedBaseImage struct {
+	// name of the storage pool
+	StoragePool string `json:"storagePool"`
+	// name of the volume of the base image
+	Name string `json:"name"`
+	// declared digest of the image, empty if the image is identified by its URL
+	Digest string `json:"digest,omitempty"`
+	// the base image has been found in the cache, otherwise it has been uploaded
+	CacheHit bool `json:"cacheHit"`
+	// description of the volume
+	Volume *libvirtxml.StorageVolume `json:"-"`
+}
+
+// BaseImageCacheEntry describes a cached base image and the number of boot disks backed by it
+type BaseImageCacheEntry struct {
+	// name of the storage pool
+	StoragePool string `json:"storagePool"`
+	// name of the volume of the base image
+	Name string `json:"name"`
+	// number of volumes backed by the base image
+	References int `json:"references"`
+}
+
+// ParseImageDigest validates a digest of the form `sha256:<hex>` and returns its hex part
+func ParseImageDigest(digest string) (string, error) {
+	value, ok := strings.CutPrefix(digest, imageDigestPrefix)
+	if !ok {
+		return "", fmt.Errorf("%w: [%s] does not start with [%s]", ErrInvalidImageDigest, digest, imageDigestPrefix)
+	}
+	value = strings.ToLower(value)
+	data, err := hex.DecodeString(value)
+	if err != nil || len(data) != sha256.Size {
+		return "", fmt.Errorf("%w: [%s] is not a sha256 hash", ErrInvalidImageDigest, digest)
+	}
+	return value, nil
+}
+
+// GetCachedBaseImageName returns the name of the volume of a cached base image
+func GetCachedBaseImageName(key string) string {
+	return cachedBaseImagePrefix + key + cachedBaseImageSuffix
+}
+
+// getCompleteBaseImageName returns the name of the marker volume of a cached base image
+func getCompleteBaseImageName(name string) string {
+	return name + completeBaseImageSuffix
+}
+
+// createBaseImageMarker creates the marker volume that signals a completely uploaded base image
+func createBaseImageMarker(conn *libvirt.Libvirt) func(pool libvirt.StoragePool, name string) error {
+	return func(pool libvirt.StoragePool, name string) error {
+		volumeDef := createDefaultVolume()
+		volumeDef.Name = getCompleteBaseImageName(name)
+
+		volumeDefXML, err := XMLMarshall(volumeDef)
+		if err != nil {
+			return err
+		}
+		_, err = conn.StorageVolCreateXML(pool, string(volumeDefXML), 0)
+		return err
+	}
+}
+
+// deleteBaseImageMarker deletes the marker volume of a base image if it exists, the base image is incomplete afterwards
+func deleteBaseImageMarker(conn *libvirt.Libvirt) func(pool libvirt.StoragePool, name string) error {
+	storageVolXMLDesc := getStorageVolByNameXMLDesc(conn)
+	deleteVolume := deleteStorageVol(conn)
+
+	return func(pool libvirt.StoragePool, name string) error {
+		markerName := getCompleteBaseImageName(name)
+		if _, err := storageVolXMLDesc(pool, markerName); err != nil {
+			return nil
+		}
+		_, err := deleteVolume(pool, markerName)
+		return err
+	}
+}
+
+// isBaseImageComplete tests if the upload of a cached base image has completed
+func isBaseImageComplete(conn *libvirt.Libvirt) func(pool libvirt.StoragePool, name string) bool {
+	storageVolXMLDesc := getStorageVolByNameXMLDesc(conn)
+
+	return func(pool libvirt.StoragePool, name string) bool {
+		_, err := storageVolXMLDesc(pool, getCompleteBaseImageName(name))
+		return err == nil
+	}
+}
+
+// IsCachedBaseImageName tests if a volume holds a cached base image
+func IsCachedBaseImageName(name string) bool {
+	rest, ok := strings.CutPrefix(name, cachedBaseImagePrefix)
+	if !ok {
+		return false
+	}
+	key, ok := strings.CutSuffix(rest, cachedBaseImageSuffix)
+	return ok && len(key) == 2*sha256.Size
+}
+
+// getURLCacheKey identifies an image without declared digest by its URL and the validators of the HTTP server, so a
+// modified image gets a new key. Some servers produce a new ETag per request, so the ETag only counts if the server
+// does not report the modification time.
+func getURLCacheKey(url string, header http.Header, contentLength int64) string {
+	validator := header.Get("Last-Modified")
+	if validator == "" {
+		validator = header.Get("ETag")
+	}
+	h := sha256.New()
+	h.Write([]byte(url))
+	h.Write([]byte{0})
+	h.Write([]byte(validator))
+	h.Write([]byte{0})
+	h.Write([]byte(fmt.Sprintf("%d", contentLength)))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// getImageCacheKey returns the key of an image in the cache, this is the declared digest or a key derived from the URL.
+// Servers that do not answer HEAD requests, e.g. for pre-signed URLs, only provide the URL as a key.
+func getImageCacheKey(imageURL, digest string) (string, error) {
+	if len(digest) > 0 {
+		return ParseImageDigest(digest)
+	}
+	resp, err := http.Head(imageURL) // #nosec G107 - we do want the URL to come from config
+	if err != nil {
+		log.Printf("Unable to access image [%s], identifying it by its URL, cause: [%v]", imageURL, err)
+		return getURLCacheKey(imageURL, nil, 0), nil
+	}
+	defer safeClose(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Unable to access image [%s] via HEAD, identifying it by its URL, status: [%d]", imageURL, resp.StatusCode)
+		return getURLCacheKey(imageURL, nil, 0), nil
+	}
+	return getURLCacheKey(imageURL, resp.Header, resp.ContentLength), nil
+}
+
+// CacheBaseImage makes sure that a base image is available in the cache of a storage pool. Images with the same digest
+// share the same volume, independent of their URL. The image is uploaded into its final volume, a marker volume
+// signals that the upload completed, so an interrupted upload never shows up as a cached image.
+func CacheBaseImage(client *LivirtClient) func(storagePool, imageURL, digest string) (*CachedBaseImage, error) {
+	conn := client.LibVirt
+	storageVolXMLDesc := getStorageVolByNameXMLDesc(conn)
+	uploadVolume := uploadVolumeFromURL(conn)
+	deleteVolume := deleteStorageVol(conn)
+	isComplete := isBaseImageComplete(conn)
+	createMarker := createBaseImageMarker(conn)
+	deleteMarker := deleteBaseImageMarker(conn)
+	getReferences := GetBaseImageReferences(client)
+
+	return func(storagePool, imageURL, digest string) (*CachedBaseImage, error) {
+		// log this config
+		defer CM.EntryExit(fmt.Sprintf("CacheBaseImage(%s, %s)", storagePool, imageURL))()
+		key, err := getImageCacheKey(imageURL, digest)
+		if err != nil {
+			return nil, err
+		}
+		name := GetCachedBaseImageName(key)
+		result := &CachedBaseImage{
+			StoragePool: storagePool,
+			Name:        name,
+			Digest:      digest,
+		}
+		// access the pool
+		pool, err := conn.StoragePoolLookupByName(storagePool)
+		if err != nil {
+			return nil, err
+		}
+		// cached images are never modified, so a complete volume is up to date
+		existing, err := storageVolXMLDesc(pool, name)
+		if err == nil {
+			if isComplete(pool, name) {
+				log.Printf("Base image cache hit for [%s] on pool [%s], volume [%s]", imageURL, storagePool, name)
+				result.CacheHit = true
+				result.Volume = existing
+				return result, nil
+			}
+			// images cached before the marker existed are complete if they back volumes
+			refs, err := getReferences(storagePool)
+			if err != nil {
+				return nil, err
+			}
+			if refs[name] > 0 {
+				log.Printf("Base image cache hit for [%s] on pool [%s], marking volume [%s] as complete", imageURL, storagePool, name)
+				if err := createMarker(pool, name); err != nil {
+					return nil, err
+				}
+				result.CacheHit = true
+				result.Volume = existing
+				return result, nil
+			}
+			log.Printf("Base image [%s] on pool [%s] is incomplete, uploading it again ...", name, storagePool)
+			if _, err := deleteVolume(pool, name); err != nil {
+				return nil, err
+			}
+		}
+		log.Printf("Base image cache miss for [%s] on pool [%s], volume [%s]", imageURL, storagePool, name)
+		// a stale marker must not vouch for the new upload
+		if err := deleteMarker(pool, name); err != nil {
+			return nil, err
+		}
+		// discard the volume of a failed upload, so the next attempt starts from scratch
+		discard := func() {
+			if _, err := deleteVolume(pool, name); err != nil {
+				log.Printf("Unable to delete the incomplete base image [%s], cause: [%v]", name, err)
+			}
+		}
+		h := sha256.New()
+		err = uploadVolume(pool, name, imageURL, h)
+		if err != nil {
+			discard()
+			return nil, err
+		}
+		if actual := hex.EncodeToString(h.Sum(nil)); len(digest) > 0 && actual != key {
+			discard()
+			return nil, fmt.Errorf("%w: image [%s] has digest [%s%s], expected [%s]", ErrImageDigestMismatch, imageURL, imageDigestPrefix, actual, digest)
+		}
+		if err := createMarker(pool, name); err != nil {
+			return nil, err
+		}
+		err = refreshPool(conn)(pool)
+		if err != nil {
+			return nil, err
+		}
+		result.Volume, err = storageVolXMLDesc(pool, name)
+		if err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+}
+
+// CreateBootDiskOverlay creates a qcow2 boot disk backed by a base image, so writes of the VSI never touch the image
+func CreateBootDiskOverlay(client *LivirtClient) func(storagePool string, baseVolumeXML *libvirtxml.StorageVolume, newName string) (*libvirtxml.StorageVolume, error) {
+	conn := client.LibVirt
+	storageVolByNameXMLDesc := getStorageVolByNameXMLDesc(conn)
+	storageVolXMLDesc := getStorageVolXMLDesc(conn)
+
+	return func(storagePool string, baseVolumeXML *libvirtxml.StorageVolume, newName string) (*libvirtxml.StorageVolume, error) {
+		// some logging
+		log.Printf("Creating boot disk [%s] backed by [%s] on pool [%s] ...", newName, baseVolumeXML.Name, storagePool)
+		if baseVolumeXML.Target == nil || len(baseVolumeXML.Target.Path) == 0 {
+			return nil, fmt.Errorf("base image [%s] has no path", baseVolumeXML.Name)
+		}
+		// access the pool
+		pool, err := conn.StoragePoolLookupByName(storagePool)
+		if err != nil {
+			return nil, err
+		}
+		// check if we already know the new volume
+		_, err = storageVolByNameXMLDesc(pool, newName)
+		if err == nil {
+			// we need to delete the volume
+			_, err := deleteStorageVol(conn)(pool, newName)
+			if err != nil {
+				return nil, err
+			}
+		}
+		// the overlay has the virtual size of its base image
+		volumeDef := createDefaultVolume()
+		volumeDef.Name = newName
+		volumeDef.Capacity = baseVolumeXML.Capacity
+		volumeDef.BackingStore = &libvirtxml.StorageVolumeBackingStore{
+			Path: baseVolumeXML.Target.Path,
+			Format: &libvirtxml.StorageVolumeTargetFormat{
+				Type: "qcow2",
+			},
+		}
+
+		volumeDefXML, err := XMLMarshall(volumeDef)
+		if err != nil {
+			return nil, err
+		}
+
+		volume, err := conn.StorageVolCreateXML(pool, string(volumeDefXML), 0)
+		if err != nil {
+			return nil, err
+		}
+
+		// Refresh the pool
+		err = refreshPool(conn)(pool)
+		if err != nil {
+			return nil, err
+		}
+
+		// refresh the description
+		return storageVolXMLDesc(&volume)
+	}
+}
+
+// countBaseImageReferences counts the volumes backed by each cached base image, keyed by the name of the base image
+func countBaseImageReferences(volumes []*libvirtxml.StorageVolume) map[string]int {
+	result := make(map[string]int)
+	paths := make(map[string]string)
+	for _, vol := range volumes {
+		if !IsCachedBaseImageName(vol.Name) {
+			continue
+		}
+		result[vol.Name] = 0
+		if vol.Target != nil && len(vol.Target.Path) > 0 {
+			paths[vol.Target.Path] = vol.Name
+		}
+	}
+	for _, vol := range volumes {
+		if vol.BackingStore == nil {
+			continue
+		}
+		if name, ok := paths[vol.BackingStore.Path]; ok {
+			result[name]++
+		}
+	}
+	return result
+}
+
+// getStoragePoolVolumesXML returns the descriptions of all volumes of a pool, a volume that cannot be described is an
+// error, since it might reference a base image
+func getStoragePoolVolumesXML(conn *libvirt.Libvirt) func(pool libvirt.StoragePool) ([]*libvirtxml.StorageVolume, error) {
+	storageVolXMLDesc := getStorageVolXMLDesc(conn)
+
+	return func(pool libvirt.StoragePool) ([]*libvirtxml.StorageVolume, error) {
+		volumes, _, err := conn.StoragePoolListAllVolumes(pool, NeedResults, 0)
+		if err != nil {
+			return nil, err
+		}
+		result := make([]*libvirtxml.StorageVolume, 0, len(volumes))
+		for idx := range volumes {
+			volXML, err := storageVolXMLDesc(&volumes[idx])
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, volXML)
+		}
+		return result, nil
+	}
+}
+
+// GetBaseImageReferences counts the volumes backed by each cached base image of a storage pool
+func GetBaseImageReferences(client *LivirtClient) func(storagePool string) (map[string]int, error) {
+	conn := client.LibVirt
+	getVolumes := getStoragePoolVolumesXML(conn)
+
+	return func(storagePool string) (map[string]int, error) {
+		pool, err := conn.StoragePoolLookupByName(storagePool)
+		if err != nil {
+			return nil, err
+		}
+		volumes, err := getVolumes(pool)
+		if err != nil {
+			return nil, err
+		}
+		return countBaseImageReferences(volumes), nil
+	}
+}
+
+// ListBaseImageCache lists the cached base images of the storage pools together with their number of references
+func ListBaseImageCache(client *LivirtClient) func(storagePools []string) ([]BaseImageCacheEntry, error) {
+	getReferences := GetBaseImageReferences(client)
+
+	return func(storagePools []string) ([]BaseImageCacheEntry, error) {
+		var result []BaseImageCacheEntry
+		for _, storagePool := range storagePools {
+			refs, err := getReferences(storagePool)
+			if err != nil {
+				return nil, err
+			}
+			var entries []BaseImageCacheEntry
+			for name, count := range refs {
+				entries = append(entries, BaseImageCacheEntry{StoragePool: storagePool, Name: name, References: count})
+			}
+			sort.Slice(entries, func(i, j int) bool {
+				return entries[i].Name < entries[j].Name
+			})
+			result = append(result, entries...)
+		}
+		return result, nil
+	}
+}
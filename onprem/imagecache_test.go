@@ -0,0 +1,139 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"libvirt.org/go/libvirtxml"
+)
+
+const testImageDigest = "sha256:9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08"
+
+func TestParseImageDigest(t *testing.T) {
+	key, err := ParseImageDigest(testImageDigest)
+	require.NoError(t, err)
+	assert.Equal(t, "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", key)
+
+	_, err = ParseImageDigest("md5:098f6bcd4621d373cade4e832627b4f6")
+	assert.ErrorIs(t, err, ErrInvalidImageDigest)
+
+	_, err = ParseImageDigest("sha256:9f86d081")
+	assert.ErrorIs(t, err, ErrInvalidImageDigest)
+}
+
+func TestCachedBaseImageName(t *testing.T) {
+	key, err := ParseImageDigest(testImageDigest)
+	require.NoError(t, err)
+
+	name := GetCachedBaseImageName(key)
+	assert.True(t, IsCachedBaseImageName(name))
+	// markers of complete uploads and legacy base images are not part of the cache
+	assert.False(t, IsCachedBaseImageName(getCompleteBaseImageName(name)))
+	assert.False(t, IsCachedBaseImageName("base-hpcr.qcow2"))
+	assert.False(t, IsCachedBaseImageName("boot-vsi.qcow2"))
+}
+
+func TestGetImageCacheKey(t *testing.T) {
+	etag := `"v1"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Length", "4")
+	}))
+	defer server.Close()
+
+	url := server.URL + "/hpcr.qcow2"
+
+	first, err := getImageCacheKey(url, "")
+	require.NoError(t, err)
+	second, err := getImageCacheKey(url, "")
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+	assert.True(t, IsCachedBaseImageName(GetCachedBaseImageName(first)))
+
+	// a modified image gets a new key
+	etag = `"v2"`
+	modified, err := getImageCacheKey(url, "")
+	require.NoError(t, err)
+	assert.NotEqual(t, first, modified)
+
+	// a per-request ETag does not matter if the modification time is known
+	lastModified := ""
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		w.Header().Set("Content-Length", "4")
+	})
+	lastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+	stable, err := getImageCacheKey(url, "")
+	require.NoError(t, err)
+	etag = `"v3"`
+	again, err := getImageCacheKey(url, "")
+	require.NoError(t, err)
+	assert.Equal(t, stable, again)
+
+	// a server without HEAD support identifies the image by its URL
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	})
+	urlOnly, err := getImageCacheKey(url, "")
+	require.NoError(t, err)
+	assert.Equal(t, getURLCacheKey(url, nil, 0), urlOnly)
+
+	// the declared digest wins and does not access the URL
+	declared, err := getImageCacheKey("http://invalid.invalid/hpcr.qcow2", testImageDigest)
+	require.NoError(t, err)
+	assert.Equal(t, strings.ToLower(strings.TrimPrefix(testImageDigest, imageDigestPrefix)), declared)
+}
+
+func TestCountBaseImageReferences(t *testing.T) {
+	used := GetCachedBaseImageName(strings.Repeat("a", 64))
+	unused := GetCachedBaseImageName(strings.Repeat("b", 64))
+
+	volume := func(name, backingPath string) *libvirtxml.StorageVolume {
+		vol := &libvirtxml.StorageVolume{
+			Name:   name,
+			Target: &libvirtxml.StorageVolumeTarget{Path: "/var/lib/libvirt/images/" + name},
+		}
+		if len(backingPath) > 0 {
+			vol.BackingStore = &libvirtxml.StorageVolumeBackingStore{Path: backingPath}
+		}
+		return vol
+	}
+
+	refs := countBaseImageReferences([]*libvirtxml.StorageVolume{
+		volume(used, ""),
+		volume(unused, ""),
+		volume("boot-first.qcow2", "/var/lib/libvirt/images/"+used),
+		volume("boot-second.qcow2", "/var/lib/libvirt/images/"+used),
+		volume("boot-other.qcow2", "/var/lib/libvirt/images/hpcr.qcow2"),
+		volume("hpcr.qcow2", ""),
+	})
+
+	assert.Equal(t, map[string]int{used: 2, unused: 0}, refs)
+}
+
+func TestInstanceHashWithImageDigest(t *testing.T) {
+	opt := &InstanceOptions{Name: "vsi", ImageURL: "http://localhost/hpcr.qcow2"}
+	hash := CreateInstanceHash(opt)
+
+	opt.ImageDigest = testImageDigest
+	assert.NotEqual(t, hash, CreateInstanceHash(opt))
+}
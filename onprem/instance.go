@@ -17,10 +17,12 @@ package onprem
 import (
 	"encoding/hex"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"log"
 	"path"
 	"sort"
+	"time"
 
 	"crypto/sha256"
 
@@ -31,9 +33,22 @@ import (
 	"libvirt.org/go/libvirtxml"
 )
 
+// InstanceMetadataNamespace is the XML namespace of the metadata the operator writes into its domains
+const InstanceMetadataNamespace = "https://github.com/ibm-hyper-protect/k8s-operator-hpcr"
+
 type InstanceMetadata struct {
 	XMLName xml.Name `xml:"https://github.com/ibm-hyper-protect/k8s-operator-hpcr instance"`
 	Hash    string   `xml:"hash"`
+	// UID of the custom resource that owns the domain
+	UID string `xml:"uid,omitempty"`
+	// namespace of the custom resource that owns the domain
+	Namespace string `xml:"namespace,omitempty"`
+	// name of the custom resource that owns the domain
+	Name string `xml:"name,omitempty"`
+	// identifier of the k8s cluster
+	ClusterID string `xml:"clusterID,omitempty"`
+	// version of the operator that wrote the metadata
+	OperatorVersion string `xml:"operatorVersion,omitempty"`
 }
 
 type AttachedDataDisk struct {
@@ -41,6 +56,12 @@ type AttachedDataDisk struct {
 	Name string
 	// name of the libvirt storage pool, the pool must exist
 	StoragePool string
+	// cache mode of the disk driver
+	Cache string
+	// IO mode of the disk driver
+	IO string
+	// discard mode of the disk driver
+	Discard string
 }
 
 type InstanceOptions struct {
@@ -50,12 +71,51 @@ type InstanceOptions struct {
 	UserData string
 	// URL to the HPCR qcow2
 	ImageURL string
+	// optional digest of the HPCR qcow2, identifies the image in the cache
+	ImageDigest string
 	// name of the libvirt storage pool, the pool must exist
 	StoragePool string
 	// attached data disks
 	DataDisks []*AttachedDataDisk
 	// attached networks
 	Networks []string
+	// static IP addresses keyed by the name of the attached network
+	StaticIPs map[string]string
+	// cloud-init configuration of the interfaces keyed by the name of the attached network
+	InterfaceConfigs map[string]*InterfaceConfig
+	// public SSH keys passed via the cloud-init metadata
+	PublicKeys []string
+	// number of console logs of previous boots to retain
+	ConsoleLogRetention int
+	// probe that decides if the started instance is ready
+	ReadinessProbe *ProbeSpec
+	// probe that decides if the started instance is alive
+	LivenessProbe *LivenessProbeSpec
+	// requested power state of the instance
+	PowerState string
+	// time in seconds the instance gets to power off gracefully before it is destroyed
+	TerminationGracePeriodSeconds int
+	// what happens if the domain has been modified outside of the operator
+	DriftPolicy string
+	// the domain has been adopted, its disks have not been created by the operator
+	Adopted bool
+	// the k8s resource that owns the instance
+	Owner InstanceOwner
+	// version of the operator, recorded in the metadata of the domain
+	OperatorVersion string
+	// Secure Execution of the instance
+	SecureExecution string
+}
+
+type ImageOptions struct {
+	// URL to the HPCR qcow2
+	ImageURL string
+	// optional digest of the HPCR qcow2
+	ImageDigest string
+	// names of the storage pools to stage the image in
+	StoragePools []string
+	// remove the staged image once it backs no boot disk
+	Retired bool
 }
 
 type DataDiskOptions struct {
@@ -65,6 +125,29 @@ type DataDiskOptions struct {
 	StoragePool string
 	// size of the disk
 	Size uint64
+	// snapshot volume to initialize a new disk from
+	RestoreFrom *DataDiskRestoreSource
+	// what happens to the volume when the data disk is deleted
+	ReclaimPolicy string
+	// name of the volume that keeps the data of a retained disk
+	RetainedName string
+	// deletion time of the data disk, names the final snapshot
+	Deleted time.Time
+	// format of the volume
+	Format string
+	// allocation of the volume
+	Provisioning string
+}
+
+type DataDiskSnapshotOptions struct {
+	// name of the snapshot volume
+	Name string
+	// name of the libvirt storage pool of the data disk
+	StoragePool string
+	// name of the data disk volume
+	DataDisk string
+	// number of snapshots of the data disk to retain, 0 retains all snapshots
+	Retention int
 }
 
 type DataDiskRefOptions struct {
@@ -77,6 +160,38 @@ type DataDiskRefOptions struct {
 type NetworkRefOptions struct {
 	// name of the network
 	Name string
+	// static IP addresses reserved on the network
+	StaticIPs []StaticIPReservation
+	// configuration of the interfaces of attached VSIs
+	NetworkConfig *NetworkConfigSpec
+}
+
+type NetworkOptions struct {
+	// name of the network
+	Name string
+	// forward mode of the network
+	Mode string
+	// host interface to forward to or host bridge to attach to
+	Interface string
+	// IPv4 subnet in CIDR notation
+	Subnet string
+	// DHCP configuration
+	DHCP *NetworkDHCPSpec
+	// static DNS entries
+	DNSHosts []NetworkDNSHostSpec
+	// MTU of the network
+	MTU uint
+}
+
+type StoragePoolOptions struct {
+	// name of the storage pool
+	Name string
+	// type of the storage pool
+	Type string
+	// target path of the storage pool
+	Path string
+	// source of the storage pool
+	Source *StoragePoolSourceSpec
 }
 
 // GetNetwork returns the network attached to the instane
@@ -99,6 +214,11 @@ func GetLoggingVolumeName(name string) string {
 	return fmt.Sprintf("console-%s.log", name)
 }
 
+// GetBaseImageName returns the name of the volume the image of a VSI is uploaded to
+func GetBaseImageName(imageURL string) string {
+	return path.Base(imageURL)
+}
+
 // sort the data disks by name, so the hash is predictable
 func sortDataDisks(disks []*AttachedDataDisk) []*AttachedDataDisk {
 	if !A.IsNonEmpty(disks) {
@@ -126,15 +246,27 @@ func sortNetwoks(networks []string) []string {
 	return sorted
 }
 
-// createInstanceHash computes a hash value for the instance options
+// CreateInstanceHash computes a hash value for the instance options. Data disks are not part of the hash, they are
+// attached to and detached from the running instance.
 func CreateInstanceHash(opt *InstanceOptions) string {
+	return createInstanceHash(opt, nil)
+}
+
+// createLegacyInstanceHash computes the hash of instances created before data disks were hot-plugged, it covers
+// the attached data disks
+func createLegacyInstanceHash(opt *InstanceOptions) string {
+	return createInstanceHash(opt, opt.DataDisks)
+}
+
+// createInstanceHash computes a hash value for the instance options and the given data disks
+func createInstanceHash(opt *InstanceOptions, dataDisks []*AttachedDataDisk) string {
 	h := sha256.New()
 	h.Write([]byte(opt.Name))
 	h.Write([]byte(opt.ImageURL))
 	h.Write([]byte(opt.StoragePool))
 	h.Write([]byte(opt.UserData))
 	// add the data disks to the mix
-	for _, disk := range sortDataDisks(opt.DataDisks) {
+	for _, disk := range sortDataDisks(dataDisks) {
 		h.Write([]byte(disk.Name))
 		h.Write([]byte(disk.StoragePool))
 	}
@@ -142,15 +274,22 @@ func CreateInstanceHash(opt *InstanceOptions) string {
 	for _, network := range sortNetwoks(opt.Networks) {
 		h.Write([]byte(network))
 	}
+	// the optional fields only contribute if present, so the hash of existing instances is stable
+	if len(opt.ImageDigest) > 0 {
+		h.Write([]byte(opt.ImageDigest))
+	}
+	// the optional cloud-init data only contributes if present, so the hash of existing instances is stable
+	for _, key := range opt.PublicKeys {
+		h.Write([]byte(key))
+	}
+	if networkConfig, err := createNetworkConfig(opt); err == nil {
+		h.Write(networkConfig)
+	}
 	bs := h.Sum(nil)
 
 	return hex.EncodeToString(bs)
 }
 
-func createMetaData(name string) []byte {
-	return []byte(fmt.Sprintf("local-hostname: %s", name))
-}
-
 // IsInstanceValid tests if an instance has a valid configuration
 func IsInstanceValid(client *LivirtClient) func(opt *InstanceOptions) (*libvirtxml.Domain, bool) {
 	// connection
@@ -164,16 +303,8 @@ func IsInstanceValid(client *LivirtClient) func(opt *InstanceOptions) (*libvirtx
 		if err != nil {
 			return nil, false
 		}
-		// check if the instance is running
-		state, _, err := conn.DomainGetState(existing, 0)
-		if err != nil {
-			log.Printf("Unable to get state for domain [%s], cause: [%v]", name, err)
-			return nil, false
-		}
-		if libvirt.DomainState(state) != libvirt.DomainRunning {
-			log.Printf("Domain [%s] is not running, instead it is in state [%d].", name, state)
-			return nil, false
-		}
+		// the power state does not affect the validity, a stopped or paused instance is started or resumed
+		// according to its requested power state
 		// get some more info
 		existingStrg, err := conn.DomainGetXMLDesc(existing, 0)
 		if err != nil {
@@ -188,10 +319,10 @@ func IsInstanceValid(client *LivirtClient) func(opt *InstanceOptions) (*libvirtx
 		}
 		if existingXML.Metadata == nil {
 			log.Printf("Domain [%s] does not have metadata", name)
+			return existingXML, false
 		}
 		// check the metadata
-		metadata := InstanceMetadata{}
-		err = xml.Unmarshal([]byte(existingXML.Metadata.XML), &metadata)
+		metadata, err := parseInstanceMetadata(existingXML.Metadata.XML)
 		if err != nil {
 			log.Printf("Unable to parse metadata XML for domain [%s], cause: [%v]", name, err)
 			return existingXML, false
@@ -203,106 +334,208 @@ func IsInstanceValid(client *LivirtClient) func(opt *InstanceOptions) (*libvirtx
 			log.Printf("Domain [%s] is already up to date, hashes match.", name)
 			return existingXML, true
 		}
+		if A.IsNonEmpty(opt.DataDisks) && metadata.Hash == createLegacyInstanceHash(opt) {
+			// created by a previous version of the operator, the metadata gets the new hash with the next update
+			log.Printf("Domain [%s] is up to date, legacy hashes match.", name)
+			return existingXML, true
+		}
 		// needs update
 		log.Printf("Domain [%s] needs an update, hashes differ!", name)
 		return existingXML, false
 	}
 }
 
-// CreateInstanceSync (synchronously) creates an instance
+// CreateInstanceSync (synchronously) creates an instance unless a valid instance exists
 func CreateInstanceSync(client *LivirtClient) func(opt *InstanceOptions) (*libvirtxml.Domain, error) {
+	isInstanceValid := IsInstanceValid(client)
+	recreateInstance := RecreateInstanceSync(client)
+
+	return func(opt *InstanceOptions) (*libvirtxml.Domain, error) {
+		// check for domain
+		existingDomain, valid := isInstanceValid(opt)
+		if valid {
+			return existingDomain, nil
+		}
+		dom, _, err := recreateInstance(opt)
+		return dom, err
+	}
+}
+
+// getDomainXMLByName returns the description of a domain or nil if the domain does not exist
+func getDomainXMLByName(conn *libvirt.Libvirt) func(name string) *libvirtxml.Domain {
+	return func(name string) *libvirtxml.Domain {
+		domain, err := conn.DomainLookupByName(name)
+		if err != nil {
+			return nil
+		}
+		xmlDesc, err := conn.DomainGetXMLDesc(domain, 0)
+		if err != nil {
+			log.Printf("Unable to get domain description for domain [%s], cause: [%v]", name, err)
+			return nil
+		}
+		domainXML, err := parseDomainXML(xmlDesc)
+		if err != nil {
+			log.Printf("Unable to parse domain XML for domain [%s], cause: [%v]", name, err)
+			return nil
+		}
+		return domainXML
+	}
+}
+
+// CreateInstanceInterfacesXML produces the network interfaces of an instance, an instance without networks is
+// attached to the default network
+func CreateInstanceInterfacesXML(opt *InstanceOptions) ([]libvirtxml.DomainInterface, error) {
+	name := opt.Name
+	if A.IsNonEmpty(opt.Networks) {
+		return CreateNetworksXML(name)(opt.Networks)
+	}
+	// mac address based on the UUID
+	macAddress := CreateMacAddressFromMaybeUUID(name)
+	// construct a network
+	return []libvirtxml.DomainInterface{{
+		Model: &libvirtxml.DomainInterfaceModel{
+			Type: "virtio",
+		},
+		Source: &libvirtxml.DomainInterfaceSource{
+			Network: &libvirtxml.DomainInterfaceSourceNetwork{
+				Network: DefaultNetwork,
+			},
+		},
+		Driver: &libvirtxml.DomainInterfaceDriver{
+			IOMMU: "on",
+		},
+		MAC: &libvirtxml.DomainInterfaceMAC{
+			Address: macAddress,
+		},
+	},
+	}, nil
+}
+
+// RecreateInstanceSync (synchronously) deletes an existing instance and creates it again, the boot disk is an overlay
+// of the cached base image, which is returned as well
+func RecreateInstanceSync(client *LivirtClient) func(opt *InstanceOptions) (*libvirtxml.Domain, *CachedBaseImage, error) {
 	// some shortcuts
-	uploadBootDisk := UploadBootDisk(client)
-	cloneBootDisk := CloneBootDisk(client)
+	cacheBaseImage := CacheBaseImage(client)
+	createBootDiskOverlay := CreateBootDiskOverlay(client)
 	uploadCloudInit := UploadCloudInit(client)
 
 	createBootDisk := CreateBootDiskXML(client)
 	createCloudInit := CreateCloudInitDisk(client)
-	startDomain := StartDomain(client)
-	deleteDomain := DeleteDomainByName(client)
+	defineDomain := DefineDomain(client)
+	deleteDomain := DeleteOwnedDomainByName(client)
+	checkOwnership := CheckDomainOwnership(client)
+	isSecureExecutionSupported := IsSecureExecutionSupported(client)
 
 	createLoggingVolume := CreateLoggingVolume(client)
-	isInstanceValid := IsInstanceValid(client)
+	archiveLoggingVolume := ArchiveLoggingVolume(client)
 	createDataDiskXML := CreateDataDiskXML(client)
+	getDomainXML := getDomainXMLByName(client.LibVirt)
+	getDataDiskDevices := GetDomainDataDiskDevices(client)
+	checkExclusive := checkDataDisksExclusive(client.LibVirt)
 
-	return func(opt *InstanceOptions) (*libvirtxml.Domain, error) {
+	return func(opt *InstanceOptions) (*libvirtxml.Domain, *CachedBaseImage, error) {
 		// log this config
-		defer CM.EntryExit(fmt.Sprintf("CreateInstanceSync(%s)", opt.Name))()
+		defer CM.EntryExit(fmt.Sprintf("RecreateInstanceSync(%s)", opt.Name))()
 		// prepare some names
 		name := opt.Name
 		cidataName := GetCIDataVolumeName(name)
 		bootName := GetBootVolumeName(name)
 		logName := GetLoggingVolumeName(name)
-		// compute some identifier of the input
-		metadata := InstanceMetadata{
-			Hash: CreateInstanceHash(opt),
+		// the domain and its volumes are replaced, so they must not belong to a different cluster
+		if err := checkOwnership(name, &opt.Owner); err != nil {
+			return nil, nil, err
+		}
+		// a workload that requires Secure Execution must not start unprotected
+		secureExecution := isSecureExecutionSupported()
+		if err := CheckSecureExecution(opt, secureExecution); err != nil {
+			return nil, nil, err
 		}
+		// compute some identifier of the input
+		metadata := createInstanceMetadata(opt)
 		metadataXML, err := XMLMarshall(metadata)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		// check for domain
-		existingDomain, valid := isInstanceValid(opt)
-		if valid {
-			return existingDomain, nil
+		// data disks must not be shared with other instances
+		err = checkExclusive(name, opt.DataDisks)
+		if err != nil {
+			return nil, nil, err
 		}
+		// keep the devices of the data disks of a previous domain
+		devices := AssignDataDiskDevices(getDataDiskDevices(getDomainXML(name)), opt.DataDisks)
 		// cidata
-		cidataIso, err := CreateCloudInit([]byte(opt.UserData), createMetaData(name))
+		metaData, err := createMetaData(opt)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		networkConfig, err := createNetworkConfig(opt)
+		if err != nil {
+			return nil, nil, err
+		}
+		cidataIso, err := CreateCloudInit([]byte(opt.UserData), metaData, networkConfig)
+		if err != nil {
+			return nil, nil, err
 		}
 		// delete a previous domain
 		log.Println("Deleting domain ...")
-		err = deleteDomain(name)
+		err = deleteDomain(name, &opt.Owner)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		// make sure to upload the image
-		log.Println("Uploading boot disk ...")
-		bootVolume, err := uploadBootDisk(opt.StoragePool, path.Base(opt.ImageURL), opt.ImageURL)
+		// make sure the image is cached
+		log.Println("Caching base image ...")
+		baseImage, err := cacheBaseImage(opt.StoragePool, opt.ImageURL, opt.ImageDigest)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-		// make sure to clone the image
-		log.Println("Cloning boot disk ...")
-		clonedBootVolume, err := cloneBootDisk(opt.StoragePool, bootVolume, bootName)
+		// the boot disk only holds the changes of the VSI
+		log.Println("Creating boot disk ...")
+		bootVolume, err := createBootDiskOverlay(opt.StoragePool, baseImage.Volume, bootName)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		// make sure to upload cidata
 		log.Println("Uploading cidata disk ...")
 		cidataVolume, err := uploadCloudInit(opt.StoragePool, cidataName, cidataIso)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		// keep the console log of the previous boot
+		log.Println("Archiving console logging ...")
+		err = archiveLoggingVolume(opt.StoragePool, name, BoxConsoleLogRetention(opt.ConsoleLogRetention))
+		if err != nil {
+			// the archive is not essential to start the instance
+			log.Printf("Unable to archive the console log of [%s], cause: [%v]", name, err)
 		}
 		// reserve space for the logs
 		log.Println("Initializing console logging ...")
 		logVolume, err := createLoggingVolume(opt.StoragePool, logName)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		// construct the libvirt XML
-		bootXML, err := createBootDisk(clonedBootVolume.Key)
+		bootXML, err := createBootDisk(bootVolume.Key)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		cidataXML, err := createCloudInit(cidataVolume.Key)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		domainXML, err := createDefaultDomainDef(client)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		// update some fields
 		domainXML.Name = name
 		domainXML.Metadata.XML = metadataXML
+		domainXML.LaunchSecurity = createLaunchSecurity(secureExecution)
 		domainXML.Devices.Disks = append(domainXML.Devices.Disks, *bootXML, *cidataXML) // order of disks is important
 		// add data disks
-		for idx, dataDisk := range opt.DataDisks {
-			diskXML, err := createDataDiskXML(dataDisk.StoragePool, dataDisk.Name, idx)
+		for _, dataDisk := range sortDataDisks(opt.DataDisks) {
+			diskXML, err := createDataDiskXML(dataDisk, devices[dataDisk.Name])
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			domainXML.Devices.Disks = append(domainXML.Devices.Disks, *diskXML)
 		}
@@ -312,33 +545,9 @@ func CreateInstanceSync(client *LivirtClient) func(opt *InstanceOptions) (*libvi
 			Append: "off",
 		}
 		// add networks
-		if A.IsNonEmpty(opt.Networks) {
-			networks, err := CreateNetworksXML(name)(opt.Networks)
-			if err != nil {
-				return nil, err
-			}
-			domainXML.Devices.Interfaces = networks
-		} else {
-			// mac address based on the UUID
-			macAddress := CreateMacAddressFromMaybeUUID(name)
-			// construct a network
-			domainXML.Devices.Interfaces = []libvirtxml.DomainInterface{{
-				Model: &libvirtxml.DomainInterfaceModel{
-					Type: "virtio",
-				},
-				Source: &libvirtxml.DomainInterfaceSource{
-					Network: &libvirtxml.DomainInterfaceSourceNetwork{
-						Network: DefaultNetwork,
-					},
-				},
-				Driver: &libvirtxml.DomainInterfaceDriver{
-					IOMMU: "on",
-				},
-				MAC: &libvirtxml.DomainInterfaceMAC{
-					Address: macAddress,
-				},
-			},
-			}
+		domainXML.Devices.Interfaces, err = CreateInstanceInterfacesXML(opt)
+		if err != nil {
+			return nil, nil, err
 		}
 		// check if we can hardcode the UUID
 		uid, err := uuid.Parse(name)
@@ -346,17 +555,61 @@ func CreateInstanceSync(client *LivirtClient) func(opt *InstanceOptions) (*libvi
 			// explicitly set the domain UUID
 			domainXML.UUID = uid.String()
 		}
-		// start the domain
-		return startDomain(domainXML)
+		// start the domain, other power states are applied by the next sync
+		dom, err := defineDomain(domainXML, opt.PowerState)
+		if err != nil {
+			return nil, nil, err
+		}
+		return dom, baseImage, nil
 	}
 }
 
-// DeleteInstanceSync (synchronously) deletes an instance
-func DeleteInstanceSync(client *LivirtClient) func(storagePool, name string) error {
+// RestartInstanceSync power cycles an instance. The console log of the previous boot is archived and the logging
+// volume starts from scratch, so the log of the new boot is not mixed with the previous one.
+func RestartInstanceSync(client *LivirtClient) func(opt *InstanceOptions) error {
+	conn := client.LibVirt
+	createLoggingVolume := CreateLoggingVolume(client)
+	archiveLoggingVolume := ArchiveLoggingVolume(client)
+	checkOwnership := CheckDomainOwnership(client)
+
+	return func(opt *InstanceOptions) error {
+		// log this config
+		defer CM.EntryExit(fmt.Sprintf("RestartInstanceSync(%s)", opt.Name))()
+		// the logging volume is recreated, so it must not belong to a different cluster
+		if err := checkOwnership(opt.Name, &opt.Owner); err != nil {
+			return err
+		}
+
+		dom, err := conn.DomainLookupByName(opt.Name)
+		if err != nil {
+			return err
+		}
+		log.Printf("Stopping domain [%s] ...", opt.Name)
+		err = conn.DomainDestroy(dom)
+		if err != nil && !isError(err, libvirt.ErrOperationInvalid) {
+			return err
+		}
+		err = archiveLoggingVolume(opt.StoragePool, opt.Name, BoxConsoleLogRetention(opt.ConsoleLogRetention))
+		if err != nil {
+			// the archive is not essential to start the instance
+			log.Printf("Unable to archive the console log of [%s], cause: [%v]", opt.Name, err)
+		}
+		_, err = createLoggingVolume(opt.StoragePool, GetLoggingVolumeName(opt.Name))
+		if err != nil {
+			return err
+		}
+		log.Printf("Starting domain [%s] ...", opt.Name)
+		return conn.DomainCreate(dom)
+	}
+}
+
+// DeleteInstanceSync (synchronously) deletes an instance unless it belongs to the operator of a different cluster
+func DeleteInstanceSync(client *LivirtClient) func(storagePool, name string, owner *InstanceOwner) error {
 
 	conn := client.LibVirt
-	deleteDomain := DeleteDomainByName(client)
+	deleteDomain := DeleteOwnedDomainByName(client)
 	delDisk := deleteStorageVol(conn)
+	delArchives := DeleteLoggingArchives(client)
 
 	// delete the disks, but failure will only be logged
 	delDisks := func(storagePool, name string) {
@@ -383,11 +636,20 @@ func DeleteInstanceSync(client *LivirtClient) func(storagePool, name string) err
 				log.Printf("Unable to delete disk [%s], cause: [%v]", vol, err)
 			}
 		}
+		// delete the console logs of previous boots
+		err = delArchives(storagePool, name)
+		if err != nil {
+			log.Printf("Unable to delete the console log archives of [%s], cause: [%v]", name, err)
+		}
 	}
 
-	return func(storagePool, name string) error {
+	return func(storagePool, name string, owner *InstanceOwner) error {
 		// delete the domain
-		err := deleteDomain(name)
+		err := deleteDomain(name, owner)
+		if errors.Is(err, ErrForeignOwner) {
+			// the volumes belong to the domain of the other cluster
+			return err
+		}
 		// delete the disks
 		delDisks(storagePool, name)
 		// done
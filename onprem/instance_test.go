@@ -104,3 +104,39 @@ func TestCreateHash(t *testing.T) {
 
 	assert.Equal(t, hash1, hash2)
 }
+
+func TestCreateHashIgnoresDataDisks(t *testing.T) {
+	opt1 := InstanceOptions{
+		Name:        "Carsten",
+		UserData:    "user_data",
+		ImageURL:    "http://example.com",
+		StoragePool: "defaultPool",
+	}
+	opt2 := opt1
+	opt2.DataDisks = []*AttachedDataDisk{{
+		Name:        "first",
+		StoragePool: "defaultPool",
+	}}
+
+	// data disks are hot-plugged, so they must not cause a recreation of the instance
+	assert.Equal(t, CreateInstanceHash(&opt1), CreateInstanceHash(&opt2))
+}
+
+func TestCreateHashIsStable(t *testing.T) {
+	opt := InstanceOptions{
+		Name:        "Carsten",
+		UserData:    "user_data",
+		ImageURL:    "http://example.com",
+		StoragePool: "defaultPool",
+		DataDisks: []*AttachedDataDisk{
+			{Name: "second", StoragePool: "defaultPool"},
+			{Name: "first", StoragePool: "defaultPool"},
+		},
+		Networks: []string{"second", "first"},
+	}
+
+	// the hash is persisted in the metadata of existing domains, changing it recreates these domains
+	assert.Equal(t, "fbfc0b13cc6d31db98ccf8f5e85aaa8763aa5081505666addba3e0c619711ac7", CreateInstanceHash(&opt))
+	// domains created before data disks were hot-plugged carry the hash including the data disks
+	assert.Equal(t, "f2acaf10c7a3ba18aaaba7a56d71b7c9d413396fcf9f3e0945eaec87521c8539", createLegacyInstanceHash(&opt))
+}
@@ -27,6 +27,8 @@ type LivirtClient struct {
 	LibVirt   *libvirt.Libvirt
 	Hash      string
 	SSHConfig *SSHConfig
+	// decides which libvirt objects may be modified, nil on a host that is not shared
+	Fence *Fence
 }
 
 func (client *LivirtClient) Close() error {
@@ -61,6 +63,14 @@ func CreateLivirtClient(sshConfig *SSHConfig) (*LivirtClient, error) {
 
 // CreateLivirtClientFromEnvMap constructs the libvirt client from an env map
 func CreateLivirtClientFromEnvMap(envMap env.Environment) (*LivirtClient, error) {
-	// just dispatch
-	return CreateLivirtClient(GetSSHConfigFromEnvMap(envMap))
+	fence, err := GetFenceFromEnvMap(envMap)
+	if err != nil {
+		return nil, err
+	}
+	client, err := CreateLivirtClient(GetSSHConfigFromEnvMap(envMap))
+	if err != nil {
+		return nil, err
+	}
+	client.Fence = fence
+	return client, nil
 }
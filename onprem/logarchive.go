@@ -0,0 +1,222 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	A "github.com/IBM/fp-go/array"
+	F "github.com/IBM/fp-go/function"
+	libvirt "github.com/digitalocean/go-libvirt"
+	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+)
+
+const (
+	// timestamp format used to name archived console logs, sorts chronologically. The nanoseconds keep the names
+	// of archives taken within the same second apart.
+	logArchiveTimestampFormat = "20060102150405.000000000"
+)
+
+// GetLoggingArchivePrefix returns the common prefix of all archived console logs of a VSI
+func GetLoggingArchivePrefix(name string) string {
+	return fmt.Sprintf("%s.", GetLoggingVolumeName(name))
+}
+
+// GetLoggingArchiveName returns the name of the archive of a console log, similar to logrotate
+func GetLoggingArchiveName(name string, t time.Time) string {
+	return fmt.Sprintf("%s%s", GetLoggingArchivePrefix(name), t.UTC().Format(logArchiveTimestampFormat))
+}
+
+// getVolumeName returns the name of a storage volume
+func getVolumeName(vol libvirt.StorageVol) string {
+	return vol.Name
+}
+
+// FilterLoggingArchives selects the names of the archived console logs of a VSI and sorts them from oldest to newest
+func FilterLoggingArchives(name string) func(volumes []string) []string {
+	prefix := GetLoggingArchivePrefix(name)
+	return func(volumes []string) []string {
+		archives := F.Pipe1(
+			volumes,
+			A.Filter(func(vol string) bool {
+				return strings.HasPrefix(vol, prefix)
+			}),
+		)
+		sort.Strings(archives)
+		return archives
+	}
+}
+
+// ListLoggingArchives returns the names of the archived console logs of a VSI, from oldest to newest
+func ListLoggingArchives(client *LivirtClient) func(storagePool, name string) ([]string, error) {
+	conn := client.LibVirt
+
+	return func(storagePool, name string) ([]string, error) {
+		// access the pool
+		pool, err := conn.StoragePoolLookupByName(storagePool)
+		if err != nil {
+			return nil, err
+		}
+		volumes, _, err := conn.StoragePoolListAllVolumes(pool, NeedResults, 0)
+		if err != nil {
+			return nil, err
+		}
+		return F.Pipe2(
+			volumes,
+			A.Map(getVolumeName),
+			FilterLoggingArchives(name),
+		), nil
+	}
+}
+
+// GetLoggingVolumeSize returns the number of bytes that have been written to the logging volume
+func GetLoggingVolumeSize(client *LivirtClient) func(storagePool, name string) (uint64, error) {
+	conn := client.LibVirt
+
+	return func(storagePool, name string) (uint64, error) {
+		// access the pool
+		pool, err := conn.StoragePoolLookupByName(storagePool)
+		if err != nil {
+			return 0, err
+		}
+		vol, err := conn.StorageVolLookupByName(pool, name)
+		if err != nil {
+			return 0, err
+		}
+		// for a file based volume the capacity reflects the size of the file
+		_, capacity, _, err := conn.StorageVolGetInfo(vol)
+		return capacity, err
+	}
+}
+
+// pruneLoggingArchives deletes the oldest archived console logs so that at most `retain` archives remain
+func pruneLoggingArchives(client *LivirtClient) func(storagePool, name string, retain int) error {
+	conn := client.LibVirt
+	listLoggingArchives := ListLoggingArchives(client)
+	delVolume := deleteStorageVol(conn)
+
+	return func(storagePool, name string, retain int) error {
+		archives, err := listLoggingArchives(storagePool, name)
+		if err != nil {
+			return err
+		}
+		if len(archives) <= retain {
+			return nil
+		}
+		pool, err := conn.StoragePoolLookupByName(storagePool)
+		if err != nil {
+			return err
+		}
+		for _, archive := range archives[:len(archives)-retain] {
+			log.Printf("Pruning console log archive [%s] from pool [%s] ...", archive, pool.Name)
+			if _, err := delVolume(pool, archive); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// ArchiveLoggingVolume copies the current logging volume of a VSI into a new archive and prunes old archives,
+// this preserves the console log of the previous boot when the VSI is recreated
+func ArchiveLoggingVolume(client *LivirtClient) func(storagePool, name string, retain int) error {
+	conn := client.LibVirt
+	storageVolXMLDesc := getStorageVolXMLDesc(conn)
+	pruneArchives := pruneLoggingArchives(client)
+
+	return func(storagePool, name string, retain int) error {
+		// log this config
+		defer CM.EntryExit(fmt.Sprintf("ArchiveLoggingVolume(%s, %s)", storagePool, name))()
+		// access the pool
+		pool, err := conn.StoragePoolLookupByName(storagePool)
+		if err != nil {
+			return err
+		}
+		logName := GetLoggingVolumeName(name)
+		existing, err := conn.StorageVolLookupByName(pool, logName)
+		if err != nil {
+			// nothing to archive
+			log.Printf("Logging volume [%s] does not exist on pool [%s], nothing to archive", logName, pool.Name)
+			return nil
+		}
+		existingXML, err := storageVolXMLDesc(&existing)
+		if err != nil {
+			return err
+		}
+		// clone the volume into the archive
+		volumeDef := createLoggingVolumeDef(GetLoggingArchiveName(name, time.Now()))
+		volumeDef.Capacity = existingXML.Capacity
+		volumeDefXML, err := XMLMarshall(volumeDef)
+		if err != nil {
+			return err
+		}
+		log.Printf("Archiving logging volume [%s] into [%s] on pool [%s] ...", logName, volumeDef.Name, pool.Name)
+		if _, err := conn.StorageVolCreateXMLFrom(pool, volumeDefXML, existing, 0); err != nil {
+			return err
+		}
+		// keep the configured number of archives
+		return pruneArchives(storagePool, name, retain)
+	}
+}
+
+// ArchiveLogs stores console log content that has been read before the logging volume got truncated,
+// e.g. because the domain has been restarted
+func ArchiveLogs(client *LivirtClient) func(storagePool, name, content string, retain int) error {
+	conn := client.LibVirt
+	pruneArchives := pruneLoggingArchives(client)
+
+	return func(storagePool, name, content string, retain int) error {
+		// log this config
+		defer CM.EntryExit(fmt.Sprintf("ArchiveLogs(%s, %s)", storagePool, name))()
+		if len(content) == 0 {
+			return nil
+		}
+		// access the pool
+		pool, err := conn.StoragePoolLookupByName(storagePool)
+		if err != nil {
+			return err
+		}
+		size := uint64(len(content))
+		volumeDef := createLoggingVolumeDef(GetLoggingArchiveName(name, time.Now()))
+		volumeDef.Capacity.Value = size
+		volumeDefXML, err := XMLMarshall(volumeDef)
+		if err != nil {
+			return err
+		}
+		log.Printf("Archiving [%d] bytes of console log into [%s] on pool [%s] ...", size, volumeDef.Name, pool.Name)
+		volume, err := conn.StorageVolCreateXML(pool, volumeDefXML, 0)
+		if err != nil {
+			return err
+		}
+		if err := conn.StorageVolUpload(volume, strings.NewReader(content), 0, size, 0); err != nil {
+			return err
+		}
+		// keep the configured number of archives
+		return pruneArchives(storagePool, name, retain)
+	}
+}
+
+// DeleteLoggingArchives deletes all archived console logs of a VSI
+func DeleteLoggingArchives(client *LivirtClient) func(storagePool, name string) error {
+	pruneArchives := pruneLoggingArchives(client)
+
+	return func(storagePool, name string) error {
+		return pruneArchives(storagePool, name, 0)
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggingArchiveName(t *testing.T) {
+	ts := time.Date(2023, 3, 17, 10, 18, 45, 0, time.UTC)
+
+	assert.Equal(t, "console-sample.log.20230317101845.000000000", GetLoggingArchiveName("sample", ts))
+	// archives within the same second get different names that sort chronologically
+	first := GetLoggingArchiveName("sample", ts.Add(time.Millisecond))
+	second := GetLoggingArchiveName("sample", ts.Add(2*time.Millisecond))
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, []string{first, second}, FilterLoggingArchives("sample")([]string{second, first}))
+}
+
+func TestFilterLoggingArchives(t *testing.T) {
+	volumes := []string{
+		"boot-sample.qcow2",
+		"console-sample.log",
+		"console-sample.log.20230318000000",
+		"console-other.log.20230317000000",
+		"console-sample.log.20230317000000",
+	}
+
+	archives := FilterLoggingArchives("sample")(volumes)
+
+	assert.Equal(t, []string{"console-sample.log.20230317000000", "console-sample.log.20230318000000"}, archives)
+}
@@ -22,10 +22,14 @@ import (
 	"log"
 	"os"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"os/exec"
 
+	A "github.com/IBM/fp-go/array"
+	F "github.com/IBM/fp-go/function"
 	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
 	"golang.org/x/crypto/ssh"
 	"libvirt.org/go/libvirtxml"
@@ -102,10 +106,20 @@ func CreateLoggingVolume(client *LivirtClient) func(storagePool, name string) (*
 // GetLoggingVolume retrieves the value of the logging volume
 // the HPCR console log is very small by design, so passing it as a string does make sense
 func GetLoggingVolume(client *LivirtClient) func(storagePool, name string) (string, error) {
-	conn := client.LibVirt
+	getLoggingVolumeFromOffset := GetLoggingVolumeFromOffset(client)
 
 	return func(storagePool, name string) (string, error) {
-		msg := fmt.Sprintf("GetLoggingVolume(%s, %s)", storagePool, name)
+		return getLoggingVolumeFromOffset(storagePool, name, 0)
+	}
+}
+
+// GetLoggingVolumeFromOffset retrieves the content of the logging volume starting at the given byte offset,
+// so callers that remember the offset of the last read only transfer the bytes that have been added since
+func GetLoggingVolumeFromOffset(client *LivirtClient) func(storagePool, name string, offset uint64) (string, error) {
+	conn := client.LibVirt
+
+	return func(storagePool, name string, offset uint64) (string, error) {
+		msg := fmt.Sprintf("GetLoggingVolumeFromOffset(%s, %s, %d)", storagePool, name, offset)
 
 		defer CM.PanicAfterTimeout(msg, maxDownloadTimeout)()
 		defer CM.EntryExit(msg)()
@@ -129,18 +143,49 @@ func GetLoggingVolume(client *LivirtClient) func(storagePool, name string) (stri
 
 		// load the value of the logging volume
 		var buffer bytes.Buffer
-		log.Printf("Downloading volume [%s] ...", vol.Key)
-		err = conn.StorageVolDownload(vol, &buffer, 0, maxLoggingVolumeSize, 0)
+		log.Printf("Downloading volume [%s] from offset [%d] ...", vol.Key, offset)
+		err = conn.StorageVolDownload(vol, &buffer, offset, maxLoggingVolumeSize, 0)
 		if err != nil {
 			log.Printf("Error downloading volume [%s], cause: [%v]", vol.Key, err)
 			return "", err
 		}
-		log.Printf("Download of volume [%s] was successful", vol.Key)
+		log.Printf("Download of [%d] bytes of volume [%s] was successful", buffer.Len(), vol.Key)
 		// returns the content of the logs
 		return buffer.String(), nil
 	}
 }
 
+// CompleteLogLines splits the log into lines and returns only the lines that have been terminated by a newline,
+// the last line of a console log that is still being written might be incomplete
+func CompleteLogLines(logs string) []string {
+	idx := strings.LastIndex(logs, "\n")
+	if idx < 0 {
+		return A.Empty[string]()
+	}
+	return F.Pipe1(
+		strings.Split(logs[:idx], "\n"),
+		A.Map(strings.TrimSpace),
+	)
+}
+
+// IsHPLLogLine tests if a log line carries an HPL token
+func IsHPLLogLine(line string) bool {
+	return reSuccessToken.MatchString(line) || reErrorToken.MatchString(line)
+}
+
+// IsHPLErrorLogLine tests if a log line carries an HPL error token
+func IsHPLErrorLogLine(line string) bool {
+	return reErrorToken.MatchString(line)
+}
+
+// GetHPLToken returns the HPL token of a log line, e.g. `HPL10001I`
+func GetHPLToken(line string) string {
+	if token := reErrorToken.FindString(line); len(token) > 0 {
+		return token
+	}
+	return reSuccessToken.FindString(line)
+}
+
 // PartitionLogs partitions the original logs into success and error logs
 func PartitionLogs(logs []string) ([]string, []string) {
 	var success, failure []string
@@ -180,9 +225,28 @@ func VSIFailedToStart(logs []string) bool {
 // GetLoggingVolumeViaSSH retrieves the value of the logging volume via a new and direct SSH connection
 // the HPCR console log is very small by design, so passing it as a string does make sense
 func GetLoggingVolumeViaSSH(config *SSHConfig) func(path string) (string, error) {
+	getLoggingVolumeFromOffsetViaSSH := GetLoggingVolumeFromOffsetViaSSH(config)
 
 	return func(path string) (string, error) {
-		msg := fmt.Sprintf("GetLoggingVolumeViaSSH(%s)", path)
+		return getLoggingVolumeFromOffsetViaSSH(path, 0)
+	}
+}
+
+// loggingVolumeCommand returns the shell command that prints the content of the logging volume starting at the given byte offset
+func loggingVolumeCommand(path string, offset uint64) string {
+	if offset == 0 {
+		return fmt.Sprintf("/usr/bin/cat \"%s\"", path)
+	}
+	// tail counts bytes starting at 1
+	return fmt.Sprintf("/usr/bin/tail -c +%d \"%s\"", offset+1, path)
+}
+
+// GetLoggingVolumeFromOffsetViaSSH retrieves the content of the logging volume starting at the given byte offset via a
+// new and direct SSH connection
+func GetLoggingVolumeFromOffsetViaSSH(config *SSHConfig) func(path string, offset uint64) (string, error) {
+
+	return func(path string, offset uint64) (string, error) {
+		msg := fmt.Sprintf("GetLoggingVolumeFromOffsetViaSSH(%s, %d)", path, offset)
 		defer CM.PanicAfterTimeout(msg, maxDownloadTimeout)()
 		defer CM.EntryExit(msg)()
 
@@ -231,12 +295,12 @@ func GetLoggingVolumeViaSSH(config *SSHConfig) func(path string) (string, error)
 		var buffer bytes.Buffer
 		session.Stdout = &buffer
 
-		log.Printf("Downloading volume [%s] ...", path)
-		if err := session.Run(fmt.Sprintf("/usr/bin/cat \"%s\"", path)); err != nil {
-			log.Printf("Unable to cat [%s], cause: [%v]", path, err)
+		log.Printf("Downloading volume [%s] from offset [%d] ...", path, offset)
+		if err := session.Run(loggingVolumeCommand(path, offset)); err != nil {
+			log.Printf("Unable to read [%s], cause: [%v]", path, err)
 			return "", err
 		}
-		log.Printf("Download of volume [%s] was successful", path)
+		log.Printf("Download of [%d] bytes of volume [%s] was successful", buffer.Len(), path)
 
 		return buffer.String(), nil
 	}
@@ -245,8 +309,8 @@ func GetLoggingVolumeViaSSH(config *SSHConfig) func(path string) (string, error)
 // getLoggingVolumeViaSSH retrieves the value of the logging volume by spawning a separate command. The advantage of this approach is
 // that that command can be canceled if it times out
 // the HPCR console log is very small by design, so passing it as a string does make sense
-func getLoggingVolumeViaCommand(ctx context.Context, config *SSHConfig, command string, path string) (string, error) {
-	msg := fmt.Sprintf("getLoggingVolumeViaCommand(%s, %s)", command, path)
+func getLoggingVolumeViaCommand(ctx context.Context, config *SSHConfig, command string, path string, offset uint64) (string, error) {
+	msg := fmt.Sprintf("getLoggingVolumeViaCommand(%s, %s, %d)", command, path, offset)
 	defer CM.EntryExit(msg)()
 
 	// marshal the ssh config
@@ -262,7 +326,7 @@ func getLoggingVolumeViaCommand(ctx context.Context, config *SSHConfig, command
 
 	var buffer bytes.Buffer
 
-	cmd := exec.CommandContext(withTimeout, command, "download", "--path", path)
+	cmd := exec.CommandContext(withTimeout, command, "download", "--path", path, "--offset", strconv.FormatUint(offset, 10))
 	cmd.Stdin = bytes.NewReader(configBytes)
 	cmd.Stdout = &buffer
 	cmd.Stderr = os.Stderr
@@ -282,12 +346,22 @@ func getLoggingVolumeViaCommand(ctx context.Context, config *SSHConfig, command
 // that that command can be canceled if it times out
 // the HPCR console log is very small by design, so passing it as a string does make sense
 func GetLoggingVolumeViaCommand(client *LivirtClient) func(storagePool, name string) (string, error) {
+	getLoggingVolumeFromOffsetViaCommand := GetLoggingVolumeFromOffsetViaCommand(client)
+
+	return func(storagePool, name string) (string, error) {
+		return getLoggingVolumeFromOffsetViaCommand(storagePool, name, 0)
+	}
+}
+
+// GetLoggingVolumeFromOffsetViaCommand retrieves the content of the logging volume starting at the given byte offset by spawning
+// a separate command, so a download that hangs can be canceled without affecting the controller
+func GetLoggingVolumeFromOffsetViaCommand(client *LivirtClient) func(storagePool, name string, offset uint64) (string, error) {
 	// config needed for further processing
 	sshConfig := client.SSHConfig
 	conn := client.LibVirt
 
-	return func(storagePool, name string) (string, error) {
-		msg := fmt.Sprintf("GetLoggingVolumeViaCommand(%s, %s)", storagePool, name)
+	return func(storagePool, name string, offset uint64) (string, error) {
+		msg := fmt.Sprintf("GetLoggingVolumeFromOffsetViaCommand(%s, %s, %d)", storagePool, name, offset)
 		defer CM.EntryExit(msg)()
 
 		executable, err := os.Executable()
@@ -314,6 +388,6 @@ func GetLoggingVolumeViaCommand(client *LivirtClient) func(storagePool, name str
 		}
 		log.Printf("Lookup up of volume [%s] by name in pool [%s] was successful.", vol.Name, pool.Name)
 
-		return getLoggingVolumeViaCommand(context.Background(), sshConfig, executable, vol.Key)
+		return getLoggingVolumeViaCommand(context.Background(), sshConfig, executable, vol.Key, offset)
 	}
 }
@@ -85,6 +85,36 @@ func TestPartitionFailedStartup(t *testing.T) {
 	assert.NotEmpty(t, success)
 }
 
+func TestCompleteLogLines(t *testing.T) {
+	// the last line is still being written
+	lines := CompleteLogLines("first\nsecond\nthi")
+	assert.Equal(t, []string{"first", "second"}, lines)
+	// no complete line, yet
+	assert.Empty(t, CompleteLogLines("fir"))
+	// all lines are complete
+	assert.Len(t, CompleteLogLines(successLog+"\n"), len(strings.Split(successLog, "\n")))
+}
+
+func TestHPLTokens(t *testing.T) {
+	success := "hpcr-catch-success[1421]: HPL10001I: Services succeeded -> systemd triggered hpl-catch-success service"
+	failure := "hpcr-contract[855]: HPL12002E: Contract decryption failed."
+
+	assert.True(t, IsHPLLogLine(success))
+	assert.True(t, IsHPLLogLine(failure))
+	assert.False(t, IsHPLLogLine("# create root filesystem..."))
+
+	assert.False(t, IsHPLErrorLogLine(success))
+	assert.True(t, IsHPLErrorLogLine(failure))
+
+	assert.Equal(t, "HPL10001I", GetHPLToken(success))
+	assert.Equal(t, "HPL12002E", GetHPLToken(failure))
+}
+
+func TestLoggingVolumeCommand(t *testing.T) {
+	assert.Equal(t, `/usr/bin/cat "/var/lib/libvirt/images/console.log"`, loggingVolumeCommand("/var/lib/libvirt/images/console.log", 0))
+	assert.Equal(t, `/usr/bin/tail -c +2049 "/var/lib/libvirt/images/console.log"`, loggingVolumeCommand("/var/lib/libvirt/images/console.log", 2048))
+}
+
 // func TestDirectVolumeDownload(t *testing.T) {
 // 	config, err := defaultSSHConfig("../.env")
 // 	if err != nil {
@@ -105,7 +135,7 @@ func TestPartitionFailedStartup(t *testing.T) {
 // 		t.SkipNow()
 // 	}
 
-// 	data, err := getLoggingVolumeViaCommand(context.Background(), config, "../k8s-operator-hpcr.exe", "/var/lib/libvirt/images/console-e140b66c-be72-4d49-9348-b0f4b658b073.log")
+// 	data, err := getLoggingVolumeViaCommand(context.Background(), config, "../k8s-operator-hpcr.exe", "/var/lib/libvirt/images/console-e140b66c-be72-4d49-9348-b0f4b658b073.log", 0)
 // 	require.NoError(t, err)
 
 // 	fmt.Println(data)
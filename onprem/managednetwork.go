@@ -0,0 +1,445 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+
+	A "github.com/IBM/fp-go/array"
+	libvirt "github.com/digitalocean/go-libvirt"
+	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	"libvirt.org/go/libvirtxml"
+)
+
+var (
+	// full identifier of the network config entry
+	KeyNetworkConfig = fmt.Sprintf("%s.%s", KindNetwork, APIVersion)
+)
+
+// parseSubnet decodes an IPv4 subnet in CIDR notation
+func parseSubnet(subnet string) (*net.IPNet, error) {
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, err
+	}
+	if ipNet.IP.To4() == nil {
+		return nil, fmt.Errorf("subnet [%s] is not an IPv4 subnet", subnet)
+	}
+	if ones, _ := ipNet.Mask.Size(); ones > 30 {
+		return nil, fmt.Errorf("subnet [%s] is too small", subnet)
+	}
+	return ipNet, nil
+}
+
+// hostAddress returns the n-th address of the subnet, negative values count from the broadcast address
+func hostAddress(ipNet *net.IPNet, n int) string {
+	base := binary.BigEndian.Uint32(ipNet.IP.To4())
+	if n < 0 {
+		base |= ^binary.BigEndian.Uint32(ipNet.Mask)
+	}
+	addr := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(addr, uint32(int64(base)+int64(n)))
+	return addr.String()
+}
+
+// createNetworkIPDef produces the IP section of a network, the first host address is assigned to the host
+func createNetworkIPDef(opt *NetworkOptions) (*libvirtxml.NetworkIP, error) {
+	ipNet, err := parseSubnet(opt.Subnet)
+	if err != nil {
+		return nil, err
+	}
+	prefix, _ := ipNet.Mask.Size()
+	ipDef := &libvirtxml.NetworkIP{
+		Address: hostAddress(ipNet, 1),
+		Prefix:  uint(prefix),
+	}
+	if opt.DHCP != nil && opt.DHCP.Disabled {
+		return ipDef, nil
+	}
+	// the DHCP range defaults to the full subnet
+	dhcpRange := libvirtxml.NetworkDHCPRange{
+		Start: hostAddress(ipNet, 2),
+		End:   hostAddress(ipNet, -1),
+	}
+	if opt.DHCP != nil && opt.DHCP.Start != "" {
+		dhcpRange.Start = opt.DHCP.Start
+	}
+	if opt.DHCP != nil && opt.DHCP.End != "" {
+		dhcpRange.End = opt.DHCP.End
+	}
+	for _, addr := range []string{dhcpRange.Start, dhcpRange.End} {
+		ip := net.ParseIP(addr)
+		if ip == nil || !ipNet.Contains(ip) {
+			return nil, fmt.Errorf("DHCP address [%s] is not part of the subnet [%s]", addr, opt.Subnet)
+		}
+	}
+	ipDef.DHCP = &libvirtxml.NetworkDHCP{
+		Ranges: []libvirtxml.NetworkDHCPRange{dhcpRange},
+	}
+	return ipDef, nil
+}
+
+// createNetworkDNSHostDef converts a DNS host entry
+func createNetworkDNSHostDef(host NetworkDNSHostSpec) libvirtxml.NetworkDNSHost {
+	return libvirtxml.NetworkDNSHost{
+		IP: host.IP,
+		Hostnames: A.MonadMap(host.Hostnames, func(name string) libvirtxml.NetworkDNSHostHostname {
+			return libvirtxml.NetworkDNSHostHostname{Hostname: name}
+		}),
+	}
+}
+
+// CreateNetworkDef produces the libvirt definition of a managed network
+func CreateNetworkDef(opt *NetworkOptions) (*libvirtxml.Network, error) {
+	netDef := &libvirtxml.Network{
+		Name: opt.Name,
+	}
+	if opt.MTU > 0 {
+		netDef.MTU = &libvirtxml.NetworkMTU{Size: opt.MTU}
+	}
+	switch opt.Mode {
+	case NetworkModeBridge:
+		if opt.Interface == "" {
+			return nil, fmt.Errorf("network [%s] in mode [%s] requires the name of the host bridge", opt.Name, opt.Mode)
+		}
+		if opt.Subnet != "" || A.IsNonEmpty(opt.DNSHosts) {
+			return nil, fmt.Errorf("network [%s] in mode [%s] does not support a subnet or DNS entries", opt.Name, opt.Mode)
+		}
+		netDef.Forward = &libvirtxml.NetworkForward{Mode: NetworkModeBridge}
+		netDef.Bridge = &libvirtxml.NetworkBridge{Name: opt.Interface}
+		return netDef, nil
+	case NetworkModeNAT, NetworkModeRoute:
+		if opt.Subnet == "" {
+			return nil, fmt.Errorf("network [%s] in mode [%s] requires a subnet", opt.Name, opt.Mode)
+		}
+		netDef.Forward = &libvirtxml.NetworkForward{Mode: opt.Mode, Dev: opt.Interface}
+	case NetworkModeIsolated:
+		// no forward element
+	default:
+		return nil, fmt.Errorf("network [%s] has unsupported mode [%s]", opt.Name, opt.Mode)
+	}
+	if opt.Subnet != "" {
+		ipDef, err := createNetworkIPDef(opt)
+		if err != nil {
+			return nil, err
+		}
+		netDef.IPs = []libvirtxml.NetworkIP{*ipDef}
+	} else if A.IsNonEmpty(opt.DNSHosts) {
+		return nil, fmt.Errorf("network [%s] requires a subnet for DNS entries", opt.Name)
+	}
+	if A.IsNonEmpty(opt.DNSHosts) {
+		netDef.DNS = &libvirtxml.NetworkDNS{
+			Host: A.MonadMap(opt.DNSHosts, createNetworkDNSHostDef),
+		}
+	}
+	return netDef, nil
+}
+
+// networkFingerprint captures the parts of a network definition that are managed by the operator
+type networkFingerprint struct {
+	Mode   string
+	Dev    string
+	Bridge string
+	MTU    uint
+	IPs    []string
+	DNS    []string
+}
+
+func getNetworkPrefix(ip *libvirtxml.NetworkIP) uint {
+	if ip.Netmask != "" {
+		ipNet, err := getNetworkIPNet(ip)
+		if err == nil {
+			ones, _ := ipNet.Mask.Size()
+			return uint(ones)
+		}
+	}
+	return ip.Prefix
+}
+
+func createNetworkFingerprint(netDef *libvirtxml.Network) networkFingerprint {
+	var fp networkFingerprint
+	if netDef.Forward != nil {
+		fp.Mode = netDef.Forward.Mode
+		fp.Dev = netDef.Forward.Dev
+	}
+	// libvirt assigns the bridge name for all modes except bridge
+	if fp.Mode == NetworkModeBridge && netDef.Bridge != nil {
+		fp.Bridge = netDef.Bridge.Name
+	}
+	if netDef.MTU != nil {
+		fp.MTU = netDef.MTU.Size
+	}
+	for idx := range netDef.IPs {
+		ip := &netDef.IPs[idx]
+		entry := fmt.Sprintf("%s/%d", ip.Address, getNetworkPrefix(ip))
+		if ip.DHCP != nil {
+			for _, rng := range ip.DHCP.Ranges {
+				entry = fmt.Sprintf("%s dhcp=%s-%s", entry, rng.Start, rng.End)
+			}
+		}
+		fp.IPs = append(fp.IPs, entry)
+	}
+	if netDef.DNS != nil {
+		for _, host := range netDef.DNS.Host {
+			names := A.MonadMap(host.Hostnames, func(name libvirtxml.NetworkDNSHostHostname) string {
+				return name.Hostname
+			})
+			fp.DNS = append(fp.DNS, fmt.Sprintf("%s=%s", host.IP, strings.Join(names, ",")))
+		}
+	}
+	return fp
+}
+
+// IsNetworkInSync checks if the managed parts of the actual network definition match the desired definition
+func IsNetworkInSync(desired, actual *libvirtxml.Network) bool {
+	left := createNetworkFingerprint(desired)
+	right := createNetworkFingerprint(actual)
+	return left.Mode == right.Mode &&
+		left.Dev == right.Dev &&
+		left.Bridge == right.Bridge &&
+		left.MTU == right.MTU &&
+		slices.Equal(left.IPs, right.IPs) &&
+		slices.Equal(left.DNS, right.DNS)
+}
+
+// mergeDHCPHosts carries over the DHCP host entries of the existing network, these are managed per VSI
+func mergeDHCPHosts(desired, existing *libvirtxml.Network) {
+	for idx := range existing.IPs {
+		dhcp := existing.IPs[idx].DHCP
+		if dhcp == nil {
+			continue
+		}
+		for _, host := range dhcp.Hosts {
+			ipIdx, err := FindDHCPIPIndex(desired, host.IP)
+			if err != nil {
+				log.Printf("Dropping DHCP host [%s] with IP [%s] from network [%s], cause: [%v]", host.MAC, host.IP, desired.Name, err)
+				continue
+			}
+			desired.IPs[ipIdx].DHCP.Hosts = append(desired.IPs[ipIdx].DHCP.Hosts, host)
+		}
+	}
+}
+
+// GetNetworkDomains returns the names of all domains with an interface on the network
+func GetNetworkDomains(client *LivirtClient) func(networkName string) ([]string, error) {
+	conn := client.LibVirt
+	getDomains := GetDomains(client)
+
+	return func(networkName string) ([]string, error) {
+		domains, err := getDomains()
+		if err != nil {
+			return nil, err
+		}
+		var result []string
+		for _, domain := range domains {
+			xmlDesc, err := conn.DomainGetXMLDesc(domain, 0)
+			if err != nil {
+				log.Printf("Unable to get the description of domain [%s], cause: [%v]", domain.Name, err)
+				continue
+			}
+			domainXML, err := parseDomainXML(xmlDesc)
+			if err != nil || domainXML.Devices == nil {
+				continue
+			}
+			for idx := range domainXML.Devices.Interfaces {
+				source := domainXML.Devices.Interfaces[idx].Source
+				if source != nil && source.Network != nil && source.Network.Network == networkName {
+					result = append(result, domain.Name)
+					break
+				}
+			}
+		}
+		return result, nil
+	}
+}
+
+// ManagedNetwork describes the state of a managed network
+type ManagedNetwork struct {
+	// the live definition of the network
+	Network *libvirtxml.Network
+	// true if the persistent definition differs from the live definition, because the network could
+	// not be restarted while VSIs are attached
+	RestartPending bool
+}
+
+// CreateNetworkSync creates the managed network or reconciles its definition
+func CreateNetworkSync(client *LivirtClient) func(opt *NetworkOptions) (*ManagedNetwork, error) {
+	conn := client.LibVirt
+	networkXMLDesc := getNetworkXMLDesc(conn)
+	getNetworkDomains := GetNetworkDomains(client)
+
+	getInactiveXMLDesc := func(network libvirt.Network) (*libvirtxml.Network, error) {
+		xmlDef, err := conn.NetworkGetXMLDesc(network, uint32(libvirt.NetworkXMLInactive))
+		if err != nil {
+			return nil, err
+		}
+		return parseNetworkXML(xmlDef)
+	}
+
+	defineNetwork := func(netDef *libvirtxml.Network) (libvirt.Network, error) {
+		netDefXML, err := XMLMarshall(netDef)
+		if err != nil {
+			return libvirt.Network{}, err
+		}
+		log.Printf("Defining network [%s] ...", netDef.Name)
+		return conn.NetworkDefineXML(netDefXML)
+	}
+
+	return func(opt *NetworkOptions) (*ManagedNetwork, error) {
+		defer CM.EntryExit(fmt.Sprintf("CreateNetworkSync(%s)", opt.Name))()
+
+		desired, err := CreateNetworkDef(opt)
+		if err != nil {
+			return nil, err
+		}
+		network, err := conn.NetworkLookupByName(opt.Name)
+		if err != nil {
+			if !isError(err, libvirt.ErrNoNetwork) {
+				return nil, err
+			}
+			// create the network from scratch
+			network, err = defineNetwork(desired)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			// reconcile drift of the persistent definition
+			existing, err := getInactiveXMLDesc(network)
+			if err != nil {
+				return nil, err
+			}
+			if !IsNetworkInSync(desired, existing) {
+				log.Printf("Network [%s] differs from its specification, redefining ...", opt.Name)
+				desired.UUID = existing.UUID
+				mergeDHCPHosts(desired, existing)
+				network, err = defineNetwork(desired)
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err = conn.NetworkSetAutostart(network, 1); err != nil {
+			return nil, err
+		}
+		active, err := conn.NetworkIsActive(network)
+		if err != nil {
+			return nil, err
+		}
+		if active == 0 {
+			log.Printf("Starting network [%s] ...", opt.Name)
+			if err = conn.NetworkCreate(network); err != nil {
+				return nil, err
+			}
+		}
+		live, err := networkXMLDesc(&network)
+		if err != nil {
+			return nil, err
+		}
+		if IsNetworkInSync(desired, live) {
+			return &ManagedNetwork{Network: live}, nil
+		}
+		// the live network needs a restart to pick up the definition
+		domains, err := getNetworkDomains(opt.Name)
+		if err != nil {
+			return nil, err
+		}
+		if A.IsNonEmpty(domains) {
+			log.Printf("Network [%s] cannot be restarted, it is in use by %v", opt.Name, domains)
+			return &ManagedNetwork{Network: live, RestartPending: true}, nil
+		}
+		log.Printf("Restarting network [%s] ...", opt.Name)
+		if err = conn.NetworkDestroy(network); err != nil {
+			return nil, err
+		}
+		if err = conn.NetworkCreate(network); err != nil {
+			return nil, err
+		}
+		live, err = networkXMLDesc(&network)
+		if err != nil {
+			return nil, err
+		}
+		return &ManagedNetwork{Network: live}, nil
+	}
+}
+
+// DeleteNetworkSync stops and undefines a managed network
+func DeleteNetworkSync(client *LivirtClient) func(name string) error {
+	conn := client.LibVirt
+
+	return func(name string) error {
+		defer CM.EntryExit(fmt.Sprintf("DeleteNetworkSync(%s)", name))()
+
+		network, err := conn.NetworkLookupByName(name)
+		if err != nil {
+			log.Printf("Network [%s] cannot be located, assuming it's been deleted, cause: [%v]", name, err)
+			return nil
+		}
+		active, err := conn.NetworkIsActive(network)
+		if err != nil {
+			return err
+		}
+		if active != 0 {
+			log.Printf("Stopping network [%s] ...", name)
+			if err = conn.NetworkDestroy(network); err != nil {
+				return err
+			}
+		}
+		log.Printf("Undefining network [%s] ...", name)
+		return conn.NetworkUndefine(network)
+	}
+}
+
+// NetworksFromRelated decodes the set of managed networks from the related data structure
+func NetworksFromRelated(data map[string]any) ([]*NetworkCustomResource, error) {
+	var result []*NetworkCustomResource
+	if related, ok := data["related"].(map[string]any); ok {
+		if networks, ok := related[KeyNetworkConfig].(map[string]any); ok {
+			for _, network := range networks {
+				// transcode to the expected format
+				net, err := common.Transcode[*NetworkCustomResource](network)
+				if err != nil {
+					return nil, err
+				}
+				// validate the status of the network
+				if common.Status(net.Status.Status) == common.Ready {
+					result = append(result, net)
+				} else {
+					// print the invalid network config
+					res, err := json.Marshal(net)
+					if err == nil {
+						log.Printf("Network not ready is [%s]", string(res))
+					}
+					log.Printf("Network [%s] is not in ready state, ignoring, cause: [%s]", net.Name, net.Status.Description)
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// GetManagedNetworkName returns the name of the libvirt network backing the custom resource
+func GetManagedNetworkName(res *NetworkCustomResource) string {
+	return string(res.UID)
+}
+
+// NetworkCustomResourceToNetworks converts from an array of NetworkCustomResource to the names of the libvirt networks
+var NetworkCustomResourceToNetworks = A.Map(GetManagedNetworkName)
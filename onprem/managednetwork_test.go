@@ -0,0 +1,142 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"libvirt.org/go/libvirtxml"
+)
+
+func TestCreateNetworkDefNAT(t *testing.T) {
+	netDef, err := CreateNetworkDef(&NetworkOptions{
+		Name:   "sample",
+		Mode:   NetworkModeNAT,
+		Subnet: "192.168.150.0/24",
+		DNSHosts: []NetworkDNSHostSpec{
+			{IP: "192.168.150.10", Hostnames: []string{"registry.example.com"}},
+		},
+		MTU: 1400,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, NetworkModeNAT, netDef.Forward.Mode)
+	require.Len(t, netDef.IPs, 1)
+	assert.Equal(t, "192.168.150.1", netDef.IPs[0].Address)
+	assert.Equal(t, uint(24), netDef.IPs[0].Prefix)
+	assert.Equal(t, []libvirtxml.NetworkDHCPRange{{Start: "192.168.150.2", End: "192.168.150.254"}}, netDef.IPs[0].DHCP.Ranges)
+	assert.Equal(t, "registry.example.com", netDef.DNS.Host[0].Hostnames[0].Hostname)
+	assert.Equal(t, uint(1400), netDef.MTU.Size)
+}
+
+func TestCreateNetworkDefDHCP(t *testing.T) {
+	netDef, err := CreateNetworkDef(&NetworkOptions{
+		Name:   "sample",
+		Mode:   NetworkModeIsolated,
+		Subnet: "10.10.0.0/16",
+		DHCP:   &NetworkDHCPSpec{Start: "10.10.1.0"},
+	})
+	require.NoError(t, err)
+
+	assert.Nil(t, netDef.Forward)
+	assert.Equal(t, []libvirtxml.NetworkDHCPRange{{Start: "10.10.1.0", End: "10.10.255.254"}}, netDef.IPs[0].DHCP.Ranges)
+
+	netDef, err = CreateNetworkDef(&NetworkOptions{
+		Name:   "sample",
+		Mode:   NetworkModeRoute,
+		Subnet: "10.10.0.0/16",
+		DHCP:   &NetworkDHCPSpec{Disabled: true},
+	})
+	require.NoError(t, err)
+	assert.Nil(t, netDef.IPs[0].DHCP)
+
+	_, err = CreateNetworkDef(&NetworkOptions{
+		Name:   "sample",
+		Mode:   NetworkModeNAT,
+		Subnet: "10.10.0.0/16",
+		DHCP:   &NetworkDHCPSpec{Start: "10.11.0.1"},
+	})
+	assert.Error(t, err)
+}
+
+func TestCreateNetworkDefBridge(t *testing.T) {
+	netDef, err := CreateNetworkDef(&NetworkOptions{
+		Name:      "sample",
+		Mode:      NetworkModeBridge,
+		Interface: "br0",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "br0", netDef.Bridge.Name)
+	assert.Empty(t, netDef.IPs)
+
+	_, err = CreateNetworkDef(&NetworkOptions{
+		Name:   "sample",
+		Mode:   NetworkModeBridge,
+		Subnet: "192.168.150.0/24",
+	})
+	assert.Error(t, err)
+}
+
+func TestIsNetworkInSync(t *testing.T) {
+	desired, err := CreateNetworkDef(&NetworkOptions{
+		Name:   "sample",
+		Mode:   NetworkModeNAT,
+		Subnet: "192.168.150.0/24",
+	})
+	require.NoError(t, err)
+
+	// libvirt adds details, uses a netmask and the DHCP hosts are managed per VSI
+	actual := &libvirtxml.Network{
+		Name: "sample",
+		UUID: "97d16d9e-da57-492c-82a0-0388561bf065",
+		Forward: &libvirtxml.NetworkForward{
+			Mode: NetworkModeNAT,
+			NAT:  &libvirtxml.NetworkForwardNAT{},
+		},
+		Bridge: &libvirtxml.NetworkBridge{Name: "virbr1"},
+		IPs: []libvirtxml.NetworkIP{
+			{
+				Address: "192.168.150.1",
+				Netmask: "255.255.255.0",
+				DHCP: &libvirtxml.NetworkDHCP{
+					Ranges: []libvirtxml.NetworkDHCPRange{{Start: "192.168.150.2", End: "192.168.150.254"}},
+					Hosts:  []libvirtxml.NetworkDHCPHost{{MAC: "52:54:00:00:00:01", IP: "192.168.150.20"}},
+				},
+			},
+		},
+	}
+	assert.True(t, IsNetworkInSync(desired, actual))
+
+	actual.MTU = &libvirtxml.NetworkMTU{Size: 9000}
+	assert.False(t, IsNetworkInSync(desired, actual))
+}
+
+func TestMergeDHCPHosts(t *testing.T) {
+	desired, err := CreateNetworkDef(&NetworkOptions{
+		Name:   "sample",
+		Mode:   NetworkModeNAT,
+		Subnet: "192.168.150.0/24",
+	})
+	require.NoError(t, err)
+
+	existing := createTestNetwork()
+	existing.IPs[1].DHCP.Hosts = append(existing.IPs[1].DHCP.Hosts, libvirtxml.NetworkDHCPHost{MAC: "52:54:00:00:00:02", IP: "192.168.150.20"})
+
+	mergeDHCPHosts(desired, existing)
+
+	assert.Equal(t, []libvirtxml.NetworkDHCPHost{{MAC: "52:54:00:00:00:02", IP: "192.168.150.20"}}, desired.IPs[0].DHCP.Hosts)
+}
@@ -0,0 +1,188 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/digitalocean/go-libvirt"
+)
+
+const (
+	// KeyClusterID is the key into the configuration for the identifier of the k8s cluster, part of the names of the domains
+	KeyClusterID = "CLUSTER_ID"
+	// KeyNamingScheme is the key into the configuration for the template of the names of the domains
+	KeyNamingScheme = "NAMING_SCHEME"
+
+	// placeholders of the naming scheme
+	NamingPlaceholderCluster   = "{cluster}"
+	NamingPlaceholderNamespace = "{namespace}"
+	NamingPlaceholderName      = "{name}"
+	NamingPlaceholderUID       = "{uid}"
+
+	// maximum length of an instance name, the name is also the hostname of the VSI
+	maxInstanceNameLength = 63
+	// length of the prefix of the UID that makes a name unique
+	uniqueSuffixLength = 8
+)
+
+var (
+	// characters that are not allowed in an instance name
+	invalidNameCharacters = regexp.MustCompile(`[^a-z0-9-]+`)
+	// sequences of separators
+	repeatedSeparators = regexp.MustCompile(`-{2,}`)
+)
+
+// InstanceOwner identifies the k8s resource that owns a domain
+type InstanceOwner struct {
+	// UID of the custom resource
+	UID string
+	// namespace of the custom resource
+	Namespace string
+	// name of the custom resource
+	Name string
+	// identifier of the k8s cluster
+	ClusterID string
+}
+
+// sanitizeInstanceName turns a name into a valid hostname label
+func sanitizeInstanceName(name string) string {
+	name = invalidNameCharacters.ReplaceAllString(strings.ToLower(name), "-")
+	name = repeatedSeparators.ReplaceAllString(name, "-")
+	return strings.Trim(name, "-")
+}
+
+// CreateUniqueInstanceName appends a prefix of the UID to a name, so it does not collide with the name of a different VSI
+func CreateUniqueInstanceName(name, uid string) string {
+	suffix := sanitizeInstanceName(uid)
+	if len(suffix) > uniqueSuffixLength {
+		suffix = suffix[:uniqueSuffixLength]
+	}
+	if len(name) > maxInstanceNameLength-len(suffix)-1 {
+		name = strings.TrimRight(name[:maxInstanceNameLength-len(suffix)-1], "-")
+	}
+	return fmt.Sprintf("%s-%s", name, suffix)
+}
+
+// CreateInstanceName renders the naming scheme for the owner of a domain. Placeholders that resolve to an empty value
+// are dropped together with their separator, names that exceed the length of a hostname are shortened and made unique
+// by a prefix of the UID.
+func CreateInstanceName(scheme string, owner *InstanceOwner) string {
+	name := strings.NewReplacer(
+		NamingPlaceholderCluster, owner.ClusterID,
+		NamingPlaceholderNamespace, owner.Namespace,
+		NamingPlaceholderName, owner.Name,
+		NamingPlaceholderUID, owner.UID,
+	).Replace(BoxNamingScheme(scheme))
+	name = sanitizeInstanceName(name)
+	if name == "" {
+		return owner.UID
+	}
+	if len(name) > maxInstanceNameLength {
+		return CreateUniqueInstanceName(name, owner.UID)
+	}
+	return name
+}
+
+// createInstanceMetadata assembles the metadata the operator writes into its domains
+func createInstanceMetadata(opt *InstanceOptions) InstanceMetadata {
+	return InstanceMetadata{
+		Hash:            CreateInstanceHash(opt),
+		UID:             opt.Owner.UID,
+		Namespace:       opt.Owner.Namespace,
+		Name:            opt.Owner.Name,
+		ClusterID:       opt.Owner.ClusterID,
+		OperatorVersion: opt.OperatorVersion,
+	}
+}
+
+// parseInstanceMetadata decodes the metadata the operator wrote into a domain
+func parseInstanceMetadata(metadataXML string) (*InstanceMetadata, error) {
+	metadata := InstanceMetadata{}
+	if err := xml.Unmarshal([]byte(metadataXML), &metadata); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// setInstanceMetadata writes the metadata of the operator into an existing domain, the running domain is updated,
+// too, so no restart is required
+func setInstanceMetadata(conn *libvirt.Libvirt, dom libvirt.Domain, metadata InstanceMetadata) error {
+	metadataXML, err := XMLMarshall(metadata)
+	if err != nil {
+		return err
+	}
+	flags := libvirt.DomainAffectConfig
+	if active, err := conn.DomainIsActive(dom); err == nil && active == 1 {
+		flags |= libvirt.DomainAffectLive
+	}
+	return conn.DomainSetMetadata(dom, int32(libvirt.DomainMetadataElement), libvirt.OptString{metadataXML}, libvirt.OptString{instanceMetadataKey}, libvirt.OptString{InstanceMetadataNamespace}, flags)
+}
+
+// GetInstanceMetadata returns the metadata of the operator of a domain. The flag tells if the domain exists, the
+// metadata is nil for a domain that has not been created by the operator.
+func GetInstanceMetadata(client *LivirtClient) func(name string) (*InstanceMetadata, bool) {
+	conn := client.LibVirt
+	getDomainXML := getDomainXMLByName(conn)
+
+	return func(name string) (*InstanceMetadata, bool) {
+		domainXML := getDomainXML(name)
+		if domainXML == nil {
+			return nil, false
+		}
+		if domainXML.Metadata == nil || !isManagedDomainXML(domainXML.Metadata.XML) {
+			return nil, true
+		}
+		metadata, err := parseInstanceMetadata(domainXML.Metadata.XML)
+		if err != nil {
+			log.Printf("Unable to parse metadata XML for domain [%s], cause: [%v]", name, err)
+			return nil, true
+		}
+		return metadata, true
+	}
+}
+
+// UpdateInstanceMetadata refreshes the hash, the owner and the operator version in the metadata of a valid domain, e.g. for
+// domains created by a previous version of the operator
+func UpdateInstanceMetadata(client *LivirtClient) func(opt *InstanceOptions) error {
+	conn := client.LibVirt
+	getInstanceMetadata := GetInstanceMetadata(client)
+
+	return func(opt *InstanceOptions) error {
+		existing, ok := getInstanceMetadata(opt.Name)
+		if !ok || existing == nil {
+			return nil
+		}
+		if !client.Fence.Permits(opt.Name, existing, &opt.Owner) {
+			return fmt.Errorf("%w: domain [%s] is owned by cluster [%s]", ErrForeignOwner, opt.Name, existing.ClusterID)
+		}
+		desired := createInstanceMetadata(opt)
+		// the parsed metadata carries the name of its element
+		desired.XMLName = existing.XMLName
+		if *existing == desired {
+			return nil
+		}
+		dom, err := conn.DomainLookupByName(opt.Name)
+		if err != nil {
+			return err
+		}
+		log.Printf("Updating the metadata of domain [%s] ...", opt.Name)
+		return setInstanceMetadata(conn, dom, desired)
+	}
+}
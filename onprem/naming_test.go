@@ -0,0 +1,93 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testOwner = InstanceOwner{
+	UID:       "3f2c1a9e-7b4d-4e21-9c3a-5d6e7f809a1b",
+	Namespace: "default",
+	Name:      "onpremsample",
+	ClusterID: "prod",
+}
+
+func TestCreateInstanceName(t *testing.T) {
+	assert.Equal(t, "prod-default-onpremsample", CreateInstanceName("", &testOwner))
+	assert.Equal(t, "default-onpremsample", CreateInstanceName("{namespace}-{name}", &testOwner))
+	assert.Equal(t, testOwner.UID, CreateInstanceName("{uid}", &testOwner))
+	// an empty cluster ID does not leave a dangling separator
+	owner := testOwner
+	owner.ClusterID = ""
+	assert.Equal(t, "default-onpremsample", CreateInstanceName("", &owner))
+	// invalid characters are replaced
+	owner.Name = "my.sample_VSI"
+	assert.Equal(t, "default-my-sample-vsi", CreateInstanceName("", &owner))
+	// a scheme that resolves to nothing falls back to the UID
+	assert.Equal(t, testOwner.UID, CreateInstanceName("{cluster}", &owner))
+}
+
+func TestCreateInstanceNameTooLong(t *testing.T) {
+	owner := testOwner
+	owner.Name = strings.Repeat("a", 80)
+	name := CreateInstanceName("", &owner)
+	assert.Len(t, name, maxInstanceNameLength)
+	assert.True(t, strings.HasSuffix(name, "-3f2c1a9e"))
+}
+
+func TestCreateUniqueInstanceName(t *testing.T) {
+	assert.Equal(t, "prod-default-onpremsample-3f2c1a9e", CreateUniqueInstanceName("prod-default-onpremsample", testOwner.UID))
+}
+
+func TestInstanceMetadata(t *testing.T) {
+	opt := &InstanceOptions{Name: "prod-default-onpremsample", Owner: testOwner, OperatorVersion: "1.2.3"}
+	metadataXML, err := XMLMarshall(createInstanceMetadata(opt))
+	require.NoError(t, err)
+	assert.True(t, isManagedDomainXML(metadataXML))
+
+	metadata, err := parseInstanceMetadata(metadataXML)
+	require.NoError(t, err)
+	assert.Equal(t, CreateInstanceHash(opt), metadata.Hash)
+	assert.Equal(t, testOwner.UID, metadata.UID)
+	assert.Equal(t, "default", metadata.Namespace)
+	assert.Equal(t, "onpremsample", metadata.Name)
+	assert.Equal(t, "prod", metadata.ClusterID)
+	assert.Equal(t, "1.2.3", metadata.OperatorVersion)
+}
+
+func TestParseLegacyInstanceMetadata(t *testing.T) {
+	// metadata written by previous versions of the operator only carries the hash
+	metadata, err := parseInstanceMetadata(`<hpcr:instance xmlns:hpcr="https://github.com/ibm-hyper-protect/k8s-operator-hpcr"><hpcr:hash>abc</hpcr:hash></hpcr:instance>`)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", metadata.Hash)
+	assert.Empty(t, metadata.UID)
+}
+
+func TestInstanceMetadataUnchanged(t *testing.T) {
+	opt := &InstanceOptions{Name: "prod-default-onpremsample", Owner: testOwner, OperatorVersion: "1.2.3"}
+	metadataXML, err := XMLMarshall(createInstanceMetadata(opt))
+	require.NoError(t, err)
+	existing, err := parseInstanceMetadata(metadataXML)
+	require.NoError(t, err)
+
+	desired := createInstanceMetadata(opt)
+	desired.XMLName = existing.XMLName
+	assert.Equal(t, *existing, desired)
+}
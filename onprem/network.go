@@ -159,10 +159,35 @@ func networkRefCustomResourceToNetworks(res *NetworkRefCustomResource) string {
 // NetworkRefCustomResourceToNetworks converts from an array of NetworkRefCustomResource to an array of attached disks
 var NetworkRefCustomResourceToNetworks = A.Map(networkRefCustomResourceToNetworks)
 
+// NetworkRefCustomResourceToStaticIPs selects the static IP addresses reserved for a VSI and served via DHCP, keyed by
+// network name
+func NetworkRefCustomResourceToStaticIPs(name string) func([]*NetworkRefCustomResource) map[string]string {
+	return func(refs []*NetworkRefCustomResource) map[string]string {
+		result := make(map[string]string)
+		for _, ref := range refs {
+			// the VSI configures the address itself, the network might not serve DHCP
+			if isStaticAddressing(ref) {
+				continue
+			}
+			for _, reservation := range ref.Spec.StaticIPs {
+				if reservation.Name == name {
+					result[ref.Spec.NetworkName] = reservation.IP
+				}
+			}
+		}
+		return result
+	}
+}
+
+// GetInterfaceMacAddress returns the deterministic mac address of the interface of a VSI on a network
+func GetInterfaceMacAddress(prefix, networkName string) string {
+	return CreateMacAddressFromHash(fmt.Sprintf("%s-%s", prefix, networkName))
+}
+
 func createNetworkXML(prefix string) func(networkName string) libvirtxml.DomainInterface {
 	return func(networkName string) libvirtxml.DomainInterface {
 		// produce a mac address
-		macAddr := CreateMacAddressFromHash(fmt.Sprintf("%s-%s", prefix, networkName))
+		macAddr := GetInterfaceMacAddress(prefix, networkName)
 
 		log.Printf("Defining domain interface on network [%s], mac [%s]", networkName, macAddr)
 		return libvirtxml.DomainInterface{
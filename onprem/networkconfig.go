@@ -0,0 +1,192 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"libvirt.org/go/libvirtxml"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	// ErrInvalidNetworkConfig signals a network config that cannot be applied to the interfaces of VSIs
+	ErrInvalidNetworkConfig = errors.New("invalid network config")
+)
+
+type InterfaceConfig struct {
+	// static IP addresses in CIDR notation, the interface uses DHCP if empty
+	Addresses []string
+	// default gateway
+	Gateway string
+	// IP addresses of the DNS servers
+	Nameservers []string
+}
+
+type networkConfigRoute struct {
+	To  string `json:"to"`
+	Via string `json:"via"`
+}
+
+type networkConfigNameservers struct {
+	Addresses []string `json:"addresses"`
+}
+
+type networkConfigMatch struct {
+	MACAddress string `json:"macaddress"`
+}
+
+type networkConfigEthernet struct {
+	Match       networkConfigMatch        `json:"match"`
+	DHCP4       bool                      `json:"dhcp4"`
+	Addresses   []string                  `json:"addresses,omitempty"`
+	Routes      []networkConfigRoute      `json:"routes,omitempty"`
+	Nameservers *networkConfigNameservers `json:"nameservers,omitempty"`
+}
+
+// networkConfig is the version 2 format of the cloud-init network configuration
+type networkConfig struct {
+	Version   int                              `json:"version"`
+	Ethernets map[string]networkConfigEthernet `json:"ethernets"`
+}
+
+// getDefaultRoute returns the destination of the default route via a gateway
+func getDefaultRoute(gateway net.IP) string {
+	if gateway.To4() != nil {
+		return "0.0.0.0/0"
+	}
+	return "::/0"
+}
+
+// ValidateNetworkConfig checks that the network config of a network reference can be applied to the interfaces of VSIs,
+// reservations of a network with `Static` addressing must lie in its subnet instead of a DHCP range of the network
+func ValidateNetworkConfig(netXML *libvirtxml.Network, spec *NetworkConfigSpec, reservations []StaticIPReservation) error {
+	if spec == nil {
+		return ValidateStaticIPs(netXML, reservations)
+	}
+	for _, nameserver := range spec.Nameservers {
+		if net.ParseIP(nameserver) == nil {
+			return fmt.Errorf("%w: nameserver [%s] is not an IP address", ErrInvalidNetworkConfig, nameserver)
+		}
+	}
+	addressing := BoxAddressing(spec.Addressing)
+	switch addressing {
+	case AddressingDHCP:
+		return ValidateStaticIPs(netXML, reservations)
+	case AddressingStatic:
+		_, subnet, err := net.ParseCIDR(spec.Subnet)
+		if err != nil {
+			return fmt.Errorf("%w: subnet [%s] is not in CIDR notation, cause: [%v]", ErrInvalidNetworkConfig, spec.Subnet, err)
+		}
+		if len(spec.Gateway) > 0 {
+			gateway := net.ParseIP(spec.Gateway)
+			if gateway == nil || !subnet.Contains(gateway) {
+				return fmt.Errorf("%w: gateway [%s] is not part of subnet [%s]", ErrInvalidNetworkConfig, spec.Gateway, spec.Subnet)
+			}
+		}
+		seen := make(map[string]string)
+		for _, reservation := range reservations {
+			if other, ok := seen[reservation.IP]; ok {
+				return fmt.Errorf("IP address [%s] is reserved for both [%s] and [%s]", reservation.IP, other, reservation.Name)
+			}
+			seen[reservation.IP] = reservation.Name
+			ip := net.ParseIP(reservation.IP)
+			if ip == nil || !subnet.Contains(ip) {
+				return fmt.Errorf("%w: IP address [%s] is not part of subnet [%s]", ErrInvalidNetworkConfig, reservation.IP, spec.Subnet)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown addressing [%s]", ErrInvalidNetworkConfig, addressing)
+	}
+}
+
+// isStaticAddressing checks if the VSIs attached to a network reference configure their IP address themselves
+func isStaticAddressing(ref *NetworkRefCustomResource) bool {
+	return ref.Spec.NetworkConfig != nil && BoxAddressing(ref.Spec.NetworkConfig.Addressing) == AddressingStatic
+}
+
+// NetworkRefCustomResourceToInterfaceConfigs selects the cloud-init configuration of the interfaces of a VSI, keyed by
+// network name. Only network references with a network config contribute.
+func NetworkRefCustomResourceToInterfaceConfigs(name string) func([]*NetworkRefCustomResource) (map[string]*InterfaceConfig, error) {
+	return func(refs []*NetworkRefCustomResource) (map[string]*InterfaceConfig, error) {
+		result := make(map[string]*InterfaceConfig)
+		for _, ref := range refs {
+			spec := ref.Spec.NetworkConfig
+			if spec == nil {
+				continue
+			}
+			cfg := &InterfaceConfig{
+				Nameservers: spec.Nameservers,
+			}
+			if isStaticAddressing(ref) {
+				_, subnet, err := net.ParseCIDR(spec.Subnet)
+				if err != nil {
+					return nil, fmt.Errorf("%w: subnet [%s] of network [%s] is not in CIDR notation", ErrInvalidNetworkConfig, spec.Subnet, ref.Spec.NetworkName)
+				}
+				ones, _ := subnet.Mask.Size()
+				for _, reservation := range ref.Spec.StaticIPs {
+					if reservation.Name == name {
+						cfg.Addresses = append(cfg.Addresses, fmt.Sprintf("%s/%d", reservation.IP, ones))
+					}
+				}
+				// without DHCP the interface would not get any address
+				if len(cfg.Addresses) == 0 {
+					return nil, fmt.Errorf("%w: network [%s] uses static addressing but reserves no IP address for [%s]", ErrInvalidNetworkConfig, ref.Spec.NetworkName, name)
+				}
+				cfg.Gateway = spec.Gateway
+			}
+			result[ref.Spec.NetworkName] = cfg
+		}
+		return result, nil
+	}
+}
+
+// createNetworkConfig produces the version 2 network-config document of an instance, the result is nil if none of
+// the networks of the instance carries an interface config, so cloud-init falls back to its default configuration
+func createNetworkConfig(opt *InstanceOptions) ([]byte, error) {
+	if len(opt.InterfaceConfigs) == 0 {
+		return nil, nil
+	}
+	doc := networkConfig{
+		Version:   2,
+		Ethernets: make(map[string]networkConfigEthernet),
+	}
+	// interface configs only exist for network references, so the instance is not attached to the default network
+	for idx, network := range opt.Networks {
+		ethernet := networkConfigEthernet{
+			Match: networkConfigMatch{
+				MACAddress: GetInterfaceMacAddress(opt.Name, network),
+			},
+		}
+		cfg, ok := opt.InterfaceConfigs[network]
+		if !ok || len(cfg.Addresses) == 0 {
+			ethernet.DHCP4 = true
+		}
+		if ok {
+			ethernet.Addresses = cfg.Addresses
+			if gateway := net.ParseIP(cfg.Gateway); gateway != nil {
+				ethernet.Routes = []networkConfigRoute{{To: getDefaultRoute(gateway), Via: cfg.Gateway}}
+			}
+			if len(cfg.Nameservers) > 0 {
+				ethernet.Nameservers = &networkConfigNameservers{Addresses: cfg.Nameservers}
+			}
+		}
+		doc.Ethernets[fmt.Sprintf("nic%d", idx)] = ethernet
+	}
+	return yaml.Marshal(doc)
+}
@@ -0,0 +1,136 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"libvirt.org/go/libvirtxml"
+	"sigs.k8s.io/yaml"
+)
+
+func createTestNetworkRef(networkName string, spec *NetworkConfigSpec, reservations ...StaticIPReservation) *NetworkRefCustomResource {
+	ref := &NetworkRefCustomResource{}
+	ref.Spec.NetworkName = networkName
+	ref.Spec.NetworkConfig = spec
+	ref.Spec.StaticIPs = reservations
+	return ref
+}
+
+func TestValidateNetworkConfig(t *testing.T) {
+	// a bridged network without DHCP
+	bridged := &libvirtxml.Network{Name: "bridged"}
+
+	static := &NetworkConfigSpec{
+		Addressing:  AddressingStatic,
+		Subnet:      "10.0.0.0/24",
+		Gateway:     "10.0.0.1",
+		Nameservers: []string{"10.0.0.2"},
+	}
+	assert.NoError(t, ValidateNetworkConfig(bridged, static, []StaticIPReservation{{Name: "vsi", IP: "10.0.0.10"}}))
+	// outside of the subnet
+	assert.ErrorIs(t, ValidateNetworkConfig(bridged, static, []StaticIPReservation{{Name: "vsi", IP: "10.0.1.10"}}), ErrInvalidNetworkConfig)
+	// duplicate reservation
+	assert.Error(t, ValidateNetworkConfig(bridged, static, []StaticIPReservation{{Name: "vsi", IP: "10.0.0.10"}, {Name: "other", IP: "10.0.0.10"}}))
+	// invalid subnet and gateway
+	assert.ErrorIs(t, ValidateNetworkConfig(bridged, &NetworkConfigSpec{Addressing: AddressingStatic}, nil), ErrInvalidNetworkConfig)
+	assert.ErrorIs(t, ValidateNetworkConfig(bridged, &NetworkConfigSpec{Addressing: AddressingStatic, Subnet: "10.0.0.0/24", Gateway: "10.0.1.1"}, nil), ErrInvalidNetworkConfig)
+	// invalid nameserver and addressing
+	assert.ErrorIs(t, ValidateNetworkConfig(bridged, &NetworkConfigSpec{Nameservers: []string{"dns"}}, nil), ErrInvalidNetworkConfig)
+	assert.ErrorIs(t, ValidateNetworkConfig(bridged, &NetworkConfigSpec{Addressing: "Manual"}, nil), ErrInvalidNetworkConfig)
+	// DHCP reservations must be served by the network
+	assert.Error(t, ValidateNetworkConfig(bridged, &NetworkConfigSpec{}, []StaticIPReservation{{Name: "vsi", IP: "10.0.0.10"}}))
+	assert.NoError(t, ValidateNetworkConfig(createTestNetwork(), nil, []StaticIPReservation{{Name: "vsi", IP: "192.168.122.20"}}))
+}
+
+func TestNetworkRefCustomResourceToInterfaceConfigs(t *testing.T) {
+	refs := []*NetworkRefCustomResource{
+		createTestNetworkRef("default", nil, StaticIPReservation{Name: "vsi", IP: "192.168.122.20"}),
+		createTestNetworkRef("dhcp", &NetworkConfigSpec{Nameservers: []string{"10.1.0.2"}}),
+		createTestNetworkRef("bridged", &NetworkConfigSpec{Addressing: AddressingStatic, Subnet: "10.0.0.0/24", Gateway: "10.0.0.1"},
+			StaticIPReservation{Name: "vsi", IP: "10.0.0.10"},
+			StaticIPReservation{Name: "other", IP: "10.0.0.11"}),
+	}
+
+	configs, err := NetworkRefCustomResourceToInterfaceConfigs("vsi")(refs)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]*InterfaceConfig{
+		"dhcp":    {Nameservers: []string{"10.1.0.2"}},
+		"bridged": {Addresses: []string{"10.0.0.10/24"}, Gateway: "10.0.0.1"},
+	}, configs)
+
+	// statically addressed networks are not served via DHCP
+	assert.Equal(t, map[string]string{"default": "192.168.122.20"}, NetworkRefCustomResourceToStaticIPs("vsi")(refs))
+
+	// a VSI without reservation would not get an address
+	_, err = NetworkRefCustomResourceToInterfaceConfigs("unknown")(refs)
+	assert.ErrorIs(t, err, ErrInvalidNetworkConfig)
+}
+
+func TestCreateNetworkConfig(t *testing.T) {
+	opt := &InstanceOptions{
+		Name:     "vsi",
+		Networks: []string{"default", "bridged"},
+	}
+	// no network config without interface configs
+	data, err := createNetworkConfig(opt)
+	require.NoError(t, err)
+	assert.Nil(t, data)
+
+	opt.InterfaceConfigs = map[string]*InterfaceConfig{
+		"bridged": {Addresses: []string{"10.0.0.10/24"}, Gateway: "10.0.0.1", Nameservers: []string{"10.0.0.2"}},
+	}
+	data, err = createNetworkConfig(opt)
+	require.NoError(t, err)
+
+	var doc networkConfig
+	require.NoError(t, yaml.Unmarshal(data, &doc))
+
+	assert.Equal(t, 2, doc.Version)
+	assert.Equal(t, networkConfigEthernet{
+		Match: networkConfigMatch{MACAddress: GetInterfaceMacAddress("vsi", "default")},
+		DHCP4: true,
+	}, doc.Ethernets["nic0"])
+	assert.Equal(t, networkConfigEthernet{
+		Match:       networkConfigMatch{MACAddress: GetInterfaceMacAddress("vsi", "bridged")},
+		Addresses:   []string{"10.0.0.10/24"},
+		Routes:      []networkConfigRoute{{To: "0.0.0.0/0", Via: "10.0.0.1"}},
+		Nameservers: &networkConfigNameservers{Addresses: []string{"10.0.0.2"}},
+	}, doc.Ethernets["nic1"])
+}
+
+func TestCreateMetaData(t *testing.T) {
+	opt := &InstanceOptions{
+		Name:  "default-ns-vsi",
+		Owner: InstanceOwner{UID: "6d997109-6b44-40eb-8d88-8bf7fc90bfb5"},
+	}
+	data, err := createMetaData(opt)
+	require.NoError(t, err)
+	assert.Equal(t, "instance-id: 6d997109-6b44-40eb-8d88-8bf7fc90bfb5\nlocal-hostname: default-ns-vsi\n", string(data))
+
+	opt.PublicKeys = []string{"ssh-ed25519 AAAA"}
+	data, err = createMetaData(opt)
+	require.NoError(t, err)
+
+	var metaData cloudInitMetaData
+	require.NoError(t, yaml.Unmarshal(data, &metaData))
+	assert.Equal(t, opt.PublicKeys, metaData.PublicKeys)
+
+	// the public keys change the hash, the instance identifier does not
+	assert.NotEqual(t, CreateInstanceHash(opt), CreateInstanceHash(&InstanceOptions{Name: opt.Name}))
+	assert.Equal(t, CreateInstanceHash(&InstanceOptions{Name: opt.Name, Owner: opt.Owner}), CreateInstanceHash(&InstanceOptions{Name: opt.Name}))
+}
@@ -0,0 +1,261 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/digitalocean/go-libvirt"
+	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+)
+
+// DomainPowerState is the actual power state of a domain as reported by libvirt
+type DomainPowerState struct {
+	// name of the state of the domain
+	State string `json:"state"`
+	// name of the reason for the state
+	Reason string `json:"reason"`
+	// libvirt code of the reason for the state
+	ReasonCode int32 `json:"reasonCode"`
+}
+
+const (
+	// names of the libvirt domain states
+	DomainStateNoState      = "NoState"
+	DomainStateRunning      = "Running"
+	DomainStateBlocked      = "Blocked"
+	DomainStatePaused       = "Paused"
+	DomainStateShuttingDown = "ShuttingDown"
+	DomainStateShutoff      = "Shutoff"
+	DomainStateCrashed      = "Crashed"
+	DomainStatePMSuspended  = "PMSuspended"
+)
+
+// operations that move a domain towards its requested power state
+const (
+	powerActionNone        = ""
+	powerActionStart       = "start"
+	powerActionStartPaused = "startPaused"
+	powerActionResume      = "resume"
+	powerActionWakeup      = "wakeup"
+	powerActionSuspend     = "suspend"
+	powerActionShutdown    = "shutdown"
+	powerActionDestroy     = "destroy"
+)
+
+var (
+	domainStateNames = map[libvirt.DomainState]string{
+		libvirt.DomainNostate:     DomainStateNoState,
+		libvirt.DomainRunning:     DomainStateRunning,
+		libvirt.DomainBlocked:     DomainStateBlocked,
+		libvirt.DomainPaused:      DomainStatePaused,
+		libvirt.DomainShutdown:    DomainStateShuttingDown,
+		libvirt.DomainShutoff:     DomainStateShutoff,
+		libvirt.DomainCrashed:     DomainStateCrashed,
+		libvirt.DomainPmsuspended: DomainStatePMSuspended,
+	}
+	// names of the reasons per domain state, from libvirt-domain.h
+	domainReasonNames = map[libvirt.DomainState]map[int32]string{
+		libvirt.DomainRunning: {
+			int32(libvirt.DomainRunningBooted):            "Booted",
+			int32(libvirt.DomainRunningMigrated):          "Migrated",
+			int32(libvirt.DomainRunningRestored):          "Restored",
+			int32(libvirt.DomainRunningFromSnapshot):      "FromSnapshot",
+			int32(libvirt.DomainRunningUnpaused):          "Unpaused",
+			int32(libvirt.DomainRunningMigrationCanceled): "MigrationCanceled",
+			int32(libvirt.DomainRunningSaveCanceled):      "SaveCanceled",
+			int32(libvirt.DomainRunningWakeup):            "Wakeup",
+			int32(libvirt.DomainRunningCrashed):           "Crashed",
+			int32(libvirt.DomainRunningPostcopy):          "Postcopy",
+		},
+		libvirt.DomainPaused: {
+			int32(libvirt.DomainPausedUser):           "User",
+			int32(libvirt.DomainPausedMigration):      "Migration",
+			int32(libvirt.DomainPausedSave):           "Save",
+			int32(libvirt.DomainPausedDump):           "Dump",
+			int32(libvirt.DomainPausedIoerror):        "IOError",
+			int32(libvirt.DomainPausedWatchdog):       "Watchdog",
+			int32(libvirt.DomainPausedFromSnapshot):   "FromSnapshot",
+			int32(libvirt.DomainPausedShuttingDown):   "ShuttingDown",
+			int32(libvirt.DomainPausedSnapshot):       "Snapshot",
+			int32(libvirt.DomainPausedCrashed):        "Crashed",
+			int32(libvirt.DomainPausedStartingUp):     "StartingUp",
+			int32(libvirt.DomainPausedPostcopy):       "Postcopy",
+			int32(libvirt.DomainPausedPostcopyFailed): "PostcopyFailed",
+		},
+		libvirt.DomainShutdown: {
+			int32(libvirt.DomainShutdownUser): "User",
+		},
+		libvirt.DomainShutoff: {
+			int32(libvirt.DomainShutoffShutdown):     "Shutdown",
+			int32(libvirt.DomainShutoffDestroyed):    "Destroyed",
+			int32(libvirt.DomainShutoffCrashed):      "Crashed",
+			int32(libvirt.DomainShutoffMigrated):     "Migrated",
+			int32(libvirt.DomainShutoffSaved):        "Saved",
+			int32(libvirt.DomainShutoffFailed):       "Failed",
+			int32(libvirt.DomainShutoffFromSnapshot): "FromSnapshot",
+			int32(libvirt.DomainShutoffDaemon):       "Daemon",
+		},
+		libvirt.DomainCrashed: {
+			int32(libvirt.DomainCrashedPanicked): "Panicked",
+		},
+	}
+)
+
+// CreateDomainPowerState translates the state and reason codes returned by libvirt
+func CreateDomainPowerState(state, reason int32) *DomainPowerState {
+	name, ok := domainStateNames[libvirt.DomainState(state)]
+	if !ok {
+		name = fmt.Sprintf("Unknown(%d)", state)
+	}
+	reasonName, ok := domainReasonNames[libvirt.DomainState(state)][reason]
+	if !ok {
+		reasonName = "Unknown"
+	}
+	return &DomainPowerState{
+		State:      name,
+		Reason:     reasonName,
+		ReasonCode: reason,
+	}
+}
+
+// IsPowerStateReached tests if the actual state of a domain matches the requested power state
+func IsPowerStateReached(powerState string, actual *DomainPowerState) bool {
+	switch BoxPowerState(powerState) {
+	case PowerStateStopped:
+		return actual.State == DomainStateShutoff
+	case PowerStatePaused:
+		return actual.State == DomainStatePaused
+	default:
+		return actual.State == DomainStateRunning || actual.State == DomainStateBlocked
+	}
+}
+
+// nextPowerAction selects the operation that moves a domain from its state towards the requested power state. Some
+// transitions take more than one operation, e.g. a paused domain is resumed before it can be shut down gracefully.
+func nextPowerAction(state libvirt.DomainState, powerState string) string {
+	// states that need an operation regardless of the requested power state
+	switch state {
+	case libvirt.DomainCrashed:
+		return powerActionDestroy
+	case libvirt.DomainPmsuspended:
+		return powerActionWakeup
+	}
+	switch BoxPowerState(powerState) {
+	case PowerStateStopped:
+		switch state {
+		case libvirt.DomainRunning, libvirt.DomainBlocked:
+			return powerActionShutdown
+		case libvirt.DomainPaused:
+			return powerActionResume
+		}
+	case PowerStatePaused:
+		switch state {
+		case libvirt.DomainRunning, libvirt.DomainBlocked:
+			return powerActionSuspend
+		case libvirt.DomainShutoff:
+			return powerActionStartPaused
+		}
+	default:
+		switch state {
+		case libvirt.DomainPaused:
+			return powerActionResume
+		case libvirt.DomainShutoff:
+			return powerActionStart
+		}
+	}
+	// nothing to do or wait for a transition in progress
+	return powerActionNone
+}
+
+// GetDomainPowerState returns the actual power state of a domain
+func GetDomainPowerState(client *LivirtClient) func(name string) (*DomainPowerState, error) {
+	conn := client.LibVirt
+
+	return func(name string) (*DomainPowerState, error) {
+		dom, err := conn.DomainLookupByName(name)
+		if err != nil {
+			return nil, err
+		}
+		state, reason, err := conn.DomainGetState(dom, 0)
+		if err != nil {
+			return nil, err
+		}
+		return CreateDomainPowerState(state, reason), nil
+	}
+}
+
+// ApplyPowerState moves a domain towards the requested power state. It returns the actual power state after the
+// operation and if an operation has been executed. The caller repeats the call until the requested power state
+// has been reached.
+func ApplyPowerState(client *LivirtClient) func(name, powerState string) (*DomainPowerState, bool, error) {
+	conn := client.LibVirt
+	getPowerState := GetDomainPowerState(client)
+
+	return func(name, powerState string) (*DomainPowerState, bool, error) {
+		// log this config
+		defer CM.EntryExit(fmt.Sprintf("ApplyPowerState(%s, %s)", name, powerState))()
+
+		dom, err := conn.DomainLookupByName(name)
+		if err != nil {
+			return nil, false, err
+		}
+		state, _, err := conn.DomainGetState(dom, 0)
+		if err != nil {
+			return nil, false, err
+		}
+		// a stopped VSI must not come back when the hypervisor host restarts
+		autostart := int32(1)
+		if BoxPowerState(powerState) == PowerStateStopped {
+			autostart = 0
+		}
+		current, err := conn.DomainGetAutostart(dom)
+		if err == nil && current != autostart {
+			err = conn.DomainSetAutostart(dom, autostart)
+		}
+		if err != nil {
+			log.Printf("Unable to set autostart of domain [%s] to [%d], cause: [%v]", name, autostart, err)
+		}
+		action := nextPowerAction(libvirt.DomainState(state), powerState)
+		if action == powerActionNone {
+			actual, err := getPowerState(name)
+			return actual, false, err
+		}
+		log.Printf("Domain [%s] is in state [%d], executing [%s] towards power state [%s] ...", name, state, action, BoxPowerState(powerState))
+		switch action {
+		case powerActionStart:
+			err = conn.DomainCreate(dom)
+		case powerActionStartPaused:
+			_, err = conn.DomainCreateWithFlags(dom, uint32(libvirt.DomainStartPaused))
+		case powerActionResume:
+			err = conn.DomainResume(dom)
+		case powerActionWakeup:
+			err = conn.DomainPmWakeup(dom, 0)
+		case powerActionSuspend:
+			err = conn.DomainSuspend(dom)
+		case powerActionShutdown:
+			// graceful, the guest decides when to power off
+			err = conn.DomainShutdown(dom)
+		case powerActionDestroy:
+			err = conn.DomainDestroy(dom)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		actual, err := getPowerState(name)
+		return actual, true, err
+	}
+}
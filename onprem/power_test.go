@@ -0,0 +1,75 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"testing"
+
+	"github.com/digitalocean/go-libvirt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateDomainPowerState(t *testing.T) {
+	state := CreateDomainPowerState(int32(libvirt.DomainShutoff), int32(libvirt.DomainShutoffCrashed))
+	assert.Equal(t, &DomainPowerState{State: DomainStateShutoff, Reason: "Crashed", ReasonCode: 3}, state)
+
+	state = CreateDomainPowerState(int32(libvirt.DomainRunning), int32(libvirt.DomainRunningBooted))
+	assert.Equal(t, DomainStateRunning, state.State)
+	assert.Equal(t, "Booted", state.Reason)
+
+	unknown := CreateDomainPowerState(42, 7)
+	assert.Equal(t, "Unknown(42)", unknown.State)
+	assert.Equal(t, "Unknown", unknown.Reason)
+	assert.Equal(t, int32(7), unknown.ReasonCode)
+}
+
+func TestIsPowerStateReached(t *testing.T) {
+	running := &DomainPowerState{State: DomainStateRunning}
+	blocked := &DomainPowerState{State: DomainStateBlocked}
+	paused := &DomainPowerState{State: DomainStatePaused}
+	shutoff := &DomainPowerState{State: DomainStateShutoff}
+
+	assert.True(t, IsPowerStateReached("", running))
+	assert.True(t, IsPowerStateReached(PowerStateRunning, blocked))
+	assert.False(t, IsPowerStateReached(PowerStateRunning, paused))
+	assert.True(t, IsPowerStateReached(PowerStateStopped, shutoff))
+	assert.False(t, IsPowerStateReached(PowerStateStopped, running))
+	assert.True(t, IsPowerStateReached(PowerStatePaused, paused))
+	assert.False(t, IsPowerStateReached(PowerStatePaused, shutoff))
+}
+
+func TestNextPowerAction(t *testing.T) {
+	// running
+	assert.Equal(t, powerActionNone, nextPowerAction(libvirt.DomainRunning, PowerStateRunning))
+	assert.Equal(t, powerActionStart, nextPowerAction(libvirt.DomainShutoff, PowerStateRunning))
+	assert.Equal(t, powerActionResume, nextPowerAction(libvirt.DomainPaused, ""))
+	assert.Equal(t, powerActionNone, nextPowerAction(libvirt.DomainShutdown, PowerStateRunning))
+	// stopped
+	assert.Equal(t, powerActionShutdown, nextPowerAction(libvirt.DomainRunning, PowerStateStopped))
+	assert.Equal(t, powerActionResume, nextPowerAction(libvirt.DomainPaused, PowerStateStopped))
+	assert.Equal(t, powerActionNone, nextPowerAction(libvirt.DomainShutoff, PowerStateStopped))
+	// paused
+	assert.Equal(t, powerActionSuspend, nextPowerAction(libvirt.DomainRunning, PowerStatePaused))
+	assert.Equal(t, powerActionStartPaused, nextPowerAction(libvirt.DomainShutoff, PowerStatePaused))
+	assert.Equal(t, powerActionNone, nextPowerAction(libvirt.DomainPaused, PowerStatePaused))
+	// independent of the power state
+	assert.Equal(t, powerActionDestroy, nextPowerAction(libvirt.DomainCrashed, PowerStateRunning))
+	assert.Equal(t, powerActionWakeup, nextPowerAction(libvirt.DomainPmsuspended, PowerStatePaused))
+}
+
+func TestBoxPowerState(t *testing.T) {
+	assert.Equal(t, PowerStateRunning, BoxPowerState(""))
+	assert.Equal(t, PowerStateStopped, BoxPowerState(PowerStateStopped))
+}
@@ -0,0 +1,229 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"strings"
+
+	A "github.com/IBM/fp-go/array"
+	libvirt "github.com/digitalocean/go-libvirt"
+	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+	"libvirt.org/go/libvirtxml"
+)
+
+const (
+	// minimum version of libvirt, encoded as major * 1,000,000 + minor * 1,000 + release
+	MinLibvirtVersion = uint64(6_000_000)
+	// architecture of HPCR guests
+	guestArch = "s390x"
+	// OS type of HPCR guests
+	guestOSType = "hvm"
+
+	PreflightCheckLibvirtVersion          = "libvirtVersion"
+	PreflightCheckGuestSupport            = "guestSupport"
+	PreflightCheckProtectedVirtualization = "protectedVirtualization"
+	PreflightCheckFreeResources           = "freeResources"
+)
+
+// PreflightCheck is the result of a single check of a hypervisor host
+type PreflightCheck struct {
+	// name of the check
+	Name string `json:"name"`
+	// the host passed the check
+	Passed bool `json:"passed"`
+	// details of the result
+	Message string `json:"message"`
+	// the result is reported only, a failure does not disqualify the host
+	Informational bool `json:"informational,omitempty"`
+}
+
+// PreflightOptions lists the libvirt objects a hypervisor host must provide
+type PreflightOptions struct {
+	// names of the storage pools that must be active
+	StoragePools []string
+	// names of the networks that must be active
+	Networks []string
+}
+
+// HostFacts is the information about a hypervisor host the checks are evaluated on
+type HostFacts struct {
+	// version of libvirt
+	LibVersion uint64
+	// capabilities of the host
+	Caps *libvirtxml.Caps
+	// capabilities of s390x KVM domains, nil if the host cannot provide them
+	DomainCaps *libvirtxml.DomainCaps
+	// names of the active networks
+	Networks map[string]bool
+	// resources of the host, including the free space of the active storage pools
+	Info *HostInfo
+}
+
+func formatLibvirtVersion(version uint64) string {
+	return fmt.Sprintf("%d.%d.%d", version/1_000_000, (version/1_000)%1_000, version%1_000)
+}
+
+func createPreflightCheck(name string, passed bool, format string, args ...any) PreflightCheck {
+	return PreflightCheck{Name: name, Passed: passed, Message: fmt.Sprintf(format, args...)}
+}
+
+func getStoragePoolCheckName(name string) string {
+	return fmt.Sprintf("storagePool[%s]", name)
+}
+
+func getNetworkCheckName(name string) string {
+	return fmt.Sprintf("network[%s]", name)
+}
+
+// hasGuestSupport tests if the host can run s390x guests with hardware virtualization
+func hasGuestSupport(caps *libvirtxml.Caps) bool {
+	if caps == nil {
+		return false
+	}
+	for _, guest := range caps.Guests {
+		if guest.OSType == guestOSType && guest.Arch.Name == guestArch {
+			return true
+		}
+	}
+	return false
+}
+
+// hasProtectedVirtualization tests if the host supports Secure Execution guests
+func hasProtectedVirtualization(domainCaps *libvirtxml.DomainCaps) bool {
+	return domainCaps != nil && domainCaps.Features != nil && domainCaps.Features.S390PV != nil && domainCaps.Features.S390PV.Supported == "yes"
+}
+
+// checkFreeResources tests if the host has room for at least one more VSI. A full host is still able to run its VSIs
+// and to stage images, the placement checks the free resources per VSI, so the check is informational.
+func checkFreeResources(info *HostInfo, storagePools []string) PreflightCheck {
+	var missing []string
+	if info.FreeMemory < InstanceMemory {
+		missing = append(missing, fmt.Sprintf("[%d] of [%d] bytes of memory free", info.FreeMemory, InstanceMemory))
+	}
+	if cpus := info.CPUs - info.AllocatedCPUs; cpus < InstanceCPUs {
+		missing = append(missing, fmt.Sprintf("[%d] of [%d] CPUs available", cpus, InstanceCPUs))
+	}
+	for _, pool := range storagePools {
+		if available, ok := info.StoragePools[pool]; ok && available < InstanceStorage {
+			missing = append(missing, fmt.Sprintf("[%d] of [%d] bytes free in storage pool [%s]", available, InstanceStorage, pool))
+		}
+	}
+	var check PreflightCheck
+	if A.IsNonEmpty(missing) {
+		check = createPreflightCheck(PreflightCheckFreeResources, false, "insufficient resources for a VSI, %s", strings.Join(missing, ", "))
+	} else {
+		check = createPreflightCheck(PreflightCheckFreeResources, true, "[%d] bytes of memory and [%d] CPUs available", info.FreeMemory, info.CPUs-info.AllocatedCPUs)
+	}
+	check.Informational = true
+	return check
+}
+
+// EvaluatePreflight checks if a hypervisor host is able to run VSIs
+func EvaluatePreflight(facts *HostFacts, opt *PreflightOptions) []PreflightCheck {
+	var result []PreflightCheck
+	// libvirt
+	result = append(result, createPreflightCheck(PreflightCheckLibvirtVersion, facts.LibVersion >= MinLibvirtVersion,
+		"libvirt [%s], required [%s]", formatLibvirtVersion(facts.LibVersion), formatLibvirtVersion(MinLibvirtVersion)))
+	// guests
+	if hasGuestSupport(facts.Caps) {
+		result = append(result, createPreflightCheck(PreflightCheckGuestSupport, true, "%s/%s guests supported", guestOSType, guestArch))
+	} else {
+		result = append(result, createPreflightCheck(PreflightCheckGuestSupport, false, "could not find any guests for architecture type %s/%s", guestOSType, guestArch))
+	}
+	if hasProtectedVirtualization(facts.DomainCaps) {
+		result = append(result, createPreflightCheck(PreflightCheckProtectedVirtualization, true, "prot-virt available"))
+	} else {
+		result = append(result, createPreflightCheck(PreflightCheckProtectedVirtualization, false, "prot-virt is not available, Secure Execution guests cannot be started"))
+	}
+	// libvirt objects
+	for _, pool := range opt.StoragePools {
+		_, ok := facts.Info.StoragePools[pool]
+		result = append(result, createPreflightCheck(getStoragePoolCheckName(pool), ok, "storage pool [%s] active: [%t]", pool, ok))
+	}
+	for _, network := range opt.Networks {
+		ok := facts.Networks[network]
+		result = append(result, createPreflightCheck(getNetworkCheckName(network), ok, "network [%s] active: [%t]", network, ok))
+	}
+	// resources
+	return append(result, checkFreeResources(facts.Info, opt.StoragePools))
+}
+
+// GetFailedPreflightChecks returns the checks a host did not pass, informational checks never fail
+func GetFailedPreflightChecks(checks []PreflightCheck) []PreflightCheck {
+	return A.Filter(func(check PreflightCheck) bool {
+		return !check.Passed && !check.Informational
+	})(checks)
+}
+
+// GetHostFacts collects the information about a hypervisor host the pre-flight checks are evaluated on
+func GetHostFacts(client *LivirtClient) func() (*HostFacts, error) {
+	conn := client.LibVirt
+	getHostInfo := GetHostInfo(client)
+
+	return func() (*HostFacts, error) {
+		defer CM.EntryExit("GetHostFacts")()
+
+		version, err := conn.ConnectGetLibVersion()
+		if err != nil {
+			return nil, err
+		}
+		capsXML, err := conn.Capabilities()
+		if err != nil {
+			return nil, err
+		}
+		var caps libvirtxml.Caps
+		if err := xml.Unmarshal(capsXML, &caps); err != nil {
+			return nil, err
+		}
+		facts := &HostFacts{
+			LibVersion: version,
+			Caps:       &caps,
+			Networks:   make(map[string]bool),
+		}
+		// the domain capabilities are not available on hosts that cannot run s390x KVM guests
+		facts.DomainCaps, err = getDomainCapabilities(conn)
+		if err != nil {
+			log.Printf("Unable to get the domain capabilities of the host, cause: [%v]", err)
+		}
+		networks, _, err := conn.ConnectListAllNetworks(NeedResults, libvirt.ConnectListNetworksActive)
+		if err != nil {
+			return nil, err
+		}
+		for _, network := range networks {
+			facts.Networks[network.Name] = true
+		}
+		facts.Info, err = getHostInfo()
+		if err != nil {
+			return nil, err
+		}
+		return facts, nil
+	}
+}
+
+// RunPreflight checks if a hypervisor host is able to run VSIs
+func RunPreflight(client *LivirtClient) func(opt *PreflightOptions) (*HostFacts, []PreflightCheck, error) {
+	getHostFacts := GetHostFacts(client)
+
+	return func(opt *PreflightOptions) (*HostFacts, []PreflightCheck, error) {
+		facts, err := getHostFacts()
+		if err != nil {
+			return nil, nil, err
+		}
+		return facts, EvaluatePreflight(facts, opt), nil
+	}
+}
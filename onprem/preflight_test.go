@@ -0,0 +1,109 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"libvirt.org/go/libvirtxml"
+)
+
+// createHostFacts produces the facts of a host that passes all checks
+func createHostFacts() *HostFacts {
+	return &HostFacts{
+		LibVersion: 9_000_000,
+		Caps: &libvirtxml.Caps{
+			Guests: []libvirtxml.CapsGuest{
+				{OSType: "hvm", Arch: libvirtxml.CapsGuestArch{Name: "s390x"}},
+			},
+		},
+		DomainCaps: &libvirtxml.DomainCaps{
+			Features: &libvirtxml.DomainCapsFeatures{
+				S390PV: &libvirtxml.DomainCapsFeatureS390PV{Supported: "yes"},
+			},
+		},
+		Networks: map[string]bool{"default": true},
+		Info: &HostInfo{
+			CPUs:         8,
+			Memory:       64 * 1024 * 1024 * 1024,
+			FreeMemory:   32 * 1024 * 1024 * 1024,
+			StoragePools: map[string]uint64{"default": 100 * 1024 * 1024 * 1024},
+		},
+	}
+}
+
+var defaultPreflightOptions = BoxPreflightOptions(nil)
+
+func findPreflightCheck(t *testing.T, checks []PreflightCheck, name string) PreflightCheck {
+	for _, check := range checks {
+		if check.Name == name {
+			return check
+		}
+	}
+	require.Failf(t, "missing check", "check [%s] not found", name)
+	return PreflightCheck{}
+}
+
+func TestPreflightPassed(t *testing.T) {
+	checks := EvaluatePreflight(createHostFacts(), defaultPreflightOptions)
+	assert.Len(t, checks, 6)
+	assert.Empty(t, GetFailedPreflightChecks(checks))
+}
+
+func TestPreflightNoS390xGuests(t *testing.T) {
+	facts := createHostFacts()
+	facts.Caps.Guests[0].Arch.Name = "x86_64"
+	facts.DomainCaps = nil
+
+	failed := GetFailedPreflightChecks(EvaluatePreflight(facts, defaultPreflightOptions))
+	require.Len(t, failed, 2)
+	assert.Equal(t, PreflightCheckGuestSupport, failed[0].Name)
+	assert.Equal(t, "could not find any guests for architecture type hvm/s390x", failed[0].Message)
+	assert.Equal(t, PreflightCheckProtectedVirtualization, failed[1].Name)
+}
+
+func TestPreflightOldLibvirt(t *testing.T) {
+	facts := createHostFacts()
+	facts.LibVersion = 5_010_000
+
+	check := findPreflightCheck(t, EvaluatePreflight(facts, defaultPreflightOptions), PreflightCheckLibvirtVersion)
+	assert.False(t, check.Passed)
+	assert.Equal(t, "libvirt [5.10.0], required [6.0.0]", check.Message)
+}
+
+func TestPreflightMissingObjects(t *testing.T) {
+	opt := &PreflightOptions{StoragePools: []string{"default", "images"}, Networks: []string{"default", "hpcr"}}
+
+	checks := EvaluatePreflight(createHostFacts(), opt)
+	assert.True(t, findPreflightCheck(t, checks, "storagePool[default]").Passed)
+	assert.False(t, findPreflightCheck(t, checks, "storagePool[images]").Passed)
+	assert.True(t, findPreflightCheck(t, checks, "network[default]").Passed)
+	assert.False(t, findPreflightCheck(t, checks, "network[hpcr]").Passed)
+}
+
+func TestPreflightInsufficientResources(t *testing.T) {
+	facts := createHostFacts()
+	facts.Info.AllocatedCPUs = 7
+	facts.Info.StoragePools["default"] = 1024
+
+	check := findPreflightCheck(t, EvaluatePreflight(facts, defaultPreflightOptions), PreflightCheckFreeResources)
+	assert.False(t, check.Passed)
+	assert.Contains(t, check.Message, "[1] of [2] CPUs available")
+	assert.Contains(t, check.Message, "bytes free in storage pool [default]")
+	// a full host still qualifies
+	assert.Empty(t, GetFailedPreflightChecks(EvaluatePreflight(facts, defaultPreflightOptions)))
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	A "github.com/IBM/fp-go/array"
+)
+
+var (
+	// ErrProbeFailed signals that a probe did not succeed
+	ErrProbeFailed = errors.New("probe failed")
+)
+
+// checkLogTokens validates that each token appears in one of the HPL lines of the console log
+func checkLogTokens(tokens []string, lines []string) error {
+	for _, token := range tokens {
+		found := A.Any(func(line string) bool {
+			return strings.Contains(line, token)
+		})(lines)
+		if !found {
+			return fmt.Errorf("%w: token [%s] not found in the console log", ErrProbeFailed, token)
+		}
+	}
+	return nil
+}
+
+// probeTCPSocket connects to the port of an address
+func probeTCPSocket(probe *TCPSocketProbeSpec, address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(address, strconv.Itoa(probe.Port)), timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// probeHTTPGet sends a GET request to an address
+func probeHTTPGet(probe *HTTPGetProbeSpec, address string, timeout time.Duration) error {
+	scheme := "http"
+	if strings.EqualFold(probe.Scheme, ProbeSchemeHTTPS) {
+		scheme = "https"
+	}
+	path := probe.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// workloads commonly use self signed certificates
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, // #nosec G402
+		},
+	}
+	resp, err := client.Get(fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(address, strconv.Itoa(probe.Port)), path))
+	if err != nil {
+		return err
+	}
+	defer safeClose(resp.Body)
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("unexpected status code [%d]", resp.StatusCode)
+	}
+	return nil
+}
+
+// probeTimeout returns the timeout of a single probe attempt, limited by the deadline of all probes
+func probeTimeout(timeout time.Duration, deadline time.Time) (time.Duration, error) {
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return 0, fmt.Errorf("%w: the time for probing the VSI is exhausted", ErrProbeFailed)
+	}
+	return min(timeout, remaining), nil
+}
+
+// probeAddresses succeeds if the check succeeds for one of the addresses before the deadline
+func probeAddresses(addresses []string, timeout time.Duration, deadline time.Time, check func(address string, timeout time.Duration) error) error {
+	if A.IsEmpty(addresses) {
+		return fmt.Errorf("%w: the VSI has no IP address", ErrProbeFailed)
+	}
+	var errs []string
+	for _, address := range addresses {
+		attemptTimeout, err := probeTimeout(timeout, deadline)
+		if err != nil {
+			errs = append(errs, err.Error())
+			break
+		}
+		err = check(address, attemptTimeout)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Sprintf("[%s]: %v", address, err))
+	}
+	return fmt.Errorf("%w: %s", ErrProbeFailed, strings.Join(errs, ", "))
+}
+
+// RunProbe executes a probe against the IP addresses of a VSI and the HPL lines of its console log, the network checks
+// fail once the deadline has passed
+func RunProbe(probe *ProbeSpec, addresses []string, lines []string, deadline time.Time) error {
+	if err := checkLogTokens(probe.LogTokens, lines); err != nil {
+		return err
+	}
+	timeout := time.Duration(BoxProbeTimeoutSeconds(probe.TimeoutSeconds)) * time.Second
+	if probe.TCPSocket != nil {
+		if err := probeAddresses(addresses, timeout, deadline, func(address string, timeout time.Duration) error {
+			return probeTCPSocket(probe.TCPSocket, address, timeout)
+		}); err != nil {
+			return err
+		}
+	}
+	if probe.HTTPGet != nil {
+		if err := probeAddresses(addresses, timeout, deadline, func(address string, timeout time.Duration) error {
+			return probeHTTPGet(probe.HTTPGet, address, timeout)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// splitTestAddress returns host and port of a listener
+func splitTestAddress(t *testing.T, addr string) (string, int) {
+	host, portStrg, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(portStrg)
+	require.NoError(t, err)
+	return host, port
+}
+
+func testDeadline() time.Time {
+	return time.Now().Add(time.Minute)
+}
+
+func TestRunProbeLogTokens(t *testing.T) {
+	lines := []string{"HPL10001I: Services succeeded -> systemd triggered hpl-catch-success service"}
+
+	assert.NoError(t, RunProbe(&ProbeSpec{LogTokens: []string{"HPL10001I"}}, nil, lines, testDeadline()))
+	assert.ErrorIs(t, RunProbe(&ProbeSpec{LogTokens: []string{"HPL14000I"}}, nil, lines, testDeadline()), ErrProbeFailed)
+}
+
+func TestRunProbeTCPSocket(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	host, port := splitTestAddress(t, listener.Addr().String())
+
+	probe := &ProbeSpec{TCPSocket: &TCPSocketProbeSpec{Port: port}}
+	// one of the addresses responds
+	assert.NoError(t, RunProbe(probe, []string{"127.0.0.2", host}, nil, testDeadline()))
+
+	require.NoError(t, listener.Close())
+	assert.ErrorIs(t, RunProbe(probe, []string{host}, nil, testDeadline()), ErrProbeFailed)
+	// no addresses
+	assert.ErrorIs(t, RunProbe(probe, nil, nil, testDeadline()), ErrProbeFailed)
+}
+
+func TestRunProbeDeadline(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	host, port := splitTestAddress(t, listener.Addr().String())
+
+	probe := &ProbeSpec{TCPSocket: &TCPSocketProbeSpec{Port: port}}
+	// the time for probing is exhausted
+	assert.ErrorIs(t, RunProbe(probe, []string{host}, nil, time.Now()), ErrProbeFailed)
+
+	timeout, err := probeTimeout(time.Minute, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.LessOrEqual(t, timeout, time.Second)
+}
+
+func TestRunProbeHTTPGet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	host, port := splitTestAddress(t, server.Listener.Addr().String())
+
+	assert.NoError(t, RunProbe(&ProbeSpec{HTTPGet: &HTTPGetProbeSpec{Port: port, Path: "healthz"}}, []string{host}, nil, testDeadline()))
+	assert.ErrorIs(t, RunProbe(&ProbeSpec{HTTPGet: &HTTPGetProbeSpec{Port: port, Path: "/other"}}, []string{host}, nil, testDeadline()), ErrProbeFailed)
+}
+
+func TestRunProbeHTTPSGet(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	host, port := splitTestAddress(t, server.Listener.Addr().String())
+
+	assert.NoError(t, RunProbe(&ProbeSpec{HTTPGet: &HTTPGetProbeSpec{Port: port, Scheme: ProbeSchemeHTTPS}}, []string{host}, nil, testDeadline()))
+}
+
+func TestBoxProbeDefaults(t *testing.T) {
+	assert.Equal(t, DefaultProbeTimeoutSeconds, BoxProbeTimeoutSeconds(0))
+	assert.Equal(t, DefaultProbeFailureThreshold, BoxProbeFailureThreshold(0))
+	assert.Equal(t, ProbeFailurePolicyNone, BoxProbeFailurePolicy(""))
+}
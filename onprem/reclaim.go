@@ -0,0 +1,146 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/digitalocean/go-libvirt"
+	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+)
+
+const (
+	// suffix of the marker volume that signals a running copy of a data disk
+	reclaimMarkerSuffix = ".reclaiming"
+)
+
+var (
+	// ErrVolumeExists signals that the target volume of a copy exists already
+	ErrVolumeExists = errors.New("volume exists")
+)
+
+// GetRetainedVolumeName returns the name of the volume that keeps the data of a deleted data disk with the `Retain` policy
+func GetRetainedVolumeName(namespace, name string) string {
+	return fmt.Sprintf("retained-%s-%s", namespace, name)
+}
+
+// GetReclaimVolumeName returns the name of the volume that keeps the data of a deleted data disk according to its
+// reclaim policy. The name of a final snapshot is derived from the deletion time of the data disk, so it is the same
+// for every attempt to reclaim the disk.
+func GetReclaimVolumeName(opt *DataDiskOptions) (string, error) {
+	policy := BoxReclaimPolicy(opt.ReclaimPolicy)
+	switch policy {
+	case ReclaimPolicyDelete:
+		return "", nil
+	case ReclaimPolicyRetain:
+		return opt.RetainedName, nil
+	case ReclaimPolicySnapshot:
+		return GetSnapshotVolumeName(opt.Name, opt.Deleted, "reclaim"), nil
+	default:
+		return "", fmt.Errorf("data disk [%s] has unsupported reclaim policy [%s]", opt.Name, policy)
+	}
+}
+
+// getReclaimMarkerName returns the name of the marker volume of a data disk that is being copied
+func getReclaimMarkerName(name string) string {
+	return name + reclaimMarkerSuffix
+}
+
+// GetVolumeDomains returns the names of the domains that have the volume attached, a stopped domain still needs the
+// volume to start again
+func GetVolumeDomains(client *LivirtClient) func(storagePool, name string) ([]string, error) {
+	conn := client.LibVirt
+	volumePath := getVolumePath(conn)
+	volumeDomains := getVolumeDomains(conn)
+
+	return func(storagePool, name string) ([]string, error) {
+		path, err := volumePath(storagePool, name)
+		if err != nil {
+			// no volume, not attached
+			return nil, nil
+		}
+		return volumeDomains(path, 0)
+	}
+}
+
+// ReclaimDataDisk releases the volume of a deleted data disk according to its reclaim policy. Retained volumes and
+// final snapshots are copies of the volume, the original volume is deleted once the copy succeeded. A copy might take
+// long, so the caller runs it in the background.
+//
+// A marker volume exists for as long as the copy is incomplete. A target volume with a marker is the remainder of an
+// interrupted copy, it is replaced by a new copy. A target volume without a marker belongs to someone else.
+func ReclaimDataDisk(client *LivirtClient) func(opt *DataDiskOptions) error {
+	conn := client.LibVirt
+	cloneVolume := cloneStorageVol(conn)
+	deleteVolume := deleteStorageVol(conn)
+	deleteDataDisk := DeleteDataDiskSync(client)
+	createMarker := createMarkerVolume(conn)
+	exists := hasVolume(conn)
+
+	deleteMarker := func(pool libvirt.StoragePool, marker string) error {
+		if !exists(pool, marker) {
+			return nil
+		}
+		_, err := deleteVolume(pool, marker)
+		return err
+	}
+
+	return func(opt *DataDiskOptions) error {
+		defer CM.EntryExit(fmt.Sprintf("ReclaimDataDisk(%s, %s)", opt.StoragePool, opt.Name))()
+
+		target, err := GetReclaimVolumeName(opt)
+		if err != nil {
+			return err
+		}
+		if target == "" {
+			return deleteDataDisk(opt.StoragePool, opt.Name)
+		}
+		pool, err := conn.StoragePoolLookupByName(opt.StoragePool)
+		if err != nil {
+			return err
+		}
+		marker := getReclaimMarkerName(opt.Name)
+		source, err := conn.StorageVolLookupByName(pool, opt.Name)
+		if err != nil {
+			// nothing to reclaim, the copy has been completed before
+			log.Printf("Volume [%s] does not exist on pool [%s], nothing to do", opt.Name, pool.Name)
+			return deleteMarker(pool, marker)
+		}
+		if exists(pool, target) {
+			if !exists(pool, marker) {
+				return fmt.Errorf("%w: unable to keep data disk [%s] as volume [%s] on pool [%s]", ErrVolumeExists, opt.Name, target, pool.Name)
+			}
+			log.Printf("Replacing the incomplete copy [%s] of data disk [%s] on pool [%s] ...", target, opt.Name, pool.Name)
+			if _, err := deleteVolume(pool, target); err != nil {
+				return err
+			}
+		}
+		if !exists(pool, marker) {
+			if err := createMarker(pool, marker); err != nil {
+				return err
+			}
+		}
+		log.Printf("Keeping data disk [%s] as volume [%s] on pool [%s] according to the reclaim policy [%s] ...", opt.Name, target, pool.Name, BoxReclaimPolicy(opt.ReclaimPolicy))
+		if _, err := cloneVolume(pool, source, target); err != nil {
+			return err
+		}
+		if err := deleteDataDisk(opt.StoragePool, opt.Name); err != nil {
+			return err
+		}
+		return deleteMarker(pool, marker)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRetainedVolumeName(t *testing.T) {
+	assert.Equal(t, "retained-default-sampledisk", GetRetainedVolumeName("default", "sampledisk"))
+}
+
+func TestBoxReclaimPolicy(t *testing.T) {
+	assert.Equal(t, ReclaimPolicyDelete, BoxReclaimPolicy(""))
+	assert.Equal(t, ReclaimPolicyRetain, BoxReclaimPolicy(ReclaimPolicyRetain))
+}
+
+func TestGetReclaimVolumeName(t *testing.T) {
+	opt := &DataDiskOptions{
+		Name:         "uid",
+		RetainedName: "retained-default-sampledisk",
+		Deleted:      time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC),
+	}
+	name, err := GetReclaimVolumeName(opt)
+	require.NoError(t, err)
+	assert.Empty(t, name)
+
+	opt.ReclaimPolicy = ReclaimPolicyRetain
+	name, err = GetReclaimVolumeName(opt)
+	require.NoError(t, err)
+	assert.Equal(t, "retained-default-sampledisk", name)
+
+	// every attempt copies into the same final snapshot
+	opt.ReclaimPolicy = ReclaimPolicySnapshot
+	name, err = GetReclaimVolumeName(opt)
+	require.NoError(t, err)
+	assert.Equal(t, "snapshot-uid.20230301000000.reclaim", name)
+
+	opt.ReclaimPolicy = "Recycle"
+	_, err = GetReclaimVolumeName(opt)
+	assert.Error(t, err)
+}
@@ -0,0 +1,177 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	A "github.com/IBM/fp-go/array"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+)
+
+var (
+	// ErrNoHostAvailable signals that none of the hypervisor hosts can run the VSI
+	ErrNoHostAvailable = errors.New("no hypervisor host available")
+)
+
+// SchedulingRequest describes the resources a VSI requires on a hypervisor host, all sizes are in bytes
+type SchedulingRequest struct {
+	// number of virtual CPUs
+	CPUs int
+	// memory of the VSI
+	Memory uint64
+	// name of the storage pool that holds the disks of the VSI
+	StoragePool string
+	// space required in the storage pool
+	Storage uint64
+	// names of the hosts excluded by anti-affinity
+	Excluded []string
+}
+
+// HostCandidate is a hypervisor host a VSI may be scheduled on
+type HostCandidate struct {
+	// name of the hypervisor host resource
+	Name string
+	// resources of the host as last observed
+	Info *HostInfo
+	// optional limits of the allocatable resources
+	Capacity *HypervisorHostCapacitySpec
+	// resources of placements that are not reflected in the host info, yet
+	ReservedCPUs    int
+	ReservedMemory  uint64
+	ReservedStorage uint64
+}
+
+// CreateSchedulingRequest returns the resources required by a VSI
+func CreateSchedulingRequest(storagePool string, excluded []string) *SchedulingRequest {
+	return &SchedulingRequest{
+		CPUs:        InstanceCPUs,
+		Memory:      InstanceMemory,
+		StoragePool: BoxStoragePool(storagePool),
+		Storage:     InstanceStorage,
+		Excluded:    excluded,
+	}
+}
+
+// CreateHostCandidate decodes the resources reported in the status of a hypervisor host
+func CreateHostCandidate(host *HypervisorHostCustomResource) (*HostCandidate, error) {
+	info, err := common.Transcode[*HostInfo](host.Status.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	return &HostCandidate{
+		Name:     host.Name,
+		Info:     info,
+		Capacity: host.Spec.Capacity,
+	}, nil
+}
+
+// subtract returns the difference of two sizes or zero
+func subtract(total, used uint64) uint64 {
+	if used >= total {
+		return 0
+	}
+	return total - used
+}
+
+// AvailableCPUs returns the number of virtual CPUs that can still be allocated
+func (c *HostCandidate) AvailableCPUs() int {
+	total := c.Info.CPUs
+	if c.Capacity != nil && c.Capacity.CPUs > 0 {
+		total = c.Capacity.CPUs
+	}
+	return max(total-c.Info.AllocatedCPUs-c.ReservedCPUs, 0)
+}
+
+// AvailableMemory returns the memory that can still be allocated
+func (c *HostCandidate) AvailableMemory() uint64 {
+	total := c.Info.Memory
+	if c.Capacity != nil && c.Capacity.Memory > 0 {
+		total = c.Capacity.Memory
+	}
+	return subtract(total, c.Info.AllocatedMemory+c.ReservedMemory)
+}
+
+// AvailableStorage returns the free space of a storage pool and if the pool exists on the host
+func (c *HostCandidate) AvailableStorage(storagePool string) (uint64, bool) {
+	available, ok := c.Info.StoragePools[storagePool]
+	return subtract(available, c.ReservedStorage), ok
+}
+
+// Reserve accounts for the resources of a placement that the host info does not reflect, yet
+func (c *HostCandidate) Reserve(req *SchedulingRequest) {
+	c.ReservedCPUs += req.CPUs
+	c.ReservedMemory += req.Memory
+	c.ReservedStorage += req.Storage
+}
+
+// checkHostCandidate returns the reason why the host cannot run the VSI or the empty string
+func checkHostCandidate(req *SchedulingRequest, c *HostCandidate) string {
+	if slices.Contains(req.Excluded, c.Name) {
+		return "anti-affinity"
+	}
+	if c.AvailableMemory() < req.Memory {
+		return fmt.Sprintf("insufficient memory, [%d] of [%d] bytes available", c.AvailableMemory(), req.Memory)
+	}
+	if c.AvailableCPUs() < req.CPUs {
+		return fmt.Sprintf("insufficient CPUs, [%d] of [%d] available", c.AvailableCPUs(), req.CPUs)
+	}
+	available, ok := c.AvailableStorage(req.StoragePool)
+	if !ok {
+		return fmt.Sprintf("no storage pool [%s]", req.StoragePool)
+	}
+	if available < req.Storage {
+		return fmt.Sprintf("insufficient space in storage pool [%s], [%d] of [%d] bytes available", req.StoragePool, available, req.Storage)
+	}
+	return ""
+}
+
+// ScheduleInstance selects the host to run a VSI on. Of all hosts that fit the request the one with the most
+// available memory wins, ties are broken by the number of available CPUs and then by name, so the result is stable.
+func ScheduleInstance(req *SchedulingRequest) func(candidates []*HostCandidate) (*HostCandidate, error) {
+	return func(candidates []*HostCandidate) (*HostCandidate, error) {
+		var fits []*HostCandidate
+		var reasons []string
+		for _, c := range candidates {
+			if reason := checkHostCandidate(req, c); reason != "" {
+				reasons = append(reasons, fmt.Sprintf("[%s]: %s", c.Name, reason))
+				continue
+			}
+			fits = append(fits, c)
+		}
+		if A.IsEmpty(fits) {
+			if A.IsEmpty(reasons) {
+				return nil, fmt.Errorf("%w: no hypervisor host matches the host selector", ErrNoHostAvailable)
+			}
+			sort.Strings(reasons)
+			return nil, fmt.Errorf("%w: %s", ErrNoHostAvailable, strings.Join(reasons, ", "))
+		}
+		sort.Slice(fits, func(i, j int) bool {
+			left, right := fits[i], fits[j]
+			if left.AvailableMemory() != right.AvailableMemory() {
+				return left.AvailableMemory() > right.AvailableMemory()
+			}
+			if left.AvailableCPUs() != right.AvailableCPUs() {
+				return left.AvailableCPUs() > right.AvailableCPUs()
+			}
+			return left.Name < right.Name
+		})
+		return fits[0], nil
+	}
+}
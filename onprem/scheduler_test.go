@@ -0,0 +1,134 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"testing"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	gib = uint64(1024 * 1024 * 1024)
+)
+
+func createTestHostCandidate(name string, cpus int, memory, allocatedMemory uint64) *HostCandidate {
+	return &HostCandidate{
+		Name: name,
+		Info: &HostInfo{
+			CPUs:            cpus,
+			Memory:          memory,
+			AllocatedMemory: allocatedMemory,
+			StoragePools: map[string]uint64{
+				DefaultStoragePool: 100 * gib,
+			},
+		},
+	}
+}
+
+func TestScheduleInstancePrefersMostAvailableMemory(t *testing.T) {
+	req := CreateSchedulingRequest("", nil)
+
+	host, err := ScheduleInstance(req)([]*HostCandidate{
+		createTestHostCandidate("lpar1", 8, 64*gib, 48*gib),
+		createTestHostCandidate("lpar2", 8, 64*gib, 8*gib),
+		createTestHostCandidate("lpar3", 8, 64*gib, 32*gib),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "lpar2", host.Name)
+}
+
+func TestScheduleInstanceBreaksTiesByName(t *testing.T) {
+	req := CreateSchedulingRequest("", nil)
+
+	host, err := ScheduleInstance(req)([]*HostCandidate{
+		createTestHostCandidate("lpar2", 8, 64*gib, 0),
+		createTestHostCandidate("lpar1", 8, 64*gib, 0),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "lpar1", host.Name)
+}
+
+func TestScheduleInstanceAntiAffinity(t *testing.T) {
+	req := CreateSchedulingRequest("", []string{"lpar2"})
+
+	host, err := ScheduleInstance(req)([]*HostCandidate{
+		createTestHostCandidate("lpar1", 8, 64*gib, 32*gib),
+		createTestHostCandidate("lpar2", 8, 64*gib, 0),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "lpar1", host.Name)
+}
+
+func TestScheduleInstanceHonorsCapacity(t *testing.T) {
+	req := CreateSchedulingRequest("", nil)
+
+	limited := createTestHostCandidate("lpar1", 8, 64*gib, 0)
+	limited.Capacity = &HypervisorHostCapacitySpec{Memory: 2 * gib}
+
+	_, err := ScheduleInstance(req)([]*HostCandidate{limited})
+	assert.ErrorIs(t, err, ErrNoHostAvailable)
+	assert.ErrorContains(t, err, "insufficient memory")
+}
+
+func TestScheduleInstanceChecksCPUsAndStorage(t *testing.T) {
+	req := CreateSchedulingRequest("fast", nil)
+
+	busy := createTestHostCandidate("lpar1", 1, 64*gib, 0)
+	noPool := createTestHostCandidate("lpar2", 8, 64*gib, 0)
+
+	_, err := ScheduleInstance(req)([]*HostCandidate{busy, noPool})
+	assert.ErrorIs(t, err, ErrNoHostAvailable)
+	assert.ErrorContains(t, err, "[lpar1]: insufficient CPUs")
+	assert.ErrorContains(t, err, "[lpar2]: no storage pool [fast]")
+}
+
+func TestScheduleInstanceHonorsReservations(t *testing.T) {
+	req := CreateSchedulingRequest("", nil)
+
+	lpar1 := createTestHostCandidate("lpar1", 8, 64*gib, 0)
+	lpar2 := createTestHostCandidate("lpar2", 8, 64*gib, 2*gib)
+	// two pending placements on the otherwise emptier host
+	lpar1.Reserve(req)
+	lpar1.Reserve(req)
+
+	host, err := ScheduleInstance(req)([]*HostCandidate{lpar1, lpar2})
+	require.NoError(t, err)
+	assert.Equal(t, "lpar2", host.Name)
+}
+
+func TestCreateHostCandidate(t *testing.T) {
+	info := &HostInfo{
+		CPUs:   16,
+		Memory: 128 * gib,
+		StoragePools: map[string]uint64{
+			DefaultStoragePool: 10 * gib,
+		},
+	}
+	metadata, err := common.Transcode[map[string]any](info)
+	require.NoError(t, err)
+
+	candidate, err := CreateHostCandidate(&HypervisorHostCustomResource{
+		ObjectMeta: metav1.ObjectMeta{Name: "lpar1"},
+		Status:     HypervisorHostStatus{Metadata: metadata},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "lpar1", candidate.Name)
+	assert.Equal(t, 16, candidate.AvailableCPUs())
+	assert.Equal(t, 128*gib, candidate.AvailableMemory())
+}
@@ -0,0 +1,75 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+
+	libvirt "github.com/digitalocean/go-libvirt"
+	"libvirt.org/go/libvirtxml"
+)
+
+var (
+	// ErrSecureExecutionUnsupported signals a VSI that requires Secure Execution on a host without protected virtualization
+	ErrSecureExecutionUnsupported = errors.New("secure execution is not supported by the host")
+)
+
+// getDomainCapabilities returns the capabilities of s390x KVM domains, the call fails on hosts that cannot run them
+func getDomainCapabilities(conn *libvirt.Libvirt) (*libvirtxml.DomainCaps, error) {
+	domainCapsXML, err := conn.ConnectGetDomainCapabilities(nil, libvirt.OptString{guestArch}, nil, libvirt.OptString{"kvm"}, 0)
+	if err != nil {
+		return nil, err
+	}
+	domainCaps := &libvirtxml.DomainCaps{}
+	if err := xml.Unmarshal([]byte(domainCapsXML), domainCaps); err != nil {
+		return nil, err
+	}
+	return domainCaps, nil
+}
+
+// IsSecureExecutionSupported tests if the host is able to start Secure Execution guests
+func IsSecureExecutionSupported(client *LivirtClient) func() bool {
+	conn := client.LibVirt
+
+	return func() bool {
+		domainCaps, err := getDomainCapabilities(conn)
+		return err == nil && hasProtectedVirtualization(domainCaps)
+	}
+}
+
+// CheckSecureExecution refuses to start a VSI that requires Secure Execution on a host without it
+func CheckSecureExecution(opt *InstanceOptions, supported bool) error {
+	if supported || BoxSecureExecution(opt.SecureExecution) != SecureExecutionRequired {
+		return nil
+	}
+	return fmt.Errorf("%w: VSI [%s] requires secure execution", ErrSecureExecutionUnsupported, opt.Name)
+}
+
+// createLaunchSecurity declares the domain as a Secure Execution guest if the host supports it
+func createLaunchSecurity(supported bool) *libvirtxml.DomainLaunchSecurity {
+	if !supported {
+		return nil
+	}
+	return &libvirtxml.DomainLaunchSecurity{
+		S390PV: &libvirtxml.DomainLaunchSecurityS390PV{},
+	}
+}
+
+// IsDomainProtected tests if a domain has been declared as a Secure Execution guest
+func IsDomainProtected(domainXML *libvirtxml.Domain) bool {
+	return domainXML != nil && domainXML.LaunchSecurity != nil && domainXML.LaunchSecurity.S390PV != nil
+}
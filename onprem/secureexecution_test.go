@@ -0,0 +1,50 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"libvirt.org/go/libvirtxml"
+)
+
+func TestCheckSecureExecution(t *testing.T) {
+	auto := &InstanceOptions{Name: "vsi"}
+	required := &InstanceOptions{Name: "vsi", SecureExecution: SecureExecutionRequired}
+
+	assert.NoError(t, CheckSecureExecution(auto, true))
+	assert.NoError(t, CheckSecureExecution(auto, false))
+	assert.NoError(t, CheckSecureExecution(required, true))
+	assert.ErrorIs(t, CheckSecureExecution(required, false), ErrSecureExecutionUnsupported)
+}
+
+func TestLaunchSecurity(t *testing.T) {
+	domain := &libvirtxml.Domain{Type: "kvm", Name: "vsi", LaunchSecurity: createLaunchSecurity(true)}
+	domainXML, err := XMLMarshall(domain)
+	require.NoError(t, err)
+	assert.Contains(t, domainXML, `<launchSecurity type="s390-pv"></launchSecurity>`)
+
+	parsed, err := parseDomainXML(domainXML)
+	require.NoError(t, err)
+	assert.True(t, IsDomainProtected(parsed))
+}
+
+func TestLaunchSecurityUnsupported(t *testing.T) {
+	assert.Nil(t, createLaunchSecurity(false))
+	assert.False(t, IsDomainProtected(&libvirtxml.Domain{Name: "vsi"}))
+	assert.False(t, IsDomainProtected(nil))
+}
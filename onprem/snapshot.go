@@ -0,0 +1,363 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	A "github.com/IBM/fp-go/array"
+	F "github.com/IBM/fp-go/function"
+	libvirt "github.com/digitalocean/go-libvirt"
+	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	"libvirt.org/go/libvirtxml"
+)
+
+const (
+	// timestamp format used to name snapshots, sorts chronologically
+	snapshotTimestampFormat = "20060102150405"
+	// suffix of the marker volume that signals an incomplete snapshot
+	incompleteSnapshotSuffix = ".incomplete"
+)
+
+var (
+	// full identifier of the snapshot config entry
+	KeySnapshotConfig = fmt.Sprintf("%s.%s", KindSnapshot, APIVersion)
+)
+
+// GetSnapshotVolumePrefix returns the common prefix of all snapshot volumes of a data disk
+func GetSnapshotVolumePrefix(dataDisk string) string {
+	return fmt.Sprintf("snapshot-%s.", dataDisk)
+}
+
+// GetSnapshotVolumeName returns the name of a snapshot volume, the name is derived from the creation time of the
+// snapshot so it is stable and sorts chronologically
+func GetSnapshotVolumeName(dataDisk string, created time.Time, uid string) string {
+	return fmt.Sprintf("%s%s.%s", GetSnapshotVolumePrefix(dataDisk), created.UTC().Format(snapshotTimestampFormat), uid)
+}
+
+// FilterSnapshotVolumes selects the names of the snapshot volumes of a data disk and sorts them from oldest to newest
+func FilterSnapshotVolumes(dataDisk string) func(volumes []string) []string {
+	prefix := GetSnapshotVolumePrefix(dataDisk)
+	return func(volumes []string) []string {
+		snapshots := F.Pipe1(
+			volumes,
+			A.Filter(func(vol string) bool {
+				return strings.HasPrefix(vol, prefix)
+			}),
+		)
+		sort.Strings(snapshots)
+		return snapshots
+	}
+}
+
+// IsSnapshotSuperseded tests if at least `retention` ready snapshots of the same data disk are newer than the
+// snapshot volume. Only the volumes of snapshot resources are considered, so final snapshots taken by the reclaim
+// policy of a data disk never count.
+func IsSnapshotSuperseded(volume, dataDisk string, retention int) func(snapshots []*DataDiskSnapshotCustomResource) bool {
+	return func(snapshots []*DataDiskSnapshotCustomResource) bool {
+		if retention <= 0 {
+			return false
+		}
+		volumes := []string{volume}
+		for _, snapshot := range snapshots {
+			if other, ok := snapshot.Status.Metadata["volume"].(string); ok && other != volume {
+				volumes = append(volumes, other)
+			}
+		}
+		sorted := FilterSnapshotVolumes(dataDisk)(volumes)
+		for idx, name := range sorted {
+			if name == volume {
+				return len(sorted)-idx-1 >= retention
+			}
+		}
+		return false
+	}
+}
+
+// getIncompleteSnapshotName returns the name of the marker volume of a snapshot that is being taken
+func getIncompleteSnapshotName(name string) string {
+	return name + incompleteSnapshotSuffix
+}
+
+// quiesceDomain brings the file systems of a running domain into a consistent state before one of its disks is
+// copied. The guest agent freezes the file systems, a guest without an agent is paused for the time of the copy. The
+// returned function undoes the operation.
+func quiesceDomain(conn *libvirt.Libvirt) func(name string) (func(), error) {
+	return func(name string) (func(), error) {
+		dom, err := conn.DomainLookupByName(name)
+		if err != nil {
+			return nil, err
+		}
+		state, _, err := conn.DomainGetState(dom, 0)
+		if err != nil {
+			return nil, err
+		}
+		switch libvirt.DomainState(state) {
+		case libvirt.DomainRunning, libvirt.DomainBlocked:
+		default:
+			// the guest does not write
+			return func() {}, nil
+		}
+		_, err = conn.DomainFsfreeze(dom, nil, 0)
+		if err == nil {
+			log.Printf("Froze the file systems of domain [%s]", name)
+			return func() {
+				if _, err := conn.DomainFsthaw(dom, nil, 0); err != nil {
+					log.Printf("Unable to thaw the file systems of domain [%s], cause: [%v]", name, err)
+				}
+			}, nil
+		}
+		log.Printf("Unable to freeze the file systems of domain [%s], pausing it instead, cause: [%v]", name, err)
+		if err := conn.DomainSuspend(dom); err != nil {
+			return nil, err
+		}
+		return func() {
+			if err := conn.DomainResume(dom); err != nil {
+				log.Printf("Unable to resume domain [%s], cause: [%v]", name, err)
+			}
+		}, nil
+	}
+}
+
+// CreateSnapshotSync takes a snapshot of a data disk by cloning its volume. The domain a disk is attached to is
+// quiesced during the copy. A marker volume exists for as long as the copy is incomplete, an incomplete snapshot is
+// replaced by a new copy.
+func CreateSnapshotSync(client *LivirtClient) func(opt *DataDiskSnapshotOptions) (*libvirtxml.StorageVolume, error) {
+	conn := client.LibVirt
+	storageVolXMLDesc := getStorageVolXMLDesc(conn)
+	cloneVolume := cloneStorageVol(conn)
+	deleteVolume := deleteStorageVol(conn)
+	createMarker := createMarkerVolume(conn)
+	exists := hasVolume(conn)
+	volumeDomains := getVolumeDomains(conn)
+	quiesce := quiesceDomain(conn)
+
+	return func(opt *DataDiskSnapshotOptions) (*libvirtxml.StorageVolume, error) {
+		defer CM.EntryExit(fmt.Sprintf("CreateSnapshotSync(%s, %s)", opt.StoragePool, opt.Name))()
+
+		pool, err := conn.StoragePoolLookupByName(opt.StoragePool)
+		if err != nil {
+			return nil, err
+		}
+		marker := getIncompleteSnapshotName(opt.Name)
+		// the snapshot might exist already
+		existing, err := conn.StorageVolLookupByName(pool, opt.Name)
+		if err == nil {
+			if !exists(pool, marker) {
+				return storageVolXMLDesc(&existing)
+			}
+			log.Printf("Replacing the incomplete snapshot [%s] on pool [%s] ...", opt.Name, pool.Name)
+			if _, err := deleteVolume(pool, opt.Name); err != nil {
+				return nil, err
+			}
+		}
+		disk, err := conn.StorageVolLookupByName(pool, opt.DataDisk)
+		if err != nil {
+			return nil, err
+		}
+		path, err := conn.StorageVolGetPath(disk)
+		if err != nil {
+			return nil, err
+		}
+		domains, err := volumeDomains(path, libvirt.ConnectListDomainsActive)
+		if err != nil {
+			return nil, err
+		}
+		if !exists(pool, marker) {
+			if err := createMarker(pool, marker); err != nil {
+				return nil, err
+			}
+		}
+		for _, domain := range domains {
+			thaw, err := quiesce(domain)
+			if err != nil {
+				return nil, err
+			}
+			defer thaw()
+		}
+		log.Printf("Creating snapshot [%s] of data disk [%s] on pool [%s] ...", opt.Name, opt.DataDisk, pool.Name)
+		snapshot, err := cloneVolume(pool, disk, opt.Name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := deleteVolume(pool, marker); err != nil {
+			return nil, err
+		}
+		return storageVolXMLDesc(snapshot)
+	}
+}
+
+// IsSnapshotAvailable checks if the snapshot volume exists and is complete
+func IsSnapshotAvailable(client *LivirtClient) func(storagePool, name string) (*libvirtxml.StorageVolume, bool) {
+	conn := client.LibVirt
+	storageVolByNameXMLDesc := getStorageVolByNameXMLDesc(conn)
+	exists := hasVolume(conn)
+
+	return func(storagePool, name string) (*libvirtxml.StorageVolume, bool) {
+		pool, err := conn.StoragePoolLookupByName(storagePool)
+		if err != nil {
+			log.Printf("Unable to lookup storage pool [%s], cause: [%v]", storagePool, err)
+			return nil, false
+		}
+		volXML, err := storageVolByNameXMLDesc(pool, name)
+		if err != nil || exists(pool, getIncompleteSnapshotName(name)) {
+			return nil, false
+		}
+		return volXML, true
+	}
+}
+
+// DeleteSnapshotSync deletes a snapshot volume
+func DeleteSnapshotSync(client *LivirtClient) func(storagePool, name string) error {
+	return DeleteDataDiskSync(client)
+}
+
+// RestoreDataDisk creates a data disk volume as a clone of a snapshot volume
+func RestoreDataDisk(client *LivirtClient) func(opt *DataDiskOptions) (*libvirt.StorageVol, error) {
+	conn := client.LibVirt
+	cloneVolume := cloneStorageVol(conn)
+
+	return func(opt *DataDiskOptions) (*libvirt.StorageVol, error) {
+		defer CM.EntryExit(fmt.Sprintf("RestoreDataDisk(%s, %s)", opt.StoragePool, opt.Name))()
+
+		pool, err := conn.StoragePoolLookupByName(opt.StoragePool)
+		if err != nil {
+			return nil, err
+		}
+		sourcePool, err := conn.StoragePoolLookupByName(opt.RestoreFrom.StoragePool)
+		if err != nil {
+			return nil, err
+		}
+		source, err := conn.StorageVolLookupByName(sourcePool, opt.RestoreFrom.VolumeName)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Restoring data disk [%s] on pool [%s] from snapshot [%s] on pool [%s] ...", opt.Name, pool.Name, source.Name, sourcePool.Name)
+		return cloneVolume(pool, source, opt.Name)
+	}
+}
+
+// ExportProgress receives the number of bytes of a snapshot that have been exported so far
+type ExportProgress func(exported, size int64)
+
+// progressReader reports the bytes read from the underlying reader
+type progressReader struct {
+	reader   io.Reader
+	exported int64
+	size     int64
+	progress ExportProgress
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.exported += int64(n)
+	r.progress(r.exported, r.size)
+	return n, err
+}
+
+// getDownloadSize returns the number of bytes a download of the volume produces, this is the size of the file of
+// the volume if libvirt reports it
+func getDownloadSize(vol *libvirtxml.StorageVolume) (int64, error) {
+	if vol.Physical != nil && vol.Physical.Value > 0 {
+		return int64(vol.Physical.Value), nil
+	}
+	if vol.Capacity != nil {
+		return int64(vol.Capacity.Value), nil
+	}
+	return 0, fmt.Errorf("unable to determine the size of volume [%s]", vol.Name)
+}
+
+// ExportSnapshot streams the bytes of a snapshot volume to an export target without buffering the volume. The data
+// disk is encrypted by the HPCR guest, so the exported bytes are encrypted, too.
+func ExportSnapshot(client *LivirtClient) func(storagePool, name string, exporter SnapshotExporter, key string, progress ExportProgress) error {
+	conn := client.LibVirt
+	storageVolXMLDesc := getStorageVolXMLDesc(conn)
+
+	return func(storagePool, name string, exporter SnapshotExporter, key string, progress ExportProgress) error {
+		defer CM.EntryExit(fmt.Sprintf("ExportSnapshot(%s, %s)", storagePool, name))()
+
+		pool, err := conn.StoragePoolLookupByName(storagePool)
+		if err != nil {
+			return err
+		}
+		vol, err := conn.StorageVolLookupByName(pool, name)
+		if err != nil {
+			return err
+		}
+		volXML, err := storageVolXMLDesc(&vol)
+		if err != nil {
+			return err
+		}
+		// the length of the upload must be known upfront
+		size, err := getDownloadSize(volXML)
+		if err != nil {
+			return err
+		}
+
+		t0 := time.Now()
+		log.Printf("Exporting [%d] bytes of snapshot [%s] from pool [%s] as [%s] ...", size, name, pool.Name, key)
+		reader, writer := io.Pipe()
+		downloaded := make(chan error, 1)
+		go func() {
+			err := conn.StorageVolDownload(vol, writer, 0, 0, 0)
+			writer.CloseWithError(err)
+			downloaded <- err
+		}()
+		err = exporter.Export(key, &progressReader{reader: reader, size: size, progress: progress}, size)
+		// unblocks the download if the export stopped reading
+		reader.CloseWithError(err)
+		if downloadErr := <-downloaded; err == nil {
+			err = downloadErr
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("Export of snapshot [%s] done in [%f s].", name, time.Since(t0).Seconds())
+		return nil
+	}
+}
+
+// SnapshotsFromRelated decodes the set of ready snapshots from the related data structure
+func SnapshotsFromRelated(data map[string]any) ([]*DataDiskSnapshotCustomResource, error) {
+	var result []*DataDiskSnapshotCustomResource
+	if related, ok := data["related"].(map[string]any); ok {
+		// all snapshots
+		if snapshots, ok := related[KeySnapshotConfig].(map[string]any); ok {
+			// decode each snapshot
+			for _, snapshot := range snapshots {
+				// transcode to the expected format
+				snap, err := common.Transcode[*DataDiskSnapshotCustomResource](snapshot)
+				if err != nil {
+					return nil, err
+				}
+				// validate the status of the snapshot
+				if common.Status(snap.Status.Status) == common.Ready {
+					result = append(result, snap)
+				} else {
+					// snapshot is not in a valid status
+					log.Printf("Snapshot [%s] is not in ready state, ignoring, cause: [%s]", snap.Name, snap.Status.Description)
+				}
+			}
+		}
+	}
+	// ok
+	return result, nil
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"libvirt.org/go/libvirtxml"
+)
+
+func TestGetSnapshotVolumeName(t *testing.T) {
+	created := time.Date(2023, 5, 17, 10, 11, 12, 0, time.FixedZone("CEST", 2*60*60))
+
+	name := GetSnapshotVolumeName("disk-uid", created, "snap-uid")
+
+	assert.Equal(t, "snapshot-disk-uid.20230517081112.snap-uid", name)
+}
+
+func TestFilterSnapshotVolumes(t *testing.T) {
+	volumes := []string{
+		"disk-uid",
+		"snapshot-disk-uid.20230517081112.b",
+		"snapshot-other-uid.20230101000000.c",
+		"snapshot-disk-uid.20230101000000.a",
+		"boot-disk-uid",
+	}
+
+	snapshots := FilterSnapshotVolumes("disk-uid")(volumes)
+
+	assert.Equal(t, []string{
+		"snapshot-disk-uid.20230101000000.a",
+		"snapshot-disk-uid.20230517081112.b",
+	}, snapshots)
+}
+
+func createTestSnapshot(volume string) *DataDiskSnapshotCustomResource {
+	return &DataDiskSnapshotCustomResource{
+		Status: DataDiskSnapshotStatus{
+			Metadata: map[string]any{"volume": volume},
+		},
+	}
+}
+
+func TestIsSnapshotSuperseded(t *testing.T) {
+	snapshots := []*DataDiskSnapshotCustomResource{
+		createTestSnapshot("snapshot-disk-uid.20230101000000.a"),
+		createTestSnapshot("snapshot-disk-uid.20230201000000.b"),
+		createTestSnapshot("snapshot-disk-uid.20230301000000.c"),
+		createTestSnapshot("snapshot-other-uid.20230401000000.d"),
+	}
+
+	assert.True(t, IsSnapshotSuperseded("snapshot-disk-uid.20230101000000.a", "disk-uid", 2)(snapshots))
+	assert.False(t, IsSnapshotSuperseded("snapshot-disk-uid.20230201000000.b", "disk-uid", 2)(snapshots))
+	assert.False(t, IsSnapshotSuperseded("snapshot-disk-uid.20230101000000.a", "disk-uid", 0)(snapshots))
+	// the newest snapshot is never superseded
+	assert.False(t, IsSnapshotSuperseded("snapshot-disk-uid.20230301000000.c", "disk-uid", 1)(snapshots))
+}
+
+func TestGetDownloadSize(t *testing.T) {
+	vol := &libvirtxml.StorageVolume{
+		Name:     "snapshot",
+		Capacity: &libvirtxml.StorageVolumeSize{Value: 1 << 30},
+	}
+	size, err := getDownloadSize(vol)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<30), size)
+
+	// a qcow2 volume downloads the bytes of its file
+	vol.Physical = &libvirtxml.StorageVolumeSize{Value: 1 << 20}
+	size, err = getDownloadSize(vol)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<20), size)
+}
+
+func TestProgressReader(t *testing.T) {
+	var reported []int64
+	reader := &progressReader{
+		reader: strings.NewReader("0123456789"),
+		size:   10,
+		progress: func(exported, size int64) {
+			reported = append(reported, exported)
+		},
+	}
+	data, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+	assert.Equal(t, int64(10), reported[len(reported)-1])
+}
@@ -0,0 +1,285 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	A "github.com/IBM/fp-go/array"
+	libvirt "github.com/digitalocean/go-libvirt"
+	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+	"libvirt.org/go/libvirtxml"
+)
+
+var (
+	// ErrInsufficientStorage signals that a storage pool does not have enough free space for a volume
+	ErrInsufficientStorage = errors.New("insufficient storage")
+)
+
+// StoragePoolInfo describes the capacity of a storage pool, all sizes are in bytes
+type StoragePoolInfo struct {
+	// name of the pool
+	Name string
+	// total capacity of the pool
+	Capacity uint64
+	// space allocated by volumes
+	Allocation uint64
+	// free space
+	Available uint64
+}
+
+// Usage returns the percentage of the capacity that is in use
+func (info *StoragePoolInfo) Usage() int {
+	if info.Capacity == 0 || info.Available > info.Capacity {
+		return 0
+	}
+	return int((info.Capacity - info.Available) * 100 / info.Capacity)
+}
+
+// CreateStoragePoolDef produces the libvirt definition of a managed storage pool
+func CreateStoragePoolDef(opt *StoragePoolOptions) (*libvirtxml.StoragePool, error) {
+	poolDef := &libvirtxml.StoragePool{
+		Type: opt.Type,
+		Name: opt.Name,
+	}
+	source := opt.Source
+	if source == nil {
+		source = &StoragePoolSourceSpec{}
+	}
+	switch opt.Type {
+	case StoragePoolTypeDir:
+		if opt.Path == "" {
+			return nil, fmt.Errorf("storage pool [%s] of type [%s] requires a path", opt.Name, opt.Type)
+		}
+	case StoragePoolTypeLogical:
+		vgName := source.Name
+		if vgName == "" {
+			vgName = opt.Name
+		}
+		poolDef.Source = &libvirtxml.StoragePoolSource{
+			Name: vgName,
+			Device: A.MonadMap(source.Devices, func(dev string) libvirtxml.StoragePoolSourceDevice {
+				return libvirtxml.StoragePoolSourceDevice{Path: dev}
+			}),
+		}
+		if source.Format != "" {
+			poolDef.Source.Format = &libvirtxml.StoragePoolSourceFormat{Type: source.Format}
+		}
+		if opt.Path == "" {
+			opt.Path = fmt.Sprintf("/dev/%s", vgName)
+		}
+	case StoragePoolTypeNetFS:
+		if source.Host == "" || source.Dir == "" || opt.Path == "" {
+			return nil, fmt.Errorf("storage pool [%s] of type [%s] requires a path, a source host and a source dir", opt.Name, opt.Type)
+		}
+		format := source.Format
+		if format == "" {
+			format = "nfs"
+		}
+		poolDef.Source = &libvirtxml.StoragePoolSource{
+			Host:   []libvirtxml.StoragePoolSourceHost{{Name: source.Host}},
+			Dir:    &libvirtxml.StoragePoolSourceDir{Path: source.Dir},
+			Format: &libvirtxml.StoragePoolSourceFormat{Type: format},
+		}
+	case StoragePoolTypeISCSI:
+		if source.Host == "" || source.IQN == "" {
+			return nil, fmt.Errorf("storage pool [%s] of type [%s] requires a source host and an IQN", opt.Name, opt.Type)
+		}
+		poolDef.Source = &libvirtxml.StoragePoolSource{
+			Host:   []libvirtxml.StoragePoolSourceHost{{Name: source.Host}},
+			Device: []libvirtxml.StoragePoolSourceDevice{{Path: source.IQN}},
+		}
+		if opt.Path == "" {
+			opt.Path = "/dev/disk/by-path"
+		}
+	default:
+		return nil, fmt.Errorf("storage pool [%s] has unsupported type [%s]", opt.Name, opt.Type)
+	}
+	poolDef.Target = &libvirtxml.StoragePoolTarget{Path: opt.Path}
+	return poolDef, nil
+}
+
+// getStoragePoolInfo returns the capacity information of a pool
+func getStoragePoolInfo(conn *libvirt.Libvirt) func(pool libvirt.StoragePool) (*StoragePoolInfo, error) {
+	return func(pool libvirt.StoragePool) (*StoragePoolInfo, error) {
+		_, capacity, allocation, available, err := conn.StoragePoolGetInfo(pool)
+		if err != nil {
+			return nil, err
+		}
+		return &StoragePoolInfo{
+			Name:       pool.Name,
+			Capacity:   capacity,
+			Allocation: allocation,
+			Available:  available,
+		}, nil
+	}
+}
+
+// GetStoragePoolInfo returns the capacity information of a pool by name
+func GetStoragePoolInfo(client *LivirtClient) func(storagePool string) (*StoragePoolInfo, error) {
+	conn := client.LibVirt
+	poolInfo := getStoragePoolInfo(conn)
+
+	return func(storagePool string) (*StoragePoolInfo, error) {
+		pool, err := conn.StoragePoolLookupByName(storagePool)
+		if err != nil {
+			return nil, err
+		}
+		return poolInfo(pool)
+	}
+}
+
+// checkStoragePoolSpace validates that the pool has at least the required number of bytes available
+func checkStoragePoolSpace(conn *libvirt.Libvirt) func(pool libvirt.StoragePool, required uint64) error {
+	poolInfo := getStoragePoolInfo(conn)
+
+	return func(pool libvirt.StoragePool, required uint64) error {
+		info, err := poolInfo(pool)
+		if err != nil {
+			return err
+		}
+		if info.Available < required {
+			return fmt.Errorf("%w: storage pool [%s] has [%d] bytes available, but [%d] bytes are required", ErrInsufficientStorage, pool.Name, info.Available, required)
+		}
+		return nil
+	}
+}
+
+// CreateStoragePoolSync defines, builds, starts and autostarts a managed storage pool. The flag reports if the pool
+// has been defined by this call, as opposed to adopting an existing pool.
+func CreateStoragePoolSync(client *LivirtClient) func(opt *StoragePoolOptions) (*StoragePoolInfo, bool, error) {
+	conn := client.LibVirt
+	poolInfo := getStoragePoolInfo(conn)
+
+	return func(opt *StoragePoolOptions) (*StoragePoolInfo, bool, error) {
+		defer CM.EntryExit(fmt.Sprintf("CreateStoragePoolSync(%s)", opt.Name))()
+
+		poolDef, err := CreateStoragePoolDef(opt)
+		if err != nil {
+			return nil, false, err
+		}
+		created := false
+		pool, err := conn.StoragePoolLookupByName(opt.Name)
+		if err != nil {
+			if !isError(err, libvirt.ErrNoStoragePool) {
+				return nil, false, err
+			}
+			poolDefXML, err := XMLMarshall(poolDef)
+			if err != nil {
+				return nil, false, err
+			}
+			log.Printf("Defining storage pool [%s] of type [%s] ...", opt.Name, opt.Type)
+			pool, err = conn.StoragePoolDefineXML(poolDefXML, 0)
+			if err != nil {
+				return nil, false, err
+			}
+			created = true
+			// iSCSI pools cannot be built, for the other types the build must not destroy existing data
+			if opt.Type != StoragePoolTypeISCSI {
+				log.Printf("Building storage pool [%s] ...", opt.Name)
+				err = conn.StoragePoolBuild(pool, libvirt.StoragePoolBuildNoOverwrite)
+				if err != nil {
+					log.Printf("Unable to build storage pool [%s], assuming it exists already, cause: [%v]", opt.Name, err)
+				}
+			}
+		} else {
+			// make sure we do not take over a pool of a different type
+			xmlDesc, err := conn.StoragePoolGetXMLDesc(pool, 0)
+			if err != nil {
+				return nil, false, err
+			}
+			existing, err := parseStoragePoolXML(xmlDesc)
+			if err != nil {
+				return nil, false, err
+			}
+			if existing.Type != poolDef.Type {
+				return nil, false, fmt.Errorf("storage pool [%s] exists with type [%s], but type [%s] is requested", opt.Name, existing.Type, poolDef.Type)
+			}
+		}
+		if err = conn.StoragePoolSetAutostart(pool, 1); err != nil {
+			return nil, created, err
+		}
+		active, err := conn.StoragePoolIsActive(pool)
+		if err != nil {
+			return nil, created, err
+		}
+		if active == 0 {
+			log.Printf("Starting storage pool [%s] ...", opt.Name)
+			if err = conn.StoragePoolCreate(pool, 0); err != nil {
+				return nil, created, err
+			}
+		}
+		// refresh to report the current capacity
+		if err = refreshPool(conn)(pool); err != nil {
+			return nil, created, err
+		}
+		info, err := poolInfo(pool)
+		return info, created, err
+	}
+}
+
+// GetStoragePoolVolumes returns the names of the volumes on a storage pool
+func GetStoragePoolVolumes(client *LivirtClient) func(storagePool string) ([]string, error) {
+	conn := client.LibVirt
+
+	return func(storagePool string) ([]string, error) {
+		pool, err := conn.StoragePoolLookupByName(storagePool)
+		if err != nil {
+			if isError(err, libvirt.ErrNoStoragePool) {
+				return A.Empty[string](), nil
+			}
+			return nil, err
+		}
+		active, err := conn.StoragePoolIsActive(pool)
+		if err != nil || active == 0 {
+			return A.Empty[string](), err
+		}
+		volumes, _, err := conn.StoragePoolListAllVolumes(pool, NeedResults, 0)
+		if err != nil {
+			return nil, err
+		}
+		return A.MonadMap(volumes, getVolumeName), nil
+	}
+}
+
+// DeleteStoragePoolSync stops and undefines a managed storage pool, the underlying storage is retained. Only pools
+// that have been defined by the operator must be passed, adopted pools are left alone.
+func DeleteStoragePoolSync(client *LivirtClient) func(name string) error {
+	conn := client.LibVirt
+
+	return func(name string) error {
+		defer CM.EntryExit(fmt.Sprintf("DeleteStoragePoolSync(%s)", name))()
+
+		pool, err := conn.StoragePoolLookupByName(name)
+		if err != nil {
+			log.Printf("Storage pool [%s] cannot be located, assuming it's been deleted, cause: [%v]", name, err)
+			return nil
+		}
+		active, err := conn.StoragePoolIsActive(pool)
+		if err != nil {
+			return err
+		}
+		if active != 0 {
+			log.Printf("Stopping storage pool [%s] ...", name)
+			if err = conn.StoragePoolDestroy(pool); err != nil {
+				return err
+			}
+		}
+		log.Printf("Undefining storage pool [%s] ...", name)
+		return conn.StoragePoolUndefine(pool)
+	}
+}
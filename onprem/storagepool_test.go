@@ -0,0 +1,93 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateStoragePoolDefDir(t *testing.T) {
+	poolDef, err := CreateStoragePoolDef(&StoragePoolOptions{
+		Name: "images",
+		Type: StoragePoolTypeDir,
+		Path: "/var/lib/libvirt/images/hpcr",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "dir", poolDef.Type)
+	assert.Equal(t, "/var/lib/libvirt/images/hpcr", poolDef.Target.Path)
+	assert.Nil(t, poolDef.Source)
+
+	_, err = CreateStoragePoolDef(&StoragePoolOptions{
+		Name: "images",
+		Type: StoragePoolTypeDir,
+	})
+	assert.Error(t, err)
+}
+
+func TestCreateStoragePoolDefLogical(t *testing.T) {
+	poolDef, err := CreateStoragePoolDef(&StoragePoolOptions{
+		Name: "images",
+		Type: StoragePoolTypeLogical,
+		Source: &StoragePoolSourceSpec{
+			Devices: []string{"/dev/sdb", "/dev/sdc"},
+			Format:  "lvm2",
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "images", poolDef.Source.Name)
+	assert.Len(t, poolDef.Source.Device, 2)
+	assert.Equal(t, "lvm2", poolDef.Source.Format.Type)
+	assert.Equal(t, "/dev/images", poolDef.Target.Path)
+}
+
+func TestCreateStoragePoolDefNetFS(t *testing.T) {
+	poolDef, err := CreateStoragePoolDef(&StoragePoolOptions{
+		Name: "images",
+		Type: StoragePoolTypeNetFS,
+		Path: "/mnt/images",
+		Source: &StoragePoolSourceSpec{
+			Host: "nfs.example.com",
+			Dir:  "/exports/images",
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "nfs.example.com", poolDef.Source.Host[0].Name)
+	assert.Equal(t, "/exports/images", poolDef.Source.Dir.Path)
+	assert.Equal(t, "nfs", poolDef.Source.Format.Type)
+
+	_, err = CreateStoragePoolDef(&StoragePoolOptions{
+		Name: "images",
+		Type: StoragePoolTypeISCSI,
+	})
+	assert.Error(t, err)
+}
+
+func TestStoragePoolUsage(t *testing.T) {
+	info := StoragePoolInfo{
+		Capacity:  1000,
+		Available: 150,
+	}
+	assert.Equal(t, 85, info.Usage())
+
+	assert.Equal(t, 0, (&StoragePoolInfo{}).Usage())
+	// libvirt might report more available space than capacity, e.g. for shared file systems
+	assert.Equal(t, 0, (&StoragePoolInfo{Capacity: 1000, Available: 1200}).Usage())
+}
@@ -62,6 +62,15 @@ func parseStorageVolumeXML(s string) (*libvirtxml.StorageVolume, error) {
 	return &volumeDef, nil
 }
 
+func parseStoragePoolXML(s string) (*libvirtxml.StoragePool, error) {
+	var poolDef libvirtxml.StoragePool
+	err := xml.Unmarshal([]byte(s), &poolDef)
+	if err != nil {
+		return nil, err
+	}
+	return &poolDef, nil
+}
+
 func timeFromEpoch(str string) time.Time {
 	var s, ns int
 
@@ -137,6 +146,150 @@ func BoxNetworkName(name string) string {
 	return name
 }
 
+func BoxNetworkMode(mode string) string {
+	if len(mode) <= 0 {
+		return DefaultNetworkMode
+	}
+	return mode
+}
+
+func BoxStoragePoolType(poolType string) string {
+	if len(poolType) <= 0 {
+		return DefaultStoragePoolType
+	}
+	return poolType
+}
+
+func BoxCapacityWarningThreshold(threshold int) int {
+	if threshold <= 0 || threshold > 100 {
+		return DefaultCapacityWarningThreshold
+	}
+	return threshold
+}
+
+func BoxConsoleLogRetention(count int) int {
+	if count <= 0 {
+		return DefaultConsoleLogRetention
+	}
+	return count
+}
+
+func BoxReclaimPolicy(policy string) string {
+	if len(policy) <= 0 {
+		return DefaultReclaimPolicy
+	}
+	return policy
+}
+
+func BoxVolumeFormat(format string) string {
+	if len(format) <= 0 {
+		return DefaultVolumeFormat
+	}
+	return format
+}
+
+func BoxProvisioning(provisioning string) string {
+	if len(provisioning) <= 0 {
+		return DefaultProvisioning
+	}
+	return provisioning
+}
+
+func BoxProbeTimeoutSeconds(timeout int) int {
+	if timeout <= 0 {
+		return DefaultProbeTimeoutSeconds
+	}
+	return timeout
+}
+
+func BoxProbeFailureThreshold(threshold int) int {
+	if threshold <= 0 {
+		return DefaultProbeFailureThreshold
+	}
+	return threshold
+}
+
+func BoxProbeFailurePolicy(policy string) string {
+	if len(policy) <= 0 {
+		return DefaultProbeFailurePolicy
+	}
+	return policy
+}
+
+func BoxTerminationGracePeriodSeconds(seconds *int) int {
+	if seconds == nil || *seconds < 0 {
+		return DefaultTerminationGracePeriodSeconds
+	}
+	return *seconds
+}
+
+func BoxDriftPolicy(policy string) string {
+	if len(policy) <= 0 {
+		return DefaultDriftPolicy
+	}
+	return policy
+}
+
+func BoxSecureExecution(mode string) string {
+	if len(mode) <= 0 {
+		return DefaultSecureExecution
+	}
+	return mode
+}
+
+func BoxAddressing(addressing string) string {
+	if len(addressing) <= 0 {
+		return DefaultAddressing
+	}
+	return addressing
+}
+
+func BoxNamingScheme(scheme string) string {
+	if len(scheme) <= 0 {
+		return DefaultNamingScheme
+	}
+	return scheme
+}
+
+func BoxNetworks(networks []string) []string {
+	if len(networks) <= 0 {
+		return []string{DefaultNetwork}
+	}
+	return networks
+}
+
+// BoxPreflightOptions derives the pre-flight checks of a hypervisor host from its spec
+func BoxPreflightOptions(spec *HypervisorHostPreflightSpec) *PreflightOptions {
+	if spec == nil {
+		spec = &HypervisorHostPreflightSpec{}
+	}
+	return &PreflightOptions{
+		StoragePools: BoxStoragePools(spec.StoragePools),
+		Networks:     BoxNetworks(spec.Networks),
+	}
+}
+
+func BoxGarbageCollectionGracePeriodSeconds(seconds *int) int {
+	if seconds == nil || *seconds < 0 {
+		return DefaultGarbageCollectionGracePeriodSeconds
+	}
+	return *seconds
+}
+
+func BoxStoragePools(pools []string) []string {
+	if len(pools) <= 0 {
+		return []string{DefaultStoragePool}
+	}
+	return pools
+}
+
+func BoxPowerState(state string) string {
+	if len(state) <= 0 {
+		return DefaultPowerState
+	}
+	return state
+}
+
 func BoxDataDiskSize(size uint64) uint64 {
 	if size <= 0 {
 		return DefaultDataDiskSize
@@ -17,6 +17,7 @@ package onprem
 import (
 	"fmt"
 	"log"
+	"time"
 
 	libvirt "github.com/digitalocean/go-libvirt"
 	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
@@ -53,6 +54,46 @@ func deleteStorageVol(conn *libvirt.Libvirt) func(pool libvirt.StoragePool, name
 	}
 }
 
+// cloneStorageVol copies a volume into a new volume with the same capacity on the given pool
+func cloneStorageVol(conn *libvirt.Libvirt) func(pool libvirt.StoragePool, source libvirt.StorageVol, name string) (*libvirt.StorageVol, error) {
+	storageVolXMLDesc := getStorageVolXMLDesc(conn)
+	checkSpace := checkStoragePoolSpace(conn)
+
+	return func(pool libvirt.StoragePool, source libvirt.StorageVol, name string) (*libvirt.StorageVol, error) {
+		// log this config
+		defer CM.EntryExit(fmt.Sprintf("cloneStorageVol(%s, %s, %s)", pool.Name, source.Name, name))()
+		sourceXML, err := storageVolXMLDesc(&source)
+		if err != nil {
+			return nil, err
+		}
+		// make sure the copy fits
+		if size, ok := getVolumeSize(sourceXML); ok {
+			if err := checkSpace(pool, size); err != nil {
+				return nil, err
+			}
+		}
+		volumeDef := createDefaultVolume()
+		volumeDef.Name = name
+		volumeDef.Capacity = sourceXML.Capacity
+		// keep the format of the source, volumes of block based pools do not have a format
+		if sourceXML.Target != nil {
+			volumeDef.Target.Format = sourceXML.Target.Format
+		}
+		volumeDefXML, err := XMLMarshall(volumeDef)
+		if err != nil {
+			return nil, err
+		}
+		t0 := time.Now()
+		log.Printf("Cloning volume [%s] into volume [%s] on pool [%s] ...", source.Name, name, pool.Name)
+		volume, err := conn.StorageVolCreateXMLFrom(pool, volumeDefXML, source, 0)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Cloned volume [%s] into volume [%s] in [%f s].", source.Name, name, time.Since(t0).Seconds())
+		return &volume, nil
+	}
+}
+
 func getStorageVolByNameXMLDesc(conn *libvirt.Libvirt) func(pool libvirt.StoragePool, name string) (*libvirtxml.StorageVolume, error) {
 	storageVolXMLDesc := getStorageVolXMLDesc(conn)
 	return func(pool libvirt.StoragePool, name string) (*libvirtxml.StorageVolume, error) {
@@ -153,3 +194,26 @@ func deleteVolumeByKey(client *LivirtClient) func(key string) error {
 		return err
 	}
 }
+
+// hasVolume tests if a volume exists on a pool
+func hasVolume(conn *libvirt.Libvirt) func(pool libvirt.StoragePool, name string) bool {
+	return func(pool libvirt.StoragePool, name string) bool {
+		_, err := conn.StorageVolLookupByName(pool, name)
+		return err == nil
+	}
+}
+
+// createMarkerVolume creates an empty volume whose existence signals a state, e.g. an incomplete copy
+func createMarkerVolume(conn *libvirt.Libvirt) func(pool libvirt.StoragePool, name string) error {
+	return func(pool libvirt.StoragePool, name string) error {
+		volumeDef := createDefaultVolume()
+		volumeDef.Name = name
+
+		volumeDefXML, err := XMLMarshall(volumeDef)
+		if err != nil {
+			return err
+		}
+		_, err = conn.StorageVolCreateXML(pool, string(volumeDefXML), 0)
+		return err
+	}
+}
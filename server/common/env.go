@@ -21,6 +21,8 @@ import (
 
 	C "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
 	"github.com/ibm-hyper-protect/k8s-operator-hpcr/env"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 var (
@@ -28,8 +30,38 @@ var (
 	keySecret    = fmt.Sprintf("%s.%s", "Secret", C.K8SAPIVersion)
 )
 
+// matchesSelector tests if the labels of a resource match the selector
+func matchesSelector(selector labels.Selector) func(item map[string]any) bool {
+	return func(item map[string]any) bool {
+		itemLabels := make(labels.Set)
+		if metadata, ok := item["metadata"].(map[string]any); ok {
+			if lbls, ok := metadata["labels"].(map[string]any); ok {
+				for key, value := range lbls {
+					if strgVal, ok := value.(string); ok {
+						itemLabels[key] = strgVal
+					}
+				}
+			}
+		}
+		return selector.Matches(itemLabels)
+	}
+}
+
 // EnvFromConfigMapsOrSecrets merges all config maps into one
 func EnvFromConfigMapsOrSecrets(data map[string]any) env.Environment {
+	return envFromConfigMapsOrSecrets(data, matchesSelector(labels.Everything()))
+}
+
+// EnvFromConfigMapsOrSecretsBySelector merges the config maps and secrets with labels matching the selector into one
+func EnvFromConfigMapsOrSecretsBySelector(data map[string]any, selector *metav1.LabelSelector) (env.Environment, error) {
+	sel, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	return envFromConfigMapsOrSecrets(data, matchesSelector(sel)), nil
+}
+
+func envFromConfigMapsOrSecrets(data map[string]any, match func(item map[string]any) bool) env.Environment {
 	res := make(env.Environment)
 	if related, ok := data["related"].(map[string]any); ok {
 		// all config maps
@@ -37,7 +69,7 @@ func EnvFromConfigMapsOrSecrets(data map[string]any) env.Environment {
 			// iterate over all config maps and merge
 			for name, item := range configmaps {
 				log.Printf("Merging ConfigMap [%s] ...", name)
-				if configmap, ok := item.(map[string]any); ok {
+				if configmap, ok := item.(map[string]any); ok && match(configmap) {
 					// extract data
 					if configmapdata, ok := configmap["data"].(map[string]any); ok {
 						// merge
@@ -55,7 +87,7 @@ func EnvFromConfigMapsOrSecrets(data map[string]any) env.Environment {
 			// iterate over all config maps and merge
 			for name, item := range secrets {
 				log.Printf("Merging Secret [%s] ...", name)
-				if secret, ok := item.(map[string]any); ok {
+				if secret, ok := item.(map[string]any); ok && match(secret) {
 					// extract data
 					if secretdata, ok := secret["data"].(map[string]any); ok {
 						// merge
@@ -23,6 +23,7 @@ import (
 	"github.com/ibm-hyper-protect/k8s-operator-hpcr/vpc"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func readJson(name string) (map[string]any, error) {
@@ -61,3 +62,34 @@ func TestEnvFromConfigMaps2(t *testing.T) {
 	assert.Equal(t, "https://us-south-stage01.iaasdev.cloud.ibm.com", endpoint)
 	assert.Equal(t, "https://iam.test.cloud.ibm.com", iamEndpoint)
 }
+
+func TestEnvFromConfigMapsBySelector(t *testing.T) {
+	data := map[string]any{
+		"related": map[string]any{
+			keyConfigMap: map[string]any{
+				"default/vsi": map[string]any{
+					"metadata": map[string]any{
+						"labels": map[string]any{"app": "vsi"},
+					},
+					"data": map[string]any{"HOSTNAME": "vsi-host", "PORT": "22"},
+				},
+				"default/lpar1": map[string]any{
+					"metadata": map[string]any{
+						"labels": map[string]any{"host": "lpar1"},
+					},
+					"data": map[string]any{"HOSTNAME": "lpar1"},
+				},
+			},
+		},
+	}
+
+	env, err := EnvFromConfigMapsOrSecretsBySelector(data, &metav1.LabelSelector{
+		MatchLabels: map[string]string{"host": "lpar1"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "lpar1", env["HOSTNAME"])
+	assert.NotContains(t, env, "PORT")
+
+	all := EnvFromConfigMapsOrSecrets(data)
+	assert.Equal(t, "22", all["PORT"])
+}
@@ -0,0 +1,58 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package common
+
+import (
+	"time"
+
+	C "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// name of the component reporting the events
+	EventComponent = "k8s-operator-hpcr"
+	// label used by the metacontroller to select the children of a parent if `generateSelector` is set
+	LabelControllerUID = "controller-uid"
+)
+
+// CreateChildEvent creates an event on the involved object that is managed as a child resource of that object
+func CreateChildEvent(involved v1.ObjectReference, name, eventType, reason, message string, t time.Time) *v1.Event {
+	timestamp := metav1.NewTime(t)
+	return &v1.Event{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Event",
+			APIVersion: C.K8SAPIVersion,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: involved.Namespace,
+			Labels: map[string]string{
+				LabelControllerUID: string(involved.UID),
+			},
+		},
+		InvolvedObject: involved,
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source: v1.EventSource{
+			Component: EventComponent,
+		},
+		FirstTimestamp: timestamp,
+		LastTimestamp:  timestamp,
+		Count:          1,
+	}
+}
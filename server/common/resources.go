@@ -51,6 +51,17 @@ func createRelatedResourceRule(res RelatedResource) *RelatedResourceRule {
 	}
 }
 
+// CreateNamedRelatedResourceRule constructs a resource rule that selects resources by name instead of by label
+func CreateNamedRelatedResourceRule(apiVersion, resource string, names ...string) *RelatedResourceRule {
+	return &RelatedResourceRule{
+		ResourceRule: ResourceRule{
+			APIVersion: apiVersion,
+			Resource:   resource,
+		},
+		Names: names,
+	}
+}
+
 // isValidRelatedResource tests if a resource is valid
 func isValidRelatedResource(res RelatedResource) bool {
 	return F.IsNonNil(res.F3)
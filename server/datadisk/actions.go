@@ -15,8 +15,14 @@
 package datadisk
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"strings"
 
+	A "github.com/IBM/fp-go/array"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/env"
 	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
 	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
 	C "github.com/ibm-hyper-protect/terraform-provider-hpcr/contract"
@@ -24,11 +30,28 @@ import (
 )
 
 // createDataDiskReadyAction create the action
-func createDataDiskReadyAction(disk *libvirtxml.StorageVolume) (*common.ResourceStatus, error) {
+const (
+	// condition of a data disk whose requested size is smaller than its capacity
+	conditionShrinkRefused = "ShrinkRefused"
+)
+
+func createDataDiskReadyAction(client *onprem.LivirtClient, opt *onprem.DataDiskOptions, disk *libvirtxml.StorageVolume) (*common.ResourceStatus, error) {
 
 	// metadata to attach
 	metadata := C.RawMap{
-		"Name": disk.Name,
+		"Name":              disk.Name,
+		"requestedCapacity": onprem.GetDataDiskCapacity(opt.Size),
+	}
+	if disk.Capacity != nil {
+		metadata["capacity"] = disk.Capacity.Value
+	}
+	// report the VSIs the disk is attached to
+	getAttachments := onprem.GetDataDiskAttachments(client)
+	attachments, err := getAttachments(opt.StoragePool, opt.Name)
+	if err == nil {
+		metadata["attachments"] = attachments
+	} else {
+		log.Printf("Unable to get the attachments of the disk [%s], cause: [%v]", opt.Name, err)
 	}
 	// marshal the disk info into metadata
 	diskStrg, err := onprem.XMLMarshall(disk)
@@ -45,6 +68,15 @@ func createDataDiskReadyAction(disk *libvirtxml.StorageVolume) (*common.Resource
 	}, nil
 }
 
+// createShrinkRefusedAction reports a data disk that keeps its capacity because the requested size is smaller. The
+// disk stays ready, VSIs only consider ready disks and would otherwise detach it from the running workload.
+func createShrinkRefusedAction(client *onprem.LivirtClient, opt *onprem.DataDiskOptions, disk *libvirtxml.StorageVolume, err error) (*common.ResourceStatus, error) {
+	state, _ := createDataDiskReadyAction(client, opt, disk)
+	state.Description = err.Error()
+	state.Metadata["condition"] = conditionShrinkRefused
+	return state, nil
+}
+
 // CreateSyncAction synchronizes the state of the resource and determines what to do next
 func CreateSyncAction(client *onprem.LivirtClient, opt *onprem.DataDiskOptions) (*common.ResourceStatus, error) {
 	// checks for the validity of the data disk
@@ -52,13 +84,16 @@ func CreateSyncAction(client *onprem.LivirtClient, opt *onprem.DataDiskOptions)
 	diskXML, ok := isDataDiskValid(opt)
 	if ok {
 		// ready
-		return createDataDiskReadyAction(diskXML)
+		return createDataDiskReadyAction(client, opt, diskXML)
 	}
 	// create a disk (will resize if required)
 	diskSync := onprem.CreateDataDiskSync(client)
 	disk, err := diskSync(opt)
 	if err != nil {
 		log.Printf("Unable to create data disk [%s], cause: [%v]", opt.Name, err)
+		if errors.Is(err, onprem.ErrShrinkRefused) && diskXML != nil {
+			return createShrinkRefusedAction(client, opt, diskXML, err)
+		}
 		return common.CreateErrorAction(err)
 	}
 	// try to get the XML description
@@ -69,16 +104,41 @@ func CreateSyncAction(client *onprem.LivirtClient, opt *onprem.DataDiskOptions)
 		return common.CreateErrorAction(err)
 	}
 	// ready
-	return createDataDiskReadyAction(diskXML)
+	return createDataDiskReadyAction(client, opt, diskXML)
 }
 
-func CreateFinalizeAction(client *onprem.LivirtClient, opt *onprem.DataDiskOptions) (*common.ResourceStatus, error) {
-	// TODO proper check for existence comes here
-	// ...
-	// destroy the instance
-	deleteSync := onprem.DeleteDataDiskSync(client)
-	err := deleteSync(opt.StoragePool, opt.Name)
+func CreateFinalizeAction(client *onprem.LivirtClient, opt *onprem.DataDiskOptions, envMap env.Environment) (*common.ResourceStatus, error) {
+	// refuse deletion while the disk is in use
+	getDomains := onprem.GetVolumeDomains(client)
+	domains, err := getDomains(opt.StoragePool, opt.Name)
+	if err != nil {
+		log.Printf("Unable to check if data disk [%s] is in use, cause: [%v]", opt.Name, err)
+		return common.CreateErrorAction(err)
+	}
+	if A.IsNonEmpty(domains) {
+		log.Printf("Data disk [%s] is still attached to the domains %v, waiting ...", opt.Name, domains)
+		return &common.ResourceStatus{
+			Status:      common.Waiting,
+			Description: fmt.Sprintf("Data disk is still attached to the domains [%s]", strings.Join(domains, ", ")),
+		}, nil
+	}
+	// delete or keep the volume
+	err = reclaimDataDisk(client, opt, envMap)
+	if errors.Is(err, errReclaimRunning) {
+		return &common.ResourceStatus{
+			Status:      common.Waiting,
+			Description: err.Error(),
+		}, nil
+	}
 	if err != nil {
+		if errors.Is(err, onprem.ErrVolumeExists) || errors.Is(err, onprem.ErrInsufficientStorage) {
+			// the volume is still there, so retry instead of losing track of it
+			log.Printf("Unable to reclaim data disk [%s], waiting, cause: [%v]", opt.Name, err)
+			return &common.ResourceStatus{
+				Status:      common.Waiting,
+				Description: err.Error(),
+			}, nil
+		}
 		return common.CreateErrorAction(err)
 	}
 	// done
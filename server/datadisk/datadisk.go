@@ -79,7 +79,7 @@ func finalizeDataDisk(req map[string]any) (*common.ResourceStatus, error) {
 		return common.CreateErrorAction(err)
 	}
 
-	return CreateFinalizeAction(client, opt)
+	return CreateFinalizeAction(client, opt, env)
 }
 
 func CreateControllerSyncRoute() gin.HandlerFunc {
@@ -24,9 +24,17 @@ import (
 func dataDiskOptionsFromConfigMap(data *DataDiskConfigResource, envMap env.Environment) (*onprem.DataDiskOptions, error) {
 	spec := data.Parent.Spec
 	opt := &onprem.DataDiskOptions{
-		Name:        string(data.Parent.UID),
-		StoragePool: onprem.BoxStoragePool(spec.StoragePool),
-		Size:        onprem.BoxDataDiskSize(spec.Size),
+		Name:          string(data.Parent.UID),
+		StoragePool:   onprem.BoxStoragePool(spec.StoragePool),
+		Size:          onprem.BoxDataDiskSize(spec.Size),
+		RestoreFrom:   spec.RestoreFrom,
+		ReclaimPolicy: onprem.BoxReclaimPolicy(spec.ReclaimPolicy),
+		RetainedName:  onprem.GetRetainedVolumeName(data.Parent.Namespace, data.Parent.Name),
+		Format:        onprem.BoxVolumeFormat(spec.Format),
+		Provisioning:  onprem.BoxProvisioning(spec.Provisioning),
+	}
+	if data.Parent.DeletionTimestamp != nil {
+		opt.Deleted = data.Parent.DeletionTimestamp.Time
 	}
 	return opt, nil
 }
@@ -0,0 +1,123 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package datadisk
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/env"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+)
+
+// reclaimJob tracks the copy of a deleted data disk that runs in the background, the copy of a large volume spans
+// several finalize calls
+type reclaimJob struct {
+	// the copy has finished
+	done bool
+	// result of a finished copy
+	err error
+}
+
+var (
+	// errReclaimRunning signals that the copy of a deleted data disk is still running
+	errReclaimRunning = errors.New("reclaim running")
+
+	reclaimJobsLock sync.Mutex
+	// copies keyed by the name of the data disk volume
+	reclaimJobs = make(map[string]*reclaimJob)
+)
+
+// getReclaimJob returns a copy of the state of the reclaim of a data disk volume
+func getReclaimJob(volume string) (reclaimJob, bool) {
+	reclaimJobsLock.Lock()
+	defer reclaimJobsLock.Unlock()
+
+	job, ok := reclaimJobs[volume]
+	if !ok {
+		return reclaimJob{}, false
+	}
+	return *job, true
+}
+
+// endReclaimJob forgets the reclaim of a data disk volume
+func endReclaimJob(volume string) {
+	reclaimJobsLock.Lock()
+	defer reclaimJobsLock.Unlock()
+
+	delete(reclaimJobs, volume)
+}
+
+// startReclaimJob reclaims the data disk volume in the background. The job uses its own connection to the host, the
+// connection of the finalize call is closed when the call returns.
+func startReclaimJob(opt *onprem.DataDiskOptions, envMap env.Environment) {
+	reclaimJobsLock.Lock()
+	defer reclaimJobsLock.Unlock()
+
+	if _, ok := reclaimJobs[opt.Name]; ok {
+		return
+	}
+	job := &reclaimJob{}
+	reclaimJobs[opt.Name] = job
+
+	finish := func(err error) {
+		reclaimJobsLock.Lock()
+		defer reclaimJobsLock.Unlock()
+
+		job.done = true
+		job.err = err
+	}
+
+	go func() {
+		client, err := onprem.CreateLivirtClientFromEnvMap(envMap)
+		if err != nil {
+			finish(err)
+			return
+		}
+		defer client.Close()
+
+		reclaim := onprem.ReclaimDataDisk(client)
+		err = reclaim(opt)
+		if err != nil {
+			log.Printf("Unable to reclaim data disk [%s], cause: [%v]", opt.Name, err)
+		}
+		finish(err)
+	}()
+}
+
+// reclaimDataDisk deletes the volume of a data disk right away or copies it in the background according to its
+// reclaim policy. It returns [errReclaimRunning] for as long as the copy runs and the result of the copy once it
+// has finished.
+func reclaimDataDisk(client *onprem.LivirtClient, opt *onprem.DataDiskOptions, envMap env.Environment) error {
+	target, err := onprem.GetReclaimVolumeName(opt)
+	if err != nil {
+		return err
+	}
+	if target == "" {
+		reclaim := onprem.ReclaimDataDisk(client)
+		return reclaim(opt)
+	}
+	job, ok := getReclaimJob(opt.Name)
+	if ok && job.done {
+		endReclaimJob(opt.Name)
+		return job.err
+	}
+	if !ok {
+		startReclaimJob(opt, envMap)
+	}
+	return fmt.Errorf("%w: keeping data disk [%s] as volume [%s]", errReclaimRunning, opt.Name, target)
+}
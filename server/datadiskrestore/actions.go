@@ -0,0 +1,103 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package datadiskrestore
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	C "github.com/ibm-hyper-protect/terraform-provider-hpcr/contract"
+)
+
+const (
+	// key into the status metadata for the name of the restored data disk
+	keyDataDisk = "dataDisk"
+	// key into the status metadata, marks a restored data disk that is no longer owned by the restore
+	keyReleased = "released"
+)
+
+// restoredDataDiskFromStatus returns the name of the restored data disk once the restore released it
+func restoredDataDiskFromStatus(parent *onprem.DataDiskRestoreCustomResource) (string, bool) {
+	released, _ := parent.Status.Metadata[keyReleased].(bool)
+	name, _ := parent.Status.Metadata[keyDataDisk].(string)
+	return name, released && name != ""
+}
+
+// createRestoredStatus reports a data disk that has been restored and released
+func createRestoredStatus(parent *onprem.DataDiskRestoreCustomResource, name string) (*common.ResourceStatus, error) {
+	return &common.ResourceStatus{
+		Status:      common.Ready,
+		Description: fmt.Sprintf("Data disk [%s] has been restored", name),
+		Error:       nil,
+		Metadata: C.RawMap{
+			keyDataDisk: name,
+			keyReleased: true,
+			"volume":    parent.Status.Metadata["volume"],
+		},
+	}, nil
+}
+
+func createRestoreStatus(disk *onprem.DataDiskCustomResource) (*common.ResourceStatus, []*DataDiskChild, error) {
+	if common.Status(disk.Status.Status) != common.Ready {
+		return &common.ResourceStatus{
+			Status:      common.Waiting,
+			Description: fmt.Sprintf("Waiting for data disk [%s] to be restored", disk.Name),
+		}, []*DataDiskChild{dataDiskFromChild(disk)}, nil
+	}
+	// the data disk must outlive the restore, so it no longer matches the selector of the restore. This releases
+	// the data disk from the ownership of the restore with the next sync.
+	log.Printf("Releasing the restored data disk [%s] ...", disk.Name)
+	return &common.ResourceStatus{
+		Status:      common.Waiting,
+		Description: fmt.Sprintf("Releasing the restored data disk [%s]", disk.Name),
+		Error:       nil,
+		Metadata: C.RawMap{
+			keyDataDisk: disk.Name,
+			keyReleased: true,
+			"volume":    string(disk.UID),
+		},
+	}, []*DataDiskChild{releaseDataDiskChild(disk)}, nil
+}
+
+func CreateSyncAction(cfg *DataDiskRestoreConfigResource, snapshots []*onprem.DataDiskSnapshotCustomResource, observed *onprem.DataDiskCustomResource) (*common.ResourceStatus, []*DataDiskChild, error) {
+	// release a data disk that has been restored before, even if the snapshot is gone
+	if observed != nil {
+		return createRestoreStatus(observed)
+	}
+	// the restore is done once, the released data disk is no longer observed
+	if name, ok := restoredDataDiskFromStatus(&cfg.Parent); ok {
+		state, err := createRestoredStatus(&cfg.Parent, name)
+		return state, []*DataDiskChild{}, err
+	}
+	if len(snapshots) != 1 {
+		return &common.ResourceStatus{
+			Status:      common.Waiting,
+			Description: fmt.Sprintf("Waiting for the snapshot selector to match exactly one ready snapshot, found [%d]", len(snapshots)),
+		}, []*DataDiskChild{}, nil
+	}
+	disk, err := dataDiskFromSnapshot(cfg, snapshots[0])
+	if err != nil {
+		log.Printf("Unable to restore snapshot [%s], cause: [%v]", snapshots[0].Name, err)
+		state, err := common.CreateErrorAction(err)
+		return state, []*DataDiskChild{}, err
+	}
+	log.Printf("Restoring snapshot [%s] into data disk [%s] ...", snapshots[0].Name, disk.Name)
+	return &common.ResourceStatus{
+		Status:      common.Waiting,
+		Description: fmt.Sprintf("Restoring snapshot [%s] into data disk [%s]", snapshots[0].Name, disk.Name),
+	}, []*DataDiskChild{disk}, nil
+}
@@ -0,0 +1,67 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package datadiskrestore
+
+import (
+	"testing"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func createTestRestore() *DataDiskRestoreConfigResource {
+	return &DataDiskRestoreConfigResource{
+		Parent: onprem.DataDiskRestoreCustomResource{
+			ObjectMeta: metav1.ObjectMeta{Name: "restored", Namespace: "default", UID: "1234"},
+		},
+	}
+}
+
+func TestCreateSyncActionReleasesRestoredDataDisk(t *testing.T) {
+	cfg := createTestRestore()
+	observed := &onprem.DataDiskCustomResource{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "restored",
+			Namespace: "default",
+			UID:       "5678",
+			Labels:    map[string]string{common.LabelControllerUID: "1234", "app": "hpcr"},
+		},
+	}
+
+	// the data disk stays owned until it is ready
+	state, children, err := CreateSyncAction(cfg, nil, observed)
+	require.NoError(t, err)
+	assert.Equal(t, common.Waiting, state.Status)
+	require.Len(t, children, 1)
+	assert.Equal(t, "1234", children[0].Labels[common.LabelControllerUID])
+
+	// a ready data disk is released from the selector of the restore
+	observed.Status.Status = int(common.Ready)
+	state, children, err = CreateSyncAction(cfg, nil, observed)
+	require.NoError(t, err)
+	require.Len(t, children, 1)
+	assert.Equal(t, map[string]string{"app": "hpcr"}, children[0].Labels)
+
+	// once released the restore is done and no longer emits the data disk
+	cfg.Parent.Status.Metadata = state.Metadata
+	state, children, err = CreateSyncAction(cfg, nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, common.Ready, state.Status)
+	assert.Empty(t, children)
+	assert.Equal(t, "restored", state.Metadata[keyDataDisk])
+}
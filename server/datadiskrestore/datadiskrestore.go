@@ -0,0 +1,164 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package datadiskrestore
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/datadisksnapshot"
+)
+
+func CreatePingRoute(version, compileTime string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"version": version,
+			"compile": compileTime,
+		})
+	}
+}
+
+// syncDataDiskRestore is invoked to synchronize the state of our resource, it does not need access to libvirt
+// because the restore is carried out by the data disk child
+func syncDataDiskRestore(req map[string]any) (*common.ResourceStatus, []*DataDiskChild, error) {
+	cfg, err := common.Transcode[*DataDiskRestoreConfigResource](req)
+	if err != nil {
+		state, err := common.CreateErrorAction(err)
+		return state, []*DataDiskChild{}, err
+	}
+
+	// the data disk has been restored before
+	observed, ok := dataDiskFromChildren(req, cfg.Parent.Name)
+	if ok {
+		return CreateSyncAction(cfg, nil, observed)
+	}
+
+	snapshots, err := onprem.SnapshotsFromRelated(req)
+	if err != nil {
+		state, err := common.CreateErrorAction(err)
+		return state, []*DataDiskChild{}, err
+	}
+
+	return CreateSyncAction(cfg, snapshots, nil)
+}
+
+func CreateControllerSyncRoute() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		// log this config
+		defer CM.EntryExit("DataDiskRestoreCreateControllerSyncRoute")()
+
+		log.Printf("synchronizing data disk restore ...")
+		jsonData, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		// decode the input
+		var req map[string]any
+		err = json.Unmarshal(jsonData, &req)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		// execute and handle
+		state, children, err := syncDataDiskRestore(req)
+		if err != nil {
+			log.Printf("Error [%v]", err)
+			// switch into error mode
+			resp := common.ResourceStatusToResponse(state)
+			resp["children"] = children
+			c.JSON(http.StatusOK, resp)
+			// bail out
+			return
+		}
+		// done
+		resp := common.ResourceStatusToResponse(state)
+		resp["children"] = children
+		// set a retry if we are not ready, yet
+		if state.Status != common.Ready {
+			resp["resyncAfterSeconds"] = 10
+		}
+		// done
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func CreateControllerCustomizeRoute() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// log this config
+		defer CM.EntryExit("DataDiskRestoreCreateControllerCustomizeRoute")()
+		// parse body
+		jsonData, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		// decode the input
+		var req map[string]any
+		err = json.Unmarshal(jsonData, &req)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		// transcode to the expected format
+		cfg, err := common.Transcode[*DataDiskRestoreConfigResource](req)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		// print namespace
+		log.Printf("Getting related resources for [%s] in namespace [%s] ...", cfg.Parent.Name, cfg.Parent.Namespace)
+		// produce a response
+		resp := common.CustomizeHookResponse{
+			RelatedResourceRules: common.CreateRelatedResourceRules([]common.RelatedResource{
+				// config
+				common.RefConfigMaps(cfg.Parent.Spec.TargetSelector),
+				common.RefSecrets(cfg.Parent.Spec.TargetSelector),
+				// snapshot
+				datadisksnapshot.RefDataDiskSnapshots(cfg.Parent.Spec.SnapshotSelector),
+			}),
+		}
+		// dump it
+		data, err := json.Marshal(resp)
+		if err == nil {
+			log.Printf("customize response [%s]", string(data))
+		}
+
+		// done
+		c.JSON(http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package datadiskrestore
+
+import (
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// dataDiskFromSnapshot produces the data disk that restores the snapshot
+func dataDiskFromSnapshot(data *DataDiskRestoreConfigResource, snapshot *onprem.DataDiskSnapshotCustomResource) (*DataDiskChild, error) {
+	meta, err := common.Transcode[*snapshotMetadata](snapshot.Status.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	parent := data.Parent
+	storagePool := parent.Spec.StoragePool
+	if storagePool == "" {
+		storagePool = meta.StoragePool
+	}
+	labels := make(map[string]string)
+	for k, v := range parent.Spec.DiskLabels {
+		labels[k] = v
+	}
+	labels[common.LabelControllerUID] = string(parent.UID)
+
+	return &DataDiskChild{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       onprem.KindDataDisk,
+			APIVersion: onprem.APIVersion,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      parent.Name,
+			Namespace: parent.Namespace,
+			Labels:    labels,
+		},
+		Spec: onprem.DataDiskCustomResourceSpec{
+			Size:        meta.Capacity,
+			StoragePool: storagePool,
+			RestoreFrom: &onprem.DataDiskRestoreSource{
+				StoragePool: meta.StoragePool,
+				VolumeName:  meta.Volume,
+			},
+			TargetSelector: parent.Spec.TargetSelector,
+		},
+	}, nil
+}
+
+// dataDiskFromChild reproduces the desired state of a data disk that has been restored before
+// releaseDataDiskChild removes the label that matches the selector of the restore from the data disk
+func releaseDataDiskChild(disk *onprem.DataDiskCustomResource) *DataDiskChild {
+	child := dataDiskFromChild(disk)
+	labels := make(map[string]string)
+	for k, v := range disk.Labels {
+		if k != common.LabelControllerUID {
+			labels[k] = v
+		}
+	}
+	child.Labels = labels
+	return child
+}
+
+func dataDiskFromChild(disk *onprem.DataDiskCustomResource) *DataDiskChild {
+	return &DataDiskChild{
+		TypeMeta: disk.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      disk.Name,
+			Namespace: disk.Namespace,
+			Labels:    disk.Labels,
+		},
+		Spec: disk.Spec,
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package datadiskrestore
+
+import (
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+)
+
+// dataDiskFromChildren locates the restored data disk in the observed children
+func dataDiskFromChildren(req map[string]any, name string) (*onprem.DataDiskCustomResource, bool) {
+	children, ok := req["children"].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	disks, ok := children[onprem.KeyDiskConfig].(map[string]any)
+	if !ok {
+		return nil, false
+	}
+	disk, ok := disks[name]
+	if !ok {
+		return nil, false
+	}
+	res, err := common.Transcode[*onprem.DataDiskCustomResource](disk)
+	if err != nil {
+		return nil, false
+	}
+	return res, true
+}
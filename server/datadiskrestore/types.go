@@ -0,0 +1,40 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package datadiskrestore
+
+import (
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type (
+	DataDiskRestoreConfigResource struct {
+		Parent onprem.DataDiskRestoreCustomResource `json:"parent"`
+	}
+
+	// DataDiskChild is the data disk created by a restore
+	DataDiskChild struct {
+		metav1.TypeMeta   `json:",inline"`
+		metav1.ObjectMeta `json:"metadata,omitempty"`
+		Spec              onprem.DataDiskCustomResourceSpec `json:"spec"`
+	}
+
+	// snapshotMetadata is the status metadata reported by a snapshot
+	snapshotMetadata struct {
+		Volume      string `json:"volume"`
+		StoragePool string `json:"storagePool"`
+		Capacity    uint64 `json:"capacity"`
+	}
+)
@@ -0,0 +1,183 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package datadisksnapshot
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/env"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/lock"
+	C "github.com/ibm-hyper-protect/terraform-provider-hpcr/contract"
+	"libvirt.org/go/libvirtxml"
+)
+
+const (
+	// key into the status metadata, marks a snapshot whose volume has been removed by the retention policy
+	keyPruned = "pruned"
+)
+
+var (
+	errSnapshotPruned = errors.New("snapshot pruned")
+)
+
+func createSnapshotReadyAction(opt *onprem.DataDiskSnapshotOptions, snapshot *libvirtxml.StorageVolume, exported string, running *exportJob) (*common.ResourceStatus, error) {
+	metadata := C.RawMap{
+		"volume":      opt.Name,
+		"storagePool": opt.StoragePool,
+	}
+	if snapshot.Capacity != nil {
+		metadata["capacity"] = snapshot.Capacity.Value
+	}
+	if opt.DataDisk != "" {
+		metadata["dataDisk"] = opt.DataDisk
+	}
+	description := fmt.Sprintf("Snapshot [%s] is available on pool [%s]", opt.Name, opt.StoragePool)
+	if exported != "" {
+		metadata["exported"] = exported
+		description = fmt.Sprintf("%s and has been exported as [%s]", description, exported)
+	}
+	if running != nil {
+		// the snapshot can be restored while it is being exported
+		metadata[keyExport] = createExportProgress(*running)
+		description = fmt.Sprintf("%s, exported [%d] of [%d] bytes as [%s]", description, running.exported, running.size, running.key)
+	}
+	return &common.ResourceStatus{
+		Status:      common.Ready,
+		Description: description,
+		Error:       nil,
+		Metadata:    metadata,
+	}, nil
+}
+
+func CreateSyncAction(client *onprem.LivirtClient, opt *onprem.DataDiskSnapshotOptions, taken bool, export *onprem.DataDiskSnapshotExportSpec, exported string, envMap env.Environment) (*common.ResourceStatus, error) {
+	// check if we took the snapshot before
+	isSnapshotAvailable := onprem.IsSnapshotAvailable(client)
+	snapshot, ok := isSnapshotAvailable(opt.StoragePool, opt.Name)
+	if !ok {
+		if taken {
+			// the snapshot has been taken before but is gone
+			err := fmt.Errorf("snapshot volume [%s] does not exist on pool [%s] any more", opt.Name, opt.StoragePool)
+			log.Printf("Snapshot is not available, cause: [%v]", err)
+			return common.CreateErrorAction(err)
+		}
+		// the VSI sync must not change the power state of a domain that is quiesced for the snapshot
+		if !lock.Lock.TryLock() {
+			log.Println("Snapshot: waiting for lock ...")
+			return common.CreateStatusAction(common.Waiting)
+		}
+		defer lock.Lock.Unlock()
+		// take the snapshot
+		snapshotSync := onprem.CreateSnapshotSync(client)
+		var err error
+		snapshot, err = snapshotSync(opt)
+		if err != nil {
+			log.Printf("Unable to create snapshot [%s], cause: [%v]", opt.Name, err)
+			return common.CreateErrorAction(err)
+		}
+	}
+	// export the snapshot once
+	exported, running, err := syncExport(opt, export, exported, envMap)
+	if err != nil {
+		return common.CreateErrorAction(err)
+	}
+	// ready
+	return createSnapshotReadyAction(opt, snapshot, exported, running)
+}
+
+// syncExport starts the export of the snapshot in the background and collects its result. It returns the key of the
+// finished export and the state of a running export.
+func syncExport(opt *onprem.DataDiskSnapshotOptions, export *onprem.DataDiskSnapshotExportSpec, exported string, envMap env.Environment) (string, *exportJob, error) {
+	if export == nil {
+		return exported, nil, nil
+	}
+	key := export.Key
+	if key == "" {
+		key = opt.Name
+	}
+	if exported == key {
+		return exported, nil, nil
+	}
+	job, ok := getExportJob(opt.Name)
+	if ok && !job.done {
+		return exported, &job, nil
+	}
+	if ok {
+		endExportJob(opt.Name)
+		if job.err != nil {
+			return exported, nil, job.err
+		}
+		if job.key == key {
+			return key, nil, nil
+		}
+	}
+	exporter, err := onprem.CreateSnapshotExporter(export, envMap)
+	if err != nil {
+		return exported, nil, err
+	}
+	startExportJob(opt, exporter, key, envMap)
+	job, _ = getExportJob(opt.Name)
+	return exported, &job, nil
+}
+
+// CreatePruneAction deletes the volume of a snapshot that has been superseded by newer snapshots according to the
+// retention policy. The resource keeps reporting the pruned volume, so restores no longer select it.
+func CreatePruneAction(client *onprem.LivirtClient, opt *onprem.DataDiskSnapshotOptions) (*common.ResourceStatus, error) {
+	log.Printf("Pruning snapshot [%s] from pool [%s] according to the retention [%d] ...", opt.Name, opt.StoragePool, opt.Retention)
+	deleteSync := onprem.DeleteSnapshotSync(client)
+	if err := deleteSync(opt.StoragePool, opt.Name); err != nil {
+		log.Printf("Unable to prune snapshot [%s], cause: [%v]", opt.Name, err)
+		return common.CreateErrorAction(err)
+	}
+	return createPrunedAction(opt)
+}
+
+// createPrunedAction reports a snapshot whose volume has been removed by the retention policy
+func createPrunedAction(opt *onprem.DataDiskSnapshotOptions) (*common.ResourceStatus, error) {
+	err := fmt.Errorf("%w: snapshot [%s] has been pruned, at least [%d] newer snapshots of the data disk exist", errSnapshotPruned, opt.Name, opt.Retention)
+	return &common.ResourceStatus{
+		Status:      common.Error,
+		Description: err.Error(),
+		Error:       err,
+		Metadata: C.RawMap{
+			"volume":      opt.Name,
+			"storagePool": opt.StoragePool,
+			"dataDisk":    opt.DataDisk,
+			keyPruned:     true,
+		},
+	}, err
+}
+
+func CreateFinalizeAction(client *onprem.LivirtClient, opt *onprem.DataDiskSnapshotOptions) (*common.ResourceStatus, error) {
+	// the volume is in use by the export
+	if isExportRunning(opt.Name) {
+		return &common.ResourceStatus{
+			Status:      common.Waiting,
+			Description: fmt.Sprintf("Waiting for the export of snapshot [%s] to finish", opt.Name),
+		}, nil
+	}
+	endExportJob(opt.Name)
+	// delete the snapshot volume
+	deleteSync := onprem.DeleteSnapshotSync(client)
+	err := deleteSync(opt.StoragePool, opt.Name)
+	if err != nil {
+		return common.CreateErrorAction(err)
+	}
+	// done
+	return common.CreateReadyAction()
+}
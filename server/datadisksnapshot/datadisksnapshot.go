@@ -0,0 +1,284 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package datadisksnapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/datadisk"
+	C "github.com/ibm-hyper-protect/terraform-provider-hpcr/contract"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func CreatePingRoute(version, compileTime string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"version": version,
+			"compile": compileTime,
+		})
+	}
+}
+
+// syncDataDiskSnapshot is invoked to synchronize the state of our resource
+func syncDataDiskSnapshot(req map[string]any) (*common.ResourceStatus, error) {
+	// assemble all information about the environment by merging the config maps
+	env := common.EnvFromConfigMapsOrSecrets(req)
+
+	client, err := onprem.CreateLivirtClientFromEnvMap(env)
+	if err != nil {
+		return common.CreateErrorAction(err)
+	}
+	defer client.Close()
+
+	cfg, err := common.Transcode[*DataDiskSnapshotConfigResource](req)
+	if err != nil {
+		return common.CreateErrorAction(err)
+	}
+
+	opt, taken := snapshotOptionsFromStatus(cfg)
+	if taken {
+		if pruned, _ := cfg.Parent.Status.Metadata[keyPruned].(bool); pruned {
+			return createPrunedAction(opt)
+		}
+		// the other snapshots of the namespace, the volume is removed once enough newer snapshots exist
+		snapshots, err := onprem.SnapshotsFromRelated(req)
+		if err != nil {
+			return common.CreateErrorAction(err)
+		}
+		if onprem.IsSnapshotSuperseded(opt.Name, opt.DataDisk, opt.Retention)(snapshots) && !isExportRunning(opt.Name) {
+			return CreatePruneAction(client, opt)
+		}
+	} else {
+		// a new snapshot requires the data disk
+		dataDisks, err := onprem.DataDisksFromRelated(req)
+		if err != nil {
+			return common.CreateErrorAction(err)
+		}
+		if len(dataDisks) != 1 {
+			return &common.ResourceStatus{
+				Status:      common.Waiting,
+				Description: fmt.Sprintf("Waiting for the disk selector to match exactly one ready data disk, found [%d]", len(dataDisks)),
+			}, nil
+		}
+		opt = snapshotOptionsFromDataDisk(cfg, dataDisks[0])
+	}
+	exported, _ := cfg.Parent.Status.Metadata["exported"].(string)
+
+	return CreateSyncAction(client, opt, taken, cfg.Parent.Spec.Export, exported, env)
+}
+
+// previousMetadataFromRequest returns the status metadata of the resource as reported by the last sync
+func previousMetadataFromRequest(req map[string]any) C.RawMap {
+	cfg, err := common.Transcode[*DataDiskSnapshotConfigResource](req)
+	if err != nil {
+		return nil
+	}
+	return cfg.Parent.Status.Metadata
+}
+
+func finalizeDataDiskSnapshot(req map[string]any) (*common.ResourceStatus, error) {
+
+	env := common.EnvFromConfigMapsOrSecrets(req)
+
+	client, err := onprem.CreateLivirtClientFromEnvMap(env)
+	if err != nil {
+		return common.CreateErrorAction(err)
+	}
+	defer client.Close()
+
+	cfg, err := common.Transcode[*DataDiskSnapshotConfigResource](req)
+	if err != nil {
+		return common.CreateErrorAction(err)
+	}
+
+	opt, ok := snapshotOptionsFromStatus(cfg)
+	if !ok {
+		// the snapshot has never been taken
+		return common.CreateReadyAction()
+	}
+
+	return CreateFinalizeAction(client, opt)
+}
+
+func CreateControllerSyncRoute() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		// log this config
+		defer CM.EntryExit("DataDiskSnapshotCreateControllerSyncRoute")()
+
+		log.Printf("synchronizing data disk snapshot ...")
+		jsonData, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		// decode the input
+		var req map[string]any
+		err = json.Unmarshal(jsonData, &req)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		// log the request
+		// log.Printf("JSON Input [%s]", string(jsonData))
+		// execute and handle
+		state, err := syncDataDiskSnapshot(req)
+		// keep the information about an existing snapshot
+		if state.Metadata == nil {
+			state.Metadata = previousMetadataFromRequest(req)
+		}
+		if err != nil {
+			log.Printf("Error [%v]", err)
+			// switch into error mode
+			c.JSON(http.StatusOK, common.ResourceStatusToResponse(state))
+			// bail out
+			return
+		}
+		// done
+		resp := common.ResourceStatusToResponse(state)
+		// set a retry if we are not ready, yet, or to report the progress of the export
+		if _, exporting := state.Metadata[keyExport]; state.Status != common.Ready || exporting {
+			resp["resyncAfterSeconds"] = 10
+		}
+		// done
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func CreateControllerFinalizeRoute() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		// log this config
+		defer CM.EntryExit("DataDiskSnapshotCreateControllerFinalizeRoute")()
+
+		log.Printf("finalizing ...")
+
+		jsonData, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		var req map[string]any
+		err = json.Unmarshal(jsonData, &req)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		// execute and handle
+		state, err := finalizeDataDiskSnapshot(req)
+		if err != nil {
+			log.Printf("Error [%v]", err)
+			// keep the finalizer and report the error, the deletion is retried
+			resp := gin.H{
+				"finalized":          false,
+				"resyncAfterSeconds": 10,
+			}
+			maps.Copy(resp, common.ResourceStatusToResponse(state))
+			c.JSON(http.StatusOK, resp)
+			// bail out
+			return
+		}
+		// done finalizing
+		finalized := state.Status == common.Ready
+		resp := gin.H{
+			"finalized": finalized,
+		}
+		if !finalized {
+			resp["resyncAfterSeconds"] = 10
+		}
+		// final response
+		c.JSON(http.StatusOK, resp)
+		log.Printf("Finalized: [%t]", finalized)
+	}
+}
+
+// CreateControllerCustomizeRoute is invoked to
+func CreateControllerCustomizeRoute() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// log this config
+		defer CM.EntryExit("DataDiskSnapshotCreateControllerCustomizeRoute")()
+		// parse body
+		jsonData, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		// decode the input
+		var req map[string]any
+		err = json.Unmarshal(jsonData, &req)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		// transcode to the expected format
+		cfg, err := common.Transcode[*DataDiskSnapshotConfigResource](req)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		// print namespace
+		log.Printf("Getting related resources for [%s] in namespace [%s] ...", cfg.Parent.Name, cfg.Parent.Namespace)
+		// produce a response
+		resp := common.CustomizeHookResponse{
+			RelatedResourceRules: common.CreateRelatedResourceRules([]common.RelatedResource{
+				// config
+				common.RefConfigMaps(cfg.Parent.Spec.TargetSelector),
+				common.RefSecrets(cfg.Parent.Spec.TargetSelector),
+				// data disk
+				datadisk.RefDataDisks(cfg.Parent.Spec.DiskSelector),
+				// all snapshots of the namespace, for the retention policy
+				RefDataDiskSnapshots(&metav1.LabelSelector{}),
+			}),
+		}
+		// dump it
+		data, err := json.Marshal(resp)
+		if err == nil {
+			log.Printf("customize response [%s]", string(data))
+		}
+
+		// done
+		c.JSON(http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package datadisksnapshot
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/env"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+)
+
+// key into the status metadata for the progress of a running export
+const keyExport = "export"
+
+// exportJob tracks the export of a snapshot volume that runs in the background, the export of a large volume spans
+// several syncs
+type exportJob struct {
+	// object key of the export
+	key string
+	// number of bytes exported so far
+	exported int64
+	// total number of bytes
+	size int64
+	// start of the export
+	started time.Time
+	// the export has finished
+	done bool
+	// result of a finished export
+	err error
+}
+
+// exportProgress is the progress of an export reported in the status
+type exportProgress struct {
+	Key      string `json:"key"`
+	Exported int64  `json:"exported"`
+	Size     int64  `json:"size"`
+	Started  string `json:"started"`
+}
+
+var (
+	exportJobsLock sync.Mutex
+	// exports keyed by the name of the snapshot volume
+	exportJobs = make(map[string]*exportJob)
+)
+
+// getExportJob returns a copy of the state of the export of a snapshot volume
+func getExportJob(volume string) (exportJob, bool) {
+	exportJobsLock.Lock()
+	defer exportJobsLock.Unlock()
+
+	job, ok := exportJobs[volume]
+	if !ok {
+		return exportJob{}, false
+	}
+	return *job, true
+}
+
+// isExportRunning tests if the snapshot volume is being exported
+func isExportRunning(volume string) bool {
+	job, ok := getExportJob(volume)
+	return ok && !job.done
+}
+
+// endExportJob forgets the export of a snapshot volume
+func endExportJob(volume string) {
+	exportJobsLock.Lock()
+	defer exportJobsLock.Unlock()
+
+	delete(exportJobs, volume)
+}
+
+// startExportJob exports the snapshot volume in the background. The job uses its own connection to the host, the
+// connection of the sync is closed when the sync returns.
+func startExportJob(opt *onprem.DataDiskSnapshotOptions, exporter onprem.SnapshotExporter, key string, envMap env.Environment) {
+	exportJobsLock.Lock()
+	defer exportJobsLock.Unlock()
+
+	if _, ok := exportJobs[opt.Name]; ok {
+		return
+	}
+	job := &exportJob{key: key, started: time.Now()}
+	exportJobs[opt.Name] = job
+
+	progress := func(exported, size int64) {
+		exportJobsLock.Lock()
+		defer exportJobsLock.Unlock()
+
+		job.exported = exported
+		job.size = size
+	}
+	finish := func(err error) {
+		exportJobsLock.Lock()
+		defer exportJobsLock.Unlock()
+
+		job.done = true
+		job.err = err
+	}
+
+	go func() {
+		client, err := onprem.CreateLivirtClientFromEnvMap(envMap)
+		if err != nil {
+			finish(err)
+			return
+		}
+		defer client.Close()
+
+		exportSnapshot := onprem.ExportSnapshot(client)
+		err = exportSnapshot(opt.StoragePool, opt.Name, exporter, key, progress)
+		if err != nil {
+			log.Printf("Unable to export snapshot [%s], cause: [%v]", opt.Name, err)
+		}
+		finish(err)
+	}()
+}
+
+// createExportProgress reports the progress of a running export
+func createExportProgress(job exportJob) exportProgress {
+	return exportProgress{
+		Key:      job.key,
+		Exported: job.exported,
+		Size:     job.size,
+		Started:  job.started.UTC().Format(time.RFC3339),
+	}
+}
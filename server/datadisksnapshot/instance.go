@@ -0,0 +1,46 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package datadisksnapshot
+
+import (
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+)
+
+// snapshotOptionsFromStatus returns the options of a snapshot that has been taken before, the data disk is not required any more
+func snapshotOptionsFromStatus(data *DataDiskSnapshotConfigResource) (*onprem.DataDiskSnapshotOptions, bool) {
+	volume, ok := data.Parent.Status.Metadata["volume"].(string)
+	if !ok || volume == "" {
+		return nil, false
+	}
+	storagePool, _ := data.Parent.Status.Metadata["storagePool"].(string)
+	dataDisk, _ := data.Parent.Status.Metadata["dataDisk"].(string)
+	return &onprem.DataDiskSnapshotOptions{
+		Name:        volume,
+		StoragePool: onprem.BoxStoragePool(storagePool),
+		DataDisk:    dataDisk,
+		Retention:   data.Parent.Spec.Retention,
+	}, true
+}
+
+// snapshotOptionsFromDataDisk returns the options to take a new snapshot of the data disk
+func snapshotOptionsFromDataDisk(data *DataDiskSnapshotConfigResource, disk *onprem.DataDiskCustomResource) *onprem.DataDiskSnapshotOptions {
+	dataDisk := string(disk.UID)
+	return &onprem.DataDiskSnapshotOptions{
+		Name:        onprem.GetSnapshotVolumeName(dataDisk, data.Parent.CreationTimestamp.Time, string(data.Parent.UID)),
+		StoragePool: onprem.BoxStoragePool(disk.Spec.StoragePool),
+		DataDisk:    dataDisk,
+		Retention:   data.Parent.Spec.Retention,
+	}
+}
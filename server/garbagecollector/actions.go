@@ -0,0 +1,157 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package garbagecollector
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/image"
+	onpremserver "github.com/ibm-hyper-protect/k8s-operator-hpcr/server/onprem"
+	C "github.com/ibm-hyper-protect/terraform-provider-hpcr/contract"
+)
+
+const (
+	// key into the status metadata for the orphans that have not been deleted
+	keyOrphans = "orphans"
+	// key into the status metadata for the base images uploaded by the operator
+	keyBaseImages = "baseImages"
+	// key into the status metadata for the cached base images and their references
+	keyBaseImageCache = "baseImageCache"
+	// key into the status metadata for the orphans deleted by the last run
+	keyDeleted = "deleted"
+)
+
+// getPreviousOrphans returns the orphans reported by the previous run
+func getPreviousOrphans(parent *onprem.GarbageCollectorCustomResource) []onprem.Orphan {
+	orphans, err := common.Transcode[[]onprem.Orphan](parent.Status.Metadata[keyOrphans])
+	if err != nil {
+		return nil
+	}
+	return orphans
+}
+
+// getPreviousBaseImages returns the base images known from previous runs
+func getPreviousBaseImages(parent *onprem.GarbageCollectorCustomResource) []onprem.BaseImage {
+	images, err := common.Transcode[[]onprem.BaseImage](parent.Status.Metadata[keyBaseImages])
+	if err != nil {
+		return nil
+	}
+	return images
+}
+
+// createGarbageCollectionOptions derives the objects in use from the existing VSIs and the staged images
+func createGarbageCollectionOptions(parent *onprem.GarbageCollectorCustomResource, vsis []*onprem.OnPremCustomResource, images []*onprem.ImageCustomResource) *onprem.GarbageCollectionOptions {
+	instances := make(map[string]bool)
+	var used []onprem.BaseImage
+	for _, vsi := range vsis {
+		instances[string(vsi.UID)] = true
+		// the name of the domain depends on the naming scheme, adopted domains keep their names
+		if name, ok := onpremserver.DomainNameFromStatus(vsi); ok {
+			instances[name] = true
+		}
+		if name, ok := onpremserver.AdoptedDomainFromStatus(vsi); ok {
+			instances[name] = true
+		}
+		if vsi.Spec.ImageURL != "" {
+			used = append(used, onprem.GetBaseImage(vsi.Spec.StoragePool, vsi.Spec.ImageURL))
+		}
+	}
+	// staged images are kept until they are retired
+	var pinned []onprem.BaseImage
+	for _, img := range images {
+		if !img.Spec.Retired {
+			pinned = append(pinned, onprem.GetPinnedBaseImages(image.StagingFromStatus(img))...)
+		}
+	}
+	return &onprem.GarbageCollectionOptions{
+		StoragePools:     onprem.BoxStoragePools(parent.Spec.StoragePools),
+		Instances:        instances,
+		BaseImages:       onprem.MergeBaseImages(getPreviousBaseImages(parent), used),
+		UsedBaseImages:   used,
+		PinnedBaseImages: pinned,
+	}
+}
+
+func removeBaseImage(images []onprem.BaseImage, orphan onprem.Orphan) []onprem.BaseImage {
+	var result []onprem.BaseImage
+	for _, image := range images {
+		if image.StoragePool != orphan.StoragePool || image.Name != orphan.Name {
+			result = append(result, image)
+		}
+	}
+	return result
+}
+
+// CreateSyncAction detects orphaned libvirt objects and deletes those whose grace period has elapsed
+func CreateSyncAction(client *onprem.LivirtClient, parent *onprem.GarbageCollectorCustomResource, vsis []*onprem.OnPremCustomResource, images []*onprem.ImageCustomResource) (*common.ResourceStatus, error) {
+	findOrphans := onprem.FindOrphans(client)
+	deleteOrphan := onprem.DeleteOrphan(client)
+
+	opt := createGarbageCollectionOptions(parent, vsis, images)
+	found, err := findOrphans(opt)
+	if err != nil {
+		log.Printf("Unable to find orphans, cause: [%v]", err)
+		return common.CreateErrorAction(err)
+	}
+	now := time.Now().UTC()
+	orphans := onprem.MergeOrphans(getPreviousOrphans(parent), found, now)
+	baseImages := opt.BaseImages
+
+	var remaining, deleted []onprem.Orphan
+	if parent.Spec.DeleteOrphans {
+		gracePeriod := time.Duration(onprem.BoxGarbageCollectionGracePeriodSeconds(parent.Spec.GracePeriodSeconds)) * time.Second
+		// domains come first, so their volumes are no longer in use
+		for _, orphan := range orphans {
+			if !onprem.IsOrphanDue(orphan, now, gracePeriod) {
+				remaining = append(remaining, orphan)
+				continue
+			}
+			if err := deleteOrphan(orphan); err != nil {
+				log.Printf("Unable to delete the orphaned [%s] [%s], cause: [%v]", orphan.Kind, orphan.Name, err)
+				remaining = append(remaining, orphan)
+				continue
+			}
+			if orphan.Kind == onprem.OrphanKindBaseImage {
+				baseImages = removeBaseImage(baseImages, orphan)
+			}
+			deleted = append(deleted, orphan)
+		}
+	} else {
+		remaining = orphans
+	}
+	for _, orphan := range remaining {
+		log.Printf("Found orphaned [%s] [%s] in pool [%s], first seen at [%s]", orphan.Kind, orphan.Name, orphan.StoragePool, orphan.FirstSeen)
+	}
+	// the cached base images and the number of volumes backed by them
+	cache, err := onprem.ListBaseImageCache(client)(opt.StoragePools)
+	if err != nil {
+		log.Printf("Unable to list the base image cache, cause: [%v]", err)
+	}
+
+	return common.CreateAction(&common.ResourceStatus{
+		Status:      common.Ready,
+		Description: fmt.Sprintf("Found [%d] orphans, deleted [%d]", len(remaining), len(deleted)),
+		Metadata: C.RawMap{
+			keyOrphans:        remaining,
+			keyBaseImages:     baseImages,
+			keyBaseImageCache: cache,
+			keyDeleted:        deleted,
+		},
+	})
+}
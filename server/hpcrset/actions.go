@@ -0,0 +1,149 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package hpcrset
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	C "github.com/ibm-hyper-protect/terraform-provider-hpcr/contract"
+)
+
+// createSetStatus summarizes the state of the replicas
+func createSetStatus(replicas, ready, current, updated int) *common.ResourceStatus {
+	status := common.Waiting
+	if ready == replicas && current == replicas && updated == replicas {
+		status = common.Ready
+	}
+	return &common.ResourceStatus{
+		Status:      status,
+		Description: fmt.Sprintf("[%d] of [%d] replicas ready, [%d] replicas exist, [%d] replicas updated", ready, replicas, current, updated),
+		Metadata: C.RawMap{
+			"replicas":        replicas,
+			"readyReplicas":   ready,
+			"currentReplicas": current,
+			"updatedReplicas": updated,
+		},
+	}
+}
+
+// retainObservedChildren keeps all existing children, so errors do not delete any VSI
+func retainObservedChildren(cfg *SetConfigResource) []*Child {
+	children := []*Child{}
+	for _, byName := range cfg.Children {
+		for _, child := range byName {
+			children = append(children, retainChild(child))
+		}
+	}
+	return children
+}
+
+// CreateSyncAction computes the status of the set and its desired children. Data disks of replicas removed by
+// scaling down are retained, so scaling up again reattaches the data. With an ordered rollout a changed template
+// is applied to one replica at a time, the next replica is updated once all of its predecessors are ready.
+func CreateSyncAction(fl *Flavour, cfg *SetConfigResource) (*common.ResourceStatus, []*Child, error) {
+	parent := &cfg.Parent
+	if !fl.DataDisks && len(parent.Spec.DataDiskTemplates) > 0 {
+		state, err := common.CreateErrorAction(fmt.Errorf("data disk templates are not supported for [%s]", fl.Kind))
+		return state, retainObservedChildren(cfg), err
+	}
+	if len(parent.Spec.DataDiskTemplates) > 0 && parent.Spec.Template.Spec["hostSelector"] != nil {
+		// the data disks would have to follow the VSIs to the hosts they are scheduled on
+		state, err := common.CreateErrorAction(errors.New("data disk templates are not supported for VSIs with a host selector"))
+		return state, retainObservedChildren(cfg), err
+	}
+	replicas := BoxReplicas(parent.Spec.Replicas)
+	ordered := BoxRollout(parent.Spec.Rollout) == RolloutOrderedReady
+
+	vsis := cfg.Children[childKey(fl.Kind, fl.APIVersion)]
+	disks := cfg.Children[childKey(onprem.KindDataDisk, onprem.APIVersion)]
+
+	children := []*Child{}
+	emitted := make(map[string]bool)
+	emit := func(child *Child) {
+		children = append(children, child)
+		emitted[child.Name] = true
+	}
+
+	// the desired replicas
+	ready := 0
+	updated := 0
+	predecessorsReady := true
+	updating := false
+	for ordinal := range replicas {
+		observed, exists := vsis[GetReplicaName(parent.Name, ordinal)]
+		if !exists && ordered && !predecessorsReady {
+			// wait for the predecessors before creating the next replica
+			continue
+		}
+		desired := createVSIChild(fl, parent, ordinal)
+		if exists && !isChildUpdated(observed, desired) && ordered && (updating || !predecessorsReady) {
+			// keep the previous template until it is the turn of this replica
+			emit(retainChild(observed))
+		} else {
+			if exists && !isChildUpdated(observed, desired) {
+				log.Printf("Updating replica [%s] of set [%s] to the current template", desired.Name, parent.Name)
+				updating = true
+			} else {
+				updated++
+			}
+			emit(desired)
+		}
+		dataDisks, err := createDataDiskChildren(parent, ordinal)
+		if err != nil {
+			state, err := common.CreateErrorAction(err)
+			return state, retainObservedChildren(cfg), err
+		}
+		for _, disk := range dataDisks {
+			emit(disk)
+		}
+		isReady := exists && isChildReady(observed)
+		if isReady {
+			ready++
+		}
+		predecessorsReady = predecessorsReady && isReady
+	}
+
+	// replicas beyond the desired number
+	var surplus []int
+	for name := range vsis {
+		if ordinal, ok := GetReplicaOrdinal(parent.Name, name); ok && ordinal >= replicas {
+			surplus = append(surplus, ordinal)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(surplus)))
+	if ordered && len(surplus) > 1 {
+		// remove the replica with the highest ordinal first, keep the others
+		for _, ordinal := range surplus[1:] {
+			emit(retainChild(vsis[GetReplicaName(parent.Name, ordinal)]))
+		}
+	}
+	if len(surplus) > 0 {
+		log.Printf("Scaling down set [%s] to [%d] replicas, surplus replicas %v", parent.Name, replicas, surplus)
+	}
+
+	// retain the data disks of removed replicas
+	for name, disk := range disks {
+		if !emitted[name] {
+			emit(retainChild(disk))
+		}
+	}
+
+	return createSetStatus(replicas, ready, len(vsis), updated), children, nil
+}
@@ -0,0 +1,239 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package hpcrset
+
+import (
+	"testing"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func createTestSet(replicas int, rollout string) *SetConfigResource {
+	return &SetConfigResource{
+		Parent: SetCustomResource{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web",
+				Namespace: "default",
+				UID:       "1234",
+			},
+			Spec: SetCustomResourceSpec{
+				Replicas: &replicas,
+				Rollout:  rollout,
+				Template: VSITemplate{
+					ObjectMeta: metav1.ObjectMeta{
+						Labels: map[string]string{"app": "web"},
+					},
+					Spec: map[string]any{
+						"contract": "contract",
+						"targetSelector": map[string]any{
+							"matchLabels": map[string]any{"host": "lpar1"},
+						},
+					},
+				},
+			},
+		},
+		Children: make(map[string]map[string]*Child),
+	}
+}
+
+func addTestVSI(cfg *SetConfigResource, ordinal int, status common.Status) {
+	key := childKey(onprem.KindVSI, onprem.APIVersion)
+	if cfg.Children[key] == nil {
+		cfg.Children[key] = make(map[string]*Child)
+	}
+	child := createVSIChild(FlavourOnPrem, &cfg.Parent, ordinal)
+	child.Status = &ChildStatus{Status: int(status)}
+	cfg.Children[key][child.Name] = child
+}
+
+func childNames(children []*Child) []string {
+	var names []string
+	for _, child := range children {
+		names = append(names, child.Name)
+	}
+	return names
+}
+
+func TestGetReplicaOrdinal(t *testing.T) {
+	ordinal, ok := GetReplicaOrdinal("web", "web-12")
+	assert.True(t, ok)
+	assert.Equal(t, 12, ordinal)
+
+	_, ok = GetReplicaOrdinal("web", "web-db-0")
+	assert.False(t, ok)
+}
+
+func TestOrderedRolloutWaitsForPredecessors(t *testing.T) {
+	cfg := createTestSet(3, "")
+
+	state, children, err := CreateSyncAction(FlavourOnPrem, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web-0"}, childNames(children))
+	assert.Equal(t, common.Waiting, state.Status)
+
+	addTestVSI(cfg, 0, common.Waiting)
+	_, children, err = CreateSyncAction(FlavourOnPrem, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web-0"}, childNames(children))
+
+	addTestVSI(cfg, 0, common.Ready)
+	_, children, err = CreateSyncAction(FlavourOnPrem, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web-0", "web-1"}, childNames(children))
+}
+
+func TestParallelRolloutCreatesAllReplicas(t *testing.T) {
+	cfg := createTestSet(3, RolloutParallel)
+
+	_, children, err := CreateSyncAction(FlavourOnPrem, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web-0", "web-1", "web-2"}, childNames(children))
+	assert.Equal(t, "web", children[2].Labels["app"])
+	assert.Equal(t, "web-2", children[2].Labels[LabelReplicaName])
+	assert.Equal(t, "1234", children[2].Labels[common.LabelControllerUID])
+}
+
+func TestOrderedScaleDownRemovesHighestOrdinalFirst(t *testing.T) {
+	cfg := createTestSet(1, RolloutOrderedReady)
+	for ordinal := range 3 {
+		addTestVSI(cfg, ordinal, common.Ready)
+	}
+
+	state, children, err := CreateSyncAction(FlavourOnPrem, cfg)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"web-0", "web-1"}, childNames(children))
+	assert.Equal(t, common.Waiting, state.Status)
+	assert.Equal(t, 3, state.Metadata["currentReplicas"])
+}
+
+func TestReadyWhenAllReplicasReady(t *testing.T) {
+	cfg := createTestSet(2, RolloutOrderedReady)
+	addTestVSI(cfg, 0, common.Ready)
+	addTestVSI(cfg, 1, common.Ready)
+
+	state, _, err := CreateSyncAction(FlavourOnPrem, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, common.Ready, state.Status)
+}
+
+func TestDataDiskTemplates(t *testing.T) {
+	cfg := createTestSet(2, RolloutParallel)
+	cfg.Parent.Spec.DataDiskTemplates = []DataDiskTemplate{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "data"},
+			Spec:       onprem.DataDiskCustomResourceSpec{Size: 1024},
+		},
+	}
+
+	_, children, err := CreateSyncAction(FlavourOnPrem, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web-0", "data-web-0", "web-1", "data-web-1"}, childNames(children))
+
+	// the VSI selects its own data disks only
+	selector, ok := children[0].Spec["diskSelector"].(*metav1.LabelSelector)
+	require.True(t, ok)
+	assert.Equal(t, "web-0", selector.MatchLabels[LabelReplicaName])
+	// the data disk inherits the target selector of the VSIs
+	assert.NotNil(t, children[1].Spec["targetSelector"])
+
+	// scaling down retains the data disks
+	key := childKey(onprem.KindDataDisk, onprem.APIVersion)
+	cfg.Children[key] = map[string]*Child{children[3].Name: children[3]}
+	replicas := 1
+	cfg.Parent.Spec.Replicas = &replicas
+	_, children, err = CreateSyncAction(FlavourOnPrem, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"web-0", "data-web-0", "data-web-1"}, childNames(children))
+}
+
+func TestHostSelectorRejectsDataDiskTemplates(t *testing.T) {
+	cfg := createTestSet(1, "")
+	cfg.Parent.Spec.Template.Spec["hostSelector"] = map[string]any{
+		"matchLabels": map[string]any{"storage": "fast"},
+	}
+	cfg.Parent.Spec.DataDiskTemplates = []DataDiskTemplate{{ObjectMeta: metav1.ObjectMeta{Name: "data"}}}
+	addTestVSI(cfg, 0, common.Ready)
+
+	state, children, err := CreateSyncAction(FlavourOnPrem, cfg)
+	assert.Error(t, err)
+	assert.Equal(t, common.Error, state.Status)
+	assert.Len(t, children, 1)
+}
+
+func TestVPCRejectsDataDiskTemplates(t *testing.T) {
+	cfg := createTestSet(1, "")
+	cfg.Parent.Spec.DataDiskTemplates = []DataDiskTemplate{{ObjectMeta: metav1.ObjectMeta{Name: "data"}}}
+	addTestVSI(cfg, 0, common.Ready)
+
+	state, children, err := CreateSyncAction(FlavourVPC, cfg)
+	assert.Error(t, err)
+	assert.Equal(t, common.Error, state.Status)
+	// errors must not delete existing VSIs
+	assert.Len(t, children, 1)
+}
+
+func TestOrderedRolloutUpdatesOneReplicaAtATime(t *testing.T) {
+	cfg := createTestSet(3, RolloutOrderedReady)
+	for ordinal := range 3 {
+		addTestVSI(cfg, ordinal, common.Ready)
+	}
+	previous := func(child *Child) string {
+		return cfg.Children[childKey(onprem.KindVSI, onprem.APIVersion)][child.Name].Annotations[AnnotationTemplateHash]
+	}
+
+	// change the template
+	cfg.Parent.Spec.Template.Spec["contract"] = "updated"
+	state, children, err := CreateSyncAction(FlavourOnPrem, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, common.Waiting, state.Status)
+	assert.Equal(t, 0, state.Metadata["updatedReplicas"])
+	assert.Equal(t, "updated", children[0].Spec["contract"])
+	assert.Equal(t, previous(children[1]), children[1].Annotations[AnnotationTemplateHash])
+	assert.Equal(t, previous(children[2]), children[2].Annotations[AnnotationTemplateHash])
+
+	// the updated replica reports the status of its previous spec
+	addTestVSI(cfg, 0, common.Ready)
+	updatedVSI := cfg.Children[childKey(onprem.KindVSI, onprem.APIVersion)]["web-0"]
+	updatedVSI.Generation = 2
+	updatedVSI.Status.ObservedGeneration = 1
+	_, children, err = CreateSyncAction(FlavourOnPrem, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, previous(children[1]), children[1].Annotations[AnnotationTemplateHash])
+
+	// the updated replica is ready, the next one follows
+	updatedVSI.Status.ObservedGeneration = 2
+	state, children, err = CreateSyncAction(FlavourOnPrem, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, 1, state.Metadata["updatedReplicas"])
+	assert.Equal(t, "updated", children[1].Spec["contract"])
+	assert.Equal(t, previous(children[2]), children[2].Annotations[AnnotationTemplateHash])
+}
+
+func TestParallelRolloutUpdatesAllReplicas(t *testing.T) {
+	cfg := createTestSet(2, RolloutParallel)
+	for ordinal := range 2 {
+		addTestVSI(cfg, ordinal, common.Ready)
+	}
+
+	cfg.Parent.Spec.Template.Spec["contract"] = "updated"
+	_, children, err := CreateSyncAction(FlavourOnPrem, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "updated", children[0].Spec["contract"])
+	assert.Equal(t, "updated", children[1].Spec["contract"])
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package hpcrset
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+)
+
+func CreatePingRoute(version, compileTime string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"version": version,
+			"compile": compileTime,
+		})
+	}
+}
+
+// CreateControllerSyncRoute synchronizes the VSIs of a set of the given flavour, it does not need access to
+// the hypervisor or the cloud because the VSIs are managed by their own controllers
+func CreateControllerSyncRoute(fl *Flavour) gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		// log this config
+		defer CM.EntryExit("SetCreateControllerSyncRoute")()
+
+		log.Printf("synchronizing set of [%s] ...", fl.Kind)
+		jsonData, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		// decode the input
+		var req map[string]any
+		err = json.Unmarshal(jsonData, &req)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		// a request that cannot be decoded must not change the children
+		cfg, err := common.Transcode[*SetConfigResource](req)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		// execute and handle
+		state, children, err := CreateSyncAction(fl, cfg)
+		if err != nil {
+			log.Printf("Error [%v]", err)
+			// switch into error mode
+			resp := common.ResourceStatusToResponse(state)
+			resp["children"] = children
+			c.JSON(http.StatusOK, resp)
+			// bail out
+			return
+		}
+		// done
+		resp := common.ResourceStatusToResponse(state)
+		resp["children"] = children
+		// set a retry if we are not ready, yet
+		if state.Status != common.Ready {
+			resp["resyncAfterSeconds"] = 10
+		}
+		// done
+		c.JSON(http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package hpcrset
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BoxReplicas returns the number of replicas or the default
+func BoxReplicas(replicas *int) int {
+	if replicas == nil {
+		return DefaultReplicas
+	}
+	return max(*replicas, 0)
+}
+
+// BoxRollout returns the rollout or the default
+func BoxRollout(rollout string) string {
+	if rollout == "" {
+		return DefaultRollout
+	}
+	return rollout
+}
+
+// GetReplicaName returns the name of the VSI with the given ordinal
+func GetReplicaName(set string, ordinal int) string {
+	return fmt.Sprintf("%s-%d", set, ordinal)
+}
+
+// GetReplicaOrdinal returns the ordinal of a VSI of the set
+func GetReplicaOrdinal(set, name string) (int, bool) {
+	suffix, ok := strings.CutPrefix(name, set+"-")
+	if !ok {
+		return 0, false
+	}
+	ordinal, err := strconv.Atoi(suffix)
+	if err != nil || ordinal < 0 {
+		return 0, false
+	}
+	return ordinal, true
+}
+
+// GetDataDiskName returns the name of the data disk of a replica
+func GetDataDiskName(template, replica string) string {
+	return fmt.Sprintf("%s-%s", template, replica)
+}
+
+// childKey returns the key of the children of the given type in the observed state
+func childKey(kind, apiVersion string) string {
+	return fmt.Sprintf("%s.%s", kind, apiVersion)
+}
+
+// createChildLabels produces the labels of a child of the set
+func createChildLabels(parent *SetCustomResource, template map[string]string, replica string) map[string]string {
+	labels := maps.Clone(template)
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[LabelSetName] = parent.Name
+	labels[LabelReplicaName] = replica
+	labels[common.LabelControllerUID] = string(parent.UID)
+	return labels
+}
+
+// createReplicaDiskSelector selects the data disks of a replica
+func createReplicaDiskSelector(parent *SetCustomResource, replica string) *metav1.LabelSelector {
+	return &metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			LabelSetName:     parent.Name,
+			LabelReplicaName: replica,
+		},
+	}
+}
+
+// createVSIChild produces the VSI with the given ordinal
+func createVSIChild(fl *Flavour, parent *SetCustomResource, ordinal int) *Child {
+	replica := GetReplicaName(parent.Name, ordinal)
+	template := parent.Spec.Template
+	spec := maps.Clone(template.Spec)
+	if spec == nil {
+		spec = make(map[string]any)
+	}
+	// each replica only sees its own data disks
+	if fl.DataDisks && len(parent.Spec.DataDiskTemplates) > 0 {
+		spec["diskSelector"] = createReplicaDiskSelector(parent, replica)
+	}
+	child := &Child{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       fl.Kind,
+			APIVersion: fl.APIVersion,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        replica,
+			Namespace:   parent.Namespace,
+			Labels:      createChildLabels(parent, template.Labels, replica),
+			Annotations: maps.Clone(template.Annotations),
+		},
+		Spec: spec,
+	}
+	if child.Annotations == nil {
+		child.Annotations = make(map[string]string)
+	}
+	child.Annotations[AnnotationTemplateHash] = createChildHash(child)
+	return child
+}
+
+// createChildHash computes the hash of the desired state of a VSI, so changes of the template can be detected
+func createChildHash(child *Child) string {
+	h := sha256.New()
+	for _, value := range []any{child.Spec, child.Labels, child.Annotations} {
+		// maps are marshalled with sorted keys, so the hash is stable
+		data, err := json.Marshal(value)
+		if err == nil {
+			h.Write(data)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isChildUpdated tests if an observed VSI has been created from the same template as the desired VSI
+func isChildUpdated(observed, desired *Child) bool {
+	return observed.Annotations[AnnotationTemplateHash] == desired.Annotations[AnnotationTemplateHash]
+}
+
+// createDataDiskChildren produces the data disks of the replica with the given ordinal
+func createDataDiskChildren(parent *SetCustomResource, ordinal int) ([]*Child, error) {
+	replica := GetReplicaName(parent.Name, ordinal)
+	var result []*Child
+	for _, template := range parent.Spec.DataDiskTemplates {
+		diskSpec := template.Spec
+		// the data disks live on the host of the VSIs by default
+		if diskSpec.TargetSelector == nil {
+			selector, err := common.Transcode[*metav1.LabelSelector](parent.Spec.Template.Spec["targetSelector"])
+			if err != nil {
+				return nil, err
+			}
+			diskSpec.TargetSelector = selector
+		}
+		spec, err := common.Transcode[map[string]any](diskSpec)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &Child{
+			TypeMeta: metav1.TypeMeta{
+				Kind:       onprem.KindDataDisk,
+				APIVersion: onprem.APIVersion,
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      GetDataDiskName(template.Name, replica),
+				Namespace: parent.Namespace,
+				Labels:    createChildLabels(parent, template.Labels, replica),
+			},
+			Spec: spec,
+		})
+	}
+	return result, nil
+}
+
+// retainChild reproduces the desired state of an observed child
+func retainChild(child *Child) *Child {
+	return &Child{
+		TypeMeta: child.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        child.Name,
+			Namespace:   child.Namespace,
+			Labels:      child.Labels,
+			Annotations: child.Annotations,
+		},
+		Spec: child.Spec,
+	}
+}
+
+// isChildReady tests if an observed child reports a ready status for its current spec
+func isChildReady(child *Child) bool {
+	if child.Status == nil || common.Status(child.Status.Status) != common.Ready {
+		return false
+	}
+	// a status that predates the last change of the spec does not count
+	return child.Status.ObservedGeneration == 0 || child.Status.ObservedGeneration >= child.Generation
+}
@@ -0,0 +1,140 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package hpcrset
+
+import (
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// the replicas are created one after the other, each one after its predecessor is ready, and removed in reverse order
+	RolloutOrderedReady = "OrderedReady"
+	// all replicas are created and removed at once
+	RolloutParallel = "Parallel"
+	// rollout of the replicas
+	DefaultRollout = RolloutOrderedReady
+	// number of replicas
+	DefaultReplicas = 1
+
+	// label carrying the name of the set on all children
+	LabelSetName = "hpse.ibm.com/set-name"
+	// label carrying the name of the replica on the VSI and its data disks
+	LabelReplicaName = "hpse.ibm.com/replica-name"
+	// annotation carrying the hash of the template a VSI has been created from
+	AnnotationTemplateHash = "hpse.ibm.com/template-hash"
+
+	// kind of the VSI resources in IBM Cloud VPC
+	KindVPC = "HyperProtectContainerRuntimeVPC"
+)
+
+type (
+	// VSITemplate describes the VSIs of a set, similar to the pod template of a replica set
+	VSITemplate struct {
+		// labels and annotations of the VSIs
+		metav1.ObjectMeta `json:"metadata,omitempty"`
+		// specification of the VSIs, the spec of the VSI resource of the flavour of the set
+		Spec map[string]any `json:"spec"`
+	}
+
+	// DataDiskTemplate describes a data disk created for each replica, similar to a volume claim template
+	DataDiskTemplate struct {
+		// name and labels of the data disk, the name of the disk of a replica is `<name>-<replica>`
+		metav1.ObjectMeta `json:"metadata,omitempty"`
+		// specification of the data disk
+		Spec onprem.DataDiskCustomResourceSpec `json:"spec"`
+	}
+
+	SetCustomResourceSpec struct {
+		// number of VSIs, defaults to 1
+		Replicas *int `json:"replicas,omitempty"`
+		// template of the VSIs
+		Template VSITemplate `json:"template"`
+		// templates of the data disks of each replica, onprem only
+		DataDiskTemplates []DataDiskTemplate `json:"dataDiskTemplates,omitempty"`
+		// rollout of the replicas, one of `OrderedReady` (default) or `Parallel`
+		Rollout string `json:"rollout,omitempty"`
+	}
+
+	SetStatus struct {
+		// description of the set status
+		Description string `json:"description"`
+		// the status flag
+		Status int `json:"status"`
+	}
+
+	SetCustomResource struct {
+		metav1.TypeMeta `json:",inline"`
+		// Standard object's metadata.
+		// More info: https://git.k8s.io/community/contributors/devel/sig-architecture/api-conventions.md#metadata
+		// +optional
+		metav1.ObjectMeta `json:"metadata,omitempty" protobuf:"bytes,1,opt,name=metadata"`
+
+		// Specification of the desired behavior of the set.
+		// +optional
+		Spec SetCustomResourceSpec `json:"spec,omitempty" protobuf:"bytes,2,opt,name=spec"`
+
+		// status of this custom resource
+		Status SetStatus `json:"status,omitempty"`
+	}
+
+	// ChildStatus is the part of the status common to VSIs and data disks
+	ChildStatus struct {
+		// description of the child status
+		Description string `json:"description"`
+		// the status flag
+		Status int `json:"status"`
+		// generation of the child the status has been computed for, maintained by the metacontroller
+		ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	}
+
+	// Child is a VSI or data disk owned by a set
+	Child struct {
+		metav1.TypeMeta   `json:",inline"`
+		metav1.ObjectMeta `json:"metadata,omitempty"`
+		Spec              map[string]any `json:"spec"`
+		Status            *ChildStatus   `json:"status,omitempty"`
+	}
+
+	SetConfigResource struct {
+		Parent SetCustomResource `json:"parent"`
+		// observed children by type and name
+		Children map[string]map[string]*Child `json:"children"`
+	}
+
+	// Flavour describes the kind of VSIs managed by a set
+	Flavour struct {
+		// kind of the VSI resources
+		Kind string
+		// API version of the VSI resources
+		APIVersion string
+		// the flavour supports data disk templates
+		DataDisks bool
+	}
+)
+
+var (
+	// FlavourOnPrem manages VSIs on KVM hosts
+	FlavourOnPrem = &Flavour{
+		Kind:       onprem.KindVSI,
+		APIVersion: onprem.APIVersion,
+		DataDisks:  true,
+	}
+	// FlavourVPC manages VSIs in IBM Cloud VPC
+	FlavourVPC = &Flavour{
+		Kind:       KindVPC,
+		APIVersion: onprem.APIVersion,
+	}
+)
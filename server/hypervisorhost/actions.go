@@ -0,0 +1,84 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package hypervisorhost
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	A "github.com/IBM/fp-go/array"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	C "github.com/ibm-hyper-protect/terraform-provider-hpcr/contract"
+)
+
+const (
+	// key into the status metadata for the results of the pre-flight checks
+	keyPreflight = "preflight"
+)
+
+// createHypervisorHostReadyAction create the action
+func createHypervisorHostReadyAction(info *onprem.HostInfo) (*common.ResourceStatus, error) {
+	metadata, err := common.Transcode[C.RawMap](info)
+	if err != nil {
+		return common.CreateErrorAction(err)
+	}
+	return &common.ResourceStatus{
+		Status:      common.Ready,
+		Description: fmt.Sprintf("Hypervisor host runs [%d] domains, [%d] of [%d] CPUs and [%d] of [%d] bytes of memory allocated", info.Domains, info.AllocatedCPUs, info.CPUs, info.AllocatedMemory, info.Memory),
+		Error:       nil,
+		Metadata:    metadata,
+	}, nil
+}
+
+// createHypervisorHostFailedAction reports a host that failed its pre-flight checks, such a host is not considered
+// for scheduling
+func createHypervisorHostFailedAction(info *onprem.HostInfo, checks, failed []onprem.PreflightCheck) (*common.ResourceStatus, error) {
+	metadata, err := common.Transcode[C.RawMap](info)
+	if err != nil {
+		return common.CreateErrorAction(err)
+	}
+	metadata[keyPreflight] = checks
+	reasons := A.MonadMap(failed, func(check onprem.PreflightCheck) string {
+		return fmt.Sprintf("[%s]: %s", check.Name, check.Message)
+	})
+	return common.CreateAction(&common.ResourceStatus{
+		Status:      common.Error,
+		Description: fmt.Sprintf("Hypervisor host failed the pre-flight checks %s", strings.Join(reasons, ", ")),
+		Error:       nil,
+		Metadata:    metadata,
+	})
+}
+
+// CreateSyncAction checks the capabilities of the hypervisor host and reports its resources
+func CreateSyncAction(client *onprem.LivirtClient, opt *onprem.PreflightOptions) (*common.ResourceStatus, error) {
+	runPreflight := onprem.RunPreflight(client)
+	facts, checks, err := runPreflight(opt)
+	if err != nil {
+		log.Printf("Unable to get the resources of the hypervisor host, cause: [%v]", err)
+		return common.CreateErrorAction(err)
+	}
+	if failed := onprem.GetFailedPreflightChecks(checks); A.IsNonEmpty(failed) {
+		log.Printf("Hypervisor host failed the pre-flight checks: [%v]", failed)
+		return createHypervisorHostFailedAction(facts.Info, checks, failed)
+	}
+	state, err := createHypervisorHostReadyAction(facts.Info)
+	if state.Metadata != nil {
+		state.Metadata[keyPreflight] = checks
+	}
+	return state, err
+}
@@ -0,0 +1,226 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package image
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	C "github.com/ibm-hyper-protect/terraform-provider-hpcr/contract"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// key into the status metadata for the state of the image in the storage pools of the hosts
+	keyStaging = "staging"
+	// key into the status metadata for the selectors of the SSH configuration of the hosts, keyed by host name
+	keyHostTargetSelectors = "hostTargetSelectors"
+)
+
+var (
+	// errHostNotConfigured signals a host whose SSH configuration has not been selected, yet
+	errHostNotConfigured = errors.New("waiting for the configuration of the host")
+)
+
+// connectHost opens a connection to a hypervisor host
+type connectHost func(host *onprem.HypervisorHostCustomResource) (*onprem.LivirtClient, error)
+
+// StagingFromStatus returns the state of an image in the storage pools of the hosts recorded in its status
+func StagingFromStatus(parent *onprem.ImageCustomResource) []onprem.ImageStaging {
+	staging, err := common.Transcode[[]onprem.ImageStaging](parent.Status.Metadata[keyStaging])
+	if err != nil {
+		return nil
+	}
+	return staging
+}
+
+// hostTargetSelectorsFromStatus returns the selectors of the SSH configuration of the hosts recorded in the status
+func hostTargetSelectorsFromStatus(parent *onprem.ImageCustomResource) map[string]*metav1.LabelSelector {
+	selectors, err := common.Transcode[map[string]*metav1.LabelSelector](parent.Status.Metadata[keyHostTargetSelectors])
+	if err != nil {
+		return nil
+	}
+	return selectors
+}
+
+// findStagedName returns the name of the volume of an image staged in a storage pool by a previous sync
+func findStagedName(previous []onprem.ImageStaging, host, storagePool string) string {
+	for _, entry := range previous {
+		if entry.Host == host && entry.StoragePool == storagePool {
+			return entry.Name
+		}
+	}
+	return ""
+}
+
+// stageImage makes sure the image is cached in the storage pools of a host. A sync uploads at most one image, so
+// it does not block other syncs for too long, the remaining storage pools follow with the next syncs.
+func stageImage(client *onprem.LivirtClient, host string, opt *onprem.ImageOptions, uploaded *bool) []onprem.ImageStaging {
+	isCached := onprem.IsBaseImageCached(client)
+	cacheBaseImage := onprem.CacheBaseImage(client)
+
+	result := make([]onprem.ImageStaging, 0, len(opt.StoragePools))
+	for _, storagePool := range opt.StoragePools {
+		entry := onprem.ImageStaging{Host: host, StoragePool: storagePool}
+		name, cached, err := isCached(storagePool, opt.ImageURL, opt.ImageDigest)
+		entry.Name = name
+		switch {
+		case err != nil:
+			log.Printf("Unable to check the image [%s] on host [%s] in pool [%s], cause: [%v]", opt.ImageURL, host, storagePool, err)
+			entry.Error = err.Error()
+		case cached:
+			entry.Ready = true
+		case *uploaded:
+			entry.Message = "waiting for the upload into a different storage pool"
+		default:
+			*uploaded = true
+			baseImage, err := cacheBaseImage(storagePool, opt.ImageURL, opt.ImageDigest)
+			if err != nil {
+				log.Printf("Unable to stage the image [%s] on host [%s] in pool [%s], cause: [%v]", opt.ImageURL, host, storagePool, err)
+				entry.Error = err.Error()
+			} else {
+				entry.Name = baseImage.Name
+				entry.Ready = true
+			}
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+// retireImage removes the image from the storage pools of a host once it backs no boot disk
+func retireImage(client *onprem.LivirtClient, host string, opt *onprem.ImageOptions, previous []onprem.ImageStaging) []onprem.ImageStaging {
+	removeBaseImage := onprem.RemoveCachedBaseImage(client)
+
+	result := make([]onprem.ImageStaging, 0, len(opt.StoragePools))
+	for _, storagePool := range opt.StoragePools {
+		entry := onprem.ImageStaging{Host: host, StoragePool: storagePool, Name: findStagedName(previous, host, storagePool)}
+		// the volume of an image with a digest is known even if it has not been staged by this resource
+		if key, err := onprem.ParseImageDigest(opt.ImageDigest); err == nil {
+			entry.Name = onprem.GetCachedBaseImageName(key)
+		}
+		if len(entry.Name) == 0 {
+			// nothing has been staged
+			entry.Retired = true
+			result = append(result, entry)
+			continue
+		}
+		refs, err := removeBaseImage(storagePool, entry.Name)
+		switch {
+		case errors.Is(err, onprem.ErrBaseImageInUse):
+			entry.References = refs
+			entry.Message = fmt.Sprintf("in use by [%d] volumes", refs)
+		case err != nil:
+			log.Printf("Unable to retire the image [%s] on host [%s] in pool [%s], cause: [%v]", entry.Name, host, storagePool, err)
+			entry.Error = err.Error()
+		default:
+			entry.Retired = true
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+// createUnavailableStaging reports the storage pools of a host that cannot be accessed
+func createUnavailableStaging(host string, opt *onprem.ImageOptions, err error) []onprem.ImageStaging {
+	result := make([]onprem.ImageStaging, 0, len(opt.StoragePools))
+	for _, storagePool := range opt.StoragePools {
+		entry := onprem.ImageStaging{Host: host, StoragePool: storagePool}
+		if errors.Is(err, errHostNotConfigured) {
+			entry.Message = err.Error()
+		} else {
+			entry.Error = err.Error()
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+// createImageStatus summarizes the state of the image in the storage pools of the hosts
+func createImageStatus(opt *onprem.ImageOptions, staging []onprem.ImageStaging, hosts int) *common.ResourceStatus {
+	done, failed := 0, 0
+	for _, entry := range staging {
+		if len(entry.Error) > 0 {
+			failed++
+		}
+		if (opt.Retired && entry.Retired) || (!opt.Retired && entry.Ready) {
+			done++
+		}
+	}
+	verb := "Staged in"
+	if opt.Retired {
+		verb = "Retired from"
+	}
+	state := &common.ResourceStatus{
+		Status:      common.Waiting,
+		Description: fmt.Sprintf("%s [%d] of [%d] storage pools on [%d] hosts", verb, done, len(staging), hosts),
+	}
+	switch {
+	case hosts == 0 && !opt.Retired:
+		state.Description = "No hypervisor host matches the host selector"
+	case failed > 0:
+		state.Status = common.Error
+	case done == len(staging):
+		state.Status = common.Ready
+	}
+	return state
+}
+
+// CreateSyncAction stages or retires the image on the selected hosts
+func CreateSyncAction(parent *onprem.ImageCustomResource, hosts []*onprem.HypervisorHostCustomResource, connect connectHost) (*common.ResourceStatus, error) {
+	opt := imageOptionsFromResource(parent)
+	previous := StagingFromStatus(parent)
+	// the image must be identifiable
+	if len(opt.ImageDigest) > 0 {
+		if _, err := onprem.ParseImageDigest(opt.ImageDigest); err != nil {
+			return common.CreateErrorAction(err)
+		}
+	}
+	// deterministic order of the hosts
+	sorted := append([]*onprem.HypervisorHostCustomResource{}, hosts...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	selectors := make(map[string]*metav1.LabelSelector)
+	staging := make([]onprem.ImageStaging, 0)
+	uploaded := false
+	for _, host := range sorted {
+		selectors[host.Name] = host.Spec.TargetSelector
+		client, err := connect(host)
+		if err != nil {
+			log.Printf("Unable to connect to hypervisor host [%s], cause: [%v]", host.Name, err)
+			staging = append(staging, createUnavailableStaging(host.Name, opt, err)...)
+			continue
+		}
+		if opt.Retired {
+			staging = append(staging, retireImage(client, host.Name, opt, previous)...)
+		} else {
+			staging = append(staging, stageImage(client, host.Name, opt, &uploaded)...)
+		}
+		client.Close()
+	}
+
+	state := createImageStatus(opt, staging, len(sorted))
+	state.Metadata = C.RawMap{
+		keyStaging:             staging,
+		keyHostTargetSelectors: selectors,
+	}
+	return common.CreateAction(state)
+}
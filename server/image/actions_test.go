@@ -0,0 +1,119 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package image
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func createTestHost(name string) *onprem.HypervisorHostCustomResource {
+	return &onprem.HypervisorHostCustomResource{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: onprem.HypervisorHostCustomResourceSpec{
+			TargetSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"host": name},
+			},
+		},
+	}
+}
+
+func TestCreateImageStatus(t *testing.T) {
+	opt := &onprem.ImageOptions{StoragePools: []string{"default"}}
+
+	state := createImageStatus(opt, nil, 0)
+	assert.Equal(t, common.Waiting, state.Status)
+	assert.Equal(t, "No hypervisor host matches the host selector", state.Description)
+
+	state = createImageStatus(opt, []onprem.ImageStaging{
+		{Host: "lpar1", StoragePool: "default", Ready: true},
+		{Host: "lpar2", StoragePool: "default", Message: "waiting for the upload into a different storage pool"},
+	}, 2)
+	assert.Equal(t, common.Waiting, state.Status)
+	assert.Equal(t, "Staged in [1] of [2] storage pools on [2] hosts", state.Description)
+
+	state = createImageStatus(opt, []onprem.ImageStaging{
+		{Host: "lpar1", StoragePool: "default", Ready: true},
+		{Host: "lpar2", StoragePool: "default", Error: "connection refused"},
+	}, 2)
+	assert.Equal(t, common.Error, state.Status)
+
+	state = createImageStatus(opt, []onprem.ImageStaging{
+		{Host: "lpar1", StoragePool: "default", Ready: true},
+	}, 1)
+	assert.Equal(t, common.Ready, state.Status)
+
+	// retired images are done once removed from all storage pools
+	retired := &onprem.ImageOptions{StoragePools: []string{"default"}, Retired: true}
+	state = createImageStatus(retired, nil, 0)
+	assert.Equal(t, common.Ready, state.Status)
+
+	state = createImageStatus(retired, []onprem.ImageStaging{
+		{Host: "lpar1", StoragePool: "default", Name: "base-a.qcow2", References: 2, Message: "in use by [2] volumes"},
+	}, 1)
+	assert.Equal(t, common.Waiting, state.Status)
+	assert.Equal(t, "Retired from [0] of [1] storage pools on [1] hosts", state.Description)
+}
+
+func TestCreateSyncActionHostNotConfigured(t *testing.T) {
+	parent := &onprem.ImageCustomResource{
+		ObjectMeta: metav1.ObjectMeta{Name: "hpcr", Namespace: "default"},
+		Spec: onprem.ImageCustomResourceSpec{
+			ImageURL:     "https://images.example.com/hpcr.qcow2",
+			StoragePools: []string{"default", "images"},
+		},
+	}
+	connect := func(host *onprem.HypervisorHostCustomResource) (*onprem.LivirtClient, error) {
+		return nil, fmt.Errorf("%w [%s]", errHostNotConfigured, host.Name)
+	}
+
+	state, err := CreateSyncAction(parent, []*onprem.HypervisorHostCustomResource{createTestHost("lpar2"), createTestHost("lpar1")}, connect)
+	require.NoError(t, err)
+	// the configuration of the hosts is related with the next sync
+	assert.Equal(t, common.Waiting, state.Status)
+	assert.Equal(t, "Staged in [0] of [4] storage pools on [2] hosts", state.Description)
+
+	// the status carries the staging and the selectors for the next sync
+	parent.Status.Metadata = state.Metadata
+	staging := StagingFromStatus(parent)
+	require.Len(t, staging, 4)
+	assert.Equal(t, "lpar1", staging[0].Host)
+	assert.Equal(t, "images", staging[1].StoragePool)
+	assert.Empty(t, staging[0].Error)
+
+	selectors := hostTargetSelectorsFromStatus(parent)
+	require.Len(t, selectors, 2)
+	assert.Equal(t, "lpar2", selectors["lpar2"].MatchLabels["host"])
+}
+
+func TestCreateSyncActionInvalidDigest(t *testing.T) {
+	parent := &onprem.ImageCustomResource{
+		ObjectMeta: metav1.ObjectMeta{Name: "hpcr", Namespace: "default"},
+		Spec: onprem.ImageCustomResourceSpec{
+			ImageURL:    "https://images.example.com/hpcr.qcow2",
+			ImageDigest: "md5:098f6bcd4621d373cade4e832627b4f6",
+		},
+	}
+
+	state, err := CreateSyncAction(parent, nil, nil)
+	assert.ErrorIs(t, err, onprem.ErrInvalidImageDigest)
+	assert.Equal(t, common.Error, state.Status)
+}
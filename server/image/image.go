@@ -0,0 +1,177 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package image
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/hypervisorhost"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/lock"
+)
+
+func CreatePingRoute(version, compileTime string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"version": version,
+			"compile": compileTime,
+		})
+	}
+}
+
+// connectHostFromRequest connects to a hypervisor host via the SSH configuration selected by the target selector of
+// the host. The configuration becomes available with the next sync after the customize hook selected it.
+func connectHostFromRequest(req map[string]any) connectHost {
+	return func(host *onprem.HypervisorHostCustomResource) (*onprem.LivirtClient, error) {
+		hostEnv, err := common.EnvFromConfigMapsOrSecretsBySelector(req, host.Spec.TargetSelector)
+		if err != nil {
+			return nil, err
+		}
+		if len(hostEnv) == 0 {
+			return nil, fmt.Errorf("%w [%s]", errHostNotConfigured, host.Name)
+		}
+		return onprem.CreateLivirtClientFromEnvMap(hostEnv)
+	}
+}
+
+func syncImage(req map[string]any) (*common.ResourceStatus, error) {
+	// the upload must not race with the creation of a VSI
+	if !lock.Lock.TryLock() {
+		log.Println("Sync: waiting for lock ...")
+		return common.CreateStatusAction(common.Waiting)
+	}
+	defer lock.Lock.Unlock()
+
+	cfg, err := common.Transcode[*ImageConfigResource](req)
+	if err != nil {
+		log.Printf("Unable to decode request, cause: [%v]", err)
+		return common.CreateErrorAction(err)
+	}
+
+	// the ready hosts matching the host selector
+	hosts, err := onprem.HostsFromRelated(req)
+	if err != nil {
+		return common.CreateErrorAction(err)
+	}
+
+	return CreateSyncAction(&cfg.Parent, hosts, connectHostFromRequest(req))
+}
+
+func CreateControllerSyncRoute() gin.HandlerFunc {
+
+	return func(c *gin.Context) {
+		// log this config
+		defer CM.EntryExit("ImageCreateControllerSyncRoute")()
+
+		jsonData, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		// decode the input
+		var req map[string]any
+		err = json.Unmarshal(jsonData, &req)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		// execute and handle
+		state, err := syncImage(req)
+		if err != nil {
+			log.Printf("Error [%v]", err)
+			// switch into error mode
+			c.JSON(http.StatusOK, common.ResourceStatusToResponse(state))
+			// bail out
+			return
+		}
+		// done
+		resp := common.ResourceStatusToResponse(state)
+		// set a retry if we are not ready, yet
+		if state.Status != common.Ready {
+			resp["resyncAfterSeconds"] = 10
+		}
+		// done
+		c.JSON(http.StatusOK, resp)
+	}
+}
+
+func CreateControllerCustomizeRoute() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// log this config
+		defer CM.EntryExit("ImageCreateControllerCustomizeRoute")()
+		// parse body
+		jsonData, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		// decode the input
+		var req map[string]any
+		err = json.Unmarshal(jsonData, &req)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		// transcode to the expected format
+		cfg, err := common.Transcode[*ImageConfigResource](req)
+		if err != nil {
+			// Handle error
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		log.Printf("Getting related resources for [%s] in namespace [%s] ...", cfg.Parent.Name, cfg.Parent.Namespace)
+		// the hosts to stage the image on
+		related := []common.RelatedResource{
+			hypervisorhost.RefHypervisorHosts(cfg.Parent.Spec.HostSelector),
+		}
+		// the config of the hosts known from the previous sync
+		for _, selector := range hostTargetSelectorsFromStatus(&cfg.Parent) {
+			related = append(related, common.RefConfigMaps(selector), common.RefSecrets(selector))
+		}
+		// produce a response
+		resp := common.CustomizeHookResponse{
+			RelatedResourceRules: common.CreateRelatedResourceRules(related),
+		}
+		// dump it
+		data, err := json.Marshal(resp)
+		if err == nil {
+			log.Printf("customize response [%s]", string(data))
+		}
+
+		// done
+		c.JSON(http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package image
+
+import (
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RefImages references images as related resources
+func RefImages(labels *metav1.LabelSelector) common.RelatedResource {
+	return common.RefResource(onprem.APIVersion, onprem.ResourceNameImages, labels)
+}
+
+// RefImageByName references a single image by its name, there is no rule for an empty name
+func RefImageByName(name string) []*common.RelatedResourceRule {
+	if len(name) == 0 {
+		return nil
+	}
+	return []*common.RelatedResourceRule{
+		common.CreateNamedRelatedResourceRule(onprem.APIVersion, onprem.ResourceNameImages, name),
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package network
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	A "github.com/IBM/fp-go/array"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	C "github.com/ibm-hyper-protect/terraform-provider-hpcr/contract"
+)
+
+// createNetworkReadyAction create the action
+func createNetworkReadyAction(net *onprem.ManagedNetwork) (*common.ResourceStatus, error) {
+
+	// metadata to attach
+	metadata := C.RawMap{
+		"Name":           net.Network.Name,
+		"restartPending": net.RestartPending,
+	}
+	if net.Network.Bridge != nil {
+		metadata["bridge"] = net.Network.Bridge.Name
+	}
+	// marshal the network info into metadata
+	netStrg, err := onprem.XMLMarshall(net.Network)
+	if err == nil {
+		metadata["networkXML"] = netStrg
+	} else {
+		log.Printf("Unable to marshal the network XML, cause: [%v]", err)
+	}
+	description := netStrg
+	if net.RestartPending {
+		description = fmt.Sprintf("Network [%s] has been redefined, the changes will be applied once no VSIs are attached", net.Network.Name)
+	}
+	return &common.ResourceStatus{
+		Status:      common.Ready,
+		Description: description,
+		Error:       nil,
+		Metadata:    metadata,
+	}, nil
+}
+
+// CreateSyncAction synchronizes the state of the resource and determines what to do next
+func CreateSyncAction(client *onprem.LivirtClient, opt *onprem.NetworkOptions) (*common.ResourceStatus, error) {
+	// create the network or reconcile its definition
+	networkSync := onprem.CreateNetworkSync(client)
+	net, err := networkSync(opt)
+	if err != nil {
+		log.Printf("Unable to synchronize network [%s], cause: [%v]", opt.Name, err)
+		return common.CreateErrorAction(err)
+	}
+	// successfully created the network
+	return createNetworkReadyAction(net)
+}
+
+// CreateFinalizeAction deletes the network unless VSIs are still attached
+func CreateFinalizeAction(client *onprem.LivirtClient, opt *onprem.NetworkOptions) (*common.ResourceStatus, error) {
+	// refuse deletion while the network is in use
+	getNetworkDomains := onprem.GetNetworkDomains(client)
+	domains, err := getNetworkDomains(opt.Name)
+	if err != nil {
+		log.Printf("Unable to determine the domains attached to network [%s], cause: [%v]", opt.Name, err)
+		return common.CreateErrorAction(err)
+	}
+	if A.IsNonEmpty(domains) {
+		log.Printf("Network [%s] is still in use by %v, waiting ...", opt.Name, domains)
+		return &common.ResourceStatus{
+			Status:      common.Waiting,
+			Description: fmt.Sprintf("Network is still in use by [%s]", strings.Join(domains, ", ")),
+		}, nil
+	}
+	// destroy the network
+	deleteSync := onprem.DeleteNetworkSync(client)
+	err = deleteSync(opt.Name)
+	if err != nil {
+		return common.CreateErrorAction(err)
+	}
+	// done
+	return common.CreateReadyAction()
+}
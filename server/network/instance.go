@@ -0,0 +1,36 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package network
+
+import (
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/env"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+)
+
+// networkOptionsFromConfigMap decodes the information required to create a network
+// from the k8s resource
+func networkOptionsFromConfigMap(data *NetworkConfigResource, envMap env.Environment) (*onprem.NetworkOptions, error) {
+	spec := data.Parent.Spec
+	opt := &onprem.NetworkOptions{
+		Name:      onprem.GetManagedNetworkName(&data.Parent),
+		Mode:      onprem.BoxNetworkMode(spec.Mode),
+		Interface: spec.Interface,
+		Subnet:    spec.Subnet,
+		DHCP:      spec.DHCP,
+		DNSHosts:  spec.DNSHosts,
+		MTU:       spec.MTU,
+	}
+	return opt, nil
+}
@@ -54,6 +54,12 @@ func CreateSyncAction(client *onprem.LivirtClient, opt *onprem.NetworkRefOptions
 		log.Printf("Unable to lookup network ref [%s], cause: [%v]", opt.Name, err)
 		return common.CreateErrorAction(err)
 	}
+	// the reserved IP addresses must be served by the network or by the network config of the VSIs
+	err = onprem.ValidateNetworkConfig(netXML, opt.NetworkConfig, opt.StaticIPs)
+	if err != nil {
+		log.Printf("Invalid static IP reservations for network ref [%s], cause: [%v]", opt.Name, err)
+		return common.CreateErrorAction(err)
+	}
 	// successfully located the network
 	return createNetworkRefReadyAction(netXML)
 }
@@ -23,6 +23,8 @@ import (
 // from the k8s resource
 func networkRefOptionsFromConfigMap(data *NetworkRefConfigResource, envMap env.Environment) (*onprem.NetworkRefOptions, error) {
 	return &onprem.NetworkRefOptions{
-		Name: onprem.BoxNetworkName(data.Parent.Spec.NetworkName),
+		Name:          onprem.BoxNetworkName(data.Parent.Spec.NetworkName),
+		StaticIPs:     data.Parent.Spec.StaticIPs,
+		NetworkConfig: data.Parent.Spec.NetworkConfig,
 	}, nil
 }
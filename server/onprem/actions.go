@@ -15,14 +15,14 @@
 package onprem
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"strings"
 	"time"
 
 	A "github.com/IBM/fp-go/array"
-	F "github.com/IBM/fp-go/function"
-	"github.com/digitalocean/go-libvirt"
 	CM "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
 	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
 	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
@@ -30,52 +30,71 @@ import (
 	"libvirt.org/go/libvirtxml"
 )
 
-var (
-	emptyIPAddresses = A.Empty[string]()
-)
+func getConsoleLogLine(line consoleLogLine) string {
+	return line.Line
+}
 
-func getIPAddress(lease libvirt.NetworkDhcpLease) string {
-	return lease.Ipaddr
+// lastLogLine returns the most recent line of a log or a default description
+func lastLogLine(lines []string) string {
+	if len(lines) == 0 {
+		return "Booting"
+	}
+	return lines[len(lines)-1]
+}
+
+// archiveTruncatedConsoleLog archives the console log read so far if the logging volume got truncated, e.g. because
+// the domain has been restarted, and starts reading the log from the beginning
+func archiveTruncatedConsoleLog(client *onprem.LivirtClient, opt *onprem.InstanceOptions) {
+	getLoggingVolumeSize := onprem.GetLoggingVolumeSize(client)
+	archiveLogs := onprem.ArchiveLogs(client)
+
+	logName := onprem.GetLoggingVolumeName(opt.Name)
+	current := getConsoleLog(opt.Name)
+	size, err := getLoggingVolumeSize(opt.StoragePool, logName)
+	if err != nil || size >= current.Offset {
+		return
+	}
+	log.Printf("Logging volume [%s] has been truncated from [%d] to [%d] bytes, archiving previous logs ...", logName, current.Offset, size)
+	err = archiveLogs(opt.StoragePool, opt.Name, current.Content, onprem.BoxConsoleLogRetention(opt.ConsoleLogRetention))
+	if err != nil {
+		log.Printf("Unable to archive the console log of [%s], cause: [%v]", opt.Name, err)
+	}
+	// start reading from the beginning
+	resetConsoleLog(opt.Name)
 }
 
 func createInstanceRunningAction(client *onprem.LivirtClient, inst *libvirtxml.Domain, opt *onprem.InstanceOptions) (*common.ResourceStatus, error) {
 	msg := fmt.Sprintf("createInstanceRunningAction(%s)", opt.Name)
 
+	// the console log of a previous boot is archived before the timeout starts, the upload may take a while
+	archiveTruncatedConsoleLog(client, opt)
+
 	defer CM.PanicAfterTimeout(msg, 5*time.Second)()
 	defer CM.EntryExit(msg)()
 
-	// getLoggingVolume := onprem.GetLoggingVolume(client)
-	getLoggingVolume := onprem.GetLoggingVolumeViaCommand(client)
-	getLeases := onprem.GetDCHPLeases(client)
-
-	// getIPAddresses determines the IP Addresses for the instance by checking for a all leases
-	// for the configured network and then filtering down the list to the hostname
-	getIPAddresses := func() []string {
-		defer CM.EntryExit(fmt.Sprintf("getIPAddresses(%s)", opt.Name))()
-		networks := onprem.GetNetworks(opt)
-		var leases []libvirt.NetworkDhcpLease
-		for _, network := range networks {
-			lses, err := getLeases(network)
-			if err != nil {
-				log.Printf("Unable to get the leases for network [%s], cause: [%v]", network, err)
-				return emptyIPAddresses
-			}
-			// append all
-			leases = append(leases, lses...)
-		}
-		// filter down
-		return F.Pipe2(
-			leases,
-			A.Filter(onprem.IsNetworkDhcpLeaseForHostname(opt.Name)),
-			A.Map(getIPAddress),
-		)
-	}
+	getLoggingVolume := onprem.GetLoggingVolumeFromOffsetViaCommand(client)
+	getInterfaceStatus := onprem.GetInterfaceStatus(client)
 
 	// fetch the logs
 	log.Printf("Domain [%s] is running, fetching logs ...", opt.Name)
-	// try to get the content of the logging volume
+	// only read the part of the logging volume that we have not seen, yet
 	logName := onprem.GetLoggingVolumeName(opt.Name)
-	data, err := getLoggingVolume(opt.StoragePool, logName)
+	current := getConsoleLog(opt.Name)
+	data, err := getLoggingVolume(opt.StoragePool, logName, current.Offset)
+	if err != nil && opt.Adopted {
+		// an adopted domain might not log its console to a volume, so we cannot follow its boot
+		log.Printf("Adopted domain [%s] does not have a logging volume, cause: [%v]", opt.Name, err)
+		interfaces := getInterfaceStatus(inst, opt)
+		return common.CreateAction(&common.ResourceStatus{
+			Status:      common.Ready,
+			Description: "Adopted",
+			Error:       nil,
+			Metadata: C.RawMap{
+				"ipaddresses": onprem.InterfaceStatusToIPAddresses(interfaces),
+				"interfaces":  interfaces,
+			},
+		})
+	}
 	if err != nil {
 		// log this
 		log.Printf("Unable to get the logging volume [%s] from pool [%s], cause: [%v]", logName, opt.StoragePool, err)
@@ -86,13 +105,11 @@ func createInstanceRunningAction(client *onprem.LivirtClient, inst *libvirtxml.D
 			Error:       err,
 		}, err
 	}
+	current = appendConsoleLog(opt.Name, data)
 	// marshal the instance
 	instStrg, err := onprem.XMLMarshall(inst)
-	// parse log into lines
-	lines := F.Pipe1(
-		strings.Split(data, "\n"),
-		A.Map(strings.TrimSpace),
-	)
+	// the HPL lines are retained even if the content exceeds the ring buffer
+	lines := A.MonadMap(current.HPLLines, getConsoleLogLine)
 	// partition the lines
 	success, failure := onprem.PartitionLogs(lines)
 	if onprem.VSIFailedToStart(failure) {
@@ -101,7 +118,7 @@ func createInstanceRunningAction(client *onprem.LivirtClient, inst *libvirtxml.D
 		log.Printf("Domain [%s] failed to start, errors: [%s]", opt.Name, logs)
 		// assemble some metadata
 		metadata := C.RawMap{
-			"logs": logs,
+			keyLogOffset: current.Offset,
 		}
 		if err == nil {
 			metadata["domainXML"] = instStrg
@@ -116,12 +133,13 @@ func createInstanceRunningAction(client *onprem.LivirtClient, inst *libvirtxml.D
 	}
 	// check if we are still booting
 	if onprem.VSIStartedSuccessfully(success) {
-		// some logs
-		logs := strings.Join(lines, "\n")
 		// assemble some metadata
+		// the addresses per interface
+		interfaces := getInterfaceStatus(inst, opt)
 		metadata := C.RawMap{
-			"logs":        logs,
-			"ipaddresses": getIPAddresses(),
+			keyLogOffset:  current.Offset,
+			"ipaddresses": onprem.InterfaceStatusToIPAddresses(interfaces),
+			"interfaces":  interfaces,
 		}
 		if err == nil {
 			metadata["domainXML"] = instStrg
@@ -129,19 +147,21 @@ func createInstanceRunningAction(client *onprem.LivirtClient, inst *libvirtxml.D
 		// juhuuu
 		return common.CreateAction(&common.ResourceStatus{
 			Status:      common.Ready,
-			Description: logs,
+			Description: lastLogLine(success),
 			Error:       nil,
 			Metadata:    metadata,
 		})
 	}
 	// log this
-	desc := strings.Join(lines, "\n")
-	log.Printf("Domain [%s] is still booting, logs: [%s]", opt.Name, desc)
+	log.Printf("Domain [%s] is still booting, read [%d] bytes of logs.", opt.Name, current.Offset)
 	// we need to wait
 	return common.CreateAction(&common.ResourceStatus{
 		Status:      common.Waiting,
-		Description: desc,
+		Description: lastLogLine(success),
 		Error:       nil,
+		Metadata: C.RawMap{
+			keyLogOffset: current.Offset,
+		},
 	})
 }
 
@@ -151,14 +171,119 @@ func CreateSyncAction(client *onprem.LivirtClient, opt *onprem.InstanceOptions)
 	defer CM.EntryExit(fmt.Sprintf("CreateSyncAction(%s)", opt.Name))()
 	// checks for the validity of the instance
 	isInstanceValid := onprem.IsInstanceValid(client)
+	// the DHCP host entries need to exist before the instance boots
+	syncDHCPHosts := onprem.SyncDHCPHosts(client)
+	if err := syncDHCPHosts(opt); err != nil {
+		log.Printf("Unable to synchronize the static IP addresses of the VSI [%s], cause: [%v]", opt.Name, err)
+		return common.CreateErrorAction(err)
+	}
 	inst, ok := isInstanceValid(opt)
+	// changes applied to the domain outside of the operator
+	var drift []onprem.DomainDrift
+	if ok {
+		drift, ok = reconcileDrift(client, inst, opt)
+		if ok && A.IsNonEmpty(drift) {
+			// the repair changed the domain
+			inst, ok = isInstanceValid(opt)
+		}
+	}
+	if ok && isRecreateRequested(opt.Name) {
+		log.Printf("VSI [%s] failed its liveness probe and is marked for recreation", opt.Name)
+		ok = false
+	}
+	if ok && requiresSecureExecution(opt) && !onprem.IsDomainProtected(inst) {
+		// the domain has been created before Secure Execution became a requirement
+		log.Printf("VSI [%s] requires secure execution, but its domain is not protected", opt.Name)
+		ok = false
+	}
 	if ok {
-		// validate the instance
-		return createInstanceRunningAction(client, inst, opt)
+		// owner and operator version of domains created by previous versions of the operator
+		updateMetadata := onprem.UpdateInstanceMetadata(client)
+		if err := updateMetadata(opt); err != nil {
+			log.Printf("Unable to update the metadata of the VSI [%s], cause: [%v]", opt.Name, err)
+		}
+		// a stopped instance gets the grace period to power off
+		var shutdownMetadata C.RawMap
+		if onprem.BoxPowerState(opt.PowerState) == onprem.PowerStateStopped {
+			shutdown, err := shutdownInstance(client, opt)
+			if err != nil {
+				log.Printf("Unable to shut down the VSI [%s], cause: [%v]", opt.Name, err)
+				return common.CreateErrorAction(err)
+			}
+			if !shutdown.Done {
+				return createShutdownAction(opt)
+			}
+			shutdownMetadata = createShutdownMetadata(shutdown)
+		} else {
+			// a shutdown interrupted by a new power state starts over next time
+			endShutdown(opt.Name)
+		}
+		// start, stop, pause or resume the instance
+		applyPowerState := onprem.ApplyPowerState(client)
+		power, changed, err := applyPowerState(opt.Name, opt.PowerState)
+		if err != nil {
+			log.Printf("Unable to apply the power state [%s] to the VSI [%s], cause: [%v]", opt.PowerState, opt.Name, err)
+			return common.CreateErrorAction(err)
+		}
+		if changed {
+			// probe results of the previous power state do not apply
+			resetProbes(opt.Name)
+		}
+		if onprem.BoxPowerState(opt.PowerState) != onprem.PowerStateRunning || !onprem.IsPowerStateReached(opt.PowerState, power) {
+			state, err := createPowerStateAction(opt, power)
+			maps.Copy(state.Metadata, shutdownMetadata)
+			state.Metadata[keyProtected] = onprem.IsDomainProtected(inst)
+			if A.IsNonEmpty(drift) {
+				state.Metadata[keyDrift] = drift
+			}
+			return state, err
+		}
+		// attach and detach data disks while the instance is running
+		syncDataDisks := onprem.SyncDataDisks(client)
+		updated, err := syncDataDisks(inst, opt)
+		if err == nil {
+			// validate the instance
+			state, err := createInstanceRunningAction(client, updated, opt)
+			if state != nil && state.Metadata != nil {
+				state.Metadata[keyPowerState] = power
+				state.Metadata[keyProtected] = onprem.IsDomainProtected(updated)
+				if A.IsNonEmpty(drift) {
+					state.Metadata[keyDrift] = drift
+				}
+			}
+			if err != nil || !isInstanceStarted(state) {
+				return state, err
+			}
+			// the workload of a started instance is probed
+			return applyProbes(client, state, opt)
+		}
+		if !errors.Is(err, onprem.ErrHotplugFailed) {
+			log.Printf("Unable to synchronize the data disks of the VSI [%s], cause: [%v]", opt.Name, err)
+			return common.CreateErrorAction(err)
+		}
+		// the guest does not support hot-plug, so restart the instance with the new disks
+		log.Printf("Recreating the VSI [%s] to update its data disks, cause: [%v]", opt.Name, err)
+	}
+	// a workload that requires Secure Execution must not replace its domain on a host without it
+	if requiresSecureExecution(opt) {
+		isSecureExecutionSupported := onprem.IsSecureExecutionSupported(client)
+		if err := onprem.CheckSecureExecution(opt, isSecureExecutionSupported()); err != nil {
+			log.Printf("Refusing to start the VSI [%s], cause: [%v]", opt.Name, err)
+			return common.CreateErrorAction(err)
+		}
+	}
+	// the previous instance gets the grace period to power off before it is replaced
+	shutdown, err := shutdownInstance(client, opt)
+	if err != nil {
+		log.Printf("Unable to shut down the VSI [%s], cause: [%v]", opt.Name, err)
+		return common.CreateErrorAction(err)
+	}
+	if !shutdown.Done {
+		return createShutdownAction(opt)
 	}
 	// start the instance
-	instSync := onprem.CreateInstanceSync(client)
-	result, err := instSync(opt)
+	instSync := onprem.RecreateInstanceSync(client)
+	result, baseImage, err := instSync(opt)
 	if err != nil {
 		log.Printf("Unable to create the VSI [%s], cause: [%v]", opt.Name, err)
 		return common.CreateErrorAction(err)
@@ -169,8 +294,20 @@ func CreateSyncAction(client *onprem.LivirtClient, opt *onprem.InstanceOptions)
 		return common.CreateErrorAction(err)
 	}
 	log.Printf("Instance: %s", resultStrg)
+	// the console log and the probes of the new instance start from scratch
+	resetConsoleLog(opt.Name)
+	resetProbes(opt.Name)
 	// we need an additional sync to tell if the instance is ready
-	return common.CreateWaitingAction()
+	state, err := common.CreateWaitingAction()
+	state.Metadata = setBaseImage(createShutdownMetadata(shutdown), baseImage)
+	if A.IsNonEmpty(drift) {
+		// report the drift that caused the recreation
+		if state.Metadata == nil {
+			state.Metadata = make(C.RawMap)
+		}
+		state.Metadata[keyDrift] = drift
+	}
+	return state, err
 }
 
 func CreateFinalizeAction(client *onprem.LivirtClient, opt *onprem.InstanceOptions) (*common.ResourceStatus, error) {
@@ -178,13 +315,27 @@ func CreateFinalizeAction(client *onprem.LivirtClient, opt *onprem.InstanceOptio
 	defer CM.EntryExit(fmt.Sprintf("CreateFinalizeAction(%s)", opt.Name))()
 	// TODO proper check for existence comes here
 	// ...
+	// the instance gets the grace period to power off
+	shutdown, err := shutdownInstance(client, opt)
+	if err != nil {
+		log.Printf("Unable to shut down the VSI [%s], cause: [%v]", opt.Name, err)
+		return common.CreateErrorAction(err)
+	}
+	if !shutdown.Done {
+		return createShutdownAction(opt)
+	}
 	// destroy the instance
 	deleteSync := onprem.DeleteInstanceSync(client)
-	err := deleteSync(opt.StoragePool, opt.Name)
+	err = deleteSync(opt.StoragePool, opt.Name, &opt.Owner)
 	if err != nil {
 		log.Printf("Unable to delete the VSI [%s], cause: [%v]", opt.Name, err)
 		return common.CreateErrorAction(err)
 	}
+	// release the static IP addresses, failure will only be logged
+	removeDHCPHosts := onprem.RemoveDHCPHosts(client)
+	if err := removeDHCPHosts(opt); err != nil {
+		log.Printf("Unable to remove the static IP addresses of the VSI [%s], cause: [%v]", opt.Name, err)
+	}
 	// done
 	return common.CreateReadyAction()
 }
@@ -0,0 +1,71 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"log"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	C "github.com/ibm-hyper-protect/terraform-provider-hpcr/contract"
+)
+
+const (
+	// key into the status metadata for the name of the domain that has been adopted by the VSI
+	keyAdoptedDomain = "adoptedDomain"
+)
+
+// AdoptedDomainFromStatus returns the name of the domain a VSI has adopted
+func AdoptedDomainFromStatus(parent *onprem.OnPremCustomResource) (string, bool) {
+	name, ok := parent.Status.Metadata[keyAdoptedDomain].(string)
+	return name, ok && name != ""
+}
+
+// useAdoptedDomain makes the options refer to the domain adopted by the VSI, if any
+func useAdoptedDomain(parent *onprem.OnPremCustomResource, opt *onprem.InstanceOptions) {
+	if name, ok := AdoptedDomainFromStatus(parent); ok {
+		opt.Name = name
+		opt.Adopted = true
+	}
+}
+
+// adoptOnRequest adopts the existing domain referenced by the spec of the VSI unless the VSI has already adopted
+// a domain, in which case the options are updated to refer to that domain
+func adoptOnRequest(client *onprem.LivirtClient, parent *onprem.OnPremCustomResource, opt *onprem.InstanceOptions) error {
+	useAdoptedDomain(parent, opt)
+	if opt.Adopted || parent.Spec.Adopt == nil {
+		return nil
+	}
+	adoptInstance := onprem.AdoptInstance(client)
+	name, err := adoptInstance(parent.Spec.Adopt, opt)
+	if err != nil {
+		log.Printf("Unable to adopt a domain for the VSI [%s], cause: [%v]", opt.Name, err)
+		return err
+	}
+	opt.Name = name
+	opt.Adopted = true
+	return nil
+}
+
+// setAdoptedDomain records the name of the adopted domain in the status metadata
+func setAdoptedDomain(metadata C.RawMap, opt *onprem.InstanceOptions) C.RawMap {
+	if !opt.Adopted {
+		return metadata
+	}
+	if metadata == nil {
+		metadata = make(C.RawMap)
+	}
+	metadata[keyAdoptedDomain] = opt.Name
+	return metadata
+}
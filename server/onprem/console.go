@@ -0,0 +1,193 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+)
+
+// consoleLogLine is a line of the console log together with the time it was first read
+type consoleLogLine struct {
+	Line string
+	Time time.Time
+}
+
+// consoleLog captures the part of the console log of a VSI that has been read so far
+type consoleLog struct {
+	// offset of the next byte to read from the logging volume
+	Offset uint64
+	// the most recent part of the log content read so far, acts as a ring buffer
+	Content string
+	// the last line that has not been terminated, yet
+	pending string
+	// lines carrying HPL tokens
+	HPLLines []consoleLogLine
+	// times of the HPL lines reported before the controller restarted, indexed like the lines
+	restoredTimes []time.Time
+	// offset reported in the status before the controller restarted
+	restoredOffset uint64
+}
+
+const (
+	// maximum size of the log content kept in memory per VSI
+	maxConsoleLogSize = 2 * 1024 * 1024
+	// key into the status metadata for the number of bytes of the console log that have been read
+	keyLogOffset = "logOffset"
+)
+
+var (
+	consoleLogsLock sync.Mutex
+	// console logs keyed by the name of the VSI
+	consoleLogs = make(map[string]*consoleLog)
+	// name of the VSI keyed by namespace and name of the custom resource
+	consoleLogRefs = make(map[string]string)
+)
+
+func consoleLogRefKey(namespace, name string) string {
+	return fmt.Sprintf("%s/%s", namespace, name)
+}
+
+// GetConsoleLogsPath returns the path of the route that serves the console log of a custom resource
+func GetConsoleLogsPath(namespace, name string) string {
+	return fmt.Sprintf("/onprem/logs/%s/%s", namespace, name)
+}
+
+// registerConsoleLog remembers which VSI belongs to a custom resource, so the log can be looked up by resource name
+func registerConsoleLog(namespace, name, vsi string) {
+	consoleLogsLock.Lock()
+	defer consoleLogsLock.Unlock()
+
+	consoleLogRefs[consoleLogRefKey(namespace, name)] = vsi
+}
+
+// logOffsetFromStatus returns the number of bytes of the console log that had been read according to the status
+func logOffsetFromStatus(parent *onprem.OnPremCustomResource) uint64 {
+	if offset, ok := parent.Status.Metadata[keyLogOffset].(float64); ok && offset > 0 {
+		return uint64(offset)
+	}
+	return 0
+}
+
+// restoreConsoleLog prepares reading the console log of a VSI that is unknown to this process, e.g. after a restart
+// of the controller. The log is read again from the beginning, the HPL lines keep the times of the events that
+// have been reported for them before, unless the log turns out to be shorter than the offset in the status.
+func restoreConsoleLog(vsi string, offset uint64, times []time.Time) {
+	consoleLogsLock.Lock()
+	defer consoleLogsLock.Unlock()
+
+	if _, ok := consoleLogs[vsi]; ok {
+		return
+	}
+	consoleLogs[vsi] = &consoleLog{
+		restoredTimes:  times,
+		restoredOffset: offset,
+	}
+}
+
+// getConsoleLog returns a copy of the console log read so far
+func getConsoleLog(vsi string) consoleLog {
+	consoleLogsLock.Lock()
+	defer consoleLogsLock.Unlock()
+
+	if current, ok := consoleLogs[vsi]; ok {
+		return *current
+	}
+	return consoleLog{}
+}
+
+// getConsoleLogByRef returns a copy of the console log of a custom resource
+func getConsoleLogByRef(namespace, name string) (consoleLog, bool) {
+	consoleLogsLock.Lock()
+	defer consoleLogsLock.Unlock()
+
+	vsi, ok := consoleLogRefs[consoleLogRefKey(namespace, name)]
+	if !ok {
+		return consoleLog{}, false
+	}
+	current, ok := consoleLogs[vsi]
+	if !ok {
+		return consoleLog{}, false
+	}
+	return *current, true
+}
+
+// appendConsoleLog adds newly read data to the console log and records new HPL lines
+func appendConsoleLog(vsi string, data string) consoleLog {
+	consoleLogsLock.Lock()
+	defer consoleLogsLock.Unlock()
+
+	current, ok := consoleLogs[vsi]
+	if !ok {
+		current = &consoleLog{}
+		consoleLogs[vsi] = current
+	}
+	if current.Offset == 0 && uint64(len(data)) < current.restoredOffset {
+		// the log has been truncated since the status was written, the reported events belong to a previous boot
+		current.restoredTimes = nil
+	}
+	current.Offset += uint64(len(data))
+	current.Content = truncateConsoleLog(current.Content + data)
+	// inspect the lines that have been completed since the last read
+	pending := current.pending + data
+	lines := onprem.CompleteLogLines(pending)
+	now := time.Now()
+	for _, line := range lines {
+		if onprem.IsHPLLogLine(line) {
+			t := now
+			if idx := len(current.HPLLines); idx < len(current.restoredTimes) {
+				t = current.restoredTimes[idx]
+			}
+			current.HPLLines = append(current.HPLLines, consoleLogLine{Line: line, Time: t})
+		}
+	}
+	current.pending = pending[strings.LastIndex(pending, "\n")+1:]
+
+	return *current
+}
+
+// truncateConsoleLog drops the oldest lines of the log if it exceeds the maximum size
+func truncateConsoleLog(content string) string {
+	if len(content) <= maxConsoleLogSize {
+		return content
+	}
+	content = content[len(content)-maxConsoleLogSize:]
+	// start at a line boundary
+	if idx := strings.Index(content, "\n"); idx >= 0 {
+		return content[idx+1:]
+	}
+	return content
+}
+
+// resetConsoleLog forgets the console log of a VSI, e.g. because the VSI has been recreated
+func resetConsoleLog(vsi string) {
+	consoleLogsLock.Lock()
+	defer consoleLogsLock.Unlock()
+
+	delete(consoleLogs, vsi)
+}
+
+// removeConsoleLog forgets the console log of a custom resource
+func removeConsoleLog(namespace, name, vsi string) {
+	consoleLogsLock.Lock()
+	defer consoleLogsLock.Unlock()
+
+	delete(consoleLogs, vsi)
+	delete(consoleLogRefs, consoleLogRefKey(namespace, name))
+}
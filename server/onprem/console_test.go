@@ -0,0 +1,90 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	testConsoleLog = "# HPL11099I: bootloader end\nhpcr-dnslookup[860]: HPL14000I: Network connectivity check completed successfully.\n"
+)
+
+func TestRestoreConsoleLog(t *testing.T) {
+	vsi := "TestRestoreConsoleLog"
+	defer resetConsoleLog(vsi)
+
+	reported := time.Date(2023, 3, 17, 10, 18, 45, 0, time.UTC)
+	restoreConsoleLog(vsi, uint64(len(testConsoleLog)), []time.Time{reported})
+
+	current := appendConsoleLog(vsi, testConsoleLog)
+	assert.Equal(t, uint64(len(testConsoleLog)), current.Offset)
+	assert.Len(t, current.HPLLines, 2)
+	// the reported line keeps its time, the new line is read now
+	assert.Equal(t, reported, current.HPLLines[0].Time)
+	assert.True(t, current.HPLLines[1].Time.After(reported))
+}
+
+func TestRestoreTruncatedConsoleLog(t *testing.T) {
+	vsi := "TestRestoreTruncatedConsoleLog"
+	defer resetConsoleLog(vsi)
+
+	reported := time.Date(2023, 3, 17, 10, 18, 45, 0, time.UTC)
+	restoreConsoleLog(vsi, 2*uint64(len(testConsoleLog)), []time.Time{reported})
+
+	current := appendConsoleLog(vsi, testConsoleLog)
+	assert.Len(t, current.HPLLines, 2)
+	assert.NotEqual(t, reported, current.HPLLines[0].Time)
+}
+
+func TestObservedConsoleEvents(t *testing.T) {
+	parent := &onprem.OnPremCustomResource{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "onpremsample",
+			Namespace: "default",
+		},
+	}
+	event := func(idx int) map[string]any {
+		return map[string]any{
+			"metadata": map[string]any{
+				"name": consoleEventName(parent, idx),
+			},
+			"message":        testConsoleLog,
+			"firstTimestamp": "2023-03-17T10:18:45Z",
+		}
+	}
+	req := map[string]any{
+		"children": map[string]any{
+			keyEventChildren: map[string]any{
+				consoleEventName(parent, 1): event(1),
+				consoleEventName(parent, 0): event(0),
+				// a gap ends the sequence
+				consoleEventName(parent, 3): event(3),
+				"other.hpl.2":               event(2),
+			},
+		},
+	}
+
+	events := observedConsoleEvents(req, parent)
+	assert.Len(t, events, 2)
+	assert.Equal(t, consoleEventName(parent, 0), events[0].Name)
+	assert.Equal(t, consoleEventName(parent, 1), events[1].Name)
+	assert.Equal(t, time.Date(2023, 3, 17, 10, 18, 45, 0, time.UTC), consoleEventTimes(events)[0].UTC())
+}
@@ -0,0 +1,80 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"log"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"libvirt.org/go/libvirtxml"
+)
+
+// key into the status metadata for the drift of the domain
+const keyDrift = "drift"
+
+func markRepaired(drift []onprem.DomainDrift) []onprem.DomainDrift {
+	result := make([]onprem.DomainDrift, len(drift))
+	for idx, item := range drift {
+		item.Repaired = true
+		result[idx] = item
+	}
+	return result
+}
+
+// reconcileDrift compares the domain with its desired configuration and applies the drift policy. It returns the
+// detected drift and if the domain is still valid, an invalid domain is recreated.
+func reconcileDrift(client *onprem.LivirtClient, inst *libvirtxml.Domain, opt *onprem.InstanceOptions) ([]onprem.DomainDrift, bool) {
+	drift := onprem.DetectDrift(opt, inst)
+	if len(drift) == 0 {
+		return nil, true
+	}
+	policy := onprem.BoxDriftPolicy(opt.DriftPolicy)
+	log.Printf("Domain [%s] drifted from its configuration, applying policy [%s], drift: [%v]", opt.Name, policy, drift)
+	// drift that is reported only does not count for the policy
+	var reported, actionable []onprem.DomainDrift
+	for _, item := range drift {
+		if onprem.IsDriftReportOnly(opt, item) {
+			reported = append(reported, item)
+		} else {
+			actionable = append(actionable, item)
+		}
+	}
+	if len(actionable) == 0 {
+		return drift, true
+	}
+	switch policy {
+	case onprem.DriftPolicyRecreate:
+		return drift, false
+	case onprem.DriftPolicyRepair:
+		repairDrift := onprem.RepairDrift(client)
+		remaining, restarted, err := repairDrift(inst, opt, actionable)
+		if err != nil {
+			log.Printf("Unable to repair the drift of domain [%s], recreating it, cause: [%v]", opt.Name, err)
+			return drift, false
+		}
+		if len(remaining) > 0 {
+			log.Printf("Unable to repair the drift [%v] of domain [%s], recreating it", remaining, opt.Name)
+			return drift, false
+		}
+		if restarted {
+			// the new boot starts with a fresh console log and fresh probes
+			resetConsoleLog(opt.Name)
+			resetProbes(opt.Name)
+		}
+		return append(markRepaired(actionable), reported...), true
+	}
+	// just report the drift
+	return drift, true
+}
@@ -0,0 +1,138 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	A "github.com/IBM/fp-go/array"
+	C "github.com/ibm-hyper-protect/k8s-operator-hpcr/common"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	v1 "k8s.io/api/core/v1"
+)
+
+var (
+	// key of the observed events in the children of a sync request
+	keyEventChildren = fmt.Sprintf("Event.%s", C.K8SAPIVersion)
+)
+
+// consoleEventName returns the name of the event for the HPL line with the given index
+func consoleEventName(parent *onprem.OnPremCustomResource, idx int) string {
+	return fmt.Sprintf("%s.hpl.%d", parent.Name, idx)
+}
+
+// consoleEventIndex returns the index of the HPL line an event has been created for
+func consoleEventIndex(parent *onprem.OnPremCustomResource, name string) (int, bool) {
+	suffix, ok := strings.CutPrefix(name, fmt.Sprintf("%s.hpl.", parent.Name))
+	if !ok {
+		return 0, false
+	}
+	idx, err := strconv.Atoi(suffix)
+	return idx, err == nil && idx >= 0
+}
+
+// observedConsoleEvents returns the events of the console log that exist for the custom resource, ordered by the index of their line
+func observedConsoleEvents(req map[string]any, parent *onprem.OnPremCustomResource) []*v1.Event {
+	children, ok := req["children"].(map[string]any)
+	if !ok {
+		return []*v1.Event{}
+	}
+	observed, ok := children[keyEventChildren].(map[string]any)
+	if !ok {
+		return []*v1.Event{}
+	}
+	var events []*v1.Event
+	for name, child := range observed {
+		idx, ok := consoleEventIndex(parent, name)
+		if !ok {
+			continue
+		}
+		event, err := common.Transcode[*v1.Event](child)
+		if err != nil {
+			log.Printf("Unable to decode event [%s], cause: [%v]", name, err)
+			continue
+		}
+		if idx >= len(events) {
+			events = append(events, make([]*v1.Event, idx+1-len(events))...)
+		}
+		events[idx] = event
+	}
+	// only the contiguous sequence of lines is meaningful
+	for idx, event := range events {
+		if event == nil {
+			return events[:idx]
+		}
+	}
+	return events
+}
+
+// consoleEventTimes returns the times the HPL lines have been reported at, indexed like the lines
+func consoleEventTimes(events []*v1.Event) []time.Time {
+	return A.MonadMap(events, func(event *v1.Event) time.Time {
+		return event.FirstTimestamp.Time
+	})
+}
+
+// createConsoleEvent converts an HPL line of the console log into a k8s event on the custom resource
+func createConsoleEvent(parent *onprem.OnPremCustomResource, idx int, line consoleLogLine) *v1.Event {
+	eventType := v1.EventTypeNormal
+	if onprem.IsHPLErrorLogLine(line.Line) {
+		eventType = v1.EventTypeWarning
+	}
+	involved := v1.ObjectReference{
+		Kind:       onprem.KindVSI,
+		APIVersion: onprem.APIVersion,
+		Name:       parent.Name,
+		Namespace:  parent.Namespace,
+		UID:        parent.UID,
+	}
+	// the index is stable since the console log only ever grows
+	name := consoleEventName(parent, idx)
+	return common.CreateChildEvent(involved, name, eventType, onprem.GetHPLToken(line.Line), line.Line, line.Time)
+}
+
+// createConsoleEvents produces the events for all HPL lines of the console log read so far. As long as this process
+// has not read the log, e.g. after a restart of the controller, the observed events are kept.
+func createConsoleEvents(parent *onprem.OnPremCustomResource, observed []*v1.Event) []*v1.Event {
+	current, _ := getConsoleLogByRef(parent.Namespace, parent.Name)
+	if current.Offset == 0 && A.IsEmpty(current.HPLLines) {
+		events := make([]*v1.Event, len(observed))
+		for idx, event := range observed {
+			events[idx] = createConsoleEvent(parent, idx, consoleLogLine{Line: event.Message, Time: event.FirstTimestamp.Time})
+		}
+		return events
+	}
+	events := make([]*v1.Event, len(current.HPLLines))
+	for idx, line := range current.HPLLines {
+		events[idx] = createConsoleEvent(parent, idx, line)
+	}
+	return events
+}
+
+// consoleEventsFromRequest produces the desired event children for a sync request, these need to be part
+// of every sync response, otherwise the metacontroller would delete the existing events
+func consoleEventsFromRequest(req map[string]any) []*v1.Event {
+	cfg, err := common.Transcode[*OnPremConfigResource](req)
+	if err != nil {
+		log.Printf("Unable to decode request, cause: [%v]", err)
+		return []*v1.Event{}
+	}
+	return createConsoleEvents(&cfg.Parent, observedConsoleEvents(req, &cfg.Parent))
+}
@@ -27,7 +27,30 @@ func onpremInstanceOptionsFromConfigMap(data *OnPremConfigResource, envMap env.E
 		Name:        string(data.Parent.UID),
 		UserData:    spec.Contract,
 		ImageURL:    spec.ImageURL,
+		ImageDigest: spec.ImageDigest,
 		StoragePool: onprem.BoxStoragePool(spec.StoragePool),
+		// number of archived console logs
+		ConsoleLogRetention: onprem.BoxConsoleLogRetention(spec.ConsoleLogRetention),
+		// probes of the workload
+		ReadinessProbe: spec.ReadinessProbe,
+		LivenessProbe:  spec.LivenessProbe,
+		// requested power state
+		PowerState: onprem.BoxPowerState(spec.PowerState),
+		// graceful shutdown
+		TerminationGracePeriodSeconds: onprem.BoxTerminationGracePeriodSeconds(spec.TerminationGracePeriodSeconds),
+		// drift of the domain
+		DriftPolicy: onprem.BoxDriftPolicy(spec.DriftPolicy),
+		// protection of the workload
+		SecureExecution: onprem.BoxSecureExecution(spec.SecureExecution),
+		// cloud-init metadata
+		PublicKeys: spec.PublicKeys,
+		// the k8s resource that owns the domain
+		Owner: onprem.InstanceOwner{
+			UID:       string(data.Parent.UID),
+			Namespace: data.Parent.Namespace,
+			Name:      data.Parent.Name,
+			ClusterID: envMap[onprem.KeyClusterID],
+		},
 	}
 	return opt, nil
 }
@@ -0,0 +1,100 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"log"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/env"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	C "github.com/ibm-hyper-protect/terraform-provider-hpcr/contract"
+)
+
+const (
+	// AnnotationMigrateName requests to replace a domain named by the UID of its VSI by a domain named according to
+	// the naming scheme
+	AnnotationMigrateName = "hpse.ibm.com/migrateName"
+
+	// key into the status metadata for the name of the domain of the VSI
+	keyDomainName = "domainName"
+)
+
+// DomainNameFromStatus returns the name of the domain of a VSI
+func DomainNameFromStatus(parent *onprem.OnPremCustomResource) (string, bool) {
+	name, ok := parent.Status.Metadata[keyDomainName].(string)
+	return name, ok && name != ""
+}
+
+// createPreferredInstanceName renders the naming scheme for a VSI, a name taken by a domain that does not belong to
+// the VSI is made unique
+func createPreferredInstanceName(client *onprem.LivirtClient, opt *onprem.InstanceOptions, envMap env.Environment) string {
+	getInstanceMetadata := onprem.GetInstanceMetadata(client)
+	name := onprem.CreateInstanceName(envMap[onprem.KeyNamingScheme], &opt.Owner)
+	if metadata, exists := getInstanceMetadata(name); exists && (metadata == nil || metadata.UID != opt.Owner.UID) {
+		log.Printf("The name [%s] is taken by a different domain, making it unique", name)
+		return onprem.CreateUniqueInstanceName(name, opt.Owner.UID)
+	}
+	return name
+}
+
+// resolveInstanceName decides about the name of the domain of a VSI. Once chosen, the name is kept in the status
+// so a later change of the naming scheme does not affect existing domains. Domains created by previous versions of
+// the operator keep their UID as name until they are migrated.
+func resolveInstanceName(client *onprem.LivirtClient, parent *onprem.OnPremCustomResource, opt *onprem.InstanceOptions, envMap env.Environment) {
+	if name, ok := DomainNameFromStatus(parent); ok {
+		opt.Name = name
+		return
+	}
+	getInstanceMetadata := onprem.GetInstanceMetadata(client)
+	uid := string(parent.UID)
+	if _, exists := getInstanceMetadata(uid); exists {
+		opt.Name = uid
+		return
+	}
+	opt.Name = createPreferredInstanceName(client, opt, envMap)
+}
+
+// migrateOnRequest replaces a domain named by the UID of its VSI with a domain named according to the naming scheme.
+// The old domain is shut down and deleted, the new domain gets created by the regular sync. A status is returned
+// while the old domain is still shutting down.
+func migrateOnRequest(client *onprem.LivirtClient, parent *onprem.OnPremCustomResource, opt *onprem.InstanceOptions, envMap env.Environment) (*common.ResourceStatus, error) {
+	if parent.Annotations[AnnotationMigrateName] != "true" || opt.Adopted || opt.Name != string(parent.UID) {
+		return nil, nil
+	}
+	name := createPreferredInstanceName(client, opt, envMap)
+	if name == opt.Name {
+		return nil, nil
+	}
+	log.Printf("Migrating the VSI [%s] to the name [%s] ...", opt.Name, name)
+	state, err := CreateFinalizeAction(client, opt)
+	if err != nil || state.Status != common.Ready {
+		return state, err
+	}
+	// the new domain starts with a fresh console log and fresh probes
+	removeConsoleLog(parent.Namespace, parent.Name, opt.Name)
+	resetProbes(opt.Name)
+	opt.Name = name
+	return nil, nil
+}
+
+// setDomainName records the name of the domain in the status metadata
+func setDomainName(metadata C.RawMap, opt *onprem.InstanceOptions) C.RawMap {
+	if metadata == nil {
+		metadata = make(C.RawMap)
+	}
+	metadata[keyDomainName] = opt.Name
+	return metadata
+}
@@ -16,8 +16,10 @@ package onprem
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
+	"maps"
 	"net/http"
 
 	A "github.com/IBM/fp-go/array"
@@ -26,8 +28,13 @@ import (
 	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
 	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
 	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/datadisk"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/hypervisorhost"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/image"
 	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/lock"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/network"
 	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/networkref"
+	C "github.com/ibm-hyper-protect/terraform-provider-hpcr/contract"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func CreatePingRoute(version, compileTime string) gin.HandlerFunc {
@@ -40,7 +47,7 @@ func CreatePingRoute(version, compileTime string) gin.HandlerFunc {
 }
 
 // syncOnPrem is invoked to synchronize the state of our resource
-func syncOnPrem(req map[string]any) (*common.ResourceStatus, error) {
+func syncOnPrem(req map[string]any, version string) (*common.ResourceStatus, error) {
 	// just a poor man's solution for now
 	if !lock.Lock.TryLock() {
 		log.Println("Sync: waiting for lock ...")
@@ -61,12 +68,11 @@ func syncOnPrem(req map[string]any) (*common.ResourceStatus, error) {
 		return common.CreateErrorAction(err)
 	}
 
-	client, err := onprem.CreateLivirtClientFromEnvMap(env)
+	// assemble information about the attached managed networks
+	networks, err := onprem.NetworksFromRelated(req)
 	if err != nil {
-		log.Printf("Unable to create libvirt client, cause: [%v]", err)
 		return common.CreateErrorAction(err)
 	}
-	defer client.Close()
 
 	cfg, err := common.Transcode[*OnPremConfigResource](req)
 	if err != nil {
@@ -74,11 +80,46 @@ func syncOnPrem(req map[string]any) (*common.ResourceStatus, error) {
 		return common.CreateErrorAction(err)
 	}
 
+	// a scheduled VSI runs on the hypervisor host it has been placed on
+	if cfg.Parent.Spec.HostSelector != nil {
+		if len(attachedDataDisks) > 0 {
+			log.Printf("Unable to schedule [%s], cause: [%v]", cfg.Parent.Name, errHostSelectorWithDataDisks)
+			return common.CreateErrorAction(errHostSelectorWithDataDisks)
+		}
+		hostEnv, state, err := hostEnvFromRequest(req, &cfg.Parent)
+		if state != nil {
+			return state, err
+		}
+		maps.Copy(env, hostEnv)
+	}
+
+	client, err := onprem.CreateLivirtClientFromEnvMap(env)
+	if err != nil {
+		log.Printf("Unable to create libvirt client, cause: [%v]", err)
+		return common.CreateErrorAction(err)
+	}
+	defer client.Close()
+
 	opt, err := onpremInstanceOptionsFromConfigMap(cfg, env)
 	if err != nil {
 		return common.CreateErrorAction(err)
 	}
 
+	// boot from a pre-staged image if referenced
+	if len(cfg.Parent.Spec.Image) > 0 {
+		images, err := onprem.ImagesFromRelated(req)
+		if err != nil {
+			return common.CreateErrorAction(err)
+		}
+		img, err := onprem.ResolveImage(images, cfg.Parent.Spec.Image)
+		if err != nil {
+			log.Printf("Unable to resolve the image of VSI [%s], cause: [%v]", cfg.Parent.Name, err)
+			return common.CreateErrorAction(err)
+		}
+		opt.ImageURL = img.Spec.ImageURL
+		opt.ImageDigest = img.Spec.ImageDigest
+	}
+
 	// dump the attached network references
 	if A.IsNonEmpty(networkRefs) {
 		// extract names
@@ -93,10 +134,56 @@ func syncOnPrem(req map[string]any) (*common.ResourceStatus, error) {
 	opt.DataDisks = attachedDataDisks
 
 	// attach networks
-	opt.Networks = onprem.NetworkRefCustomResourceToNetworks(networkRefs)
+	opt.Networks = append(onprem.NetworkRefCustomResourceToNetworks(networkRefs), onprem.NetworkCustomResourceToNetworks(networks)...)
+	opt.StaticIPs = onprem.NetworkRefCustomResourceToStaticIPs(cfg.Parent.Name)(networkRefs)
+	opt.InterfaceConfigs, err = onprem.NetworkRefCustomResourceToInterfaceConfigs(cfg.Parent.Name)(networkRefs)
+	if err != nil {
+		log.Printf("Unable to configure the interfaces of VSI [%s], cause: [%v]", cfg.Parent.Name, err)
+		return common.CreateErrorAction(err)
+	}
+
+	// the name of the domain and the metadata of the operator
+	opt.OperatorVersion = version
+	resolveInstanceName(client, &cfg.Parent, opt, env)
+
+	// import an existing domain if requested
+	if err := adoptOnRequest(client, &cfg.Parent, opt); err != nil {
+		return common.CreateErrorAction(err)
+	}
+
+	// replace a domain named by its UID if requested
+	if state, err := migrateOnRequest(client, &cfg.Parent, opt, env); state != nil {
+		state.Metadata = setDomainName(state.Metadata, opt)
+		return state, err
+	}
+
+	// make the console log accessible by resource name
+	registerConsoleLog(cfg.Parent.Namespace, cfg.Parent.Name, opt.Name)
+	// continue with the console log reported before a restart of the controller
+	restoreConsoleLog(opt.Name, logOffsetFromStatus(&cfg.Parent), consoleEventTimes(observedConsoleEvents(req, &cfg.Parent)))
+
+	// restart the VSI if requested
+	restartedAt, err := restartOnRequest(client, &cfg.Parent, opt)
+	if err != nil {
+		log.Printf("Unable to restart the VSI [%s], cause: [%v]", opt.Name, err)
+		return common.CreateErrorAction(err)
+	}
 
 	// make sure to construct the VSI
-	return CreateSyncAction(client, opt)
+	state, err := CreateSyncAction(client, opt)
+	if state != nil && restartedAt != "" {
+		if state.Metadata == nil {
+			state.Metadata = make(C.RawMap)
+		}
+		state.Metadata[keyRestartedAt] = restartedAt
+	}
+	if state != nil {
+		state.Metadata = setDomainName(setAdoptedDomain(state.Metadata, opt), opt)
+	}
+	if state != nil && state.Metadata != nil {
+		state.Metadata["logsURL"] = GetConsoleLogsPath(cfg.Parent.Namespace, cfg.Parent.Name)
+	}
+	return state, err
 }
 
 // finalizeOnPrem deletes a VSI
@@ -110,6 +197,30 @@ func finalizeOnPrem(req map[string]any) (*common.ResourceStatus, error) {
 
 	env := common.EnvFromConfigMapsOrSecrets(req)
 
+	cfg, err := common.Transcode[*OnPremConfigResource](req)
+	if err != nil {
+		log.Printf("Unable to decode request, cause: [%v]", err)
+		return common.CreateErrorAction(err)
+	}
+
+	// a scheduled VSI runs on the hypervisor host it has been placed on
+	if cfg.Parent.Spec.HostSelector != nil {
+		_, selector, ok := placementFromStatus(&cfg.Parent)
+		if !ok {
+			// never placed, so nothing has been created
+			removePlacement(cfg.Parent.UID)
+			return common.CreateReadyAction()
+		}
+		hostEnv, err := common.EnvFromConfigMapsOrSecretsBySelector(req, selector)
+		if err != nil {
+			return common.CreateErrorAction(err)
+		}
+		if len(hostEnv) == 0 {
+			return common.CreateErrorAction(fmt.Errorf("no configuration for the hypervisor host of [%s]", cfg.Parent.Name))
+		}
+		maps.Copy(env, hostEnv)
+	}
+
 	client, err := onprem.CreateLivirtClientFromEnvMap(env)
 	if err != nil {
 		log.Printf("Unable to create libvirt client, cause: [%v]", err)
@@ -117,21 +228,35 @@ func finalizeOnPrem(req map[string]any) (*common.ResourceStatus, error) {
 	}
 	defer client.Close()
 
-	cfg, err := common.Transcode[*OnPremConfigResource](req)
+	opt, err := onpremInstanceOptionsFromConfigMap(cfg, env)
 	if err != nil {
-		log.Printf("Unable to decode request, cause: [%v]", err)
 		return common.CreateErrorAction(err)
 	}
 
-	opt, err := onpremInstanceOptionsFromConfigMap(cfg, env)
+	// the networks carrying DHCP host entries of the VSI
+	networkRefs, err := onprem.NetworkRefsFromRelated(req)
 	if err != nil {
 		return common.CreateErrorAction(err)
 	}
+	networks, err := onprem.NetworksFromRelated(req)
+	if err != nil {
+		return common.CreateErrorAction(err)
+	}
+	opt.Networks = append(onprem.NetworkRefCustomResourceToNetworks(networkRefs), onprem.NetworkCustomResourceToNetworks(networks)...)
+	// the domain of the VSI, an adopted domain keeps its name
+	resolveInstanceName(client, &cfg.Parent, opt, env)
+	useAdoptedDomain(&cfg.Parent, opt)
 
-	return CreateFinalizeAction(client, opt)
+	state, err := CreateFinalizeAction(client, opt)
+	if err == nil && state.Status == common.Ready {
+		removeConsoleLog(cfg.Parent.Namespace, cfg.Parent.Name, opt.Name)
+		removePlacement(cfg.Parent.UID)
+		resetProbes(opt.Name)
+	}
+	return state, err
 }
 
-func CreateControllerSyncRoute() gin.HandlerFunc {
+func CreateControllerSyncRoute(version string) gin.HandlerFunc {
 
 	return func(c *gin.Context) {
 		// log this config
@@ -158,16 +283,25 @@ func CreateControllerSyncRoute() gin.HandlerFunc {
 		// log the request
 		// log.Printf("JSON Input [%s]", string(jsonData))
 		// execute and handle
-		state, err := syncOnPrem(req)
+		state, err := syncOnPrem(req, version)
+		// keep the placement on the hypervisor host
+		preservePlacement(req, state)
+		// remember the restart requests that have been handled, the last forced shutdown and the name of the domain
+		preserveMetadata(req, state, keyRestartedAt, keyLastShutdown, keyAdoptedDomain, keyDomainName, keyBaseImage, keyLogOffset)
+		// the events derived from the console log
+		events := consoleEventsFromRequest(req)
 		if err != nil {
 			log.Printf("Error [%v]", err)
 			// switch into error mode
-			c.JSON(http.StatusOK, common.ResourceStatusToResponse(state))
+			resp := common.ResourceStatusToResponse(state)
+			resp["children"] = events
+			c.JSON(http.StatusOK, resp)
 			// bail out
 			return
 		}
 		// done
 		resp := common.ResourceStatusToResponse(state)
+		resp["children"] = events
 		// set a retry if we are not ready, yet
 		if state.Status != common.Ready {
 			resp["resyncAfterSeconds"] = 10
@@ -222,6 +356,8 @@ func CreateControllerFinalizeRoute() gin.HandlerFunc {
 			"finalized": finalized,
 		}
 		if !finalized {
+			// report the progress, e.g. of the graceful shutdown
+			maps.Copy(resp, common.ResourceStatusToResponse(state))
 			resp["resyncAfterSeconds"] = 10
 		}
 		// final response
@@ -230,6 +366,23 @@ func CreateControllerFinalizeRoute() gin.HandlerFunc {
 	}
 }
 
+// CreateLogsRoute serves the console log of a VSI identified by namespace and name of the custom resource
+func CreateLogsRoute() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		namespace := c.Param("namespace")
+		name := c.Param("name")
+		// lookup the log
+		current, ok := getConsoleLogByRef(namespace, name)
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": fmt.Sprintf("console log for [%s] in namespace [%s] has not been read, yet", name, namespace),
+			})
+			return
+		}
+		c.String(http.StatusOK, current.Content)
+	}
+}
+
 // CreateControllerCustomizeRoute is invoked to
 func CreateControllerCustomizeRoute() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -265,6 +418,11 @@ func CreateControllerCustomizeRoute() gin.HandlerFunc {
 		}
 		// print namespace
 		log.Printf("Getting related resources for [%s] in namespace [%s] ...", cfg.Parent.Name, cfg.Parent.Namespace)
+		// the selector of the SSH configuration of the hypervisor host, once placed
+		var hostTargetSelector *metav1.LabelSelector
+		if cfg.Parent.Spec.HostSelector != nil {
+			_, hostTargetSelector, _ = placementFromStatus(&cfg.Parent)
+		}
 		// produce a response
 		resp := common.CustomizeHookResponse{
 			RelatedResourceRules: common.CreateRelatedResourceRules([]common.RelatedResource{
@@ -276,8 +434,17 @@ func CreateControllerCustomizeRoute() gin.HandlerFunc {
 				datadisk.RefDataDiskRefs(cfg.Parent.Spec.DiskSelector),
 				// networks
 				networkref.RefNetworkRefs(cfg.Parent.Spec.NetworkSelector),
+				network.RefNetworks(cfg.Parent.Spec.NetworkSelector),
+				// scheduling
+				hypervisorhost.RefHypervisorHosts(cfg.Parent.Spec.HostSelector),
+				RefVSIs(cfg.Parent.Spec.AntiAffinitySelector),
+				// config of the hypervisor host the VSI is placed on
+				common.RefConfigMaps(hostTargetSelector),
+				common.RefSecrets(hostTargetSelector),
 			}),
 		}
+		// the image the VSI boots from
+		resp.RelatedResourceRules = append(resp.RelatedResourceRules, image.RefImageByName(cfg.Parent.Spec.Image)...)
 		// dump it
 		data, err := json.Marshal(resp)
 		if err == nil {
@@ -0,0 +1,216 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/env"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	C "github.com/ibm-hyper-protect/terraform-provider-hpcr/contract"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// status metadata key of the hypervisor host the VSI is placed on
+	keyHost = "host"
+	// status metadata key of the selector of the SSH configuration of the hypervisor host
+	keyHostTargetSelector = "hostTargetSelector"
+)
+
+type placement struct {
+	// name of the hypervisor host
+	host string
+	// time of the scheduling decision
+	scheduled time.Time
+}
+
+var (
+	// the volumes of data disks live on one host, a scheduled VSI might be placed on another one
+	errHostSelectorWithDataDisks = errors.New("data disks cannot be attached to a VSI with a host selector")
+
+	placementsLock sync.Mutex
+	// scheduling decisions of this controller, keyed by the UID of the VSI. The status of the hypervisor hosts
+	// reflects a placement only after the next sync of the host, until then the decision reserves its resources.
+	placements = make(map[types.UID]placement)
+)
+
+// recordPlacement remembers the scheduling decision for a VSI
+func recordPlacement(uid types.UID, host string) {
+	placementsLock.Lock()
+	defer placementsLock.Unlock()
+
+	placements[uid] = placement{host: host, scheduled: time.Now().UTC()}
+}
+
+// removePlacement forgets the scheduling decision for a deleted VSI
+func removePlacement(uid types.UID) {
+	placementsLock.Lock()
+	defer placementsLock.Unlock()
+
+	delete(placements, uid)
+}
+
+// getPlacements returns a copy of the scheduling decisions
+func getPlacements() map[types.UID]placement {
+	placementsLock.Lock()
+	defer placementsLock.Unlock()
+
+	return maps.Clone(placements)
+}
+
+// placementFromStatus returns the hypervisor host and the selector of its SSH configuration recorded in the status
+func placementFromStatus(parent *onprem.OnPremCustomResource) (string, *metav1.LabelSelector, bool) {
+	host, ok := parent.Status.Metadata[keyHost].(string)
+	if !ok || host == "" {
+		return "", nil, false
+	}
+	selector, err := common.Transcode[*metav1.LabelSelector](parent.Status.Metadata[keyHostTargetSelector])
+	if err != nil || selector == nil {
+		log.Printf("Unable to decode the host target selector of [%s], cause: [%v]", parent.Name, err)
+		return "", nil, false
+	}
+	return host, selector, true
+}
+
+// createPlacementMetadata produces the status metadata that records the placement of a VSI
+func createPlacementMetadata(host *onprem.HypervisorHostCustomResource) C.RawMap {
+	return C.RawMap{
+		keyHost:               host.Name,
+		keyHostTargetSelector: host.Spec.TargetSelector,
+	}
+}
+
+// preservePlacement copies the placement of the previous status into the new status, so the placement
+// survives errors and stays sticky
+func preservePlacement(req map[string]any, state *common.ResourceStatus) {
+	preserveMetadata(req, state, keyHost, keyHostTargetSelector)
+}
+
+// preserveMetadata copies the given keys of the previous status metadata into a status that does not set them
+func preserveMetadata(req map[string]any, state *common.ResourceStatus, keys ...string) {
+	cfg, err := common.Transcode[*OnPremConfigResource](req)
+	if err != nil || state == nil {
+		return
+	}
+	for _, key := range keys {
+		value, ok := cfg.Parent.Status.Metadata[key]
+		if !ok {
+			continue
+		}
+		if state.Metadata == nil {
+			state.Metadata = make(C.RawMap)
+		}
+		if _, ok := state.Metadata[key]; !ok {
+			state.Metadata[key] = value
+		}
+	}
+}
+
+// scheduleInstance selects the hypervisor host for a VSI from the hosts matching its host selector
+func scheduleInstance(req map[string]any, parent *onprem.OnPremCustomResource) (*onprem.HypervisorHostCustomResource, error) {
+	hosts, err := onprem.HostsFromRelated(req)
+	if err != nil {
+		return nil, err
+	}
+	peers, err := onprem.VSIsFromRelated(req)
+	if err != nil {
+		return nil, err
+	}
+	pending := getPlacements()
+	// the hosts of the VSIs matching the anti-affinity selector
+	var excluded []string
+	for _, peer := range peers {
+		if peer.UID == parent.UID {
+			continue
+		}
+		if host, _, ok := placementFromStatus(peer); ok {
+			excluded = append(excluded, host)
+		} else if p, ok := pending[peer.UID]; ok {
+			excluded = append(excluded, p.host)
+		}
+	}
+	schedReq := onprem.CreateSchedulingRequest(parent.Spec.StoragePool, excluded)
+	// decode the resources of the hosts
+	candidates := make([]*onprem.HostCandidate, 0, len(hosts))
+	byName := make(map[string]*onprem.HypervisorHostCustomResource)
+	for _, host := range hosts {
+		candidate, err := onprem.CreateHostCandidate(host)
+		if err != nil {
+			return nil, err
+		}
+		// account for decisions the host has not reported, yet
+		for uid, p := range pending {
+			if uid != parent.UID && p.host == host.Name && p.scheduled.After(candidate.Info.Observed) {
+				candidate.Reserve(schedReq)
+			}
+		}
+		candidates = append(candidates, candidate)
+		byName[host.Name] = host
+	}
+	chosen, err := onprem.ScheduleInstance(schedReq)(candidates)
+	if err != nil {
+		return nil, err
+	}
+	return byName[chosen.Name], nil
+}
+
+// hostEnvFromRequest returns the SSH configuration of the hypervisor host of a VSI with a host selector. A VSI
+// without placement is scheduled first, the configuration of the host becomes available with the next sync after
+// the customize hook selected its config maps and secrets. A non-nil status reports that the VSI is not ready to
+// be synchronized, yet.
+func hostEnvFromRequest(req map[string]any, parent *onprem.OnPremCustomResource) (env.Environment, *common.ResourceStatus, error) {
+	host, selector, ok := placementFromStatus(parent)
+	if !ok {
+		chosen, err := scheduleInstance(req, parent)
+		if errors.Is(err, onprem.ErrNoHostAvailable) {
+			log.Printf("Unable to schedule [%s], cause: [%v]", parent.Name, err)
+			return nil, &common.ResourceStatus{
+				Status:      common.Waiting,
+				Description: err.Error(),
+			}, nil
+		}
+		if err != nil {
+			state, err := common.CreateErrorAction(err)
+			return nil, state, err
+		}
+		log.Printf("Scheduled [%s] in namespace [%s] on hypervisor host [%s]", parent.Name, parent.Namespace, chosen.Name)
+		recordPlacement(parent.UID, chosen.Name)
+		return nil, &common.ResourceStatus{
+			Status:      common.Waiting,
+			Description: fmt.Sprintf("Scheduled on hypervisor host [%s], waiting for its configuration", chosen.Name),
+			Metadata:    createPlacementMetadata(chosen),
+		}, nil
+	}
+	hostEnv, err := common.EnvFromConfigMapsOrSecretsBySelector(req, selector)
+	if err != nil {
+		state, err := common.CreateErrorAction(err)
+		return nil, state, err
+	}
+	if len(hostEnv) == 0 {
+		return nil, &common.ResourceStatus{
+			Status:      common.Waiting,
+			Description: fmt.Sprintf("Waiting for the configuration of hypervisor host [%s]", host),
+		}, nil
+	}
+	return hostEnv, nil, nil
+}
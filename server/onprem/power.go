@@ -0,0 +1,81 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	C "github.com/ibm-hyper-protect/terraform-provider-hpcr/contract"
+)
+
+const (
+	// AnnotationRestartedAt requests a restart of a VSI, each new value triggers one restart
+	AnnotationRestartedAt = "hpse.ibm.com/restartedAt"
+
+	// key into the status metadata for the actual power state of the domain
+	keyPowerState = "powerState"
+	// key into the status metadata for the value of the last restart request that has been handled
+	keyRestartedAt = "restartedAt"
+)
+
+// createPowerStateAction reports a VSI that is not requested to run or that has not reached its power state, yet
+func createPowerStateAction(opt *onprem.InstanceOptions, actual *onprem.DomainPowerState) (*common.ResourceStatus, error) {
+	metadata := C.RawMap{
+		keyPowerState: actual,
+	}
+	if onprem.IsPowerStateReached(opt.PowerState, actual) {
+		return common.CreateAction(&common.ResourceStatus{
+			Status:      common.Ready,
+			Description: actual.State,
+			Metadata:    metadata,
+		})
+	}
+	return common.CreateAction(&common.ResourceStatus{
+		Status:      common.Waiting,
+		Description: fmt.Sprintf("Domain is [%s], requested power state is [%s]", actual.State, opt.PowerState),
+		Metadata:    metadata,
+	})
+}
+
+// restartOnRequest restarts a running VSI if its restart annotation carries a value that has not been handled, yet.
+// It returns the value of the handled request or an empty string if there is nothing to handle.
+func restartOnRequest(client *onprem.LivirtClient, parent *onprem.OnPremCustomResource, opt *onprem.InstanceOptions) (string, error) {
+	requested := parent.Annotations[AnnotationRestartedAt]
+	if requested == "" {
+		return "", nil
+	}
+	if handled, _ := parent.Status.Metadata[keyRestartedAt].(string); handled == requested {
+		return "", nil
+	}
+	// only a running domain is restarted, otherwise the request is void
+	getPowerState := onprem.GetDomainPowerState(client)
+	actual, err := getPowerState(opt.Name)
+	if err != nil || actual.State != onprem.DomainStateRunning {
+		log.Printf("VSI [%s] is not running, ignoring the restart request [%s]", opt.Name, requested)
+		return requested, nil
+	}
+	log.Printf("Restarting VSI [%s] on request [%s] ...", opt.Name, requested)
+	restartSync := onprem.RestartInstanceSync(client)
+	if err := restartSync(opt); err != nil {
+		return "", err
+	}
+	// the new boot starts with a fresh console log and fresh probes
+	resetConsoleLog(opt.Name)
+	resetProbes(opt.Name)
+	return requested, nil
+}
@@ -0,0 +1,231 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	A "github.com/IBM/fp-go/array"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// condition reflecting the readiness probe
+	ConditionReady = "Ready"
+	// condition reflecting the liveness probe
+	ConditionLive = "Live"
+
+	reasonProbeSucceeded = "ProbeSucceeded"
+	reasonProbeFailed    = "ProbeFailed"
+	reasonRestarted      = "Restarted"
+	reasonRecreated      = "Recreated"
+
+	probeReadiness = "readiness"
+	probeLiveness  = "liveness"
+)
+
+// probeState tracks the results of a probe across syncs
+type probeState struct {
+	// number of consecutive failures
+	Failures int
+	// the probe succeeded at least once since the VSI started
+	Succeeded bool
+	// the status of the condition reflecting the probe
+	Status metav1.ConditionStatus
+	// time of the last change of the condition
+	LastTransition time.Time
+	// the failure policy marked the VSI for recreation
+	Recreate bool
+}
+
+var (
+	probeStatesLock sync.Mutex
+	// probe states keyed by the name of the VSI and the kind of probe
+	probeStates = make(map[string]map[string]*probeState)
+)
+
+// recordProbeResult updates the state of a probe with a new result and returns a copy of the state
+func recordProbeResult(vsi, probe string, err error) probeState {
+	probeStatesLock.Lock()
+	defer probeStatesLock.Unlock()
+
+	states, ok := probeStates[vsi]
+	if !ok {
+		states = make(map[string]*probeState)
+		probeStates[vsi] = states
+	}
+	state, ok := states[probe]
+	if !ok {
+		state = &probeState{}
+		states[probe] = state
+	}
+	if err == nil {
+		state.Failures = 0
+		state.Succeeded = true
+	} else {
+		state.Failures++
+	}
+	return *state
+}
+
+// setProbeCondition records the status of the condition of a probe and returns the time of its last transition
+func setProbeCondition(vsi, probe string, status metav1.ConditionStatus) time.Time {
+	probeStatesLock.Lock()
+	defer probeStatesLock.Unlock()
+
+	state := probeStates[vsi][probe]
+	if state.Status != status {
+		state.Status = status
+		state.LastTransition = time.Now().UTC()
+	}
+	return state.LastTransition
+}
+
+// requestRecreate marks a VSI for recreation, the next sync recreates it like after a change of its spec
+func requestRecreate(vsi, probe string) {
+	probeStatesLock.Lock()
+	defer probeStatesLock.Unlock()
+
+	if state, ok := probeStates[vsi][probe]; ok {
+		state.Recreate = true
+	}
+}
+
+// isRecreateRequested tests if a probe marked the VSI for recreation
+func isRecreateRequested(vsi string) bool {
+	probeStatesLock.Lock()
+	defer probeStatesLock.Unlock()
+
+	for _, state := range probeStates[vsi] {
+		if state.Recreate {
+			return true
+		}
+	}
+	return false
+}
+
+// resetProbes forgets the probe results of a VSI, e.g. because it has been restarted
+func resetProbes(vsi string) {
+	probeStatesLock.Lock()
+	defer probeStatesLock.Unlock()
+
+	delete(probeStates, vsi)
+}
+
+// isInstanceStarted tests if the status reports a VSI that booted successfully
+func isInstanceStarted(state *common.ResourceStatus) bool {
+	if state == nil || state.Status != common.Ready {
+		return false
+	}
+	_, ok := state.Metadata["ipaddresses"]
+	return ok
+}
+
+// createProbeCondition produces the condition reflecting a probe
+func createProbeCondition(vsi, probe, conditionType string, ok bool, reason string, err error, failures int) metav1.Condition {
+	status := metav1.ConditionTrue
+	message := "Probe succeeded"
+	if !ok {
+		status = metav1.ConditionFalse
+	}
+	if err != nil {
+		message = fmt.Sprintf("[%d] consecutive failures, last error: %v", failures, err)
+	}
+	return metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: metav1.NewTime(setProbeCondition(vsi, probe, status)),
+	}
+}
+
+// applyProbes runs the probes of a started VSI and reflects the results in the conditions of its status. A VSI
+// that failed its liveness probe is restarted or recreated according to the failure policy.
+func applyProbes(client *onprem.LivirtClient, state *common.ResourceStatus, opt *onprem.InstanceOptions) (*common.ResourceStatus, error) {
+	if opt.ReadinessProbe == nil && opt.LivenessProbe == nil {
+		return state, nil
+	}
+	addresses, _ := state.Metadata["ipaddresses"].([]string)
+	lines := A.MonadMap(getConsoleLog(opt.Name).HPLLines, getConsoleLogLine)
+
+	var conditions []metav1.Condition
+	// the probes of a VSI share a common budget, so an unreachable VSI cannot block the controller
+	deadline := time.Now().Add(onprem.MaxProbeDurationSeconds * time.Second)
+
+	if opt.ReadinessProbe != nil {
+		err := onprem.RunProbe(opt.ReadinessProbe, addresses, lines, deadline)
+		result := recordProbeResult(opt.Name, probeReadiness, err)
+		// a ready VSI tolerates failures up to the threshold
+		ready := err == nil || (result.Succeeded && result.Failures < onprem.BoxProbeFailureThreshold(opt.ReadinessProbe.FailureThreshold))
+		reason := reasonProbeSucceeded
+		if err != nil {
+			reason = reasonProbeFailed
+		}
+		conditions = append(conditions, createProbeCondition(opt.Name, probeReadiness, ConditionReady, ready, reason, err, result.Failures))
+		if !ready {
+			log.Printf("Readiness probe of VSI [%s] failed, cause: [%v]", opt.Name, err)
+			state.Status = common.Waiting
+			state.Description = fmt.Sprintf("Readiness probe failed: %v", err)
+		}
+	}
+
+	if opt.LivenessProbe != nil {
+		err := onprem.RunProbe(&opt.LivenessProbe.ProbeSpec, addresses, lines, deadline)
+		result := recordProbeResult(opt.Name, probeLiveness, err)
+		threshold := onprem.BoxProbeFailureThreshold(opt.LivenessProbe.FailureThreshold)
+		// failures only count once the workload came up, a slow start does not trigger the failure policy
+		live := !result.Succeeded || result.Failures < threshold
+		reason := reasonProbeSucceeded
+		if err != nil {
+			reason = reasonProbeFailed
+		}
+		if !live {
+			log.Printf("Liveness probe of VSI [%s] failed [%d] times, cause: [%v]", opt.Name, result.Failures, err)
+			state.Status = common.Waiting
+			state.Description = fmt.Sprintf("Liveness probe failed: %v", err)
+
+			switch onprem.BoxProbeFailurePolicy(opt.LivenessProbe.FailurePolicy) {
+			case onprem.ProbeFailurePolicyRestart:
+				restartSync := onprem.RestartInstanceSync(client)
+				if err := restartSync(opt); err != nil {
+					log.Printf("Unable to restart the VSI [%s], cause: [%v]", opt.Name, err)
+					return common.CreateErrorAction(err)
+				}
+				reason = reasonRestarted
+				state.Description = fmt.Sprintf("Restarted after [%d] failed liveness probes", result.Failures)
+			case onprem.ProbeFailurePolicyRecreate:
+				// the recreation gets the grace period and the checks of a change of the spec
+				requestRecreate(opt.Name, probeLiveness)
+				reason = reasonRecreated
+				state.Description = fmt.Sprintf("Recreating after [%d] failed liveness probes", result.Failures)
+			}
+		}
+		conditions = append(conditions, createProbeCondition(opt.Name, probeLiveness, ConditionLive, live, reason, err, result.Failures))
+		if reason == reasonRestarted {
+			// the new boot starts with a fresh console log and fresh probes
+			resetConsoleLog(opt.Name)
+			resetProbes(opt.Name)
+		}
+	}
+
+	state.Metadata["conditions"] = conditions
+	return state, nil
+}
@@ -0,0 +1,101 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package onprem
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	C "github.com/ibm-hyper-protect/terraform-provider-hpcr/contract"
+)
+
+// key into the status metadata for the result of the last shutdown that needed to destroy the domain
+const keyLastShutdown = "lastShutdown"
+
+var (
+	shutdownsLock sync.Mutex
+	// time of the first shutdown request keyed by the name of the VSI, the shutdown spans several syncs
+	shutdowns = make(map[string]time.Time)
+)
+
+// beginShutdown returns the time the shutdown of a VSI has been requested first
+func beginShutdown(vsi string) time.Time {
+	shutdownsLock.Lock()
+	defer shutdownsLock.Unlock()
+
+	requestedAt, ok := shutdowns[vsi]
+	if !ok {
+		requestedAt = time.Now()
+		shutdowns[vsi] = requestedAt
+	}
+	return requestedAt
+}
+
+// endShutdown forgets the shutdown of a VSI
+func endShutdown(vsi string) {
+	shutdownsLock.Lock()
+	defer shutdownsLock.Unlock()
+
+	delete(shutdowns, vsi)
+}
+
+// shutdownInstance advances the graceful shutdown of a VSI, the domain is destroyed once the termination grace
+// period has elapsed
+func shutdownInstance(client *onprem.LivirtClient, opt *onprem.InstanceOptions) (*onprem.ShutdownResult, error) {
+	shutdownDomain := onprem.ShutdownDomain(client)
+	checkOwnership := onprem.CheckDomainOwnership(client)
+	// never shut down the domain of a different cluster
+	if err := checkOwnership(opt.Name, &opt.Owner); err != nil {
+		return nil, err
+	}
+
+	requestedAt := beginShutdown(opt.Name)
+	result, err := shutdownDomain(opt.Name, requestedAt, time.Duration(opt.TerminationGracePeriodSeconds)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if result.Done {
+		endShutdown(opt.Name)
+	}
+	if result.Forced {
+		log.Printf("VSI [%s] did not shut down gracefully, cause: [%s]", opt.Name, result.Reason)
+	}
+	return result, nil
+}
+
+// createShutdownAction reports a VSI that is shutting down
+func createShutdownAction(opt *onprem.InstanceOptions) (*common.ResourceStatus, error) {
+	return common.CreateAction(&common.ResourceStatus{
+		Status:      common.Waiting,
+		Description: fmt.Sprintf("Shutting down, the grace period is [%ds]", opt.TerminationGracePeriodSeconds),
+	})
+}
+
+// createShutdownMetadata records a shutdown that needed to destroy the domain
+func createShutdownMetadata(result *onprem.ShutdownResult) C.RawMap {
+	if !result.Forced {
+		return nil
+	}
+	return C.RawMap{
+		keyLastShutdown: C.RawMap{
+			"reason": result.Reason,
+			"time":   time.Now().UTC().Format(time.RFC3339),
+		},
+	}
+}
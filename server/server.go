@@ -21,8 +21,16 @@ import (
 
 	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/datadisk"
 	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/datadiskref"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/datadiskrestore"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/datadisksnapshot"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/garbagecollector"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/hpcrset"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/hypervisorhost"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/image"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/network"
 	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/networkref"
 	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/storagepool"
 	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/vpc"
 )
 
@@ -38,9 +46,10 @@ func CreateServer(version, compileTime string) func(port int) error {
 	r.POST("/vpc/customize", vpc.CreateControllerCustomizeRoute())
 	// register the onprem routes
 	r.GET("/onprem/ping", onprem.CreatePingRoute(version, compileTime))
-	r.POST("/onprem/sync", onprem.CreateControllerSyncRoute())
+	r.POST("/onprem/sync", onprem.CreateControllerSyncRoute(version))
 	r.POST("/onprem/finalize", onprem.CreateControllerFinalizeRoute())
 	r.POST("/onprem/customize", onprem.CreateControllerCustomizeRoute())
+	r.GET("/onprem/logs/:namespace/:name", onprem.CreateLogsRoute())
 	// register the data disk routes
 	r.GET("/datadisk/ping", datadisk.CreatePingRoute(version, compileTime))
 	r.POST("/datadisk/sync", datadisk.CreateControllerSyncRoute())
@@ -54,6 +63,43 @@ func CreateServer(version, compileTime string) func(port int) error {
 	r.GET("/networkref/ping", networkref.CreatePingRoute(version, compileTime))
 	r.POST("/networkref/sync", networkref.CreateControllerSyncRoute())
 	r.POST("/networkref/customize", networkref.CreateControllerCustomizeRoute())
+	// register the network routes
+	r.GET("/network/ping", network.CreatePingRoute(version, compileTime))
+	r.POST("/network/sync", network.CreateControllerSyncRoute())
+	r.POST("/network/finalize", network.CreateControllerFinalizeRoute())
+	r.POST("/network/customize", network.CreateControllerCustomizeRoute())
+	// register the storage pool routes
+	r.GET("/storagepool/ping", storagepool.CreatePingRoute(version, compileTime))
+	r.POST("/storagepool/sync", storagepool.CreateControllerSyncRoute())
+	r.POST("/storagepool/finalize", storagepool.CreateControllerFinalizeRoute())
+	r.POST("/storagepool/customize", storagepool.CreateControllerCustomizeRoute())
+
+	r.GET("/datadisksnapshot/ping", datadisksnapshot.CreatePingRoute(version, compileTime))
+	r.POST("/datadisksnapshot/sync", datadisksnapshot.CreateControllerSyncRoute())
+	r.POST("/datadisksnapshot/finalize", datadisksnapshot.CreateControllerFinalizeRoute())
+	r.POST("/datadisksnapshot/customize", datadisksnapshot.CreateControllerCustomizeRoute())
+
+	r.GET("/datadiskrestore/ping", datadiskrestore.CreatePingRoute(version, compileTime))
+	r.POST("/datadiskrestore/sync", datadiskrestore.CreateControllerSyncRoute())
+	r.POST("/datadiskrestore/customize", datadiskrestore.CreateControllerCustomizeRoute())
+
+	r.GET("/onpremset/ping", hpcrset.CreatePingRoute(version, compileTime))
+	r.POST("/onpremset/sync", hpcrset.CreateControllerSyncRoute(hpcrset.FlavourOnPrem))
+
+	r.GET("/vpcset/ping", hpcrset.CreatePingRoute(version, compileTime))
+	r.POST("/vpcset/sync", hpcrset.CreateControllerSyncRoute(hpcrset.FlavourVPC))
+
+	r.GET("/hypervisorhost/ping", hypervisorhost.CreatePingRoute(version, compileTime))
+	r.POST("/hypervisorhost/sync", hypervisorhost.CreateControllerSyncRoute())
+	r.POST("/hypervisorhost/customize", hypervisorhost.CreateControllerCustomizeRoute())
+
+	r.GET("/garbagecollector/ping", garbagecollector.CreatePingRoute(version, compileTime))
+	r.POST("/garbagecollector/sync", garbagecollector.CreateControllerSyncRoute())
+	r.POST("/garbagecollector/customize", garbagecollector.CreateControllerCustomizeRoute())
+
+	r.GET("/image/ping", image.CreatePingRoute(version, compileTime))
+	r.POST("/image/sync", image.CreateControllerSyncRoute())
+	r.POST("/image/customize", image.CreateControllerCustomizeRoute())
 
 	return func(port int) error {
 		return r.Run(fmt.Sprintf(":%d", port))
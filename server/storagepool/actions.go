@@ -0,0 +1,105 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package storagepool
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	A "github.com/IBM/fp-go/array"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	C "github.com/ibm-hyper-protect/terraform-provider-hpcr/contract"
+)
+
+const (
+	// key into the status metadata recording that the pool has been defined by the operator
+	keyOwned = "owned"
+)
+
+// ownedFromStatus tests if the status records that the pool has been defined by the operator
+func ownedFromStatus(parent *onprem.StoragePoolCustomResource) bool {
+	owned, ok := parent.Status.Metadata[keyOwned].(bool)
+	return ok && owned
+}
+
+// createStoragePoolReadyAction create the action
+func createStoragePoolReadyAction(info *onprem.StoragePoolInfo, owned bool) (*common.ResourceStatus, error) {
+	usage := info.Usage()
+	return &common.ResourceStatus{
+		Status:      common.Ready,
+		Description: fmt.Sprintf("Storage pool [%s] is [%d%%] full, [%d] of [%d] bytes available", info.Name, usage, info.Available, info.Capacity),
+		Error:       nil,
+		Metadata: C.RawMap{
+			"Name":       info.Name,
+			"capacity":   info.Capacity,
+			"allocation": info.Allocation,
+			"available":  info.Available,
+			"usage":      usage,
+			keyOwned:     owned,
+		},
+	}, nil
+}
+
+// CreateSyncAction synchronizes the state of the resource and determines what to do next. The owned flag records
+// if the pool has been defined by the operator before.
+func CreateSyncAction(client *onprem.LivirtClient, opt *onprem.StoragePoolOptions, owned bool) (*common.ResourceStatus, error) {
+	// create the pool or make sure it is running
+	poolSync := onprem.CreateStoragePoolSync(client)
+	info, created, err := poolSync(opt)
+	// the ownership must not get lost, even if the pool could not be started
+	owned = owned || created
+	if err != nil {
+		log.Printf("Unable to synchronize storage pool [%s], cause: [%v]", opt.Name, err)
+		state, err := common.CreateErrorAction(err)
+		state.Metadata = C.RawMap{
+			keyOwned: owned,
+		}
+		return state, err
+	}
+	return createStoragePoolReadyAction(info, owned)
+}
+
+// CreateFinalizeAction deletes the storage pool unless it still contains volumes. Pools that have not been
+// defined by the operator are kept.
+func CreateFinalizeAction(client *onprem.LivirtClient, opt *onprem.StoragePoolOptions, owned bool) (*common.ResourceStatus, error) {
+	if !owned {
+		log.Printf("Storage pool [%s] has not been defined by the operator, keeping it", opt.Name)
+		return common.CreateReadyAction()
+	}
+	// refuse deletion while the pool is in use
+	getVolumes := onprem.GetStoragePoolVolumes(client)
+	volumes, err := getVolumes(opt.Name)
+	if err != nil {
+		log.Printf("Unable to list the volumes of storage pool [%s], cause: [%v]", opt.Name, err)
+		return common.CreateErrorAction(err)
+	}
+	if A.IsNonEmpty(volumes) {
+		log.Printf("Storage pool [%s] still contains volumes %v, waiting ...", opt.Name, volumes)
+		return &common.ResourceStatus{
+			Status:      common.Waiting,
+			Description: fmt.Sprintf("Storage pool still contains the volumes [%s]", strings.Join(volumes, ", ")),
+		}, nil
+	}
+	// destroy the pool
+	deleteSync := onprem.DeleteStoragePoolSync(client)
+	err = deleteSync(opt.Name)
+	if err != nil {
+		return common.CreateErrorAction(err)
+	}
+	// done
+	return common.CreateReadyAction()
+}
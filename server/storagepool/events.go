@@ -0,0 +1,87 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package storagepool
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/server/common"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// reason of the event reporting a storage pool that is running out of space
+	reasonCapacityWarning = "CapacityWarning"
+)
+
+var (
+	capacityWarningsLock sync.Mutex
+	// time a storage pool first exceeded its threshold, keyed by the UID of the custom resource
+	capacityWarnings = make(map[types.UID]time.Time)
+)
+
+// capacityWarningSince returns the time the capacity warning has been raised first, or clears it
+func capacityWarningSince(uid types.UID, exceeded bool) time.Time {
+	capacityWarningsLock.Lock()
+	defer capacityWarningsLock.Unlock()
+
+	if !exceeded {
+		delete(capacityWarnings, uid)
+		return time.Time{}
+	}
+	t, ok := capacityWarnings[uid]
+	if !ok {
+		t = time.Now()
+		capacityWarnings[uid] = t
+	}
+	return t
+}
+
+// createCapacityEvents produces a warning event while the usage of the pool exceeds the threshold, the event
+// disappears once the usage drops below the threshold
+func createCapacityEvents(parent *onprem.StoragePoolCustomResource, state *common.ResourceStatus) []*v1.Event {
+	usage, ok := state.Metadata["usage"].(int)
+	threshold := onprem.BoxCapacityWarningThreshold(parent.Spec.CapacityWarningThreshold)
+	exceeded := ok && state.Status == common.Ready && usage >= threshold
+	since := capacityWarningSince(parent.UID, exceeded)
+	if !exceeded {
+		return []*v1.Event{}
+	}
+	involved := v1.ObjectReference{
+		Kind:       onprem.KindStoragePool,
+		APIVersion: onprem.APIVersion,
+		Name:       parent.Name,
+		Namespace:  parent.Namespace,
+		UID:        parent.UID,
+	}
+	message := fmt.Sprintf("Storage pool is [%d%%] full, exceeding the threshold of [%d%%]", usage, threshold)
+	return []*v1.Event{
+		common.CreateChildEvent(involved, fmt.Sprintf("%s.capacity", parent.Name), v1.EventTypeWarning, reasonCapacityWarning, message, since),
+	}
+}
+
+// capacityEventsFromRequest produces the desired event children for a sync request, these need to be part
+// of every sync response, otherwise the metacontroller would delete the existing events
+func capacityEventsFromRequest(req map[string]any, state *common.ResourceStatus) []*v1.Event {
+	cfg, err := common.Transcode[*StoragePoolConfigResource](req)
+	if err != nil || state == nil {
+		return []*v1.Event{}
+	}
+	return createCapacityEvents(&cfg.Parent, state)
+}
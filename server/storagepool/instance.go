@@ -0,0 +1,37 @@
+// Copyright 2023 IBM Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.package datasource
+
+package storagepool
+
+import (
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/env"
+	"github.com/ibm-hyper-protect/k8s-operator-hpcr/onprem"
+)
+
+// storagePoolOptionsFromConfigMap decodes the information required to create a storage pool
+// from the k8s resource
+func storagePoolOptionsFromConfigMap(data *StoragePoolConfigResource, envMap env.Environment) (*onprem.StoragePoolOptions, error) {
+	spec := data.Parent.Spec
+	name := spec.Name
+	if name == "" {
+		name = data.Parent.Name
+	}
+	opt := &onprem.StoragePoolOptions{
+		Name:   name,
+		Type:   onprem.BoxStoragePoolType(spec.Type),
+		Path:   spec.Path,
+		Source: spec.Source,
+	}
+	return opt, nil
+}
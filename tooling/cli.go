@@ -30,6 +30,8 @@ func CreateApp() *c.App {
 		Commands: []*c.Command{
 			cli.CreateSSHConfigCommand(),
 			cli.CreateOnPremCommand(),
+			cli.CreateLogsCommand(),
+			cli.CreatePreflightCommand(),
 		},
 	}
 }